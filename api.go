@@ -1,9 +1,14 @@
 package flow
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/antlinker/flow/schema"
 	"github.com/teambition/gear"
@@ -12,6 +17,7 @@ import (
 // API 提供API管理
 type API struct {
 	engine *Engine
+	tenant func(*http.Request) string
 }
 
 // Init 初始化
@@ -20,6 +26,15 @@ func (a *API) Init(engine *Engine) *API {
 	return a
 }
 
+// 获取请求的上下文(包含请求的租户)
+func (a *API) context(ctx *gear.Context) context.Context {
+	c := ctx.Req.Context()
+	if a.tenant != nil {
+		c = WithTenant(c, a.tenant(ctx.Req))
+	}
+	return c
+}
+
 // 获取分页的页索引
 func (a *API) pageIndex(ctx *gear.Context) uint {
 	if v := ctx.Query("current"); v != "" {
@@ -49,7 +64,116 @@ func (a *API) QueryFlowPage(ctx *gear.Context) error {
 		Name: ctx.Query("name"),
 	}
 
-	total, items, err := a.engine.flowBll.QueryAllFlowPage(params, pageIndex, pageSize)
+	total, items, err := a.engine.tenantBll(a.context(ctx)).QueryAllFlowPage(params, pageIndex, pageSize)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	response := map[string]interface{}{
+		"list": items,
+		"pagination": map[string]interface{}{
+			"total":    total,
+			"current":  pageIndex,
+			"pageSize": pageSize,
+		},
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// QueryFlowInstancePage 查询流程实例分页数据
+func (a *API) QueryFlowInstancePage(ctx *gear.Context) error {
+	pageIndex, pageSize := a.pageIndex(ctx), a.pageSize(ctx)
+	params := schema.FlowInstanceQueryParam{
+		FlowCode:    ctx.Query("flow_code"),
+		BusinessKey: ctx.Query("business_key"),
+		Launcher:    ctx.Query("launcher"),
+	}
+	if v := ctx.Query("status"); v != "" {
+		params.Status, _ = strconv.Atoi(v)
+	}
+
+	total, items, err := a.engine.QueryFlowInstancePage(a.context(ctx), params, pageIndex, pageSize)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	response := map[string]interface{}{
+		"list": items,
+		"pagination": map[string]interface{}{
+			"total":    total,
+			"current":  pageIndex,
+			"pageSize": pageSize,
+		},
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// QueryWebhookDelivery 查询流程实例的webhook投递记录
+func (a *API) QueryWebhookDelivery(ctx *gear.Context) error {
+	items, err := a.engine.QueryWebhookDelivery(a.context(ctx), ctx.Param("id"))
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.JSON(http.StatusOK, items)
+}
+
+// QueryJobPage 查询作业分页数据
+func (a *API) QueryJobPage(ctx *gear.Context) error {
+	pageIndex, pageSize := a.pageIndex(ctx), a.pageSize(ctx)
+	params := schema.JobQueryParam{
+		TypeCode:       ctx.Query("type_code"),
+		FlowInstanceID: ctx.Query("flow_instance_id"),
+	}
+	if v := ctx.Query("status"); v != "" {
+		params.Status, _ = strconv.Atoi(v)
+	}
+
+	total, items, err := a.engine.QueryJobPage(a.context(ctx), params, pageIndex, pageSize)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	response := map[string]interface{}{
+		"list": items,
+		"pagination": map[string]interface{}{
+			"total":    total,
+			"current":  pageIndex,
+			"pageSize": pageSize,
+		},
+	}
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// RetryJob 重新执行失败的作业
+func (a *API) RetryJob(ctx *gear.Context) error {
+	err := a.engine.RetryJob(a.context(ctx), ctx.Param("id"))
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			return gear.ErrNotFound.From(err)
+		case ErrJobNotFailed:
+			return gear.ErrConflict.From(err)
+		}
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.JSON(http.StatusOK, "ok")
+}
+
+// QueryOutboxPage 查询发件箱分页数据
+func (a *API) QueryOutboxPage(ctx *gear.Context) error {
+	pageIndex, pageSize := a.pageIndex(ctx), a.pageSize(ctx)
+	params := schema.OutboxQueryParam{
+		EventType:      ctx.Query("event_type"),
+		FlowInstanceID: ctx.Query("flow_instance_id"),
+	}
+	if v := ctx.Query("status"); v != "" {
+		params.Status, _ = strconv.Atoi(v)
+	}
+
+	total, items, err := a.engine.QueryOutboxPage(a.context(ctx), params, pageIndex, pageSize)
 	if err != nil {
 		return gear.ErrInternalServerError.From(err)
 	}
@@ -66,9 +190,24 @@ func (a *API) QueryFlowPage(ctx *gear.Context) error {
 	return ctx.JSON(http.StatusOK, response)
 }
 
+// RetryOutbox 重新投递失败的发件箱消息
+func (a *API) RetryOutbox(ctx *gear.Context) error {
+	err := a.engine.RetryOutbox(a.context(ctx), ctx.Param("id"))
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			return gear.ErrNotFound.From(err)
+		case ErrOutboxNotFailed:
+			return gear.ErrConflict.From(err)
+		}
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.JSON(http.StatusOK, "ok")
+}
+
 // GetFlow 获取流程数据
 func (a *API) GetFlow(ctx *gear.Context) error {
-	item, err := a.engine.flowBll.GetFlow(ctx.Param("id"))
+	item, err := a.engine.tenantBll(a.context(ctx)).GetFlow(ctx.Param("id"))
 	if err != nil {
 		return gear.ErrInternalServerError.From(err)
 	}
@@ -93,18 +232,351 @@ func (a *API) SaveFlow(ctx *gear.Context) error {
 		return gear.ErrBadRequest.From(err)
 	}
 
-	_, err := a.engine.CreateFlow([]byte(req.XML))
+	_, err := a.engine.CreateFlowWithContext(a.context(ctx), []byte(req.XML))
 	if err != nil {
+		if verr, ok := err.(*ValidationError); ok {
+			return ctx.JSON(http.StatusUnprocessableEntity, verr)
+		}
 		return gear.ErrInternalServerError.From(err)
 	}
 	return ctx.JSON(http.StatusOK, "ok")
 }
 
-// DeleteFlow 删除流程数据
+// ValidateFlow 校验流程定义(不保存)，返回流程定义中的问题
+func (a *API) ValidateFlow(ctx *gear.Context) error {
+	var req saveFlowRequest
+	if err := ctx.ParseBody(&req); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	problems, err := a.engine.ValidateFlow(a.context(ctx), []byte(req.XML))
+	if err != nil {
+		return gear.ErrBadRequest.From(err)
+	} else if problems == nil {
+		problems = []*Problem{}
+	}
+	return ctx.JSON(http.StatusOK, problems)
+}
+
+// ImportFlow 部署请求内容中的流程定义(按Content-Type选择格式，不能识别时按内容识别)
+func (a *API) ImportFlow(ctx *gear.Context) error {
+	data, err := ioutil.ReadAll(ctx.Req.Body)
+	if err != nil {
+		return gear.ErrBadRequest.From(err)
+	} else if len(data) == 0 {
+		return gear.ErrBadRequest.From(errors.New("请求含有空数据"))
+	}
+
+	format := FormatByContentType(ctx.GetHeader("Content-Type"))
+	if format == "" {
+		format = DetectFormat(data)
+	}
+
+	flowID, err := a.engine.CreateFlowWithFormat(a.context(ctx), format, data)
+	if err != nil {
+		if verr, ok := err.(*ValidationError); ok {
+			return ctx.JSON(http.StatusUnprocessableEntity, verr)
+		}
+		return gear.ErrBadRequest.From(err)
+	}
+	return ctx.JSON(http.StatusOK, map[string]string{"record_id": flowID})
+}
+
+// ExportFlow 导出流程定义(format为json或yaml，默认json)
+func (a *API) ExportFlow(ctx *gear.Context) error {
+	format := ctx.Query("format")
+	if format == "" {
+		format = FormatJSON
+	}
+
+	data, err := a.engine.ExportFlow(a.context(ctx), ctx.Param("id"), format)
+	if err != nil {
+		if err == ErrNotFound {
+			return gear.ErrNotFound.From(err)
+		}
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	if format == FormatYAML {
+		ctx.Type("application/x-yaml; charset=utf-8")
+	} else if format == FormatXML {
+		ctx.Type("application/xml; charset=utf-8")
+	} else {
+		ctx.Type("application/json; charset=utf-8")
+	}
+	return ctx.End(http.StatusOK, data)
+}
+
+// GetFlowDiagram 获取流程图(SVG)
+func (a *API) GetFlowDiagram(ctx *gear.Context) error {
+	data, err := a.engine.RenderFlowDiagram(a.context(ctx), ctx.Param("id"))
+	return a.diagram(ctx, data, err)
+}
+
+// GetFlowInstanceDiagram 获取流程实例的流程图(SVG，高亮已完成的节点、进行中的节点及经过的顺序流)
+func (a *API) GetFlowInstanceDiagram(ctx *gear.Context) error {
+	data, err := a.engine.RenderFlowInstanceDiagram(a.context(ctx), ctx.Param("id"))
+	return a.diagram(ctx, data, err)
+}
+
+func (a *API) diagram(ctx *gear.Context, data []byte, err error) error {
+	if err != nil {
+		if err == ErrNotFound || err == ErrNoDiagram {
+			return gear.ErrNotFound.From(err)
+		}
+		return gear.ErrInternalServerError.From(err)
+	}
+
+	ctx.Type("image/svg+xml; charset=utf-8")
+	return ctx.End(http.StatusOK, data)
+}
+
+// DeleteFlow 删除流程数据(租户只能删除租户的流程)
 func (a *API) DeleteFlow(ctx *gear.Context) error {
-	err := a.engine.flowBll.DeleteFlow(ctx.Param("id"))
+	c := a.context(ctx)
+	flowBll := a.engine.tenantBll(c)
+	flow, err := flowBll.GetFlow(ctx.Param("id"))
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	} else if flow == nil || flow.TenantID != TenantFromContext(c) {
+		return gear.ErrNotFound.From(ErrNotFound)
+	}
+
+	err = flowBll.DeleteFlow(flow.RecordID)
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.JSON(http.StatusOK, "ok")
+}
+
+// QueryFlowVersion 查询流程的所有版本(active为1的是启用的版本)
+func (a *API) QueryFlowVersion(ctx *gear.Context) error {
+	items, err := a.engine.tenantBll(a.context(ctx)).QueryFlowVersion(ctx.Param("id"))
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.JSON(http.StatusOK, items)
+}
+
+// ActivateFlow 将流程设为启用的版本(租户只能设定租户的流程)
+func (a *API) ActivateFlow(ctx *gear.Context) error {
+	err := a.engine.ActivateFlow(a.context(ctx), ctx.Param("id"))
+	if err != nil {
+		if err == ErrNotFound {
+			return gear.ErrNotFound.From(err)
+		}
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.JSON(http.StatusOK, "ok")
+}
+
+// DiffFlow 比较流程版本(路径参数为原版本的流程内码，target为新版本的流程内码)
+func (a *API) DiffFlow(ctx *gear.Context) error {
+	target := ctx.Query("target")
+	if target == "" {
+		return gear.ErrBadRequest.From(errors.New("缺少比较的流程版本"))
+	}
+
+	diff, err := a.engine.DiffFlowVersions(a.context(ctx), ctx.Param("id"), target)
+	if err != nil {
+		if err == ErrNotFound {
+			return gear.ErrNotFound.From(err)
+		} else if err == ErrDiffFlowCode {
+			return gear.ErrBadRequest.From(err)
+		}
+		return gear.ErrInternalServerError.From(err)
+	}
+	return ctx.JSON(http.StatusOK, diff)
+}
+
+type launchFlowRequest struct {
+	UserID      string          `json:"user_id"`      // 发起人
+	BusinessKey string          `json:"business_key"` // 业务主键
+	Input       json.RawMessage `json:"input"`        // 输入数据
+}
+
+func (a *launchFlowRequest) Validate() error {
+	if a.UserID == "" {
+		return errors.New("无效的发起人")
+	}
+	return nil
+}
+
+// LaunchFlow 发起指定版本的流程
+func (a *API) LaunchFlow(ctx *gear.Context) error {
+	var req launchFlowRequest
+	if err := ctx.ParseBody(&req); err != nil {
+		return gear.ErrBadRequest.From(err)
+	} else if len(req.Input) == 0 {
+		req.Input = json.RawMessage("{}")
+	}
+
+	result, err := a.engine.LaunchFlow(a.context(ctx), ctx.Param("id"), req.UserID, req.Input, BusinessKeyOption(req.BusinessKey))
 	if err != nil {
+		switch err {
+		case ErrNotFound:
+			return gear.ErrNotFound.From(err)
+		case ErrBusinessKeyExists:
+			return gear.ErrConflict.From(err)
+		}
 		return gear.ErrInternalServerError.From(err)
 	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+type simulateFlowRequest struct {
+	SimulationScenario
+}
+
+func (a *simulateFlowRequest) Validate() error {
+	for i, step := range a.Steps {
+		if step == nil || step.NodeID == "" {
+			return fmt.Errorf("第%d步缺少处理的节点", i+1)
+		}
+	}
+	return nil
+}
+
+// SimulateFlow 按场景模拟运行指定版本的流程(不写入数据库)
+func (a *API) SimulateFlow(ctx *gear.Context) error {
+	var req simulateFlowRequest
+	if err := ctx.ParseBody(&req); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+	scenario := req.SimulationScenario
+
+	c := a.context(ctx)
+	flow, err := a.engine.tenantBll(c).GetFlow(ctx.Param("id"))
+	if err != nil {
+		return gear.ErrInternalServerError.From(err)
+	} else if flow == nil {
+		return gear.ErrNotFound.From(ErrNotFound)
+	}
+	scenario.Version = flow.Version
+
+	result, err := a.engine.Simulate(c, flow.Code, &scenario)
+	if err != nil {
+		if err == ErrNotFound {
+			return gear.ErrNotFound.From(err)
+		}
+		return gear.ErrBadRequest.From(err)
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// 转换外部任务的错误
+func (a *API) externalTaskError(err error) error {
+	switch err {
+	case ErrNotFound:
+		return gear.ErrNotFound.From(err)
+	case ErrJobNotLocked, ErrFlowSuspended:
+		return gear.ErrConflict.From(err)
+	}
+	return gear.ErrInternalServerError.From(err)
+}
+
+type fetchAndLockRequest struct {
+	Topic        string `json:"topic"`         // 主题
+	WorkerID     string `json:"worker_id"`     // 执行者
+	MaxTasks     int    `json:"max_tasks"`     // 最大拉取数量
+	LockDuration int64  `json:"lock_duration"` // 锁定时长(毫秒)
+}
+
+func (a *fetchAndLockRequest) Validate() error {
+	if a.Topic == "" || a.WorkerID == "" || a.MaxTasks <= 0 || a.LockDuration <= 0 {
+		return errors.New("无效的请求参数")
+	}
+	return nil
+}
+
+// FetchAndLock 拉取并锁定外部任务
+func (a *API) FetchAndLock(ctx *gear.Context) error {
+	var req fetchAndLockRequest
+	if err := ctx.ParseBody(&req); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	items, err := a.engine.ExternalTask().FetchAndLock(a.context(ctx), req.Topic, req.WorkerID, req.MaxTasks, time.Duration(req.LockDuration)*time.Millisecond)
+	if err != nil {
+		return a.externalTaskError(err)
+	}
+	return ctx.JSON(http.StatusOK, items)
+}
+
+type completeExternalTaskRequest struct {
+	WorkerID string          `json:"worker_id"` // 执行者
+	Data     json.RawMessage `json:"data"`      // 输出数据
+}
+
+func (a *completeExternalTaskRequest) Validate() error {
+	if a.WorkerID == "" {
+		return errors.New("无效的执行者")
+	}
+	return nil
+}
+
+// CompleteExternalTask 完成外部任务
+func (a *API) CompleteExternalTask(ctx *gear.Context) error {
+	var req completeExternalTaskRequest
+	if err := ctx.ParseBody(&req); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	result, err := a.engine.ExternalTask().Complete(a.context(ctx), ctx.Param("id"), req.WorkerID, req.Data)
+	if err != nil {
+		return a.externalTaskError(err)
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+type externalTaskFailureRequest struct {
+	WorkerID     string `json:"worker_id"`     // 执行者
+	ErrorMessage string `json:"error_message"` // 错误信息
+}
+
+func (a *externalTaskFailureRequest) Validate() error {
+	if a.WorkerID == "" {
+		return errors.New("无效的执行者")
+	}
+	return nil
+}
+
+// ExternalTaskFailure 外部任务执行失败
+func (a *API) ExternalTaskFailure(ctx *gear.Context) error {
+	var req externalTaskFailureRequest
+	if err := ctx.ParseBody(&req); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	err := a.engine.ExternalTask().Failure(a.context(ctx), ctx.Param("id"), req.WorkerID, req.ErrorMessage)
+	if err != nil {
+		return a.externalTaskError(err)
+	}
+	return ctx.JSON(http.StatusOK, "ok")
+}
+
+type extendLockRequest struct {
+	WorkerID     string `json:"worker_id"`     // 执行者
+	LockDuration int64  `json:"lock_duration"` // 锁定时长(毫秒)
+}
+
+func (a *extendLockRequest) Validate() error {
+	if a.WorkerID == "" || a.LockDuration <= 0 {
+		return errors.New("无效的请求参数")
+	}
+	return nil
+}
+
+// ExtendExternalTaskLock 延长外部任务的锁定时间
+func (a *API) ExtendExternalTaskLock(ctx *gear.Context) error {
+	var req extendLockRequest
+	if err := ctx.ParseBody(&req); err != nil {
+		return gear.ErrBadRequest.From(err)
+	}
+
+	err := a.engine.ExternalTask().ExtendLock(a.context(ctx), ctx.Param("id"), req.WorkerID, time.Duration(req.LockDuration)*time.Millisecond)
+	if err != nil {
+		return a.externalTaskError(err)
+	}
 	return ctx.JSON(http.StatusOK, "ok")
 }
@@ -11,7 +11,19 @@ import (
 
 // Flow 流程管理
 type Flow struct {
-	FlowModel *model.Flow `inject:""`
+	FlowModel model.Repository `inject:""`
+}
+
+// Transaction 在同一事务中执行流程业务操作，fn返回错误时回滚
+func (a *Flow) Transaction(fn func(*Flow) error) error {
+	return a.FlowModel.Tran(func(m model.Repository) error {
+		return fn(&Flow{FlowModel: m})
+	})
+}
+
+// WithTenant 获取按租户隔离的流程业务
+func (a *Flow) WithTenant(tenantID string) *Flow {
+	return &Flow{FlowModel: a.FlowModel.WithTenant(tenantID)}
 }
 
 // GetFlow 获取流程数据
@@ -38,8 +50,13 @@ func (a *Flow) GetNode(recordID string) (*schema.Node, error) {
 }
 
 // GetFlowInstance 获取流程实例
+// 未找到时查询已归档的流程实例
 func (a *Flow) GetFlowInstance(recordID string) (*schema.FlowInstance, error) {
-	return a.FlowModel.GetFlowInstance(recordID)
+	item, err := a.FlowModel.GetFlowInstance(recordID)
+	if err != nil || item != nil {
+		return item, err
+	}
+	return a.FlowModel.GetArchivedFlowInstance(recordID)
 }
 
 // GetFlowInstanceByNode 根据节点实例获取流程实例
@@ -47,11 +64,36 @@ func (a *Flow) GetFlowInstanceByNode(nodeInstanceID string) (*schema.FlowInstanc
 	return a.FlowModel.GetFlowInstanceByNode(nodeInstanceID)
 }
 
+// GetFlowInstanceByBusinessKey 根据业务主键获取流程实例
+// 未找到时查询已归档的流程实例
+func (a *Flow) GetFlowInstanceByBusinessKey(flowCode, businessKey string) (*schema.FlowInstance, error) {
+	item, err := a.FlowModel.GetFlowInstanceByBusinessKey(flowCode, businessKey)
+	if err != nil || item != nil {
+		return item, err
+	}
+	return a.FlowModel.GetArchivedFlowInstanceByBusinessKey(flowCode, businessKey)
+}
+
+// CheckFlowInstanceBusinessKey 检查业务主键是否存在进行中的流程实例
+func (a *Flow) CheckFlowInstanceBusinessKey(flowCode, businessKey string) (bool, error) {
+	return a.FlowModel.CheckFlowInstanceBusinessKey(flowCode, businessKey)
+}
+
 // GetNodeInstance 获取流程节点实例
 func (a *Flow) GetNodeInstance(recordID string) (*schema.NodeInstance, error) {
 	return a.FlowModel.GetNodeInstance(recordID)
 }
 
+// QueryNodeInstances 查询流程实例的节点实例
+func (a *Flow) QueryNodeInstances(flowInstanceID string) ([]*schema.NodeInstance, error) {
+	return a.FlowModel.QueryNodeInstances(flowInstanceID)
+}
+
+// QueryNodes 查询流程的节点
+func (a *Flow) QueryNodes(flowID string) ([]*schema.Node, error) {
+	return a.FlowModel.QueryNodes(flowID)
+}
+
 // QueryNodeRouters 查询节点路由
 func (a *Flow) QueryNodeRouters(sourceNodeID string) ([]*schema.NodeRouter, error) {
 	return a.FlowModel.QueryNodeRouters(sourceNodeID)
@@ -64,12 +106,20 @@ func (a *Flow) QueryNodeAssignments(nodeID string) ([]*schema.NodeAssignment, er
 
 // CreateNodeInstance 创建节点实例
 func (a *Flow) CreateNodeInstance(flowInstanceID, nodeID string, inputData []byte, candidates []string) (string, error) {
+	return a.CreateTaskInstance(flowInstanceID, nodeID, "", inputData, candidates, 0, 0)
+}
+
+// CreateTaskInstance 创建节点实例(指定上一节点实例、到期时间及优先级)
+func (a *Flow) CreateTaskInstance(flowInstanceID, nodeID, prevID string, inputData []byte, candidates []string, dueTime int64, priority int) (string, error) {
 	nodeInstance := &schema.NodeInstance{
 		RecordID:       util.UUID(),
 		FlowInstanceID: flowInstanceID,
 		NodeID:         nodeID,
+		PrevID:         prevID,
 		InputData:      string(inputData),
 		Status:         1,
+		DueTime:        dueTime,
+		Priority:       priority,
 		Created:        time.Now().Unix(),
 	}
 
@@ -110,29 +160,80 @@ func (a *Flow) DoneNodeInstance(nodeInstanceID, processor string, outData []byte
 	return a.FlowModel.UpdateNodeInstance(nodeInstanceID, info)
 }
 
+// LockBusinessKey 锁定业务主键(同一流程编号下的业务主键已被锁定时返回false)
+func (a *Flow) LockBusinessKey(flowCode, businessKey, flowInstanceID string) (bool, error) {
+	return a.FlowModel.LockBusinessKey(flowCode, businessKey, flowInstanceID)
+}
+
 // CheckFlowInstanceTodo 检查流程实例待办事项
 func (a *Flow) CheckFlowInstanceTodo(flowInstanceID string) (bool, error) {
 	return a.FlowModel.CheckFlowInstanceTodo(flowInstanceID)
 }
 
-// DoneFlowInstance 完成流程实例
+// DoneFlowInstance 完成流程实例(释放锁定的业务主键)
 func (a *Flow) DoneFlowInstance(flowInstanceID string) error {
 	info := map[string]interface{}{
-		"status": 9,
+		"status":  9,
+		"updated": time.Now().Unix(),
 	}
-	return a.FlowModel.UpdateFlowInstance(flowInstanceID, info)
+	err := a.FlowModel.UpdateFlowInstance(flowInstanceID, info)
+	if err != nil {
+		return err
+	}
+	return a.FlowModel.UnlockBusinessKey(flowInstanceID)
 }
 
-// StopFlowInstance 停止流程实例
+// StopFlowInstance 停止流程实例(释放锁定的业务主键)
 func (a *Flow) StopFlowInstance(flowInstanceID string) error {
 	info := map[string]interface{}{
-		"status": 9,
+		"status":  9,
+		"updated": time.Now().Unix(),
+	}
+	err := a.FlowModel.UpdateFlowInstance(flowInstanceID, info)
+	if err != nil {
+		return err
+	}
+	return a.FlowModel.UnlockBusinessKey(flowInstanceID)
+}
+
+// MigrateFlowInstance 迁移流程实例(重新绑定流程实例的流程及节点实例的节点)
+// nodes 节点实例内码->新的节点内码，流程实例不属于原版本或已结束时不迁移并返回false
+func (a *Flow) MigrateFlowInstance(flowInstanceID, sourceFlowID, targetFlowID string, nodes map[string]string) (bool, error) {
+	ok, err := a.FlowModel.MigrateFlowInstance(flowInstanceID, sourceFlowID, targetFlowID, time.Now().Unix())
+	if err != nil || !ok {
+		return false, err
+	}
+
+	for nodeInstanceID, nodeID := range nodes {
+		err := a.FlowModel.UpdateNodeInstance(nodeInstanceID, map[string]interface{}{
+			"node_id": nodeID,
+			"updated": time.Now().Unix(),
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// SuspendFlowInstance 暂停流程实例
+func (a *Flow) SuspendFlowInstance(flowInstanceID string) error {
+	info := map[string]interface{}{
+		"status": 2,
+	}
+	return a.FlowModel.UpdateFlowInstance(flowInstanceID, info)
+}
+
+// ResumeFlowInstance 恢复暂停的流程实例
+func (a *Flow) ResumeFlowInstance(flowInstanceID string) error {
+	info := map[string]interface{}{
+		"status": 1,
 	}
 	return a.FlowModel.UpdateFlowInstance(flowInstanceID, info)
 }
 
 // LaunchFlowInstance2 发起流程实例（基于流程ID），返回流程实例、开始事件节点实例
-func (a *Flow) LaunchFlowInstance2(flowID, userID string, status int, inputData []byte) (*schema.FlowInstance, *schema.NodeInstance, error) {
+func (a *Flow) LaunchFlowInstance2(flowID, userID, businessKey string, status int, inputData []byte) (*schema.FlowInstance, *schema.NodeInstance, error) {
 	node, err := a.GetNodeByFlowAndTypeCode(flowID, "startEvent")
 	if err != nil {
 		return nil, nil, err
@@ -141,12 +242,13 @@ func (a *Flow) LaunchFlowInstance2(flowID, userID string, status int, inputData
 	}
 
 	flowInstance := &schema.FlowInstance{
-		RecordID:   util.UUID(),
-		FlowID:     flowID,
-		Launcher:   userID,
-		LaunchTime: time.Now().Unix(),
-		Status:     int64(status),
-		Created:    time.Now().Unix(),
+		RecordID:    util.UUID(),
+		FlowID:      flowID,
+		BusinessKey: businessKey,
+		Launcher:    userID,
+		LaunchTime:  time.Now().Unix(),
+		Status:      int64(status),
+		Created:     time.Now().Unix(),
 	}
 
 	nodeInstance := &schema.NodeInstance{
@@ -166,29 +268,31 @@ func (a *Flow) LaunchFlowInstance2(flowID, userID string, status int, inputData
 	return flowInstance, nodeInstance, nil
 }
 
-// LaunchFlowInstance 发起流程实例
-func (a *Flow) LaunchFlowInstance(flowCode, nodeCode, launcher string, inputData []byte) (*schema.NodeInstance, error) {
-	flow, err := a.FlowModel.GetFlowByCode(flowCode)
+// LaunchFlowInstance 发起流程实例，返回流程实例、开始节点实例
+// version 为0时发起启用的版本
+func (a *Flow) LaunchFlowInstance(flowCode, nodeCode, launcher, businessKey string, version int64, inputData []byte) (*schema.FlowInstance, *schema.NodeInstance, error) {
+	flow, err := a.GetFlowByCodeAndVersion(flowCode, version)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	} else if flow == nil {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	node, err := a.FlowModel.GetNodeByCode(flow.RecordID, nodeCode)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	} else if node == nil {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	flowInstance := &schema.FlowInstance{
-		RecordID:   util.UUID(),
-		FlowID:     flow.RecordID,
-		Launcher:   launcher,
-		LaunchTime: time.Now().Unix(),
-		Status:     1,
-		Created:    time.Now().Unix(),
+		RecordID:    util.UUID(),
+		FlowID:      flow.RecordID,
+		BusinessKey: businessKey,
+		Launcher:    launcher,
+		LaunchTime:  time.Now().Unix(),
+		Status:      1,
+		Created:     time.Now().Unix(),
 	}
 
 	nodeInstance := &schema.NodeInstance{
@@ -202,10 +306,10 @@ func (a *Flow) LaunchFlowInstance(flowCode, nodeCode, launcher string, inputData
 
 	err = a.FlowModel.CreateFlowInstance(flowInstance, nodeInstance)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return nodeInstance, nil
+	return flowInstance, nodeInstance, nil
 }
 
 // QueryNodeCandidates 查询节点候选人
@@ -220,7 +324,7 @@ func (a *Flow) QueryTodo(flowCode, userID string) ([]*schema.FlowTodoResult, err
 
 // QueryDone 查询用户的已办数据
 func (a *Flow) QueryDone(flowCode, userID string, lastID int64, count int) ([]*schema.FlowDoneResult, error) {
-	return a.QueryDone(flowCode, userID, lastID, count)
+	return a.FlowModel.QueryDone(flowCode, userID, lastID, count)
 }
 
 // QueryAllFlowPage 查询流程分页数据
@@ -234,13 +338,47 @@ func (a *Flow) DeleteFlow(flowID string) error {
 }
 
 // QueryHistory 查询流程实例历史数据
+// 流程实例已归档时查询归档的历史数据
 func (a *Flow) QueryHistory(flowInstanceID string) ([]*schema.FlowHistoryResult, error) {
-	return a.FlowModel.QueryHistory(flowInstanceID)
+	items, err := a.FlowModel.QueryHistory(flowInstanceID)
+	if err != nil || len(items) > 0 {
+		return items, err
+	}
+	return a.FlowModel.QueryArchivedHistory(flowInstanceID)
+}
+
+// QueryExpiredFlowInstanceIDs 查询过期(已完成或已停止)的流程实例ID列表
+func (a *Flow) QueryExpiredFlowInstanceIDs(params schema.ExpiredFlowInstanceQueryParam, limit int) ([]string, error) {
+	return a.FlowModel.QueryExpiredFlowInstanceIDs(params, limit)
 }
 
-// QueryDoneIDs 查询已办理的流程实例ID列表
+// ArchiveFlowInstances 归档流程实例
+func (a *Flow) ArchiveFlowInstances(flowInstanceIDs []string) error {
+	return a.FlowModel.ArchiveFlowInstances(flowInstanceIDs, time.Now().Unix())
+}
+
+// PurgeFlowInstances 清除流程实例
+func (a *Flow) PurgeFlowInstances(flowInstanceIDs []string) error {
+	return a.FlowModel.PurgeFlowInstances(flowInstanceIDs)
+}
+
+// QueryDoneIDs 查询已办理的流程实例ID列表(包括已归档的流程实例)
 func (a *Flow) QueryDoneIDs(flowCode, userID string) ([]string, error) {
-	return a.FlowModel.QueryDoneIDs(flowCode, userID)
+	ids, err := a.FlowModel.QueryDoneIDs(flowCode, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	archivedIDs, err := a.FlowModel.QueryArchivedDoneIDs(flowCode, userID)
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, archivedIDs...), nil
+}
+
+// QueryFlowInstancePage 查询流程实例分页数据
+func (a *Flow) QueryFlowInstancePage(params schema.FlowInstanceQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowInstance, error) {
+	return a.FlowModel.QueryFlowInstancePage(params, pageIndex, pageSize)
 }
 
 // QueryGroupFlowPage 查询流程分组分页数据
@@ -263,6 +401,53 @@ func (a *Flow) UpdateFlowStatus(recordID string, status int) error {
 	return a.UpdateFlowInfo(recordID, info)
 }
 
+// GetFlowByCodeAndVersion 根据编号及版本号获取有效的流程(租户的流程优先于全局的流程)
+// version 为0时获取启用的版本
+func (a *Flow) GetFlowByCodeAndVersion(code string, version int64) (*schema.Flow, error) {
+	flow, err := a.FlowModel.GetFlowByCode(code)
+	if err != nil || flow == nil || version == 0 || flow.Version == version {
+		return flow, err
+	}
+
+	items, err := a.QueryFlowVersion(flow.RecordID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		if item.Version == version && item.Status == 1 {
+			return a.FlowModel.GetFlow(item.RecordID)
+		}
+	}
+	return nil, nil
+}
+
+// ActivateFlow 将流程设为启用的版本(同一编号的其他版本取消启用)
+func (a *Flow) ActivateFlow(recordID string) error {
+	items, err := a.QueryFlowVersion(recordID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if item.Active == 1 && item.RecordID != recordID {
+			err = a.UpdateFlowInfo(item.RecordID, map[string]interface{}{
+				"active":  0,
+				"updated": time.Now().Unix(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	info := map[string]interface{}{
+		"active":  1,
+		"updated": time.Now().Unix(),
+	}
+	return a.UpdateFlowInfo(recordID, info)
+}
+
 // QueryFlowVersion 查询流程版本数据
 func (a *Flow) QueryFlowVersion(recordID string) ([]*schema.FlowQueryResult, error) {
 	flow, err := a.FlowModel.GetFlow(recordID)
@@ -272,7 +457,19 @@ func (a *Flow) QueryFlowVersion(recordID string) ([]*schema.FlowQueryResult, err
 		return nil, nil
 	}
 
-	return a.FlowModel.QueryFlowVersion(flow.Code)
+	items, err := a.FlowModel.QueryFlowVersion(flow.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	// 租户的流程与全局的流程是相互独立的版本
+	result := make([]*schema.FlowQueryResult, 0, len(items))
+	for _, item := range items {
+		if item.TenantID == flow.TenantID {
+			result = append(result, item)
+		}
+	}
+	return result, nil
 }
 
 // QueryFlowIDsByType 根据类型查询流程ID列表
@@ -299,3 +496,252 @@ func (a *Flow) GetNodeByFlowAndTypeCode(flowID, typeCode string) (*schema.Node,
 func (a *Flow) GetForm(formID string) (*schema.Form, error) {
 	return a.FlowModel.GetForm(formID)
 }
+
+// CreateOutbox 写入事件发件箱
+func (a *Flow) CreateOutbox(eventType, flowCode, flowInstanceID string, payload []byte) error {
+	item := &schema.Outbox{
+		RecordID:       util.UUID(),
+		EventType:      eventType,
+		FlowCode:       flowCode,
+		FlowInstanceID: flowInstanceID,
+		Payload:        string(payload),
+		Status:         1,
+		NextTime:       time.Now().Unix(),
+		Created:        time.Now().Unix(),
+	}
+	return a.FlowModel.CreateOutbox(item)
+}
+
+// QueryPendingOutbox 查询到期待投递的事件
+func (a *Flow) QueryPendingOutbox(limit int) ([]*schema.Outbox, error) {
+	return a.FlowModel.QueryPendingOutbox(time.Now().Unix(), limit)
+}
+
+// DoneOutbox 标记事件已投递
+func (a *Flow) DoneOutbox(recordID string, attempts int) error {
+	info := map[string]interface{}{
+		"status":     2,
+		"attempts":   attempts,
+		"last_error": "",
+		"updated":    time.Now().Unix(),
+	}
+	return a.FlowModel.UpdateOutbox(recordID, info)
+}
+
+// FailOutbox 记录事件投递失败，nextTime为0时不再重试
+func (a *Flow) FailOutbox(recordID string, attempts int, nextTime int64, lastError string) error {
+	info := map[string]interface{}{
+		"status":     1,
+		"attempts":   attempts,
+		"next_time":  nextTime,
+		"last_error": lastError,
+		"updated":    time.Now().Unix(),
+	}
+	if nextTime == 0 {
+		info["status"] = 3
+	}
+	return a.FlowModel.UpdateOutbox(recordID, info)
+}
+
+// GetOutbox 获取事件发件箱
+func (a *Flow) GetOutbox(recordID string) (*schema.Outbox, error) {
+	return a.FlowModel.GetOutbox(recordID)
+}
+
+// QueryOutboxPage 查询事件发件箱分页数据
+func (a *Flow) QueryOutboxPage(params schema.OutboxQueryParam, pageIndex, pageSize uint) (int64, []*schema.Outbox, error) {
+	return a.FlowModel.QueryOutboxPage(params, pageIndex, pageSize)
+}
+
+// RetryOutbox 重新投递失败的事件(重置投递次数)
+func (a *Flow) RetryOutbox(recordID string) error {
+	info := map[string]interface{}{
+		"status":     1,
+		"attempts":   0,
+		"next_time":  time.Now().Unix(),
+		"last_error": "",
+		"updated":    time.Now().Unix(),
+	}
+	return a.FlowModel.UpdateOutbox(recordID, info)
+}
+
+// QueryNodeProperties 查询节点属性
+func (a *Flow) QueryNodeProperties(nodeID string) ([]*schema.NodeProperty, error) {
+	return a.FlowModel.QueryNodeProperties(nodeID)
+}
+
+// CreateWebhookDelivery 写入webhook投递记录(每个推送地址一条)
+func (a *Flow) CreateWebhookDelivery(flowInstanceID, nodeInstanceID, eventType string, urls []string, payload []byte) error {
+	items := make([]*schema.WebhookDelivery, len(urls))
+	for i, url := range urls {
+		items[i] = &schema.WebhookDelivery{
+			RecordID:       util.UUID(),
+			FlowInstanceID: flowInstanceID,
+			NodeInstanceID: nodeInstanceID,
+			EventType:      eventType,
+			URL:            url,
+			Payload:        string(payload),
+			Status:         1,
+			NextTime:       time.Now().Unix(),
+			Created:        time.Now().Unix(),
+		}
+	}
+	return a.FlowModel.CreateWebhookDelivery(items...)
+}
+
+// QueryPendingWebhookDelivery 查询到期待投递的webhook
+func (a *Flow) QueryPendingWebhookDelivery(limit int) ([]*schema.WebhookDelivery, error) {
+	return a.FlowModel.QueryPendingWebhookDelivery(time.Now().Unix(), limit)
+}
+
+// QueryWebhookDelivery 查询流程实例的webhook投递记录(包含已归档的投递记录)
+func (a *Flow) QueryWebhookDelivery(flowInstanceID string) ([]*schema.WebhookDelivery, error) {
+	archived, err := a.FlowModel.QueryArchivedWebhookDelivery(flowInstanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := a.FlowModel.QueryWebhookDelivery(flowInstanceID)
+	if err != nil {
+		return nil, err
+	}
+	return append(archived, items...), nil
+}
+
+// DoneWebhookDelivery 标记webhook已投递
+func (a *Flow) DoneWebhookDelivery(recordID string, attempts, responseCode int) error {
+	info := map[string]interface{}{
+		"status":        2,
+		"attempts":      attempts,
+		"response_code": responseCode,
+		"last_error":    "",
+		"updated":       time.Now().Unix(),
+	}
+	return a.FlowModel.UpdateWebhookDelivery(recordID, info)
+}
+
+// FailWebhookDelivery 记录webhook投递失败，nextTime为0时不再重试
+func (a *Flow) FailWebhookDelivery(recordID string, attempts, responseCode int, nextTime int64, lastError string) error {
+	info := map[string]interface{}{
+		"status":        1,
+		"attempts":      attempts,
+		"response_code": responseCode,
+		"next_time":     nextTime,
+		"last_error":    lastError,
+		"updated":       time.Now().Unix(),
+	}
+	if nextTime == 0 {
+		info["status"] = 3
+	}
+	return a.FlowModel.UpdateWebhookDelivery(recordID, info)
+}
+
+// CreateJob 创建作业(外部任务使用topic，异步继续使用stage)
+func (a *Flow) CreateJob(typeCode, flowInstanceID, nodeInstanceID, topic, stage, processor string, retries int) (*schema.Job, error) {
+	item := &schema.Job{
+		RecordID:       util.UUID(),
+		FlowInstanceID: flowInstanceID,
+		NodeInstanceID: nodeInstanceID,
+		TypeCode:       typeCode,
+		Topic:          topic,
+		Stage:          stage,
+		Processor:      processor,
+		Retries:        retries,
+		NextTime:       time.Now().Unix(),
+		Status:         1,
+		Created:        time.Now().Unix(),
+	}
+
+	err := a.FlowModel.CreateJob(item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// GetJob 获取作业
+func (a *Flow) GetJob(recordID string) (*schema.Job, error) {
+	return a.FlowModel.GetJob(recordID)
+}
+
+// LockJobs 锁定可执行的作业(最多max个)，返回锁定成功的作业ID列表
+func (a *Flow) LockJobs(typeCode, topic, workerID string, max int, lockExpire int64) ([]string, error) {
+	now := time.Now().Unix()
+	ids, err := a.FlowModel.QueryAvailableJobIDs(typeCode, topic, now, max)
+	if err != nil {
+		return nil, err
+	}
+
+	var lockIDs []string
+	for _, id := range ids {
+		ok, err := a.FlowModel.LockJob(id, workerID, lockExpire, now)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			lockIDs = append(lockIDs, id)
+		}
+	}
+	return lockIDs, nil
+}
+
+// QueryExternalTasks 查询外部任务
+func (a *Flow) QueryExternalTasks(jobIDs []string) ([]*schema.ExternalTaskResult, error) {
+	if len(jobIDs) == 0 {
+		return nil, nil
+	}
+	return a.FlowModel.QueryExternalTasks(jobIDs)
+}
+
+// DoneJob 完成作业
+func (a *Flow) DoneJob(recordID string) error {
+	info := map[string]interface{}{
+		"status":  3,
+		"updated": time.Now().Unix(),
+	}
+	return a.FlowModel.UpdateJob(recordID, info)
+}
+
+// FailJob 记录作业失败，nextTime为0时标记为失败不再重试
+func (a *Flow) FailJob(recordID string, attempts int, nextTime int64, errorMessage string) error {
+	info := map[string]interface{}{
+		"status":        1,
+		"attempts":      attempts,
+		"next_time":     nextTime,
+		"worker_id":     "",
+		"lock_expire":   0,
+		"error_message": errorMessage,
+		"updated":       time.Now().Unix(),
+	}
+	if nextTime == 0 {
+		info["status"] = 4
+	}
+	return a.FlowModel.UpdateJob(recordID, info)
+}
+
+// ExtendJobLock 延长作业的锁定时间
+func (a *Flow) ExtendJobLock(recordID string, lockExpire int64) error {
+	info := map[string]interface{}{
+		"lock_expire": lockExpire,
+		"updated":     time.Now().Unix(),
+	}
+	return a.FlowModel.UpdateJob(recordID, info)
+}
+
+// QueryJobPage 查询作业分页数据
+func (a *Flow) QueryJobPage(params schema.JobQueryParam, pageIndex, pageSize uint) (int64, []*schema.Job, error) {
+	return a.FlowModel.QueryJobPage(params, pageIndex, pageSize)
+}
+
+// RetryJob 重新执行失败的作业(重置失败次数)
+func (a *Flow) RetryJob(recordID string) error {
+	info := map[string]interface{}{
+		"status":        1,
+		"attempts":      0,
+		"next_time":     time.Now().Unix(),
+		"worker_id":     "",
+		"lock_expire":   0,
+		"error_message": "",
+		"updated":       time.Now().Unix(),
+	}
+	return a.FlowModel.UpdateJob(recordID, info)
+}
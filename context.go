@@ -7,7 +7,8 @@ import (
 )
 
 type (
-	expKey struct{}
+	expKey    struct{}
+	tenantKey struct{}
 )
 
 // NewExpContext 创建表达式的上下文值
@@ -20,3 +21,15 @@ func FromExpContext(ctx context.Context) (expression.ExpContext, bool) {
 	exp, ok := ctx.Value(expKey{}).(expression.ExpContext)
 	return exp, ok
 }
+
+// WithTenant 创建租户的上下文值
+// 引擎的接口按上下文中的租户隔离数据，未指定租户时为默认租户(空字符串)
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext 获取上下文中的租户
+func TenantFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantKey{}).(string)
+	return tenantID
+}
@@ -0,0 +1,303 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NodeOption 节点配置
+type NodeOption func(*NodeDefinition)
+
+// NodeNameOption 节点名称
+func NodeNameOption(name string) NodeOption {
+	return func(n *NodeDefinition) {
+		n.Name = name
+	}
+}
+
+// CandidatesOption 候选人(每个表达式返回一个候选人，如：input.bzr、"T001")
+func CandidatesOption(exps ...string) NodeOption {
+	return func(n *NodeDefinition) {
+		if len(exps) > 0 {
+			n.Candidates = append(n.Candidates, fmt.Sprintf("[]string{%s}", strings.Join(exps, ",")))
+		}
+	}
+}
+
+// CandidateExpressionOption 候选人表达式(表达式返回候选人列表，如：SliceStr(sql.Query(...),"user_id"))
+func CandidateExpressionOption(exp string) NodeOption {
+	return func(n *NodeDefinition) {
+		n.Candidates = append(n.Candidates, exp)
+	}
+}
+
+// NodePropertyOption 节点属性
+func NodePropertyOption(name, value string) NodeOption {
+	return func(n *NodeDefinition) {
+		n.Properties = append(n.Properties, &PropertyDefinition{Name: name, Value: value})
+	}
+}
+
+// NodeFormOption 节点表单
+func NodeFormOption(form *FormDefinition) NodeOption {
+	return func(n *NodeDefinition) {
+		n.Form = form
+	}
+}
+
+// AsyncBeforeOption 进入节点前异步继续
+func AsyncBeforeOption() NodeOption {
+	return func(n *NodeDefinition) {
+		n.AsyncBefore = true
+	}
+}
+
+// AsyncAfterOption 完成节点后异步继续
+func AsyncAfterOption() NodeOption {
+	return func(n *NodeDefinition) {
+		n.AsyncAfter = true
+	}
+}
+
+// AssigneeOption 处理人(用户ID，${表达式}为表达式，如：${input.bzr})
+func AssigneeOption(assignee string) NodeOption {
+	return func(n *NodeDefinition) {
+		n.Assignee = assignee
+	}
+}
+
+// CandidateGroupsOption 候选组(组ID由GroupResolver解析为候选人)
+func CandidateGroupsOption(groups ...string) NodeOption {
+	return func(n *NodeDefinition) {
+		items := groups
+		if n.CandidateGroups != "" {
+			items = append([]string{n.CandidateGroups}, groups...)
+		}
+		n.CandidateGroups = strings.Join(items, ",")
+	}
+}
+
+// DueDateOption 到期时间(ISO 8601的时间或时长，如：P3D、PT2H、2006-01-02T15:04:05Z)
+func DueDateOption(dueDate string) NodeOption {
+	return func(n *NodeDefinition) {
+		n.DueDate = dueDate
+	}
+}
+
+// PriorityOption 优先级
+func PriorityOption(priority int) NodeOption {
+	return func(n *NodeDefinition) {
+		n.Priority = strconv.Itoa(priority)
+	}
+}
+
+// FormKeyOption 表单标识
+func FormKeyOption(formKey string) NodeOption {
+	return func(n *NodeDefinition) {
+		n.FormKey = formKey
+	}
+}
+
+// DefinitionBuilder 流程定义构建器
+// 添加节点时从当前节点连接到新节点，并将新节点作为当前节点；
+// 添加已存在的节点时仅连接到该节点(用于汇合及回退)，From切换当前节点(用于网关的分支)
+type DefinitionBuilder struct {
+	def       *FlowDefinition
+	nodes     map[string]*NodeDefinition
+	current   *NodeDefinition
+	condition string
+	explain   string
+	err       error
+}
+
+// NewDefinition 创建流程定义构建器(flowID为流程编号，默认版本号为1)
+func NewDefinition(flowID string) *DefinitionBuilder {
+	return &DefinitionBuilder{
+		def: &FlowDefinition{
+			ID:      flowID,
+			Version: 1,
+		},
+		nodes: make(map[string]*NodeDefinition),
+	}
+}
+
+// Name 流程名称
+func (b *DefinitionBuilder) Name(name string) *DefinitionBuilder {
+	b.def.Name = name
+	return b
+}
+
+// Version 流程版本号
+func (b *DefinitionBuilder) Version(version int64) *DefinitionBuilder {
+	b.def.Version = version
+	return b
+}
+
+// Disabled 流程不可用
+func (b *DefinitionBuilder) Disabled() *DefinitionBuilder {
+	b.def.Status = 2
+	return b
+}
+
+// Start 开始事件(开始事件没有流入的连接，可以添加多个开始事件)
+func (b *DefinitionBuilder) Start(id string, opts ...NodeOption) *DefinitionBuilder {
+	b.current = nil
+	b.condition, b.explain = "", ""
+	return b.node(id, StartEvent, opts...)
+}
+
+// UserTask 人工任务
+func (b *DefinitionBuilder) UserTask(id string, opts ...NodeOption) *DefinitionBuilder {
+	return b.node(id, UserTask, opts...)
+}
+
+// ServiceTask 服务任务(外部任务，topic为外部任务主题)
+func (b *DefinitionBuilder) ServiceTask(id, topic string, opts ...NodeOption) *DefinitionBuilder {
+	return b.node(id, ServiceTask, append([]NodeOption{func(n *NodeDefinition) {
+		n.Topic = topic
+	}}, opts...)...)
+}
+
+// ExclusiveGateway 排他网关
+func (b *DefinitionBuilder) ExclusiveGateway(id string, opts ...NodeOption) *DefinitionBuilder {
+	return b.node(id, ExclusiveGateway, opts...)
+}
+
+// ParallelGateway 并行网关
+func (b *DefinitionBuilder) ParallelGateway(id string, opts ...NodeOption) *DefinitionBuilder {
+	return b.node(id, ParallelGateway, opts...)
+}
+
+// End 结束事件
+func (b *DefinitionBuilder) End(id string, opts ...NodeOption) *DefinitionBuilder {
+	return b.node(id, EndEvent, opts...)
+}
+
+// Terminate 终止事件
+func (b *DefinitionBuilder) Terminate(id string, opts ...NodeOption) *DefinitionBuilder {
+	return b.node(id, TerminateEvent, opts...)
+}
+
+// Condition 设定下一个连接的条件表达式及说明
+func (b *DefinitionBuilder) Condition(expression string, explain ...string) *DefinitionBuilder {
+	b.condition = expression
+	b.explain = strings.Join(explain, "")
+	return b
+}
+
+// From 将已添加的节点作为当前节点
+func (b *DefinitionBuilder) From(id string) *DefinitionBuilder {
+	n, ok := b.nodes[id]
+	if !ok {
+		b.setError(errors.Errorf("节点[%s]不存在", id))
+		return b
+	}
+	b.current = n
+	b.condition, b.explain = "", ""
+	return b
+}
+
+// To 从当前节点连接到节点(节点可以在之后添加)，并将该节点作为当前节点
+func (b *DefinitionBuilder) To(id string) *DefinitionBuilder {
+	b.connect(id)
+	if n, ok := b.nodes[id]; ok {
+		b.current = n
+	} else {
+		b.current = &NodeDefinition{ID: id}
+	}
+	return b
+}
+
+// Build 构建流程定义
+func (b *DefinitionBuilder) Build() (*FlowDefinition, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	for _, n := range b.def.Nodes {
+		for _, r := range n.Routers {
+			if _, ok := b.nodes[r.Target]; !ok {
+				return nil, errors.Errorf("节点[%s]连接的节点[%s]不存在", n.ID, r.Target)
+			}
+		}
+	}
+	return b.def, nil
+}
+
+// ParseResult 构建流程数据
+func (b *DefinitionBuilder) ParseResult() (*ParseResult, error) {
+	def, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return def.ParseResult()
+}
+
+func (b *DefinitionBuilder) setError(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// 添加节点(已存在时仅连接到该节点)
+func (b *DefinitionBuilder) node(id string, nodeType NodeType, opts ...NodeOption) *DefinitionBuilder {
+	// 当前节点为To连接的同一节点时不再连接
+	pending := b.current != nil && b.nodes[b.current.ID] == nil && b.current.ID == id
+
+	n, ok := b.nodes[id]
+	if ok {
+		if n.Type != nodeType {
+			b.setError(errors.Errorf("节点[%s]已存在(%s)", id, n.Type))
+			return b
+		}
+		for _, opt := range opts {
+			opt(n)
+		}
+	} else {
+		n = &NodeDefinition{ID: id, Type: nodeType}
+		for _, opt := range opts {
+			opt(n)
+		}
+		b.nodes[id] = n
+		b.def.Nodes = append(b.def.Nodes, n)
+	}
+
+	if b.current != nil && !pending {
+		b.connect(id)
+	}
+	b.current = n
+	return b
+}
+
+// 从当前节点连接到节点
+func (b *DefinitionBuilder) connect(id string) {
+	if b.current == nil {
+		b.setError(errors.Errorf("连接到节点[%s]前没有当前节点", id))
+		return
+	}
+
+	source, ok := b.nodes[b.current.ID]
+	if !ok {
+		b.setError(errors.Errorf("节点[%s]不存在", b.current.ID))
+		return
+	}
+
+	source.Routers = append(source.Routers, &RouterDefinition{
+		Target:     id,
+		Explain:    b.explain,
+		Expression: b.condition,
+	})
+	b.condition, b.explain = "", ""
+}
+
+// CreateFlowWithDefinition 部署流程定义(如DefinitionBuilder构建的流程定义)
+func (e *Engine) CreateFlowWithDefinition(ctx context.Context, def *FlowDefinition) (string, error) {
+	data, err := def.Marshal(FormatJSON)
+	if err != nil {
+		return "", err
+	}
+	return e.CreateFlowWithFormat(ctx, FormatJSON, data)
+}
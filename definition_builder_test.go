@@ -0,0 +1,56 @@
+package flow
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDefinitionBuilder(t *testing.T) {
+	def, err := NewDefinition("process_leave_builder").Name("请假").
+		Start("node_start").
+		UserTask("node_user_apply", NodeNameOption("填写请假申请"), CandidatesOption("flow.launcher")).
+		UserTask("node_user_bzr", CandidatesOption("input.bzr"), NodePropertyOption("cc", "11")).
+		ExclusiveGateway("node_gw_bzr").
+		Condition(`input.action=="back"`, "退回").To("node_user_apply").
+		From("node_gw_bzr").Condition(`input.day>3&&input.action=="pass"`).To("node_user_yld").
+		UserTask("node_user_yld", CandidatesOption("input.yld")).To("node_end").
+		From("node_gw_bzr").Condition(`input.day<=3&&input.action=="pass"`).End("node_end").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err := def.ParseResult()
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if problems := validateFlow(result, nil); HasProblemError(problems) {
+		t.Fatalf("流程定义存在问题：%v", problems)
+	} else if len(result.Nodes) != 6 {
+		t.Fatalf("节点数量错误：%d", len(result.Nodes))
+	}
+
+	for _, n := range result.Nodes {
+		if n.NodeID == "node_gw_bzr" && len(n.Routers) != 3 {
+			t.Fatalf("网关的路由数量错误：%d", len(n.Routers))
+		}
+	}
+
+	e := NewMemoryEngine()
+	e.SetStrictValidation(true)
+	flowID, err := e.CreateFlowWithDefinition(context.Background(), def)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result2, err := e.StartFlow(context.Background(), "process_leave_builder", "node_start", "T001", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if result2.FlowInstance.FlowID != flowID {
+		t.Fatalf("发起的流程错误：%s", result2.FlowInstance.FlowID)
+	}
+
+	_, err = NewDefinition("invalid").Start("s").To("missing").Build()
+	if err == nil {
+		t.Fatal("连接不存在的节点应返回错误")
+	}
+}
@@ -0,0 +1,514 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antlinker/flow/schema"
+	"github.com/beevik/etree"
+	"github.com/pkg/errors"
+)
+
+// ErrNoDiagram 流程定义中没有图形信息(BPMN DI)
+var ErrNoDiagram = errors.New("流程定义中没有图形信息")
+
+// DiagramHighlight 流程图中需要高亮的元素
+type DiagramHighlight struct {
+	Completed []string // 已完成的节点ID
+	Active    []string // 进行中的节点ID
+	Flows     []string // 已经过的顺序流ID
+}
+
+// RenderFlowDiagram 将流程的BPMN图形信息渲染为SVG
+func (e *Engine) RenderFlowDiagram(ctx context.Context, flowID string) ([]byte, error) {
+	flow, err := e.tenantBll(ctx).GetFlow(flowID)
+	if err != nil {
+		return nil, err
+	} else if flow == nil {
+		return nil, ErrNotFound
+	}
+	return RenderSVG([]byte(flow.XML), nil)
+}
+
+// RenderFlowInstanceDiagram 将流程实例的流程图渲染为SVG，高亮已完成的节点、进行中的节点及经过的顺序流
+func (e *Engine) RenderFlowInstanceDiagram(ctx context.Context, flowInstanceID string) ([]byte, error) {
+	flowBll := e.tenantBll(ctx)
+	flowInstance, err := flowBll.GetFlowInstance(flowInstanceID)
+	if err != nil {
+		return nil, err
+	} else if flowInstance == nil {
+		return nil, ErrNotFound
+	}
+
+	flow, err := flowBll.GetFlow(flowInstance.FlowID)
+	if err != nil {
+		return nil, err
+	} else if flow == nil {
+		return nil, ErrNotFound
+	}
+
+	nodes, err := flowBll.QueryNodes(flow.RecordID)
+	if err != nil {
+		return nil, err
+	}
+	codes := make(map[string]string)
+	types := make(map[string]string)
+	for _, n := range nodes {
+		codes[n.RecordID] = n.Code
+		types[n.Code] = n.TypeCode
+	}
+
+	nodeInstances, err := flowBll.QueryNodeInstances(flowInstance.RecordID)
+	if err != nil {
+		return nil, err
+	}
+
+	highlight := new(DiagramHighlight)
+	completed := make(map[string]bool)
+	active := make(map[string]bool)
+	for _, ni := range nodeInstances {
+		code, ok := codes[ni.NodeID]
+		if !ok {
+			continue
+		}
+		if ni.Status == 1 {
+			active[code] = true
+		} else {
+			completed[code] = true
+		}
+	}
+	for code := range completed {
+		// 存在进行中的节点实例时(如退回后再次到达)作为进行中的节点
+		if !active[code] {
+			highlight.Completed = append(highlight.Completed, code)
+		}
+	}
+	for code := range active {
+		highlight.Active = append(highlight.Active, code)
+	}
+	sort.Strings(highlight.Completed)
+	sort.Strings(highlight.Active)
+
+	data := []byte(flow.XML)
+	flows, err := parseDiagramFlows(data)
+	if err != nil {
+		return nil, err
+	}
+
+	highlight.Flows = takenFlows(flows, nodeInstances, codes, types)
+
+	return RenderSVG(data, highlight)
+}
+
+// 获取实际经过的顺序流ID
+// 节点实例记录了流转来的上一节点实例，按上一节点实例的节点到当前节点匹配顺序流；
+// 并行网关汇聚时只有最后到达的分支创建网关的节点实例，其余已完成且没有后续节点实例的分支流向已到达的并行网关；
+// 升级前创建的节点实例没有记录上一节点实例，按源节点已完成且目标节点已到达匹配
+func takenFlows(flows []*sequenceFlow, nodeInstances []*schema.NodeInstance, codes, types map[string]string) []string {
+	var (
+		instances = make(map[string]*schema.NodeInstance)
+		taken     = make(map[string]bool)
+		reached   = make(map[string]bool)
+		completed = make(map[string]bool)
+		waiting   = make(map[string]bool)
+		legacy    = make(map[string]bool)
+	)
+	for _, ni := range nodeInstances {
+		instances[ni.RecordID] = ni
+	}
+
+	hasNext := make(map[string]bool)
+	for _, ni := range nodeInstances {
+		code := codes[ni.NodeID]
+		reached[code] = true
+		if ni.Status != 1 {
+			completed[code] = true
+		}
+
+		if prev, ok := instances[ni.PrevID]; ok {
+			taken[codes[prev.NodeID]+"\x00"+code] = true
+			hasNext[prev.RecordID] = true
+		} else {
+			legacy[code] = true
+		}
+	}
+	for _, ni := range nodeInstances {
+		if ni.Status != 1 && !hasNext[ni.RecordID] {
+			waiting[codes[ni.NodeID]] = true
+		}
+	}
+
+	var items []string
+	for _, f := range flows {
+		switch {
+		case taken[f.SourceRef+"\x00"+f.TargetRef]:
+		case waiting[f.SourceRef] && reached[f.TargetRef] && types[f.TargetRef] == ParallelGateway.String():
+		case legacy[f.TargetRef] && completed[f.SourceRef]:
+		default:
+			continue
+		}
+		items = append(items, f.Code)
+	}
+	return items
+}
+
+// 解析流程XML中的顺序流(Explain为顺序流的名称)
+func parseDiagramFlows(data []byte) ([]*sequenceFlow, error) {
+	root, err := readDefinitions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var flows []*sequenceFlow
+	walkElements(root.SelectElements("process"), func(el *etree.Element) {
+		if el.Tag == "sequenceFlow" {
+			flows = append(flows, &sequenceFlow{
+				Code:      el.SelectAttrValue("id", ""),
+				SourceRef: el.SelectAttrValue("sourceRef", ""),
+				TargetRef: el.SelectAttrValue("targetRef", ""),
+				Explain:   el.SelectAttrValue("name", ""),
+			})
+		}
+	})
+	return flows, nil
+}
+
+func readDefinitions(data []byte) (*etree.Element, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, ErrNoDiagram
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, errors.Wrapf(err, "解析流程XML发生错误")
+	}
+	root := doc.SelectElement("definitions")
+	if root == nil {
+		return nil, errors.New("流程XML缺少definitions元素")
+	}
+	return root, nil
+}
+
+// 遍历元素及其所有子元素
+func walkElements(elements []*etree.Element, fn func(*etree.Element)) {
+	for _, el := range elements {
+		fn(el)
+		walkElements(el.ChildElements(), fn)
+	}
+}
+
+// 图形元素
+type diagramShape struct {
+	id         string
+	tag        string
+	name       string
+	terminate  bool
+	x, y, w, h float64
+	label      *diagramBounds
+	points     [][2]float64
+	isEdge     bool
+	highlight  string
+}
+
+type diagramBounds struct {
+	x, y, w, h float64
+}
+
+// RenderSVG 将BPMN XML中的图形信息(BPMN DI)渲染为SVG，highlight为空时不高亮
+// 元素使用data-element-id属性标识元素ID，已完成、进行中的节点及经过的顺序流分别使用completed、active、traversed样式
+func RenderSVG(data []byte, highlight *DiagramHighlight) ([]byte, error) {
+	root, err := readDefinitions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// 流程元素(包括子流程、泳道及参与者)
+	elements := make(map[string]*etree.Element)
+	walkElements(append(root.SelectElements("process"), root.SelectElements("collaboration")...), func(el *etree.Element) {
+		if id := el.SelectAttrValue("id", ""); id != "" {
+			elements[id] = el
+		}
+	})
+
+	classes := make(map[string]string)
+	if highlight != nil {
+		for _, id := range highlight.Completed {
+			classes[id] = "completed"
+		}
+		for _, id := range highlight.Active {
+			classes[id] = "active"
+		}
+		for _, id := range highlight.Flows {
+			classes[id] = "traversed"
+		}
+	}
+
+	var shapes []*diagramShape
+	for _, diagram := range root.SelectElements("BPMNDiagram") {
+		for _, plane := range diagram.SelectElements("BPMNPlane") {
+			for _, el := range plane.ChildElements() {
+				shape := parseDiagramShape(el, elements)
+				if shape != nil {
+					shape.highlight = classes[shape.id]
+					shapes = append(shapes, shape)
+				}
+			}
+		}
+	}
+	if len(shapes) == 0 {
+		return nil, ErrNoDiagram
+	}
+
+	return writeSVG(shapes), nil
+}
+
+func parseDiagramShape(el *etree.Element, elements map[string]*etree.Element) *diagramShape {
+	id := el.SelectAttrValue("bpmnElement", "")
+	shape := &diagramShape{id: id}
+	if e, ok := elements[id]; ok {
+		shape.tag = e.Tag
+		shape.name = e.SelectAttrValue("name", "")
+		shape.terminate = e.Tag == "endEvent" && e.SelectElement("terminateEventDefinition") != nil
+	}
+
+	if lb := el.SelectElement("BPMNLabel"); lb != nil {
+		if b := lb.SelectElement("Bounds"); b != nil {
+			bounds := parseBounds(b)
+			shape.label = &bounds
+		}
+	}
+
+	switch el.Tag {
+	case "BPMNShape":
+		b := el.SelectElement("Bounds")
+		if b == nil {
+			return nil
+		}
+		bounds := parseBounds(b)
+		shape.x, shape.y, shape.w, shape.h = bounds.x, bounds.y, bounds.w, bounds.h
+	case "BPMNEdge":
+		shape.isEdge = true
+		for _, p := range el.SelectElements("waypoint") {
+			shape.points = append(shape.points, [2]float64{attrFloat(p, "x"), attrFloat(p, "y")})
+		}
+		if len(shape.points) < 2 {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return shape
+}
+
+func parseBounds(el *etree.Element) diagramBounds {
+	return diagramBounds{
+		x: attrFloat(el, "x"),
+		y: attrFloat(el, "y"),
+		w: attrFloat(el, "width"),
+		h: attrFloat(el, "height"),
+	}
+}
+
+func attrFloat(el *etree.Element, name string) float64 {
+	v, _ := strconv.ParseFloat(el.SelectAttrValue(name, "0"), 64)
+	return v
+}
+
+const diagramStyle = `
+.shape{fill:#fff;stroke:#333;stroke-width:2}
+.pool{fill:none;stroke:#333;stroke-width:1.5}
+.edge{fill:none;stroke:#333;stroke-width:1.5}
+.marker{fill:#333}
+.end .shape{stroke-width:4}
+.completed .shape{fill:#f6ffed;stroke:#52c41a}
+.active .shape{fill:#e6f7ff;stroke:#1890ff;stroke-width:3}
+.completed.end .shape,.active.end .shape{stroke-width:4}
+.traversed .edge{stroke:#52c41a;stroke-width:2.5}
+.icon{fill:none;stroke:#333;stroke-width:2.5}
+.completed .icon{stroke:#52c41a}
+.active .icon{stroke:#1890ff}
+text{font-family:Arial,sans-serif;font-size:12px;fill:#000}
+`
+
+// 输出SVG
+func writeSVG(shapes []*diagramShape) []byte {
+	minX, minY := math.MaxFloat64, math.MaxFloat64
+	maxX, maxY := -math.MaxFloat64, -math.MaxFloat64
+	extend := func(x, y float64) {
+		minX, minY = math.Min(minX, x), math.Min(minY, y)
+		maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+	}
+	for _, s := range shapes {
+		if s.isEdge {
+			for _, p := range s.points {
+				extend(p[0], p[1])
+			}
+		} else {
+			extend(s.x, s.y)
+			extend(s.x+s.w, s.y+s.h)
+		}
+		if s.label != nil {
+			extend(s.label.x, s.label.y)
+			extend(s.label.x+s.label.w, s.label.y+s.label.h)
+		}
+	}
+
+	const margin = 20
+	minX, minY = minX-margin, minY-margin
+	width, height := maxX-minX+margin, maxY-minY+margin
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="%s %s %s %s">`,
+		svgNum(width), svgNum(height), svgNum(minX), svgNum(minY), svgNum(width), svgNum(height))
+	buf.WriteString("\n<defs>")
+	for _, m := range []struct{ id, color string }{{"arrow", "#333"}, {"arrow-traversed", "#52c41a"}} {
+		fmt.Fprintf(&buf, `<marker id="%s" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto"><path d="M0,0L10,5L0,10z" fill="%s"/></marker>`, m.id, m.color)
+	}
+	fmt.Fprintf(&buf, "<style>%s</style></defs>\n", diagramStyle)
+
+	// 先绘制泳道，再绘制节点，最后绘制顺序流
+	for _, pass := range []func(*diagramShape) bool{
+		func(s *diagramShape) bool { return !s.isEdge && isPoolTag(s.tag) },
+		func(s *diagramShape) bool { return !s.isEdge && !isPoolTag(s.tag) },
+		func(s *diagramShape) bool { return s.isEdge },
+	} {
+		for _, s := range shapes {
+			if pass(s) {
+				writeShape(&buf, s)
+			}
+		}
+	}
+
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+func isPoolTag(tag string) bool {
+	return tag == "participant" || tag == "lane"
+}
+
+func writeShape(buf *bytes.Buffer, s *diagramShape) {
+	class := strings.TrimSpace(fmt.Sprintf("element %s %s", s.tag, s.highlight))
+	if s.tag == "endEvent" {
+		class += " end"
+	}
+	fmt.Fprintf(buf, `<g class="%s" data-element-id="%s">`, class, svgEscape(s.id))
+	if s.name != "" {
+		fmt.Fprintf(buf, "<title>%s</title>", svgEscape(s.name))
+	}
+
+	cx, cy := s.x+s.w/2, s.y+s.h/2
+	switch {
+	case s.isEdge:
+		var points []string
+		for _, p := range s.points {
+			points = append(points, svgNum(p[0])+","+svgNum(p[1]))
+		}
+		marker := "arrow"
+		if s.highlight == "traversed" {
+			marker = "arrow-traversed"
+		}
+		fmt.Fprintf(buf, `<polyline class="edge" points="%s" marker-end="url(#%s)"/>`, strings.Join(points, " "), marker)
+		writeLabel(buf, s)
+	case isPoolTag(s.tag):
+		fmt.Fprintf(buf, `<rect class="pool" x="%s" y="%s" width="%s" height="%s"/>`, svgNum(s.x), svgNum(s.y), svgNum(s.w), svgNum(s.h))
+		if s.name != "" {
+			// 名称竖向显示在左侧
+			fmt.Fprintf(buf, `<text x="%s" y="%s" text-anchor="middle" transform="rotate(-90 %s %s)">%s</text>`,
+				svgNum(s.x+15), svgNum(cy), svgNum(s.x+15), svgNum(cy), svgEscape(s.name))
+		}
+	case strings.HasSuffix(s.tag, "Event"):
+		r := math.Min(s.w, s.h) / 2
+		fmt.Fprintf(buf, `<circle class="shape" cx="%s" cy="%s" r="%s"/>`, svgNum(cx), svgNum(cy), svgNum(r))
+		if s.terminate {
+			fmt.Fprintf(buf, `<circle class="marker" cx="%s" cy="%s" r="%s"/>`, svgNum(cx), svgNum(cy), svgNum(r*0.6))
+		}
+		writeLabel(buf, s)
+	case strings.HasSuffix(s.tag, "Gateway"):
+		fmt.Fprintf(buf, `<polygon class="shape" points="%s,%s %s,%s %s,%s %s,%s"/>`,
+			svgNum(cx), svgNum(s.y), svgNum(s.x+s.w), svgNum(cy), svgNum(cx), svgNum(s.y+s.h), svgNum(s.x), svgNum(cy))
+		d := math.Min(s.w, s.h) / 5
+		switch s.tag {
+		case "exclusiveGateway":
+			fmt.Fprintf(buf, `<path class="icon" d="M%s,%sL%s,%sM%s,%sL%s,%s"/>`,
+				svgNum(cx-d), svgNum(cy-d), svgNum(cx+d), svgNum(cy+d), svgNum(cx+d), svgNum(cy-d), svgNum(cx-d), svgNum(cy+d))
+		case "parallelGateway":
+			fmt.Fprintf(buf, `<path class="icon" d="M%s,%sL%s,%sM%s,%sL%s,%s"/>`,
+				svgNum(cx), svgNum(cy-d*1.4), svgNum(cx), svgNum(cy+d*1.4), svgNum(cx-d*1.4), svgNum(cy), svgNum(cx+d*1.4), svgNum(cy))
+		case "inclusiveGateway", "eventBasedGateway":
+			fmt.Fprintf(buf, `<circle class="icon" cx="%s" cy="%s" r="%s"/>`, svgNum(cx), svgNum(cy), svgNum(d*1.2))
+		}
+		writeLabel(buf, s)
+	default:
+		fmt.Fprintf(buf, `<rect class="shape" x="%s" y="%s" width="%s" height="%s" rx="10" ry="10"/>`, svgNum(s.x), svgNum(s.y), svgNum(s.w), svgNum(s.h))
+		lines := wrapText(s.name, s.w-10)
+		top := cy - float64(len(lines)-1)*7
+		for i, line := range lines {
+			fmt.Fprintf(buf, `<text x="%s" y="%s" text-anchor="middle" dominant-baseline="middle">%s</text>`,
+				svgNum(cx), svgNum(top+float64(i)*14), svgEscape(line))
+		}
+	}
+	buf.WriteString("</g>\n")
+}
+
+// 在标签位置输出名称(没有标签位置时输出在图形下方)
+func writeLabel(buf *bytes.Buffer, s *diagramShape) {
+	if s.name == "" {
+		return
+	}
+
+	var x, y float64
+	switch {
+	case s.label != nil:
+		x, y = s.label.x+s.label.w/2, s.label.y+s.label.h/2
+	case s.isEdge:
+		p := s.points[len(s.points)/2]
+		x, y = p[0], p[1]-8
+	default:
+		x, y = s.x+s.w/2, s.y+s.h+12
+	}
+	fmt.Fprintf(buf, `<text x="%s" y="%s" text-anchor="middle" dominant-baseline="middle">%s</text>`, svgNum(x), svgNum(y), svgEscape(s.name))
+}
+
+// 按宽度拆分文本(中文字符按12像素，其他字符按7像素计算)
+func wrapText(text string, width float64) []string {
+	var (
+		lines []string
+		line  []rune
+		w     float64
+	)
+	for _, r := range text {
+		cw := 7.0
+		if r > 0x7f {
+			cw = 12
+		}
+		if r == '\n' || (w+cw > width && len(line) > 0) {
+			lines = append(lines, string(line))
+			line, w = nil, 0
+			if r == '\n' {
+				continue
+			}
+		}
+		line = append(line, r)
+		w += cw
+	}
+	if len(line) > 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+func svgNum(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+var svgReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&#39;")
+
+func svgEscape(s string) string {
+	return svgReplacer.Replace(s)
+}
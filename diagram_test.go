@@ -0,0 +1,166 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/antlinker/flow/schema"
+)
+
+const diagramTestXML = `<?xml version="1.0" encoding="UTF-8"?>
+<bpmn:definitions xmlns:bpmn="http://www.omg.org/spec/BPMN/20100524/MODEL" xmlns:bpmndi="http://www.omg.org/spec/BPMN/20100524/DI" xmlns:di="http://www.omg.org/spec/DD/20100524/DI" xmlns:dc="http://www.omg.org/spec/DD/20100524/DC" xmlns:camunda="http://camunda.org/schema/1.0/bpmn">
+  <bpmn:process id="process_diagram" isExecutable="true">
+    <bpmn:startEvent id="start" name="开始" />
+    <bpmn:userTask id="apply" name="填写申请" camunda:assignee="T000" />
+    <bpmn:userTask id="audit" name="审核&lt;A&gt;" camunda:assignee="T001" />
+    <bpmn:endEvent id="end" name="结束" />
+    <bpmn:sequenceFlow id="flow1" sourceRef="start" targetRef="apply" />
+    <bpmn:sequenceFlow id="flow2" sourceRef="apply" targetRef="audit" />
+    <bpmn:sequenceFlow id="flow3" sourceRef="audit" targetRef="end" />
+  </bpmn:process>
+  <bpmndi:BPMNDiagram id="BPMNDiagram_1">
+    <bpmndi:BPMNPlane id="BPMNPlane_1" bpmnElement="process_diagram">
+      <bpmndi:BPMNShape id="start_di" bpmnElement="start">
+        <dc:Bounds x="100" y="100" width="36" height="36" />
+      </bpmndi:BPMNShape>
+      <bpmndi:BPMNShape id="apply_di" bpmnElement="apply">
+        <dc:Bounds x="200" y="78" width="100" height="80" />
+      </bpmndi:BPMNShape>
+      <bpmndi:BPMNShape id="audit_di" bpmnElement="audit">
+        <dc:Bounds x="360" y="78" width="100" height="80" />
+      </bpmndi:BPMNShape>
+      <bpmndi:BPMNShape id="end_di" bpmnElement="end">
+        <dc:Bounds x="520" y="100" width="36" height="36" />
+      </bpmndi:BPMNShape>
+      <bpmndi:BPMNEdge id="flow1_di" bpmnElement="flow1">
+        <di:waypoint x="136" y="118" />
+        <di:waypoint x="200" y="118" />
+      </bpmndi:BPMNEdge>
+      <bpmndi:BPMNEdge id="flow2_di" bpmnElement="flow2">
+        <di:waypoint x="300" y="118" />
+        <di:waypoint x="360" y="118" />
+      </bpmndi:BPMNEdge>
+      <bpmndi:BPMNEdge id="flow3_di" bpmnElement="flow3">
+        <di:waypoint x="460" y="118" />
+        <di:waypoint x="520" y="118" />
+      </bpmndi:BPMNEdge>
+    </bpmndi:BPMNPlane>
+  </bpmndi:BPMNDiagram>
+</bpmn:definitions>`
+
+func TestRenderSVG(t *testing.T) {
+	data, err := ioutil.ReadFile("test_data/leave.bpmn")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	svg, err := RenderSVG(data, &DiagramHighlight{Completed: []string{"node_start"}, Active: []string{"node_user_apply"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkSVG(t, svg)
+	if !strings.Contains(string(svg), `class="element startEvent completed" data-element-id="node_start"`) ||
+		!strings.Contains(string(svg), `class="element userTask active" data-element-id="node_user_apply"`) {
+		t.Fatalf("高亮的节点错误：%s", svg)
+	}
+
+	if _, err := RenderSVG([]byte(`<definitions><process id="p"/></definitions>`), nil); err != ErrNoDiagram {
+		t.Fatalf("没有图形信息时应返回ErrNoDiagram：%v", err)
+	}
+}
+
+func TestRenderFlowInstanceDiagram(t *testing.T) {
+	ctx := context.Background()
+	e := NewMemoryEngine()
+	_, err := e.CreateFlowWithContext(ctx, []byte(diagramTestXML))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err := e.StartFlow(ctx, "process_diagram", "start", "T000", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	svg, err := e.RenderFlowInstanceDiagram(ctx, result.FlowInstance.RecordID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	checkSVG(t, svg)
+
+	for _, s := range []string{
+		`class="element startEvent completed" data-element-id="start"`,
+		`class="element userTask completed" data-element-id="apply"`,
+		`class="element userTask active" data-element-id="audit"`,
+		`class="element endEvent end" data-element-id="end"`,
+		`class="element sequenceFlow traversed" data-element-id="flow2"`,
+		`class="element sequenceFlow" data-element-id="flow3"`,
+		`审核&lt;A&gt;`,
+	} {
+		if !strings.Contains(string(svg), s) {
+			t.Fatalf("流程图缺少[%s]：%s", s, svg)
+		}
+	}
+}
+
+func TestTakenFlows(t *testing.T) {
+	flows := []*sequenceFlow{
+		{Code: "f1", SourceRef: "start", TargetRef: "apply"},
+		{Code: "f2", SourceRef: "apply", TargetRef: "gw"},
+		{Code: "f3", SourceRef: "gw", TargetRef: "fork"},
+		{Code: "f4", SourceRef: "gw", TargetRef: "apply"},
+		{Code: "f5", SourceRef: "fork", TargetRef: "b1"},
+		{Code: "f6", SourceRef: "fork", TargetRef: "b2"},
+		{Code: "f7", SourceRef: "b1", TargetRef: "join"},
+		{Code: "f8", SourceRef: "b2", TargetRef: "join"},
+		{Code: "f9", SourceRef: "join", TargetRef: "end"},
+	}
+	codes := make(map[string]string)
+	for _, code := range []string{"start", "apply", "gw", "fork", "b1", "b2", "join", "end"} {
+		codes[code] = code
+	}
+	types := map[string]string{"fork": ParallelGateway.String(), "join": ParallelGateway.String()}
+
+	// 排他网关选择了f3，不经过退回的f4；并行网关汇聚时b1没有创建后续节点实例
+	nodeInstances := []*schema.NodeInstance{
+		{RecordID: "N1", NodeID: "start", Status: 2},
+		{RecordID: "N2", NodeID: "apply", PrevID: "N1", Status: 2},
+		{RecordID: "N3", NodeID: "gw", PrevID: "N2", Status: 2},
+		{RecordID: "N4", NodeID: "fork", PrevID: "N3", Status: 2},
+		{RecordID: "N5", NodeID: "b1", PrevID: "N4", Status: 2},
+		{RecordID: "N6", NodeID: "b2", PrevID: "N4", Status: 2},
+		{RecordID: "N7", NodeID: "join", PrevID: "N6", Status: 1},
+	}
+	if items := takenFlows(flows, nodeInstances, codes, types); fmt.Sprint(items) != "[f1 f2 f3 f5 f6 f7 f8]" {
+		t.Fatalf("无效的顺序流：%v", items)
+	}
+
+	// 没有记录上一节点实例时按源节点已完成且目标节点已到达匹配
+	nodeInstances = []*schema.NodeInstance{
+		{RecordID: "N1", NodeID: "start", Status: 2},
+		{RecordID: "N2", NodeID: "apply", Status: 2},
+		{RecordID: "N3", NodeID: "gw", Status: 1},
+	}
+	if items := takenFlows(flows, nodeInstances, codes, types); fmt.Sprint(items) != "[f1 f2]" {
+		t.Fatalf("无效的顺序流：%v", items)
+	}
+}
+
+// 检查SVG是否为有效的XML
+func checkSVG(t *testing.T, svg []byte) {
+	d := xml.NewDecoder(bytes.NewReader(svg))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatalf("无效的SVG：%v", err)
+		}
+	}
+}
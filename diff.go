@@ -0,0 +1,268 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// 差异类型
+const (
+	DiffAdded   = "added"   // 新增
+	DiffRemoved = "removed" // 删除
+	DiffChanged = "changed" // 修改
+)
+
+// 差异的元素类型
+const (
+	DiffElementNode   = "node"   // 节点
+	DiffElementRouter = "router" // 顺序流
+)
+
+// ErrDiffFlowCode 比较的流程编号不同
+var ErrDiffFlowCode = errors.New("只能比较相同流程编号的流程版本")
+
+// FlowDiff 两个流程版本的差异
+type FlowDiff struct {
+	FlowCode    string        `json:"flow_code"`    // 流程编号
+	FromFlowID  string        `json:"from_flow_id"` // 原版本的流程内码
+	FromVersion int64         `json:"from_version"` // 原版本号
+	ToFlowID    string        `json:"to_flow_id"`   // 新版本的流程内码
+	ToVersion   int64         `json:"to_version"`   // 新版本号
+	Changes     []*DiffChange `json:"changes"`      // 差异
+	Breaking    bool          `json:"breaking"`     // 是否存在影响流程实例迁移的差异
+}
+
+// DiffChange 元素的差异
+type DiffChange struct {
+	ElementID   string `json:"element_id"`         // 元素ID(节点ID或顺序流ID)
+	ElementType string `json:"element_type"`       // 元素类型(node/router)
+	Kind        string `json:"kind"`               // 差异类型(added/removed/changed)
+	Field       string `json:"field,omitempty"`    // 修改的字段(如：name、type、candidates、expression、form、property:名称)
+	From        string `json:"from,omitempty"`     // 原版本的值
+	To          string `json:"to,omitempty"`       // 新版本的值
+	Breaking    bool   `json:"breaking,omitempty"` // 是否影响流程实例迁移
+	Reason      string `json:"reason,omitempty"`   // 影响流程实例迁移的原因
+}
+
+// DiffFlowVersions 比较相同流程编号的两个流程版本，按元素ID返回新增、删除及修改的节点、顺序流
+// 删除等待处理的节点(人工任务、服务任务及异步继续的节点)、修改节点类型时，进行中的流程实例不能自动迁移(Breaking)
+func (e *Engine) DiffFlowVersions(ctx context.Context, fromFlowID, toFlowID string) (*FlowDiff, error) {
+	flowBll := e.tenantBll(ctx)
+	from, err := flowBll.GetFlow(fromFlowID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := flowBll.GetFlow(toFlowID)
+	if err != nil {
+		return nil, err
+	}
+	if from == nil || to == nil {
+		return nil, ErrNotFound
+	} else if from.Code != to.Code {
+		return nil, ErrDiffFlowCode
+	}
+
+	fromDef, err := e.GetFlowDefinition(ctx, fromFlowID)
+	if err != nil {
+		return nil, err
+	}
+	toDef, err := e.GetFlowDefinition(ctx, toFlowID)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &FlowDiff{
+		FlowCode:    from.Code,
+		FromFlowID:  from.RecordID,
+		FromVersion: from.Version,
+		ToFlowID:    to.RecordID,
+		ToVersion:   to.Version,
+	}
+	diff.Changes = diffDefinitions(fromDef, toDef, []byte(from.XML), []byte(to.XML))
+	for _, c := range diff.Changes {
+		if c.Breaking {
+			diff.Breaking = true
+			break
+		}
+	}
+	return diff, nil
+}
+
+// 比较流程定义，fromXML、toXML为保存的流程XML(用于获取顺序流ID，可以为空)
+func diffDefinitions(from, to *FlowDefinition, fromXML, toXML []byte) []*DiffChange {
+	var changes []*DiffChange
+
+	fromNodes := make(map[string]*NodeDefinition)
+	for _, n := range from.Nodes {
+		fromNodes[n.ID] = n
+	}
+	toNodes := make(map[string]*NodeDefinition)
+	for _, n := range to.Nodes {
+		toNodes[n.ID] = n
+	}
+
+	for _, n := range to.Nodes {
+		old, ok := fromNodes[n.ID]
+		if !ok {
+			changes = append(changes, &DiffChange{ElementID: n.ID, ElementType: DiffElementNode, Kind: DiffAdded, To: n.Type.String()})
+			continue
+		}
+		changes = append(changes, diffNode(old, n)...)
+	}
+	for _, n := range from.Nodes {
+		if _, ok := toNodes[n.ID]; ok {
+			continue
+		}
+		c := &DiffChange{ElementID: n.ID, ElementType: DiffElementNode, Kind: DiffRemoved, From: n.Type.String()}
+		if isWaitNode(n) {
+			c.Breaking = true
+			c.Reason = "在该节点等待处理的流程实例需要指定映射的节点"
+		}
+		changes = append(changes, c)
+	}
+
+	fromRouters := diffRouters(from, fromXML)
+	toRouters := diffRouters(to, toXML)
+	fromKeys := make(map[string]*diffRouter)
+	for _, r := range fromRouters {
+		fromKeys[r.key] = r
+	}
+	toKeys := make(map[string]bool)
+	for _, r := range toRouters {
+		toKeys[r.key] = true
+		old, ok := fromKeys[r.key]
+		if !ok {
+			changes = append(changes, &DiffChange{ElementID: r.id, ElementType: DiffElementRouter, Kind: DiffAdded, To: r.String()})
+			continue
+		}
+		for _, f := range []struct{ name, from, to string }{
+			{"expression", old.router.Expression, r.router.Expression},
+			{"explain", old.router.Explain, r.router.Explain},
+		} {
+			if f.from != f.to {
+				changes = append(changes, &DiffChange{ElementID: r.id, ElementType: DiffElementRouter, Kind: DiffChanged, Field: f.name, From: f.from, To: f.to})
+			}
+		}
+	}
+	for _, r := range fromRouters {
+		if !toKeys[r.key] {
+			changes = append(changes, &DiffChange{ElementID: r.id, ElementType: DiffElementRouter, Kind: DiffRemoved, From: r.String()})
+		}
+	}
+
+	return changes
+}
+
+// 比较节点
+func diffNode(from, to *NodeDefinition) []*DiffChange {
+	var changes []*DiffChange
+	add := func(field, fromValue, toValue string) *DiffChange {
+		c := &DiffChange{ElementID: to.ID, ElementType: DiffElementNode, Kind: DiffChanged, Field: field, From: fromValue, To: toValue}
+		changes = append(changes, c)
+		return c
+	}
+
+	for _, f := range []struct{ name, from, to string }{
+		{"name", from.Name, to.Name},
+		{"type", from.Type.String(), to.Type.String()},
+		{"candidates", strings.Join(nonEmpty(from.Candidates), ";"), strings.Join(nonEmpty(to.Candidates), ";")},
+		{"assignee", from.Assignee, to.Assignee},
+		{"candidate_groups", from.CandidateGroups, to.CandidateGroups},
+		{"due_date", from.DueDate, to.DueDate},
+		{"priority", from.Priority, to.Priority},
+		{"form_key", from.FormKey, to.FormKey},
+		{"topic", from.Topic, to.Topic},
+		{"async_before", strconv.FormatBool(from.AsyncBefore), strconv.FormatBool(to.AsyncBefore)},
+		{"async_after", strconv.FormatBool(from.AsyncAfter), strconv.FormatBool(to.AsyncAfter)},
+		{"form", diffForm(from.Form), diffForm(to.Form)},
+	} {
+		if f.from == f.to {
+			continue
+		}
+		c := add(f.name, f.from, f.to)
+		if f.name == "type" {
+			c.Breaking = true
+			c.Reason = "节点类型不同时不能映射流程实例的节点"
+		}
+	}
+
+	fromProps := make(map[string]string)
+	for _, p := range from.Properties {
+		fromProps[p.Name] = p.Value
+	}
+	toProps := make(map[string]bool)
+	for _, p := range to.Properties {
+		toProps[p.Name] = true
+		if v, ok := fromProps[p.Name]; !ok || v != p.Value {
+			add("property:"+p.Name, v, p.Value)
+		}
+	}
+	for _, p := range from.Properties {
+		if !toProps[p.Name] {
+			add("property:"+p.Name, p.Value, "")
+		}
+	}
+	return changes
+}
+
+func diffForm(form *FormDefinition) string {
+	if form == nil {
+		return ""
+	}
+	buf, _ := json.Marshal(form)
+	return string(buf)
+}
+
+// 是否为流程实例等待处理的节点
+func isWaitNode(n *NodeDefinition) bool {
+	return n.Type == UserTask || n.Type == ServiceTask || n.AsyncBefore || n.AsyncAfter
+}
+
+type diffRouter struct {
+	key    string // 源节点ID、目标节点ID及序号
+	id     string // 顺序流ID
+	source string
+	router *RouterDefinition
+}
+
+func (r *diffRouter) String() string {
+	s := fmt.Sprintf("%s->%s", r.source, r.router.Target)
+	if r.router.Expression != "" {
+		s = fmt.Sprintf("%s(%s)", s, r.router.Expression)
+	}
+	return s
+}
+
+// 获取流程定义的顺序流(源节点及目标节点相同的顺序流按顺序区分)
+func diffRouters(def *FlowDefinition, data []byte) []*diffRouter {
+	di := parseBPMNDiagram(data)
+	counts := make(map[string]int)
+
+	var routers []*diffRouter
+	for _, n := range def.Nodes {
+		for _, r := range n.Routers {
+			pair := n.ID + "->" + r.Target
+			counts[pair]++
+
+			id := r.ID
+			if id == "" {
+				id = di.flowID(n.ID, r.Target)
+			}
+			if id == "" {
+				id = fmt.Sprintf("%s_%s", n.ID, r.Target)
+			}
+			routers = append(routers, &diffRouter{
+				key:    fmt.Sprintf("%s#%d", pair, counts[pair]),
+				id:     id,
+				source: n.ID,
+				router: r,
+			})
+		}
+	}
+	return routers
+}
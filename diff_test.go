@@ -0,0 +1,97 @@
+package flow
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDiffFlowVersions(t *testing.T) {
+	ctx := context.Background()
+	e := NewMemoryEngine()
+
+	v1, err := NewDefinition("process_diff").Version(1).
+		Start("start").
+		UserTask("apply", CandidatesOption("flow.launcher")).
+		UserTask("audit", CandidatesOption("input.bzr"), NodePropertyOption("cc", "11")).
+		ExclusiveGateway("gw").
+		Condition(`input.action=="back"`).To("apply").
+		From("gw").Condition(`input.day>3&&input.action=="pass"`).UserTask("leader", CandidatesOption("input.yld")).To("end").
+		From("gw").Condition(`input.day<=3&&input.action=="pass"`).End("end").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fromID, err := e.CreateFlowWithDefinition(ctx, v1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	v2, err := NewDefinition("process_diff").Version(2).
+		Start("start").
+		UserTask("apply", CandidatesOption("flow.launcher")).
+		UserTask("audit", CandidatesOption("input.bzr", "input.fdy"), NodePropertyOption("cc", "12"), PriorityOption(80)).
+		ExclusiveGateway("gw").
+		Condition(`input.action=="back"`).To("apply").
+		From("gw").Condition(`input.action=="pass"`).ServiceTask("notify", "notice").End("end").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	toID, err := e.CreateFlowWithDefinition(ctx, v2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	diff, err := e.DiffFlowVersions(ctx, fromID, toID)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if diff.FromVersion != 1 || diff.ToVersion != 2 || !diff.Breaking {
+		t.Fatalf("比较结果错误：%+v", diff)
+	}
+
+	expects := map[string]bool{
+		"node:audit:changed:candidates":  true,
+		"node:audit:changed:property:cc": true,
+		"node:audit:changed:priority":    true,
+		"node:notify:added:":             true,
+		"node:leader:removed:":           true,
+		"router:gw_notify:added:":        true,
+		"router:gw_leader:removed:":      true,
+		"router:gw_end:removed:":         true,
+		"router:leader_end:removed:":     true,
+		"router:notify_end:added:":       true,
+	}
+	for _, c := range diff.Changes {
+		key := c.ElementType + ":" + c.ElementID + ":" + c.Kind + ":" + c.Field
+		if !expects[key] {
+			t.Fatalf("多余的差异：%+v", c)
+		}
+		delete(expects, key)
+		if c.ElementID == "leader" && !c.Breaking {
+			t.Fatal("删除人工任务应影响流程实例迁移")
+		}
+	}
+	if len(expects) > 0 {
+		t.Fatalf("缺少差异：%v", expects)
+	}
+
+	_, err = e.DiffFlowVersions(ctx, fromID, fromID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	other, err := e.CreateFlowWithDefinition(ctx, mustBuild(t, NewDefinition("process_other").Start("start").End("end")))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err = e.DiffFlowVersions(ctx, fromID, other); err != ErrDiffFlowCode {
+		t.Fatalf("比较不同的流程编号应返回ErrDiffFlowCode：%v", err)
+	}
+}
+
+func mustBuild(t *testing.T, b *DefinitionBuilder) *FlowDefinition {
+	def, err := b.Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return def
+}
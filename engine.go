@@ -5,12 +5,15 @@ import (
 	"database/sql"
 	"encoding/json"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/antlinker/flow/bll"
+	"github.com/antlinker/flow/model"
 	"github.com/antlinker/flow/register"
 	"github.com/antlinker/flow/schema"
 	"github.com/antlinker/flow/service/db"
@@ -20,24 +23,47 @@ import (
 	"github.com/pkg/errors"
 )
 
+// 定义错误
+var (
+	ErrBusinessKeyExists = errors.New("业务主键已存在进行中的流程实例")
+	ErrFlowSuspended     = errors.New("流程实例已暂停")
+)
+
 // Engine 流程引擎
 type Engine struct {
 	flowBll *bll.Flow
 	parser  Parser
 	execer  Execer
+
+	lock               sync.RWMutex
+	uniqueBusinessKeys map[string]bool
+	listeners          []*listenerEntry
+	outbox             bool
+	outboxTypes        []EventType
+	retries            int
+	backoff            func(attempts int) time.Duration
+	db                 *db.DB
+	autoMigrate        bool
+	strictValidation   bool
+	manualActivation   bool
+	groupResolver      GroupResolver
 }
 
-// Init 初始化流程引擎
+// Init 初始化流程引擎(使用MySQL存储)
 func (e *Engine) Init(parser Parser, execer Execer, sqlDB *sql.DB, trace bool) (*Engine, error) {
+	return e.InitWithDialect(parser, execer, db.DialectMySQL, sqlDB, trace)
+}
 
+// InitWithDialect 使用指定的数据库方言初始化流程引擎(db.DialectMySQL/db.DialectPostgres/db.DialectSQLite)
+func (e *Engine) InitWithDialect(parser Parser, execer Execer, dialect string, sqlDB *sql.DB, trace bool) (*Engine, error) {
 	var (
-		g       inject.Graph
-		flowBll bll.Flow
+		g         inject.Graph
+		flowModel model.Flow
 	)
 
-	db := db.NewMySQLWithDB(sqlDB, trace)
+	db := db.NewWithDB(dialect, sqlDB, trace)
 	err := g.Provide(&inject.Object{Value: db},
-		&inject.Object{Value: &flowBll})
+		&inject.Object{Value: &flowModel})
 	if err != nil {
 		return e, err
 	}
@@ -53,12 +79,36 @@ func (e *Engine) Init(parser Parser, execer Execer, sqlDB *sql.DB, trace bool) (
 		return e, err
 	}
 
-	e.flowBll = &flowBll
+	e.db = db
+	if e.autoMigrate {
+		_, err = e.Migrate(false)
+		if err != nil {
+			return e, err
+		}
+	}
+
+	return e.InitWithRepository(parser, execer, &flowModel)
+}
+
+// InitWithRepository 使用指定的存储初始化流程引擎
+func (e *Engine) InitWithRepository(parser Parser, execer Execer, repo model.Repository) (*Engine, error) {
+	if repo == nil {
+		return e, errors.New("无效的流程数据存储")
+	}
+
+	e.flowBll = &bll.Flow{FlowModel: repo}
 	e.parser = parser
 	e.execer = execer
 	return e, nil
 }
 
+// NewMemoryEngine 创建使用内存存储的流程引擎(适用于测试及嵌入式场景，数据不持久化)
+func NewMemoryEngine() *Engine {
+	e, _ := new(Engine).InitWithRepository(NewXMLParser(), NewQLangExecer(), model.NewMemory())
+	regSQL()
+	return e
+}
+
 // SetParser 设定解析器
 func (e *Engine) SetParser(parser Parser) {
 	e.parser = parser
@@ -69,11 +119,34 @@ func (e *Engine) SetExecer(execer Execer) {
 	e.execer = execer
 }
 
+// SetBusinessKeyUnique 设定流程编号下的业务主键是否唯一
+// 唯一时，同一业务主键存在进行中的流程实例则不允许再次发起
+func (e *Engine) SetBusinessKeyUnique(flowCode string, unique bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.uniqueBusinessKeys == nil {
+		e.uniqueBusinessKeys = make(map[string]bool)
+	}
+	e.uniqueBusinessKeys[flowCode] = unique
+}
+
+func (e *Engine) isBusinessKeyUnique(flowCode string) bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.uniqueBusinessKeys[flowCode]
+}
+
 // FlowBll 流程业务
 func (e *Engine) FlowBll() *bll.Flow {
 	return e.flowBll
 }
 
+// 获取上下文中租户的流程业务
+func (e *Engine) tenantBll(ctx context.Context) *bll.Flow {
+	return e.flowBll.WithTenant(TenantFromContext(ctx))
+}
+
 func (e *Engine) parseFile(name string) ([]byte, error) {
 	fullName, err := filepath.Abs(name)
 	if err != nil {
@@ -94,15 +167,41 @@ func (e *Engine) parseFile(name string) ([]byte, error) {
 }
 
 // LoadFile 加载文件数据
+// 按文件扩展名(.bpmn/.xml、.json、.yaml/.yml)选择解析器，不能识别时按文件内容识别
+// 使用默认租户，指定租户时使用LoadFileWithContext
 func (e *Engine) LoadFile(name string) error {
+	return e.LoadFileWithContext(context.Background(), name)
+}
+
+// LoadFileWithContext 加载文件数据
+// 按文件扩展名(.bpmn/.xml、.json、.yaml/.yml)选择解析器，不能识别时按文件内容识别
+func (e *Engine) LoadFileWithContext(ctx context.Context, name string) error {
 	data, err := e.parseFile(name)
 	if err != nil {
 		return err
 	}
-	_, err = e.CreateFlow(data)
+
+	format := FormatByExtension(name)
+	if format == "" {
+		format = DetectFormat(data)
+	}
+	_, err = e.CreateFlowWithFormat(ctx, format, data)
 	return err
 }
 
+// 获取流程定义格式的解析器(XML格式使用设定的解析器)
+func (e *Engine) formatParser(format string) (Parser, error) {
+	switch format {
+	case FormatXML:
+		return e.parser, nil
+	case FormatJSON:
+		return NewJSONParser(), nil
+	case FormatYAML:
+		return NewYAMLParser(), nil
+	}
+	return nil, errors.Errorf("不支持的流程定义格式[%s]", format)
+}
+
 func (e *Engine) parseFormOperating(formOperating *schema.FormOperating, flow *schema.Flow, node *schema.Node, formResult *NodeFormResult) {
 	if formResult.ID == "" {
 		return
@@ -196,13 +295,21 @@ func (e *Engine) parseOperating(flow *schema.Flow, nodeResults []*NodeResult) (*
 
 	for i, n := range nodeResults {
 		node := &schema.Node{
-			RecordID: util.UUID(),
-			FlowID:   flow.RecordID,
-			Code:     n.NodeID,
-			Name:     n.NodeName,
-			TypeCode: n.NodeType.String(),
-			OrderNum: strconv.FormatInt(int64(i+10), 10),
-			Created:  flow.Created,
+			RecordID:        util.UUID(),
+			FlowID:          flow.RecordID,
+			Code:            n.NodeID,
+			Name:            n.NodeName,
+			TypeCode:        n.NodeType.String(),
+			OrderNum:        strconv.FormatInt(int64(i+10), 10),
+			Topic:           n.Topic,
+			AsyncBefore:     n.AsyncBefore,
+			AsyncAfter:      n.AsyncAfter,
+			Assignee:        n.Assignee,
+			CandidateGroups: n.CandidateGroups,
+			DueDate:         n.DueDate,
+			Priority:        n.Priority,
+			FormKey:         n.FormKey,
+			Created:         flow.Created,
 		}
 
 		if n.FormResult != nil {
@@ -257,41 +364,135 @@ func (e *Engine) parseOperating(flow *schema.Flow, nodeResults []*NodeResult) (*
 	return nodeOperating, formOperating
 }
 
-// CreateFlow 创建流程数据
+// CreateFlow 创建流程数据(使用设定的解析器解析BPMN XML)
+// 使用默认租户，指定租户时使用CreateFlowWithContext
 func (e *Engine) CreateFlow(data []byte) (string, error) {
-	result, err := e.parser.Parse(context.Background(), data)
+	return e.CreateFlowWithContext(context.Background(), data)
+}
+
+// CreateFlowWithContext 创建流程数据(使用设定的解析器解析BPMN XML，流程属于上下文中的租户，未指定租户时为全局的流程)
+func (e *Engine) CreateFlowWithContext(ctx context.Context, data []byte) (string, error) {
+	return e.CreateFlowWithFormat(ctx, FormatXML, data)
+}
+
+// CreateFlowWithFormat 按流程定义格式(FormatXML/FormatJSON/FormatYAML)创建流程数据
+// 仅XML格式的流程定义保存到流程的XML数据
+func (e *Engine) CreateFlowWithFormat(ctx context.Context, format string, data []byte) (string, error) {
+	parser, err := e.formatParser(format)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := parser.Parse(ctx, data)
 	if err != nil {
 		return "", err
 	}
 
 	// 检查流程是否存在，如果存在则检查版本号是否一致，如果不一致则创建新流程
-	oldFlow, err := e.flowBll.GetFlowByCode(result.FlowID)
+	// 租户的流程与全局的流程是相互独立的版本
+	flowBll := e.tenantBll(ctx)
+	oldFlow, err := flowBll.GetFlowByCode(result.FlowID)
 	if err != nil {
 		return "", err
-	} else if oldFlow != nil {
+	} else if oldFlow != nil && oldFlow.TenantID == TenantFromContext(ctx) {
+		// 启用的版本可能不是最新的版本
+		versions, err := flowBll.QueryFlowVersion(oldFlow.RecordID)
+		if err != nil {
+			return "", err
+		}
+
+		for _, v := range versions {
+			if v.Version == result.FlowVersion {
+				return v.RecordID, nil
+			} else if v.Version > oldFlow.Version {
+				oldFlow.Version = v.Version
+			}
+		}
+
 		if result.FlowVersion <= oldFlow.Version {
 			return oldFlow.RecordID, nil
 		}
 	}
 
+	// 校验流程定义，严格校验时不允许部署存在错误的流程定义
+	if problems := e.validate(result); len(problems) > 0 {
+		if e.strictValidation && HasProblemError(problems) {
+			return "", &ValidationError{FlowID: result.FlowID, Problems: problems}
+		}
+		for _, p := range problems {
+			log.Printf("流程[%s]定义存在问题：%s", result.FlowID, p.String())
+		}
+	}
+
 	flow := &schema.Flow{
 		RecordID: util.UUID(),
 		Code:     result.FlowID,
 		Name:     result.FlowName,
 		Version:  result.FlowVersion,
-		XML:      string(data),
 		Status:   result.FlowStatus,
 		Created:  time.Now().Unix(),
 	}
+	if format == FormatXML {
+		flow.XML = string(data)
+	}
 
+	// 新部署的版本作为启用的版本(手动启用时仅在没有启用的版本时启用)
 	nodeOperating, formOperating := e.parseOperating(flow, result.Nodes)
-	err = e.flowBll.CreateFlow(flow, nodeOperating, formOperating)
+	err = flowBll.Transaction(func(flowBll *bll.Flow) error {
+		err := flowBll.CreateFlow(flow, nodeOperating, formOperating)
+		if err != nil {
+			return err
+		}
+
+		if e.isManualActivation() {
+			versions, err := flowBll.QueryFlowVersion(flow.RecordID)
+			if err != nil {
+				return err
+			}
+			for _, v := range versions {
+				if v.Active == 1 {
+					return nil
+				}
+			}
+		}
+		return flowBll.ActivateFlow(flow.RecordID)
+	})
 	if err != nil {
 		return "", err
 	}
 	return flow.RecordID, nil
 }
 
+// SetManualActivation 设定是否手动启用流程版本
+// 默认部署新版本后新版本为启用的版本；手动启用时新版本需要通过ActivateFlow启用(流程编号没有启用的版本时仍作为启用的版本)
+func (e *Engine) SetManualActivation(manual bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.manualActivation = manual
+}
+
+func (e *Engine) isManualActivation() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.manualActivation
+}
+
+// ActivateFlow 将流程设为启用的版本，按流程编号发起时优先发起启用的版本(可用于回退到之前的版本)
+// 租户只能设定租户的流程；未设定手动启用时，部署新版本后新版本为启用的版本
+func (e *Engine) ActivateFlow(ctx context.Context, flowID string) error {
+	flowBll := e.tenantBll(ctx)
+	flow, err := flowBll.GetFlow(flowID)
+	if err != nil {
+		return err
+	} else if flow == nil || flow.Flag != 1 || flow.TenantID != TenantFromContext(ctx) {
+		return ErrNotFound
+	}
+
+	return flowBll.Transaction(func(flowBll *bll.Flow) error {
+		return flowBll.ActivateFlow(flowID)
+	})
+}
+
 // HandleResult 处理结果
 type HandleResult struct {
 	IsEnd        bool                 `json:"is_end"`        // 是否结束
@@ -310,7 +511,22 @@ type NextNode struct {
 	CandidateIDs []string     // 节点候选人
 }
 
-func (e *Engine) nextFlowHandle(ctx context.Context, nodeInstanceID, userID string, inputData []byte) (*HandleResult, error) {
+// 在事务中执行流程处理，提交后分发异步事件；处理失败时分发错误事件
+func (e *Engine) transaction(ctx context.Context, errEvent *Event, fn func(*bll.Flow, *eventEmitter) error) error {
+	var emitter *eventEmitter
+	err := e.tenantBll(ctx).Transaction(func(flowBll *bll.Flow) error {
+		emitter = newEventEmitter(ctx, e, flowBll)
+		return fn(flowBll, emitter)
+	})
+	if err != nil {
+		e.emitError(ctx, errEvent, err)
+		return err
+	}
+	emitter.flush()
+	return nil
+}
+
+func (e *Engine) nextFlowHandle(ctx context.Context, flowBll *bll.Flow, emitter *eventEmitter, nodeInstanceID, userID string, inputData []byte) (*HandleResult, error) {
 	var result HandleResult
 
 	var onNextNode = OnNextNodeOption(func(node *schema.Node, nodeInstance *schema.NodeInstance, nodeCandidates []*schema.NodeCandidate) {
@@ -329,7 +545,7 @@ func (e *Engine) nextFlowHandle(ctx context.Context, nodeInstanceID, userID stri
 		result.IsEnd = true
 	})
 
-	nr, err := new(NodeRouter).Init(ctx, e, nodeInstanceID, inputData, onNextNode, onFlowEnd)
+	nr, err := new(NodeRouter).Init(ctx, e, nodeInstanceID, inputData, onNextNode, onFlowEnd, transactionOption(flowBll, emitter))
 	if err != nil {
 		return nil, err
 	}
@@ -343,29 +559,161 @@ func (e *Engine) nextFlowHandle(ctx context.Context, nodeInstanceID, userID stri
 	return &result, nil
 }
 
+type startOptions struct {
+	businessKey string
+	version     int64
+}
+
+// StartOption 发起流程配置
+type StartOption func(*startOptions)
+
+// BusinessKeyOption 业务主键配置(用于关联业务数据，表达式中可通过flow.business_key访问)
+func BusinessKeyOption(businessKey string) StartOption {
+	return func(o *startOptions) {
+		o.businessKey = businessKey
+	}
+}
+
+// VersionOption 发起指定版本的流程(默认发起启用的版本)，仅对按流程编号发起有效
+func VersionOption(version int64) StartOption {
+	return func(o *startOptions) {
+		o.version = version
+	}
+}
+
+// 检查业务主键的唯一性
+func (e *Engine) checkBusinessKey(flowBll *bll.Flow, flowCode, businessKey string) error {
+	if businessKey == "" || !e.isBusinessKeyUnique(flowCode) {
+		return nil
+	}
+
+	exists, err := flowBll.CheckFlowInstanceBusinessKey(flowCode, businessKey)
+	if err != nil {
+		return err
+	} else if exists {
+		return ErrBusinessKeyExists
+	}
+	return nil
+}
+
+// 发起的流程实例锁定业务主键，并发发起时由唯一索引保证只有一个流程实例锁定成功
+func (e *Engine) lockBusinessKey(flowBll *bll.Flow, flowCode, businessKey, flowInstanceID string) error {
+	if businessKey == "" || !e.isBusinessKeyUnique(flowCode) {
+		return nil
+	}
+
+	ok, err := flowBll.LockBusinessKey(flowCode, businessKey, flowInstanceID)
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrBusinessKeyExists
+	}
+	return nil
+}
+
 // StartFlow 启动流程
 // flowCode 流程编号
 // nodeCode 开始节点编号
 // userID 发起人
 // inputData 输入数据
-func (e *Engine) StartFlow(ctx context.Context, flowCode, nodeCode, userID string, inputData []byte) (*HandleResult, error) {
-	nodeInstance, err := e.flowBll.LaunchFlowInstance(flowCode, nodeCode, userID, inputData)
+// opts 发起配置
+func (e *Engine) StartFlow(ctx context.Context, flowCode, nodeCode, userID string, inputData []byte, opts ...StartOption) (*HandleResult, error) {
+	var o startOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var result *HandleResult
+	errEvent := &Event{FlowCode: flowCode, UserID: userID}
+	err := e.transaction(ctx, errEvent, func(flowBll *bll.Flow, emitter *eventEmitter) error {
+		err := e.checkBusinessKey(flowBll, flowCode, o.businessKey)
+		if err != nil {
+			return err
+		}
+
+		flowInstance, nodeInstance, err := flowBll.LaunchFlowInstance(flowCode, nodeCode, userID, o.businessKey, o.version, inputData)
+		if err != nil {
+			return err
+		} else if nodeInstance == nil {
+			return errors.New("未找到流程信息")
+		}
+
+		err = e.lockBusinessKey(flowBll, flowCode, o.businessKey, flowInstance.RecordID)
+		if err != nil {
+			return err
+		}
+
+		err = emitter.emit(&Event{
+			Type:         EventFlowStarted,
+			FlowCode:     flowCode,
+			FlowInstance: flowInstance,
+			NodeInstance: nodeInstance,
+			UserID:       userID,
+		})
+		if err != nil {
+			return err
+		}
+
+		result, err = e.nextFlowHandle(ctx, flowBll, emitter, nodeInstance.RecordID, userID, inputData)
+		return err
+	})
 	if err != nil {
 		return nil, err
-	} else if nodeInstance == nil {
-		return nil, errors.New("未找到流程信息")
 	}
-
-	return e.nextFlowHandle(ctx, nodeInstance.RecordID, userID, inputData)
+	return result, nil
 }
 
 // LaunchFlow 发起流程（基于流程ID）
-func (e *Engine) LaunchFlow(ctx context.Context, flowID, userID string, inputData []byte) (*HandleResult, error) {
-	_, ni, err := e.flowBll.LaunchFlowInstance2(flowID, userID, 1, inputData)
+func (e *Engine) LaunchFlow(ctx context.Context, flowID, userID string, inputData []byte, opts ...StartOption) (*HandleResult, error) {
+	var o startOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var result *HandleResult
+	errEvent := &Event{UserID: userID}
+	err := e.transaction(ctx, errEvent, func(flowBll *bll.Flow, emitter *eventEmitter) error {
+		// 租户只能发起租户的流程及全局的流程
+		flow, err := flowBll.GetFlow(flowID)
+		if err != nil {
+			return err
+		} else if flow == nil {
+			return ErrNotFound
+		}
+		errEvent.FlowCode = flow.Code
+
+		err = e.checkBusinessKey(flowBll, flow.Code, o.businessKey)
+		if err != nil {
+			return err
+		}
+
+		fi, ni, err := flowBll.LaunchFlowInstance2(flowID, userID, o.businessKey, 1, inputData)
+		if err != nil {
+			return err
+		}
+
+		err = e.lockBusinessKey(flowBll, flow.Code, o.businessKey, fi.RecordID)
+		if err != nil {
+			return err
+		}
+
+		err = emitter.emit(&Event{
+			Type:         EventFlowStarted,
+			FlowInstance: fi,
+			NodeInstance: ni,
+			UserID:       userID,
+		})
+		if err != nil {
+			return err
+		}
+
+		result, err = e.nextFlowHandle(ctx, flowBll, emitter, ni.RecordID, userID, inputData)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return e.nextFlowHandle(ctx, ni.RecordID, userID, inputData)
+	return result, nil
 }
 
 // HandleFlow 处理流程节点
@@ -373,18 +721,64 @@ func (e *Engine) LaunchFlow(ctx context.Context, flowID, userID string, inputDat
 // userID 处理人
 // inputData 输入数据
 func (e *Engine) HandleFlow(ctx context.Context, nodeInstanceID, userID string, inputData []byte) (*HandleResult, error) {
-	nodeInstance, err := e.flowBll.GetNodeInstance(nodeInstanceID)
+	nodeInstance, err := e.tenantBll(ctx).GetNodeInstance(nodeInstanceID)
 	if err != nil {
 		return nil, err
+	} else if nodeInstance == nil {
+		return nil, ErrNotFound
 	} else if nodeInstance.Status != 1 {
 		return nil, nil
 	}
-	return e.nextFlowHandle(ctx, nodeInstanceID, userID, inputData)
+
+	var result *HandleResult
+	errEvent := &Event{NodeInstance: nodeInstance, UserID: userID}
+	err = e.transaction(ctx, errEvent, func(flowBll *bll.Flow, emitter *eventEmitter) error {
+		flowInstance, err := flowBll.GetFlowInstance(nodeInstance.FlowInstanceID)
+		if err != nil {
+			return err
+		} else if flowInstance == nil {
+			return ErrNotFound
+		}
+		errEvent.FlowInstance = flowInstance
+
+		if flowInstance.Status == 2 {
+			return ErrFlowSuspended
+		}
+
+		result, err = e.nextFlowHandle(ctx, flowBll, emitter, nodeInstanceID, userID, inputData)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 在事务中变更流程实例状态并发出事件
+func (e *Engine) changeFlowInstance(ctx context.Context, flowInstance *schema.FlowInstance, eventType EventType, fn func(*bll.Flow) error) error {
+	errEvent := &Event{FlowInstance: flowInstance}
+	return e.transaction(ctx, errEvent, func(flowBll *bll.Flow, emitter *eventEmitter) error {
+		err := fn(flowBll)
+		if err != nil {
+			return err
+		}
+
+		return emitter.emit(&Event{
+			Type:         eventType,
+			FlowInstance: flowInstance,
+		})
+	})
 }
 
 // StopFlow 停止流程
+// 使用默认租户，指定租户时使用StopFlowWithContext
 func (e *Engine) StopFlow(nodeInstanceID string, allowStop func(*schema.FlowInstance) bool) error {
-	flowInstance, err := e.flowBll.GetFlowInstanceByNode(nodeInstanceID)
+	return e.StopFlowWithContext(context.Background(), nodeInstanceID, allowStop)
+}
+
+// StopFlowWithContext 停止流程
+func (e *Engine) StopFlowWithContext(ctx context.Context, nodeInstanceID string, allowStop func(*schema.FlowInstance) bool) error {
+	flowInstance, err := e.tenantBll(ctx).GetFlowInstanceByNode(nodeInstanceID)
 	if err != nil {
 		return err
 	} else if flowInstance == nil {
@@ -395,44 +789,127 @@ func (e *Engine) StopFlow(nodeInstanceID string, allowStop func(*schema.FlowInst
 		return errors.New("不允许停止流程")
 	}
 
-	return e.flowBll.StopFlowInstance(flowInstance.RecordID)
+	return e.changeFlowInstance(ctx, flowInstance, EventFlowStopped, func(flowBll *bll.Flow) error {
+		flowInstance.Status = 9
+		return flowBll.StopFlowInstance(flowInstance.RecordID)
+	})
 }
 
 // StopFlowInstance 停止流程实例
+// 使用默认租户，指定租户时使用StopFlowInstanceWithContext
 func (e *Engine) StopFlowInstance(flowInstanceID string, allowStop func(*schema.FlowInstance) bool) error {
-	flowInstance, err := e.flowBll.GetFlowInstance(flowInstanceID)
+	return e.StopFlowInstanceWithContext(context.Background(), flowInstanceID, allowStop)
+}
+
+// StopFlowInstanceWithContext 停止流程实例
+func (e *Engine) StopFlowInstanceWithContext(ctx context.Context, flowInstanceID string, allowStop func(*schema.FlowInstance) bool) error {
+	flowInstance, err := e.tenantBll(ctx).GetFlowInstance(flowInstanceID)
 	if err != nil {
 		return err
+	} else if flowInstance == nil {
+		return errors.New("流程不存在")
 	}
 
 	if allowStop != nil && !allowStop(flowInstance) {
 		return errors.New("不允许停止流程")
 	}
 
-	return e.flowBll.StopFlowInstance(flowInstanceID)
+	return e.changeFlowInstance(ctx, flowInstance, EventFlowStopped, func(flowBll *bll.Flow) error {
+		flowInstance.Status = 9
+		return flowBll.StopFlowInstance(flowInstanceID)
+	})
+}
+
+// SuspendFlowInstance 暂停流程实例(暂停期间不允许处理流程节点)
+func (e *Engine) SuspendFlowInstance(ctx context.Context, flowInstanceID string) error {
+	flowInstance, err := e.tenantBll(ctx).GetFlowInstance(flowInstanceID)
+	if err != nil {
+		return err
+	} else if flowInstance == nil {
+		return errors.New("流程不存在")
+	} else if flowInstance.Status != 1 {
+		return errors.New("流程实例不是进行中的状态")
+	}
+
+	return e.changeFlowInstance(ctx, flowInstance, EventFlowSuspended, func(flowBll *bll.Flow) error {
+		flowInstance.Status = 2
+		return flowBll.SuspendFlowInstance(flowInstanceID)
+	})
+}
+
+// ResumeFlowInstance 恢复暂停的流程实例
+func (e *Engine) ResumeFlowInstance(ctx context.Context, flowInstanceID string) error {
+	flowInstance, err := e.tenantBll(ctx).GetFlowInstance(flowInstanceID)
+	if err != nil {
+		return err
+	} else if flowInstance == nil {
+		return errors.New("流程不存在")
+	} else if flowInstance.Status != 2 {
+		return errors.New("流程实例不是暂停的状态")
+	}
+
+	return e.changeFlowInstance(ctx, flowInstance, EventFlowResumed, func(flowBll *bll.Flow) error {
+		flowInstance.Status = 1
+		return flowBll.ResumeFlowInstance(flowInstanceID)
+	})
 }
 
 // QueryTodoFlows 查询流程待办数据
 // flowCode 流程编号
 // userID 待办人
+// 使用默认租户，指定租户时使用QueryTodoFlowsWithContext
 func (e *Engine) QueryTodoFlows(flowCode, userID string) ([]*schema.FlowTodoResult, error) {
-	return e.flowBll.QueryTodo(flowCode, userID)
+	return e.QueryTodoFlowsWithContext(context.Background(), flowCode, userID)
+}
+
+// QueryTodoFlowsWithContext 查询流程待办数据
+// flowCode 流程编号
+// userID 待办人
+func (e *Engine) QueryTodoFlowsWithContext(ctx context.Context, flowCode, userID string) ([]*schema.FlowTodoResult, error) {
+	return e.tenantBll(ctx).QueryTodo(flowCode, userID)
 }
 
 // QueryFlowHistory 查询流程历史数据
 // flowInstanceID 流程实例内码
+// 使用默认租户，指定租户时使用QueryFlowHistoryWithContext
 func (e *Engine) QueryFlowHistory(flowInstanceID string) ([]*schema.FlowHistoryResult, error) {
-	return e.flowBll.QueryHistory(flowInstanceID)
+	return e.QueryFlowHistoryWithContext(context.Background(), flowInstanceID)
+}
+
+// QueryFlowHistoryWithContext 查询流程历史数据
+// flowInstanceID 流程实例内码
+func (e *Engine) QueryFlowHistoryWithContext(ctx context.Context, flowInstanceID string) ([]*schema.FlowHistoryResult, error) {
+	return e.tenantBll(ctx).QueryHistory(flowInstanceID)
 }
 
 // QueryDoneFlowIDs 查询已办理的流程实例ID列表
+// 使用默认租户，指定租户时使用QueryDoneFlowIDsWithContext
 func (e *Engine) QueryDoneFlowIDs(flowCode, userID string) ([]string, error) {
-	return e.flowBll.QueryDoneIDs(flowCode, userID)
+	return e.QueryDoneFlowIDsWithContext(context.Background(), flowCode, userID)
+}
+
+// QueryDoneFlowIDsWithContext 查询已办理的流程实例ID列表
+func (e *Engine) QueryDoneFlowIDsWithContext(ctx context.Context, flowCode, userID string) ([]string, error) {
+	return e.tenantBll(ctx).QueryDoneIDs(flowCode, userID)
 }
 
 // QueryNodeCandidates 查询节点实例的候选人ID列表
+// 使用默认租户，指定租户时使用QueryNodeCandidatesWithContext
 func (e *Engine) QueryNodeCandidates(nodeInstanceID string) ([]string, error) {
-	candidates, err := e.flowBll.QueryNodeCandidates(nodeInstanceID)
+	return e.QueryNodeCandidatesWithContext(context.Background(), nodeInstanceID)
+}
+
+// QueryNodeCandidatesWithContext 查询节点实例的候选人ID列表
+func (e *Engine) QueryNodeCandidatesWithContext(ctx context.Context, nodeInstanceID string) ([]string, error) {
+	flowBll := e.tenantBll(ctx)
+	nodeInstance, err := flowBll.GetNodeInstance(nodeInstanceID)
+	if err != nil {
+		return nil, err
+	} else if nodeInstance == nil {
+		return nil, nil
+	}
+
+	candidates, err := flowBll.QueryNodeCandidates(nodeInstanceID)
 	if err != nil {
 		return nil, err
 	}
@@ -446,7 +923,23 @@ func (e *Engine) QueryNodeCandidates(nodeInstanceID string) ([]string, error) {
 	return ids, nil
 }
 
+// GetFlowInstanceByBusinessKey 根据业务主键获取最近发起的流程实例
+func (e *Engine) GetFlowInstanceByBusinessKey(ctx context.Context, flowCode, businessKey string) (*schema.FlowInstance, error) {
+	return e.tenantBll(ctx).GetFlowInstanceByBusinessKey(flowCode, businessKey)
+}
+
+// QueryFlowInstancePage 查询流程实例分页数据
+func (e *Engine) QueryFlowInstancePage(ctx context.Context, params schema.FlowInstanceQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowInstance, error) {
+	return e.tenantBll(ctx).QueryFlowInstancePage(params, pageIndex, pageSize)
+}
+
 // GetNodeInstance 获取节点实例
+// 使用默认租户，指定租户时使用GetNodeInstanceWithContext
 func (e *Engine) GetNodeInstance(nodeInstanceID string) (*schema.NodeInstance, error) {
-	return e.flowBll.GetNodeInstance(nodeInstanceID)
+	return e.GetNodeInstanceWithContext(context.Background(), nodeInstanceID)
+}
+
+// GetNodeInstanceWithContext 获取节点实例
+func (e *Engine) GetNodeInstanceWithContext(ctx context.Context, nodeInstanceID string) (*schema.NodeInstance, error) {
+	return e.tenantBll(ctx).GetNodeInstance(nodeInstanceID)
 }
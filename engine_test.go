@@ -0,0 +1,111 @@
+package flow
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFlowVersion(t *testing.T) {
+	ctx := context.Background()
+	e := NewMemoryEngine()
+	e.SetExecer(simulateExecer{})
+
+	deploy := func(version int64) string {
+		id, err := e.CreateFlowWithDefinition(ctx, mustBuild(t, NewDefinition("process_version").Version(version).
+			Start("start").
+			UserTask("apply", CandidatesOption("flow.launcher")).
+			UserTask("audit", CandidatesOption("input.bzr")).
+			End("end")))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return id
+	}
+
+	// 发起流程，返回发起的流程ID
+	start := func(opts ...StartOption) string {
+		result, err := e.StartFlow(ctx, "process_version", "start", "V001", []byte(`{"bzr":"V002"}`), opts...)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return result.FlowInstance.FlowID
+	}
+
+	// 查询启用的版本
+	active := func(flowID string) string {
+		versions, err := e.FlowBll().QueryFlowVersion(flowID)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var ids []string
+		for _, v := range versions {
+			if v.Active == 1 {
+				ids = append(ids, v.RecordID)
+			}
+		}
+		if len(ids) != 1 {
+			t.Fatalf("应只有一个启用的版本：%v", ids)
+		}
+		return ids[0]
+	}
+
+	v1 := deploy(1)
+	v2 := deploy(2)
+
+	// 部署新版本后新版本为启用的版本
+	if id := active(v1); id != v2 {
+		t.Fatalf("新部署的版本应为启用的版本：%s", id)
+	} else if id := start(); id != v2 {
+		t.Fatalf("应发起启用的版本：%s", id)
+	}
+
+	// 发起指定的版本
+	if id := start(VersionOption(1)); id != v1 {
+		t.Fatalf("应发起指定的版本：%s", id)
+	}
+	_, err := e.StartFlow(ctx, "process_version", "start", "V001", []byte(`{}`), VersionOption(9))
+	if err == nil {
+		t.Fatal("发起不存在的版本应返回错误")
+	}
+
+	// 切换启用的版本(回退到版本1)
+	err = e.ActivateFlow(ctx, v1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if id := active(v2); id != v1 {
+		t.Fatalf("启用的版本未切换：%s", id)
+	} else if id := start(); id != v1 {
+		t.Fatalf("应发起切换后的版本：%s", id)
+	}
+
+	// 手动启用时部署的新版本不自动启用
+	e.SetManualActivation(true)
+	v3 := deploy(3)
+	if id := active(v3); id != v1 {
+		t.Fatalf("手动启用时新部署的版本不应自动启用：%s", id)
+	} else if id := start(); id != v1 {
+		t.Fatalf("应发起启用的版本：%s", id)
+	} else if id := start(VersionOption(3)); id != v3 {
+		t.Fatalf("应发起指定的版本：%s", id)
+	}
+
+	err = e.ActivateFlow(ctx, v3)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if id := start(); id != v3 {
+		t.Fatalf("应发起切换后的版本：%s", id)
+	}
+
+	// 手动启用时，没有启用版本的流程编号部署后仍启用
+	id, err := e.CreateFlowWithDefinition(ctx, mustBuild(t, NewDefinition("process_version_manual").Version(1).
+		Start("start").
+		UserTask("apply", CandidatesOption("flow.launcher")).
+		End("end")))
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if a := active(id); a != id {
+		t.Fatalf("首次部署的版本应为启用的版本：%s", a)
+	}
+}
@@ -0,0 +1,312 @@
+package flow
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/antlinker/flow/bll"
+	"github.com/antlinker/flow/schema"
+)
+
+// EventType 流程事件类型
+type EventType string
+
+func (t EventType) String() string {
+	return string(t)
+}
+
+const (
+	// EventFlowStarted 流程实例已发起
+	EventFlowStarted EventType = "flowStarted"
+	// EventNodeEntered 进入节点(节点实例已创建)
+	EventNodeEntered EventType = "nodeEntered"
+	// EventNodeCompleted 节点实例已完成
+	EventNodeCompleted EventType = "nodeCompleted"
+	// EventTaskCreated 人工任务已创建(等待处理)
+	EventTaskCreated EventType = "taskCreated"
+	// EventTaskAssigned 人工任务已指派候选人
+	EventTaskAssigned EventType = "taskAssigned"
+	// EventGatewayEvaluated 网关条件已计算
+	EventGatewayEvaluated EventType = "gatewayEvaluated"
+	// EventFlowEnded 流程实例已结束
+	EventFlowEnded EventType = "flowEnded"
+	// EventFlowStopped 流程实例已停止
+	EventFlowStopped EventType = "flowStopped"
+	// EventFlowSuspended 流程实例已暂停
+	EventFlowSuspended EventType = "flowSuspended"
+	// EventFlowResumed 流程实例已恢复
+	EventFlowResumed EventType = "flowResumed"
+	// EventFlowMigrated 流程实例已迁移到新版本
+	EventFlowMigrated EventType = "flowMigrated"
+	// EventError 流程处理发生错误
+	EventError EventType = "error"
+)
+
+// Event 流程事件
+type Event struct {
+	Type          EventType            `json:"type"`            // 事件类型
+	FlowCode      string               `json:"flow_code"`       // 流程编号
+	FlowInstance  *schema.FlowInstance `json:"flow_instance"`   // 流程实例
+	Node          *schema.Node         `json:"node"`            // 节点
+	NodeInstance  *schema.NodeInstance `json:"node_instance"`   // 节点实例
+	CandidateIDs  []string             `json:"candidate_ids"`   // 节点候选人
+	TargetNodeIDs []string             `json:"target_node_ids"` // 网关流向的目标节点内码
+	UserID        string               `json:"user_id"`         // 操作人
+	Error         string               `json:"error"`           // 错误信息
+	Time          int64                `json:"time"`            // 事件时间戳
+}
+
+// Listener 流程事件监听器
+type Listener interface {
+	// 处理事件，同步监听器返回错误时流程处理的事务将回滚
+	OnEvent(ctx context.Context, event *Event) error
+}
+
+// ListenerFunc 函数形式的流程事件监听器
+type ListenerFunc func(ctx context.Context, event *Event) error
+
+// OnEvent 处理事件
+func (f ListenerFunc) OnEvent(ctx context.Context, event *Event) error {
+	return f(ctx, event)
+}
+
+type listenerOptions struct {
+	flowCodes []string
+	types     []EventType
+	async     bool
+}
+
+// ListenerOption 事件监听配置
+type ListenerOption func(*listenerOptions)
+
+// ListenFlowCodeOption 只监听指定流程编号的事件(默认监听所有流程)
+func ListenFlowCodeOption(flowCodes ...string) ListenerOption {
+	return func(o *listenerOptions) {
+		o.flowCodes = append(o.flowCodes, flowCodes...)
+	}
+}
+
+// ListenEventOption 只监听指定类型的事件(默认监听所有事件)
+func ListenEventOption(types ...EventType) ListenerOption {
+	return func(o *listenerOptions) {
+		o.types = append(o.types, types...)
+	}
+}
+
+// ListenAsyncOption 异步监听配置
+// 默认在流程处理的事务中同步执行；异步监听在事务提交后执行，不影响流程处理结果
+func ListenAsyncOption(async bool) ListenerOption {
+	return func(o *listenerOptions) {
+		o.async = async
+	}
+}
+
+type listenerEntry struct {
+	listener Listener
+	opts     listenerOptions
+}
+
+func (l *listenerEntry) match(event *Event) bool {
+	if len(l.opts.types) > 0 {
+		ok := false
+		for _, t := range l.opts.types {
+			if t == event.Type {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if len(l.opts.flowCodes) > 0 {
+		for _, code := range l.opts.flowCodes {
+			if code == event.FlowCode {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// AddListener 注册流程事件监听器
+func (e *Engine) AddListener(listener Listener, opts ...ListenerOption) {
+	var o listenerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.listeners = append(e.listeners, &listenerEntry{listener: listener, opts: o})
+}
+
+func (e *Engine) matchListeners(event *Event) []*listenerEntry {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	var items []*listenerEntry
+	for _, l := range e.listeners {
+		if l.match(event) {
+			items = append(items, l)
+		}
+	}
+	return items
+}
+
+// 分发流程处理的错误事件(事务已回滚)
+func (e *Engine) emitError(ctx context.Context, event *Event, err error) {
+	if event == nil {
+		event = new(Event)
+	}
+	event.Type = EventError
+	event.Error = err.Error()
+	event.Time = time.Now().Unix()
+	if event.FlowCode == "" {
+		event.FlowCode = e.eventFlowCode(ctx, event)
+	}
+
+	var calls []*asyncCall
+	for _, l := range e.matchListeners(event) {
+		if l.opts.async {
+			calls = append(calls, &asyncCall{listener: l.listener, event: event})
+			continue
+		}
+		_ = l.listener.OnEvent(ctx, event)
+	}
+	dispatchAsync(calls)
+
+	if e.acceptOutbox(event.Type) {
+		if err := writeOutbox(e.flowBll, event); err != nil {
+			log.Printf("写入发件箱发生错误：%s", err.Error())
+		}
+	}
+}
+
+// 获取错误事件的流程编号(事务已回滚，从已提交的数据中查询，查询失败时为空)
+func (e *Engine) eventFlowCode(ctx context.Context, event *Event) string {
+	flowBll := e.tenantBll(ctx)
+	flowInstance := event.FlowInstance
+	if flowInstance == nil && event.NodeInstance != nil {
+		flowInstance, _ = flowBll.GetFlowInstance(event.NodeInstance.FlowInstanceID)
+	}
+	if flowInstance == nil {
+		return ""
+	}
+
+	flow, err := flowBll.GetFlow(flowInstance.FlowID)
+	if err != nil || flow == nil {
+		return ""
+	}
+	return flow.Code
+}
+
+type asyncCall struct {
+	listener Listener
+	event    *Event
+}
+
+// 在事务提交后顺序执行异步监听
+func dispatchAsync(calls []*asyncCall) {
+	if len(calls) == 0 {
+		return
+	}
+
+	go func() {
+		for _, c := range calls {
+			if err := c.listener.OnEvent(context.Background(), c.event); err != nil {
+				log.Printf("流程事件[%s]异步处理发生错误：%s", c.event.Type, err.Error())
+			}
+		}
+	}()
+}
+
+// 事件发射器(与一次流程处理的事务绑定)
+type eventEmitter struct {
+	ctx     context.Context
+	engine  *Engine
+	flowBll *bll.Flow
+	flows   map[string]string
+	props   map[string][]*schema.NodeProperty
+	pending []*asyncCall
+}
+
+func newEventEmitter(ctx context.Context, engine *Engine, flowBll *bll.Flow) *eventEmitter {
+	return &eventEmitter{
+		ctx:     ctx,
+		engine:  engine,
+		flowBll: flowBll,
+		flows:   make(map[string]string),
+		props:   make(map[string][]*schema.NodeProperty),
+	}
+}
+
+// 获取流程编号
+func (em *eventEmitter) flowCode(flowID string) (string, error) {
+	if code, ok := em.flows[flowID]; ok {
+		return code, nil
+	}
+
+	flow, err := em.flowBll.GetFlow(flowID)
+	if err != nil {
+		return "", err
+	} else if flow == nil {
+		return "", ErrNotFound
+	}
+	em.flows[flowID] = flow.Code
+	return flow.Code, nil
+}
+
+// 发出事件：同步监听器立即执行，异步监听器等待事务提交后执行
+func (em *eventEmitter) emit(event *Event) error {
+	if em == nil {
+		return nil
+	}
+
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+
+	if event.FlowCode == "" && event.FlowInstance != nil {
+		code, err := em.flowCode(event.FlowInstance.FlowID)
+		if err != nil {
+			return err
+		}
+		event.FlowCode = code
+	}
+
+	if em.engine.acceptOutbox(event.Type) {
+		if err := writeOutbox(em.flowBll, event); err != nil {
+			return err
+		}
+	}
+
+	if err := em.writeWebhook(event); err != nil {
+		return err
+	}
+
+	for _, l := range em.engine.matchListeners(event) {
+		if l.opts.async {
+			em.pending = append(em.pending, &asyncCall{listener: l.listener, event: event})
+			continue
+		}
+
+		if err := l.listener.OnEvent(em.ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 事务提交后分发异步事件
+func (em *eventEmitter) flush() {
+	if em == nil {
+		return
+	}
+	calls := em.pending
+	em.pending = nil
+	dispatchAsync(calls)
+}
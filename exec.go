@@ -51,3 +51,9 @@ func (*execer) ExecReturnStringSlice(ctx context.Context, exp, params []byte) ([
 	}
 	return expression.ExecParamSliceStr(ctx, string(exp), m)
 }
+
+// CheckExpression 解析表达式(不执行)，检查语法错误及引用的未定义变量
+// 表达式执行时可以引用的变量为input(输入数据)、flow(流程实例)、node(节点实例)及导入的模块
+func (*execer) CheckExpression(exp string) error {
+	return expression.Check(exp, "input", "flow", "node")
+}
@@ -0,0 +1,158 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/antlinker/flow/bll"
+	"github.com/antlinker/flow/schema"
+	"github.com/pkg/errors"
+)
+
+// ExportFlow 将保存的流程导出为流程定义(FormatXML/FormatJSON/FormatYAML)，导出的数据可以再次部署
+// 顺序流ID不保存，导出JSON/YAML时为空(部署时为"源节点ID_目标节点ID")，导出XML时使用保存的流程XML中的ID
+func (e *Engine) ExportFlow(ctx context.Context, flowID, format string) ([]byte, error) {
+	if format == FormatXML {
+		return e.ExportFlowXML(ctx, flowID)
+	}
+
+	def, err := e.GetFlowDefinition(ctx, flowID)
+	if err != nil {
+		return nil, err
+	}
+	return def.Marshal(format)
+}
+
+// GetFlowDefinition 获取保存的流程的流程定义
+func (e *Engine) GetFlowDefinition(ctx context.Context, flowID string) (*FlowDefinition, error) {
+	flowBll := e.tenantBll(ctx)
+	flow, err := flowBll.GetFlow(flowID)
+	if err != nil {
+		return nil, err
+	} else if flow == nil {
+		return nil, ErrNotFound
+	}
+
+	nodes, err := flowBll.QueryNodes(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make(map[string]string)
+	for _, n := range nodes {
+		codes[n.RecordID] = n.Code
+	}
+
+	def := &FlowDefinition{
+		ID:      flow.Code,
+		Name:    flow.Name,
+		Version: flow.Version,
+		Status:  flow.Status,
+	}
+	for _, n := range nodes {
+		node, err := nodeDefinition(flowBll, n, codes)
+		if err != nil {
+			return nil, err
+		}
+		def.Nodes = append(def.Nodes, node)
+	}
+	return def, nil
+}
+
+// 获取节点的定义
+func nodeDefinition(flowBll *bll.Flow, n *schema.Node, codes map[string]string) (*NodeDefinition, error) {
+	node := &NodeDefinition{
+		ID:              n.Code,
+		Name:            n.Name,
+		Type:            NodeType(n.TypeCode),
+		Topic:           n.Topic,
+		AsyncBefore:     n.AsyncBefore,
+		AsyncAfter:      n.AsyncAfter,
+		Assignee:        n.Assignee,
+		CandidateGroups: n.CandidateGroups,
+		DueDate:         n.DueDate,
+		Priority:        n.Priority,
+		FormKey:         n.FormKey,
+	}
+
+	routers, err := flowBll.QueryNodeRouters(n.RecordID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routers {
+		node.Routers = append(node.Routers, &RouterDefinition{
+			Target:     codes[r.TargetNodeID],
+			Explain:    r.Explain,
+			Expression: r.Expression,
+		})
+	}
+
+	assignments, err := flowBll.QueryNodeAssignments(n.RecordID)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range assignments {
+		node.Candidates = append(node.Candidates, a.Expression)
+	}
+
+	properties, err := flowBll.QueryNodeProperties(n.RecordID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range properties {
+		node.Properties = append(node.Properties, &PropertyDefinition{Name: p.Name, Value: p.Value})
+	}
+
+	if n.FormID != "" {
+		form, err := flowBll.GetForm(n.FormID)
+		if err != nil {
+			return nil, err
+		} else if form != nil {
+			node.Form, err = formDefinition(form)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return node, nil
+}
+
+// 获取表单的定义(URL类型的表单为type_code及data两个字段，元数据类型的表单从表单数据中解析字段)
+func formDefinition(form *schema.Form) (*FormDefinition, error) {
+	def := &FormDefinition{ID: form.Code}
+	if form.TypeCode == "URL" {
+		def.Fields = []*FormFieldDefinition{
+			{ID: "type_code", Type: "string", DefaultValue: "URL"},
+			{ID: "data", Type: "string", DefaultValue: form.Data},
+		}
+		return def, nil
+	} else if form.Data == "" {
+		return def, nil
+	}
+
+	var fields []*FormFieldResult
+	err := json.Unmarshal([]byte(form.Data), &fields)
+	if err != nil {
+		return nil, errors.Wrapf(err, "解析表单[%s]数据发生错误", form.Code)
+	}
+
+	for _, f := range fields {
+		field := &FormFieldDefinition{
+			ID:           f.ID,
+			Type:         f.Type,
+			Label:        f.Label,
+			DefaultValue: f.DefaultValue,
+		}
+		for _, v := range f.Values {
+			field.Values = append(field.Values, &FieldOptionDefinition{ID: v.ID, Name: v.Name})
+		}
+		for _, v := range f.Validations {
+			field.Validations = append(field.Validations, &FieldValidationDefinition{Name: v.Name, Config: v.Config})
+		}
+		for _, v := range f.Properties {
+			field.Properties = append(field.Properties, &FieldPropertyDefinition{ID: v.ID, Value: v.Value})
+		}
+		def.Fields = append(def.Fields, field)
+	}
+	return def, nil
+}
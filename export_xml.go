@@ -0,0 +1,472 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+// BPMN XML的命名空间
+const (
+	bpmnNamespace    = "http://www.omg.org/spec/BPMN/20100524/MODEL"
+	bpmndiNamespace  = "http://www.omg.org/spec/BPMN/20100524/DI"
+	dcNamespace      = "http://www.omg.org/spec/DD/20100524/DC"
+	diNamespace      = "http://www.omg.org/spec/DD/20100524/DI"
+	camundaNamespace = "http://camunda.org/schema/1.0/bpmn"
+	xsiNamespace     = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// ExportFlowXML 根据保存的节点、路由、指派、属性及表单数据重新生成流程的BPMN XML
+// 节点ID及顺序流(源节点、目标节点)与保存的流程XML一致时保留原有的图形信息，其他元素自动布局
+func (e *Engine) ExportFlowXML(ctx context.Context, flowID string) ([]byte, error) {
+	def, err := e.GetFlowDefinition(ctx, flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	flow, err := e.tenantBll(ctx).GetFlow(flowID)
+	if err != nil {
+		return nil, err
+	} else if flow == nil {
+		return nil, ErrNotFound
+	}
+	return marshalBPMN(def, []byte(flow.XML))
+}
+
+// 原有流程XML中的图形信息
+type bpmnDiagram struct {
+	flowIDs   map[string][]string       // 源节点ID及目标节点ID对应的顺序流ID
+	flowNames map[string]string         // 顺序流的名称(不保存到数据库)
+	shapes    map[string]*etree.Element // 节点ID对应的图形
+	edges     map[string]*etree.Element // 顺序流ID对应的连线
+}
+
+func parseBPMNDiagram(data []byte) *bpmnDiagram {
+	d := &bpmnDiagram{
+		flowIDs:   make(map[string][]string),
+		flowNames: make(map[string]string),
+		shapes:    make(map[string]*etree.Element),
+		edges:     make(map[string]*etree.Element),
+	}
+
+	root, err := readDefinitions(data)
+	if err != nil {
+		return d
+	}
+
+	flows, _ := parseDiagramFlows(data)
+	for _, f := range flows {
+		key := f.SourceRef + "\n" + f.TargetRef
+		d.flowIDs[key] = append(d.flowIDs[key], f.Code)
+		if f.Explain != "" {
+			d.flowNames[f.Code] = f.Explain
+		}
+	}
+
+	for _, diagram := range root.SelectElements("BPMNDiagram") {
+		for _, plane := range diagram.SelectElements("BPMNPlane") {
+			for _, el := range plane.ChildElements() {
+				id := el.SelectAttrValue("bpmnElement", "")
+				switch el.Tag {
+				case "BPMNShape":
+					d.shapes[id] = el
+				case "BPMNEdge":
+					d.edges[id] = el
+				}
+			}
+		}
+	}
+	return d
+}
+
+// 获取源节点到目标节点的顺序流ID(按顺序使用原有流程XML中的ID)
+func (d *bpmnDiagram) flowID(source, target string) string {
+	key := source + "\n" + target
+	if ids := d.flowIDs[key]; len(ids) > 0 {
+		d.flowIDs[key] = ids[1:]
+		return ids[0]
+	}
+	return ""
+}
+
+// 生成BPMN XML，diagram为原有的流程XML(用于保留顺序流ID及图形信息，可以为空)
+func marshalBPMN(def *FlowDefinition, diagram []byte) ([]byte, error) {
+	di := parseBPMNDiagram(diagram)
+
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8"`)
+	root := doc.CreateElement("bpmn:definitions")
+	root.CreateAttr("xmlns:bpmn", bpmnNamespace)
+	root.CreateAttr("xmlns:bpmndi", bpmndiNamespace)
+	root.CreateAttr("xmlns:dc", dcNamespace)
+	root.CreateAttr("xmlns:di", diNamespace)
+	root.CreateAttr("xmlns:camunda", camundaNamespace)
+	root.CreateAttr("xmlns:xsi", xsiNamespace)
+	root.CreateAttr("id", "Definitions_"+def.ID)
+	root.CreateAttr("targetNamespace", "http://bpmn.io/schema/bpmn")
+
+	process := root.CreateElement("bpmn:process")
+	process.CreateAttr("id", def.ID)
+	if def.Name != "" {
+		process.CreateAttr("name", def.Name)
+	}
+	process.CreateAttr("isExecutable", strconv.FormatBool(def.Status == 0 || def.Status == 1))
+	if def.Version > 0 {
+		process.CreateAttr("camunda:versionTag", strconv.FormatInt(def.Version, 10))
+	}
+
+	// 顺序流ID
+	type flowItem struct {
+		id     string
+		source string
+		router *RouterDefinition
+	}
+	var flows []*flowItem
+	usedIDs := make(map[string]bool)
+	for _, n := range def.Nodes {
+		usedIDs[n.ID] = true
+	}
+	for _, n := range def.Nodes {
+		for _, r := range n.Routers {
+			id := r.ID
+			if id == "" {
+				id = di.flowID(n.ID, r.Target)
+			}
+			if id == "" || usedIDs[id] {
+				id = uniqueID(fmt.Sprintf("%s_%s", n.ID, r.Target), usedIDs)
+			}
+			usedIDs[id] = true
+			flows = append(flows, &flowItem{id: id, source: n.ID, router: r})
+		}
+	}
+
+	incoming := make(map[string][]string)
+	outgoing := make(map[string][]string)
+	for _, f := range flows {
+		outgoing[f.source] = append(outgoing[f.source], f.id)
+		incoming[f.router.Target] = append(incoming[f.router.Target], f.id)
+	}
+
+	for _, n := range def.Nodes {
+		writeBPMNNode(process, n, incoming[n.ID], outgoing[n.ID])
+	}
+
+	for _, f := range flows {
+		el := process.CreateElement("bpmn:sequenceFlow")
+		el.CreateAttr("id", f.id)
+		el.CreateAttr("sourceRef", f.source)
+		el.CreateAttr("targetRef", f.router.Target)
+		// 顺序流的名称使用原有流程XML中的名称，没有时使用说明
+		if name := di.flowNames[f.id]; name != "" {
+			el.CreateAttr("name", name)
+		} else if f.router.Explain != "" {
+			el.CreateAttr("name", f.router.Explain)
+		}
+		if f.router.Explain != "" {
+			el.CreateElement("bpmn:documentation").SetText(f.router.Explain)
+		}
+		if f.router.Expression != "" {
+			exp := el.CreateElement("bpmn:conditionExpression")
+			exp.CreateAttr("xsi:type", "bpmn:tFormalExpression")
+			exp.SetText(f.router.Expression)
+		}
+	}
+
+	// 图形信息
+	plane := root.CreateElement("bpmndi:BPMNDiagram")
+	plane.CreateAttr("id", "BPMNDiagram_1")
+	plane = plane.CreateElement("bpmndi:BPMNPlane")
+	plane.CreateAttr("id", "BPMNPlane_1")
+	plane.CreateAttr("bpmnElement", def.ID)
+
+	layout := newBPMNLayout(def, di)
+	for _, n := range def.Nodes {
+		if el, ok := di.shapes[n.ID]; ok {
+			plane.AddChild(normalizeDI(el.Copy()))
+			continue
+		}
+		b := layout.bounds[n.ID]
+		shape := plane.CreateElement("bpmndi:BPMNShape")
+		shape.CreateAttr("id", n.ID+"_di")
+		shape.CreateAttr("bpmnElement", n.ID)
+		writeDIBounds(shape, b)
+	}
+	for _, f := range flows {
+		if el, ok := di.edges[f.id]; ok && di.shapes[f.source] != nil && di.shapes[f.router.Target] != nil {
+			plane.AddChild(normalizeDI(el.Copy()))
+			continue
+		}
+		edge := plane.CreateElement("bpmndi:BPMNEdge")
+		edge.CreateAttr("id", f.id+"_di")
+		edge.CreateAttr("bpmnElement", f.id)
+		for _, p := range layout.waypoints(f.source, f.router.Target) {
+			wp := edge.CreateElement("di:waypoint")
+			wp.CreateAttr("x", svgNum(p[0]))
+			wp.CreateAttr("y", svgNum(p[1]))
+		}
+	}
+
+	doc.Indent(2)
+	return doc.WriteToBytes()
+}
+
+// 输出节点
+func writeBPMNNode(process *etree.Element, n *NodeDefinition, incoming, outgoing []string) {
+	tag := n.Type.String()
+	if n.Type == TerminateEvent {
+		tag = EndEvent.String()
+	}
+
+	el := process.CreateElement("bpmn:" + tag)
+	el.CreateAttr("id", n.ID)
+	if n.Name != "" {
+		el.CreateAttr("name", n.Name)
+	}
+	if n.Type == ServiceTask && n.Topic != "" {
+		el.CreateAttr("camunda:type", "external")
+		el.CreateAttr("camunda:topic", n.Topic)
+	}
+	if n.AsyncBefore {
+		el.CreateAttr("camunda:asyncBefore", "true")
+	}
+	if n.AsyncAfter {
+		el.CreateAttr("camunda:asyncAfter", "true")
+	}
+	// 候选人表达式中包含分隔符(;)时，每个候选人输出为bpmn:potentialOwner元素
+	candidates := nonEmpty(n.Candidates)
+	potentialOwner := false
+	for _, c := range candidates {
+		if strings.Contains(c, ";") {
+			potentialOwner = true
+			break
+		}
+	}
+	if len(candidates) > 0 && !potentialOwner {
+		el.CreateAttr("camunda:candidateUsers", strings.Join(candidates, ";"))
+	}
+	for _, attr := range []struct{ name, value string }{
+		{"camunda:assignee", n.Assignee},
+		{"camunda:candidateGroups", n.CandidateGroups},
+		{"camunda:dueDate", n.DueDate},
+		{"camunda:priority", n.Priority},
+		{"camunda:formKey", n.FormKey},
+	} {
+		if attr.value != "" {
+			el.CreateAttr(attr.name, attr.value)
+		}
+	}
+
+	if n.Form != nil || len(n.Properties) > 0 {
+		ext := el.CreateElement("bpmn:extensionElements")
+		if n.Form != nil {
+			writeBPMNForm(ext, n.Form)
+		}
+		if len(n.Properties) > 0 {
+			props := ext.CreateElement("camunda:properties")
+			for _, p := range n.Properties {
+				prop := props.CreateElement("camunda:property")
+				prop.CreateAttr("name", p.Name)
+				prop.CreateAttr("value", p.Value)
+			}
+		}
+	}
+
+	for _, id := range incoming {
+		el.CreateElement("bpmn:incoming").SetText(id)
+	}
+	for _, id := range outgoing {
+		el.CreateElement("bpmn:outgoing").SetText(id)
+	}
+	if potentialOwner {
+		for _, c := range candidates {
+			el.CreateElement("bpmn:potentialOwner").
+				CreateElement("bpmn:resourceAssignmentExpression").
+				CreateElement("bpmn:formalExpression").SetText(c)
+		}
+	}
+	if n.Type == TerminateEvent {
+		el.CreateElement("bpmn:terminateEventDefinition")
+	}
+}
+
+// 输出表单(camunda:formData)
+func writeBPMNForm(ext *etree.Element, form *FormDefinition) {
+	formData := ext.CreateElement("camunda:formData")
+	if form.ID != "" {
+		formData.CreateAttr("id", form.ID)
+	}
+
+	for _, f := range form.Fields {
+		field := formData.CreateElement("camunda:formField")
+		field.CreateAttr("id", f.ID)
+		if f.Label != "" {
+			field.CreateAttr("label", f.Label)
+		}
+		if f.Type != "" {
+			field.CreateAttr("type", f.Type)
+		}
+		if f.DefaultValue != "" {
+			field.CreateAttr("defaultValue", f.DefaultValue)
+		}
+
+		if len(f.Properties) > 0 {
+			props := field.CreateElement("camunda:properties")
+			for _, p := range f.Properties {
+				prop := props.CreateElement("camunda:property")
+				prop.CreateAttr("id", p.ID)
+				prop.CreateAttr("value", p.Value)
+			}
+		}
+		if len(f.Validations) > 0 {
+			validation := field.CreateElement("camunda:validation")
+			for _, v := range f.Validations {
+				c := validation.CreateElement("camunda:constraint")
+				c.CreateAttr("name", v.Name)
+				if v.Config != "" {
+					c.CreateAttr("config", v.Config)
+				}
+			}
+		}
+		for _, v := range f.Values {
+			value := field.CreateElement("camunda:value")
+			value.CreateAttr("id", v.ID)
+			value.CreateAttr("name", v.Name)
+		}
+	}
+}
+
+// 图形元素使用统一的命名空间前缀
+func normalizeDI(el *etree.Element) *etree.Element {
+	switch el.Tag {
+	case "BPMNShape", "BPMNEdge", "BPMNLabel":
+		el.Space = "bpmndi"
+	case "Bounds":
+		el.Space = "dc"
+	case "waypoint":
+		el.Space = "di"
+	}
+	for _, child := range el.ChildElements() {
+		normalizeDI(child)
+	}
+	return el
+}
+
+func writeDIBounds(el *etree.Element, b diagramBounds) {
+	bounds := el.CreateElement("dc:Bounds")
+	bounds.CreateAttr("x", svgNum(b.x))
+	bounds.CreateAttr("y", svgNum(b.y))
+	bounds.CreateAttr("width", svgNum(b.w))
+	bounds.CreateAttr("height", svgNum(b.h))
+}
+
+func uniqueID(id string, used map[string]bool) string {
+	if !used[id] {
+		return id
+	}
+	for i := 2; ; i++ {
+		if s := fmt.Sprintf("%s_%d", id, i); !used[s] {
+			return s
+		}
+	}
+}
+
+// 自动布局(没有图形信息的节点按从开始事件的距离分列，排列在原有图形的下方)
+type bpmnLayout struct {
+	bounds map[string]diagramBounds
+}
+
+func newBPMNLayout(def *FlowDefinition, di *bpmnDiagram) *bpmnLayout {
+	l := &bpmnLayout{bounds: make(map[string]diagramBounds)}
+
+	// 原有的图形
+	top := 80.0
+	for _, n := range def.Nodes {
+		if el, ok := di.shapes[n.ID]; ok {
+			if b := el.SelectElement("Bounds"); b != nil {
+				l.bounds[n.ID] = parseBounds(b)
+				top = math.Max(top, l.bounds[n.ID].y+l.bounds[n.ID].h+80)
+			}
+		}
+	}
+
+	// 节点的列(从开始事件的距离)
+	nodes := make(map[string]*NodeDefinition)
+	var starts []string
+	for _, n := range def.Nodes {
+		nodes[n.ID] = n
+		if n.Type == StartEvent {
+			starts = append(starts, n.ID)
+		}
+	}
+	columns := make(map[string]int)
+	queue := starts
+	for _, id := range starts {
+		columns[id] = 0
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, r := range nodes[id].Routers {
+			if _, ok := nodes[r.Target]; !ok {
+				continue
+			} else if _, ok := columns[r.Target]; !ok {
+				columns[r.Target] = columns[id] + 1
+				queue = append(queue, r.Target)
+			}
+		}
+	}
+
+	rows := make(map[int]int)
+	for _, n := range def.Nodes {
+		if _, ok := l.bounds[n.ID]; ok {
+			continue
+		}
+		col, ok := columns[n.ID]
+		if !ok {
+			col = len(def.Nodes)
+		}
+		w, h := 100.0, 80.0
+		switch {
+		case strings.HasSuffix(n.Type.String(), "Event"):
+			w, h = 36, 36
+		case strings.HasSuffix(n.Type.String(), "Gateway"):
+			w, h = 50, 50
+		}
+		cx := 150 + float64(col)*180
+		cy := top + 40 + float64(rows[col])*130
+		rows[col]++
+		l.bounds[n.ID] = diagramBounds{x: cx - w/2, y: cy - h/2, w: w, h: h}
+	}
+	return l
+}
+
+// 源节点到目标节点的连线
+func (l *bpmnLayout) waypoints(source, target string) [][2]float64 {
+	s, t := l.bounds[source], l.bounds[target]
+	if source == target {
+		return [][2]float64{
+			{s.x + s.w/2, s.y + s.h},
+			{s.x + s.w/2, s.y + s.h + 30},
+			{s.x, s.y + s.h + 30},
+			{s.x, s.y + s.h/2},
+		}
+	} else if t.x >= s.x+s.w {
+		return [][2]float64{
+			{s.x + s.w, s.y + s.h/2},
+			{t.x, t.y + t.h/2},
+		}
+	}
+
+	// 回退的连线从节点下方绕行
+	bottom := math.Max(s.y+s.h, t.y+t.h) + 30
+	return [][2]float64{
+		{s.x + s.w/2, s.y + s.h},
+		{s.x + s.w/2, bottom},
+		{t.x + t.w/2, bottom},
+		{t.x + t.w/2, t.y + t.h},
+	}
+}
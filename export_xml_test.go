@@ -0,0 +1,137 @@
+package flow
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExportFlowXML(t *testing.T) {
+	ctx := context.Background()
+	e := NewMemoryEngine()
+
+	data, err := e.parseFile("test_data/leave.bpmn")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	source, err := e.parser.Parse(ctx, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	flowID, err := e.CreateFlowWithContext(ctx, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	exported, err := e.ExportFlowXML(ctx, flowID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	result, err := e.parser.Parse(ctx, exported)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if result.FlowID != source.FlowID || result.FlowStatus != source.FlowStatus || len(result.Nodes) != len(source.Nodes) {
+		t.Fatalf("导出的流程不一致：%s", exported)
+	}
+	for i, n := range source.Nodes {
+		m := result.Nodes[i]
+		// 没有ID的表单不保存
+		if n.FormResult != nil && n.FormResult.ID == "" {
+			n.FormResult = nil
+		}
+		if m.NodeID != n.NodeID || m.NodeType != n.NodeType ||
+			strings.Join(m.CandidateExpressions, ";") != strings.Join(nonEmpty(n.CandidateExpressions), ";") ||
+			len(m.Properties) != len(n.Properties) || len(m.Routers) != len(n.Routers) {
+			t.Fatalf("导出的节点[%s]不一致", n.NodeID)
+		}
+		if (n.FormResult == nil) != (m.FormResult == nil) ||
+			(n.FormResult != nil && (m.FormResult.ID != n.FormResult.ID || len(m.FormResult.Fields) != len(n.FormResult.Fields))) {
+			t.Fatalf("导出的节点[%s]的表单不一致", n.NodeID)
+		}
+		for j, r := range n.Routers {
+			if *m.Routers[j] != *r {
+				t.Fatalf("导出的顺序流[%s]不一致：%+v", r.ID, m.Routers[j])
+			}
+		}
+	}
+
+	// 保留原有的图形信息
+	original := parseBPMNDiagram(data)
+	regenerated := parseBPMNDiagram(exported)
+	if len(regenerated.shapes) != len(original.shapes) || len(regenerated.edges) != len(original.edges) {
+		t.Fatalf("图形信息不一致：%d/%d，%d/%d", len(regenerated.shapes), len(original.shapes), len(regenerated.edges), len(original.edges))
+	}
+	for id, el := range original.shapes {
+		a, b := parseBounds(el.SelectElement("Bounds")), parseBounds(regenerated.shapes[id].SelectElement("Bounds"))
+		if a != b {
+			t.Fatalf("节点[%s]的图形位置不一致", id)
+		}
+	}
+	if _, err := RenderSVG(exported, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestMarshalBPMNLayout(t *testing.T) {
+	def, err := NewDefinition("process_layout").
+		Start("start").
+		UserTask("apply", CandidatesOption("flow.launcher")).
+		ExclusiveGateway("gw").
+		Condition(`input.action=="back"`).To("apply").
+		From("gw").Condition(`input.action=="pass"`).End("end").
+		Build()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	data, err := def.Marshal(FormatXML)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	result, err := NewXMLParser().Parse(context.Background(), data)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if problems := validateFlow(result, nil); HasProblemError(problems) {
+		t.Fatalf("流程定义存在问题：%v", problems)
+	}
+
+	d := parseBPMNDiagram(data)
+	if len(d.shapes) != 4 || len(d.edges) != 4 {
+		t.Fatalf("自动布局的图形数量错误：%s", data)
+	}
+}
+
+func TestExportFlowXMLCandidates(t *testing.T) {
+	ctx := context.Background()
+	e := NewMemoryEngine()
+
+	// 候选人表达式中包含分隔符
+	flowID, err := e.CreateFlowWithDefinition(ctx, mustBuild(t, NewDefinition("process_export_candidates").
+		Start("start").
+		UserTask("apply", CandidatesOption(`"U1;U2"`, "flow.launcher")).
+		UserTask("audit", CandidatesOption("input.bzr")).
+		End("end")))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	exported, err := e.ExportFlowXML(ctx, flowID)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if !strings.Contains(string(exported), `camunda:candidateUsers="[]string{input.bzr}"`) {
+		t.Fatalf("不包含分隔符的候选人应输出为属性：%s", exported)
+	}
+
+	result, err := e.parser.Parse(ctx, exported)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, n := range result.Nodes {
+		if n.NodeID == "apply" && (len(n.CandidateExpressions) != 1 || n.CandidateExpressions[0] != `[]string{"U1;U2",flow.launcher}`) {
+			t.Fatalf("无效的候选人表达式：%v", n.CandidateExpressions)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package expression
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"qlang.io/cl/qlang"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// 脚本关键字及内置函数
+	builtinNames = map[string]bool{}
+
+	globalLock  sync.RWMutex
+	globalNames = map[string]bool{}
+)
+
+func init() {
+	for _, name := range strings.Fields(`
+		if else for range break continue return fn func defer go goto switch case default fallthrough
+		import include export as main var const type struct map chan class new this
+		true false nil undefined __ctx__
+		append cap copy delete len make mkmap mkslice mapOf sliceOf set get panic recover
+		print printf println sprint sprintf sprintln fprint fprintf fprintln errorf
+		max min bool byte rune char int int8 int16 int32 int64 uint uint8 uint16 uint32 uint64
+		float float32 float64 string typeof substr
+		SliceStr Slice`) {
+		builtinNames[name] = true
+	}
+}
+
+// 记录全局导入的模块(模块名为空时导入的是全局函数)
+func addGlobalNames(name string, table map[string]interface{}) {
+	globalLock.Lock()
+	defer globalLock.Unlock()
+
+	if name != "" {
+		globalNames[name] = true
+		return
+	}
+	for key := range table {
+		globalNames[key] = true
+	}
+}
+
+func isGlobalName(name string) bool {
+	globalLock.RLock()
+	defer globalLock.RUnlock()
+	return globalNames[name]
+}
+
+// CheckError 表达式检查错误
+type CheckError struct {
+	Exp       string   // 表达式
+	Syntax    error    // 语法错误
+	Undefined []string // 引用的未定义变量
+}
+
+func (e *CheckError) Error() string {
+	if e.Syntax != nil {
+		return fmt.Sprintf("表达式( %s )语法错误:%v", e.Exp, e.Syntax)
+	}
+	return fmt.Sprintf("表达式( %s )引用了未定义的变量:%s", e.Exp, strings.Join(e.Undefined, ","))
+}
+
+// Check 解析表达式(不执行)，检查语法错误及引用的未定义根变量
+// vars 为执行表达式时传入的变量名，全局导入的模块、导入的脚本模块及预定义变量视为已定义
+// 检查失败时返回*CheckError
+func Check(exp string, vars ...string) error {
+	return defaultExp.(*execExp).Check(exp, vars...)
+}
+
+// Check 解析表达式(不执行)，检查语法错误及引用的未定义根变量
+func (e *execExp) Check(exp string, vars ...string) error {
+	roots, defined, err := scanExp(exp)
+	if err == nil {
+		err = compileExp(exp)
+	}
+	if err != nil {
+		return &CheckError{Exp: exp, Syntax: err}
+	}
+
+	known := make(map[string]bool)
+	for _, v := range vars {
+		known[v] = true
+	}
+	for _, p := range e.data {
+		known[p.Key] = true
+	}
+	for model, alias := range e.imports {
+		if alias == "" {
+			alias = strings.TrimSuffix(path.Base(model), path.Ext(model))
+		}
+		known[alias] = true
+	}
+
+	var undefined []string
+	for name := range roots {
+		if known[name] || defined[name] || builtinNames[name] || isGlobalName(name) {
+			continue
+		}
+		undefined = append(undefined, name)
+	}
+	if len(undefined) > 0 {
+		sort.Strings(undefined)
+		return &CheckError{Exp: exp, Undefined: undefined}
+	}
+	return nil
+}
+
+// 编译表达式(不执行)
+func compileExp(exp string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = errors.Errorf("%v", e)
+		}
+	}()
+
+	_, err = qlang.New().Cl([]byte(exp), "")
+	return
+}
+
+// 扫描表达式，返回引用的根变量及表达式中定义的变量(赋值或函数参数)，
+// 检查字符串、注释及括号是否完整
+func scanExp(exp string) (roots, defined map[string]bool, err error) {
+	roots = make(map[string]bool)
+	defined = make(map[string]bool)
+
+	var (
+		brackets []byte
+		prev     byte // 上一个非空白字符
+		inParams bool // 是否在函数参数中
+	)
+	pairs := map[byte]byte{')': '(', ']': '[', '}': '{'}
+
+	for i := 0; i < len(exp); {
+		c := exp[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+			continue
+		case c == '/' && i+1 < len(exp) && exp[i+1] == '/':
+			for i < len(exp) && exp[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && i+1 < len(exp) && exp[i+1] == '*':
+			end := strings.Index(exp[i+2:], "*/")
+			if end < 0 {
+				return nil, nil, errors.New("注释未结束")
+			}
+			i += end + 4
+			continue
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for ; j < len(exp) && exp[j] != c; j++ {
+				if exp[j] == '\\' && c != '`' {
+					j++
+				}
+			}
+			if j >= len(exp) {
+				return nil, nil, errors.Errorf("第%d个字符开始的字符串未结束", i+1)
+			}
+			i = j + 1
+		case isIdentStart(c):
+			j := i
+			for j < len(exp) && (isIdentStart(exp[j]) || isDigit(exp[j])) {
+				j++
+			}
+			name := exp[i:j]
+			next := strings.TrimLeft(exp[j:], " \t")
+			if inParams {
+				defined[name] = true
+			} else if isMapKey(brackets, prev, next) {
+				// map字面量的键({a: 1})不是变量
+			} else if prev != '.' {
+				// 赋值(a = 1、a := 1)定义变量
+				if (strings.HasPrefix(next, "=") && !strings.HasPrefix(next, "==")) || strings.HasPrefix(next, ":=") {
+					defined[name] = true
+				} else {
+					roots[name] = true
+				}
+			}
+			if name == "fn" || name == "func" {
+				inParams = strings.HasPrefix(next, "(")
+			}
+			i = j
+			prev = 'a'
+			continue
+		case isDigit(c):
+			for i < len(exp) && (isIdentStart(exp[i]) || isDigit(exp[i]) || exp[i] == '.') {
+				i++
+			}
+			prev = '0'
+			continue
+		case c == '(' || c == '[' || c == '{':
+			brackets = append(brackets, c)
+			i++
+		case c == ')' || c == ']' || c == '}':
+			if len(brackets) == 0 || brackets[len(brackets)-1] != pairs[c] {
+				return nil, nil, errors.Errorf("第%d个字符的括号'%c'不匹配", i+1, c)
+			}
+			brackets = brackets[:len(brackets)-1]
+			if c == ')' {
+				inParams = false
+			}
+			i++
+		default:
+			i++
+		}
+		prev = c
+	}
+
+	if len(brackets) > 0 {
+		return nil, nil, errors.Errorf("括号'%c'未闭合", brackets[len(brackets)-1])
+	}
+	// 定义的变量在赋值前引用时不作为未定义的变量
+	for name := range defined {
+		delete(roots, name)
+	}
+	return roots, defined, nil
+}
+
+// 是否为map字面量中的键：位于{}中，前一个字符为'{'或','，且后跟':'(不是':=')
+func isMapKey(brackets []byte, prev byte, next string) bool {
+	if len(brackets) == 0 || brackets[len(brackets)-1] != '{' {
+		return false
+	}
+	return (prev == '{' || prev == ',') && strings.HasPrefix(next, ":") && !strings.HasPrefix(next, ":=")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
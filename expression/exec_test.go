@@ -347,3 +347,39 @@ func (t *testExpression) execslicestr(exp string) ([]string, error) {
 	}
 	return out.SliceStr()
 }
+
+func Test_Check(t *testing.T) {
+	tests := []struct {
+		exp       string
+		undefined []string
+		syntax    bool
+	}{
+		{`input.day > 3 && flow.status == 1`, nil, false},
+		{`test.testAdd(input.a, 1) == len(node.record_id)`, nil, false},
+		{`a = input.day; a > 3`, nil, false},
+		{`fn(x) { return x > 1 }(input.day)`, nil, false},
+		{`"day" == inptu.day`, []string{"inptu"}, false},
+		{`{"a":1,b:"a"}`, nil, false},
+		{`{"a":1, b: c}`, []string{"c"}, false},
+		{`input.day > (3`, nil, true},
+		{`input.name == "a`, nil, true},
+	}
+	for _, tt := range tests {
+		err := expression.Check(tt.exp, "input", "flow", "node")
+		if len(tt.undefined) == 0 && !tt.syntax {
+			if err != nil {
+				t.Errorf("Check(%s) error = %v", tt.exp, err)
+			}
+			continue
+		}
+
+		cerr, ok := err.(*expression.CheckError)
+		if !ok {
+			t.Errorf("Check(%s) error = %v, want CheckError", tt.exp, err)
+			continue
+		}
+		if (cerr.Syntax != nil) != tt.syntax || !reflect.DeepEqual(cerr.Undefined, tt.undefined) {
+			t.Errorf("Check(%s) error = %v", tt.exp, err)
+		}
+	}
+}
@@ -50,5 +50,6 @@ type Execer interface {
 // GlobalImport 全局导入模块扩展
 // 同一模块只能被导入一次，多次导入会导致panic
 func GlobalImport(name string, table map[string]interface{}) {
+	addGlobalNames(name, table)
 	qlang.Import(name, table)
 }
@@ -5,12 +5,19 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"github.com/antlinker/flow/expression"
-
-	"qlang.io/cl/qlang"
 )
 
+// 是否已注册sqlctx
+var registered int32
+
+// Registered 是否已注册sqlctx(Reg或RegMoreDB)
+func Registered() bool {
+	return atomic.LoadInt32(&registered) == 1
+}
+
 // execDB 从ctx获取数据库
 // 没有数据库发出panic
 func execDB(ctx context.Context) *sql.DB {
@@ -39,7 +46,8 @@ func getDB(ctx context.Context, defaultDB *sql.DB) *sql.DB {
 // 有默认数据库操作
 // 也支持多数据库
 func Reg(defaultDB *sql.DB) {
-	qlang.Import("sqlctx", map[string]interface{}{
+	atomic.StoreInt32(&registered, 1)
+	expression.GlobalImport("sqlctx", map[string]interface{}{
 		"QueryDB": QueryDB,
 		"Query": func(ctx context.Context, query string, args ...interface{}) []map[string]interface{} {
 
@@ -60,7 +68,8 @@ func Reg(defaultDB *sql.DB) {
 // RegMoreDB 注册多数据库支持
 // 没有默认数据库
 func RegMoreDB() {
-	qlang.Import("sqlctx", map[string]interface{}{
+	atomic.StoreInt32(&registered, 1)
+	expression.GlobalImport("sqlctx", map[string]interface{}{
 		"QueryDB": QueryDB,
 		"Query": func(ctx context.Context, query string, args ...interface{}) []map[string]interface{} {
 			return QueryDB(ctx, execDB(ctx), query, args...)
@@ -118,7 +127,7 @@ func QueryDB(ctx context.Context, db *sql.DB, query string, args ...interface{})
 // QueryDBCount 查询sql匹配的条数
 func QueryDBCount(ctx context.Context, db *sql.DB, query string, args ...interface{}) (count int) {
 
-	query = "select count(*) num from (" + query + ")"
+	query = "select count(*) num from (" + query + ") t"
 
 	rows, err := db.QueryContext(ctx, query, args...)
 
@@ -141,7 +150,7 @@ func QueryOneDB(ctx context.Context, db *sql.DB, query string, args ...interface
 	// var rows *sql.Rows
 	// var err error
 	if strings.Index(strings.ToLower(query), "limit") < 0 {
-		query = "select * from (" + query + ") limit 1"
+		query = "select * from (" + query + ") t limit 1"
 	}
 	rows, err := db.QueryContext(ctx, query, args...)
 
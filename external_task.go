@@ -0,0 +1,158 @@
+package flow
+
+import (
+	"context"
+	"time"
+
+	"github.com/antlinker/flow/bll"
+	"github.com/antlinker/flow/schema"
+	"github.com/pkg/errors"
+)
+
+// 定义错误
+var (
+	ErrJobNotLocked = errors.New("任务未被当前执行者锁定")
+)
+
+// ExternalTaskService 外部任务服务
+// 服务任务(camunda:type="external")流转时创建作业，由执行者按主题拉取并锁定，执行完成后继续流转；
+// 锁定过期后作业重新回到待执行状态，可被其他执行者拉取
+type ExternalTaskService struct {
+	engine *Engine
+}
+
+// ExternalTask 获取外部任务服务
+func (e *Engine) ExternalTask() *ExternalTaskService {
+	return &ExternalTaskService{engine: e}
+}
+
+// FetchAndLock 拉取并锁定外部任务
+// topic 主题
+// workerID 执行者
+// max 最大拉取数量
+// lockDuration 锁定时长
+func (s *ExternalTaskService) FetchAndLock(ctx context.Context, topic, workerID string, max int, lockDuration time.Duration) ([]*schema.ExternalTaskResult, error) {
+	if workerID == "" {
+		return nil, errors.New("无效的执行者")
+	} else if max <= 0 {
+		return nil, nil
+	}
+
+	var items []*schema.ExternalTaskResult
+	lockExpire := time.Now().Add(lockDuration).Unix()
+	err := s.engine.tenantBll(ctx).Transaction(func(flowBll *bll.Flow) error {
+		ids, err := flowBll.LockJobs(JobTypeExternal, topic, workerID, max, lockExpire)
+		if err != nil {
+			return err
+		}
+
+		items, err = flowBll.QueryExternalTasks(ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// 获取当前执行者锁定的作业(作业未被当前执行者锁定时同时返回作业及ErrJobNotLocked)
+func (s *ExternalTaskService) lockedJob(flowBll *bll.Flow, taskID, workerID string) (*schema.Job, error) {
+	job, err := flowBll.GetJob(taskID)
+	if err != nil {
+		return nil, err
+	} else if job == nil || job.TypeCode != JobTypeExternal {
+		return nil, ErrNotFound
+	} else if job.Status != 2 || job.WorkerID != workerID {
+		return job, ErrJobNotLocked
+	}
+	return job, nil
+}
+
+// Complete 完成外部任务，并使用输出数据继续流转
+func (s *ExternalTaskService) Complete(ctx context.Context, taskID, workerID string, outData []byte) (*HandleResult, error) {
+	var result *HandleResult
+	errEvent := &Event{UserID: workerID}
+	err := s.engine.transaction(ctx, errEvent, func(flowBll *bll.Flow, emitter *eventEmitter) error {
+		job, lockErr := s.lockedJob(flowBll, taskID, workerID)
+		if job == nil {
+			return lockErr
+		}
+
+		// 错误事件关联作业的流程实例
+		flowInstance, err := flowBll.GetFlowInstance(job.FlowInstanceID)
+		if err != nil {
+			return err
+		}
+		errEvent.FlowInstance = flowInstance
+
+		if lockErr != nil {
+			return lockErr
+		} else if flowInstance == nil {
+			return ErrNotFound
+		} else if flowInstance.Status == 2 {
+			return ErrFlowSuspended
+		} else if flowInstance.Status != 1 {
+			return errors.New("流程实例已结束")
+		}
+
+		err = flowBll.DoneJob(job.RecordID)
+		if err != nil {
+			return err
+		}
+
+		result, err = s.engine.nextFlowHandle(ctx, flowBll, emitter, job.NodeInstanceID, workerID, outData)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Failure 外部任务执行失败
+// 未超过最大重试次数时，作业按重试间隔延后回到待执行状态；否则标记为失败并发出错误事件
+func (s *ExternalTaskService) Failure(ctx context.Context, taskID, workerID, errorMessage string) error {
+	flowBll := s.engine.tenantBll(ctx)
+	job, err := s.lockedJob(flowBll, taskID, workerID)
+	if err != nil {
+		return err
+	}
+
+	attempts := job.Attempts + 1
+	var nextTime int64
+	if attempts <= job.Retries {
+		nextTime = time.Now().Add(s.engine.jobBackoff(attempts)).Unix()
+	}
+
+	err = flowBll.FailJob(job.RecordID, attempts, nextTime, truncateString(errorMessage, 1024))
+	if err != nil {
+		return err
+	}
+
+	if nextTime == 0 {
+		flowInstance, err := flowBll.GetFlowInstance(job.FlowInstanceID)
+		if err != nil {
+			return err
+		}
+
+		nodeInstance, err := flowBll.GetNodeInstance(job.NodeInstanceID)
+		if err != nil {
+			return err
+		}
+
+		errEvent := &Event{FlowInstance: flowInstance, NodeInstance: nodeInstance, UserID: workerID}
+		s.engine.emitError(ctx, errEvent, errors.New(errorMessage))
+	}
+	return nil
+}
+
+// ExtendLock 延长外部任务的锁定时间(从当前时间开始计算)
+func (s *ExternalTaskService) ExtendLock(ctx context.Context, taskID, workerID string, lockDuration time.Duration) error {
+	flowBll := s.engine.tenantBll(ctx)
+	job, err := s.lockedJob(flowBll, taskID, workerID)
+	if err != nil {
+		return err
+	}
+
+	return flowBll.ExtendJobLock(job.RecordID, time.Now().Add(lockDuration).Unix())
+}
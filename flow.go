@@ -4,24 +4,48 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/antlinker/flow/expression/sql"
+	"github.com/antlinker/flow/model"
 	"github.com/antlinker/flow/schema"
 	"github.com/antlinker/flow/service/db"
 )
 
 var (
-	engine *Engine
+	engine      *Engine
+	autoMigrate bool
 )
 
-// Init 初始化流程配置
+// SetAutoMigrate 设定初始化时是否自动执行未执行的数据库迁移(需要在Init之前调用)
+func SetAutoMigrate(b bool) {
+	autoMigrate = b
+}
+
+// Init 初始化流程配置(使用MySQL存储)
 func Init(opts ...db.Option) {
-	db, trace, err := db.NewMySQL(opts...)
+	initWithDialect(db.DialectMySQL, opts...)
+}
+
+// InitPostgres 初始化流程配置(使用PostgreSQL存储，需要导入github.com/lib/pq驱动)
+func InitPostgres(opts ...db.Option) {
+	initWithDialect(db.DialectPostgres, opts...)
+}
+
+// InitSQLite 初始化流程配置(使用SQLite存储，需要导入github.com/mattn/go-sqlite3驱动)
+func InitSQLite(opts ...db.Option) {
+	initWithDialect(db.DialectSQLite, opts...)
+}
+
+func initWithDialect(dialect string, opts ...db.Option) {
+	db, trace, err := db.Open(dialect, opts...)
 	if err != nil {
 		panic(err)
 	}
 
-	e, err := new(Engine).Init(NewXMLParser(), NewQLangExecer(), db, trace)
+	e := new(Engine)
+	e.SetAutoMigrate(autoMigrate)
+	e, err = e.InitWithDialect(NewXMLParser(), NewQLangExecer(), dialect, db, trace)
 	if err != nil {
 		panic(err)
 	}
@@ -29,6 +53,39 @@ func Init(opts ...db.Option) {
 	sql.Reg(db)
 }
 
+// InitWithRepository 使用指定的存储初始化流程配置
+func InitWithRepository(repo model.Repository) {
+	e, err := new(Engine).InitWithRepository(NewXMLParser(), NewQLangExecer(), repo)
+	if err != nil {
+		panic(err)
+	}
+	engine = e
+	regSQL()
+}
+
+// InitMemory 使用内存存储初始化流程配置(适用于测试及嵌入式场景，数据不持久化)
+func InitMemory() {
+	engine = NewMemoryEngine()
+}
+
+// 未注册sqlctx时注册多数据库支持(没有默认数据库，需要通过上下文指定数据库)
+func regSQL() {
+	if !sql.Registered() {
+		sql.RegMoreDB()
+	}
+}
+
+// Migrate 执行未执行的数据库迁移
+// dryRun 为true时仅返回迁移计划(包含需要执行的SQL)，不执行
+func Migrate(dryRun bool) ([]*db.MigrationPlan, error) {
+	return engine.Migrate(dryRun)
+}
+
+// MigrationStatus 查询数据库迁移状态
+func MigrationStatus() ([]*db.MigrationStatus, error) {
+	return engine.MigrationStatus()
+}
+
 // SetParser 设定解析器
 func SetParser(parser Parser) {
 	engine.SetParser(parser)
@@ -39,7 +96,22 @@ func SetExecer(execer Execer) {
 	engine.SetExecer(execer)
 }
 
-// LoadFile 加载流程文件数据
+// SetStrictValidation 设定是否严格校验流程定义(严格校验时不允许部署存在错误的流程定义)
+func SetStrictValidation(strict bool) {
+	engine.SetStrictValidation(strict)
+}
+
+// SetGroupResolver 设定候选组解析器(将人工任务的候选组解析为候选人)
+func SetGroupResolver(resolver GroupResolver) {
+	engine.SetGroupResolver(resolver)
+}
+
+// ValidateFlow 校验流程定义数据，返回流程定义中的问题
+func ValidateFlow(data []byte) ([]*Problem, error) {
+	return engine.ValidateFlow(context.Background(), data)
+}
+
+// LoadFile 加载流程文件数据(按扩展名支持BPMN XML、JSON及YAML格式)
 func LoadFile(name string) error {
 	return engine.LoadFile(name)
 }
@@ -49,8 +121,9 @@ func LoadFile(name string) error {
 // nodeCode 开始节点编号
 // userID 发起人
 // input 输入数据
-func StartFlow(flowCode, nodeCode, userID string, input interface{}) (*HandleResult, error) {
-	return StartFlowWithContext(context.Background(), flowCode, nodeCode, userID, input)
+// opts 发起配置(如：BusinessKeyOption、VersionOption)
+func StartFlow(flowCode, nodeCode, userID string, input interface{}, opts ...StartOption) (*HandleResult, error) {
+	return StartFlowWithContext(context.Background(), flowCode, nodeCode, userID, input, opts...)
 }
 
 // StartFlowWithContext 启动流程
@@ -58,12 +131,68 @@ func StartFlow(flowCode, nodeCode, userID string, input interface{}) (*HandleRes
 // nodeCode 开始节点编号
 // userID 发起人
 // input 输入数据
-func StartFlowWithContext(ctx context.Context, flowCode, nodeCode, userID string, input interface{}) (*HandleResult, error) {
+// opts 发起配置(如：BusinessKeyOption、VersionOption)
+func StartFlowWithContext(ctx context.Context, flowCode, nodeCode, userID string, input interface{}, opts ...StartOption) (*HandleResult, error) {
 	inputData, err := json.Marshal(input)
 	if err != nil {
 		return nil, err
 	}
-	return engine.StartFlow(ctx, flowCode, nodeCode, userID, inputData)
+	return engine.StartFlow(ctx, flowCode, nodeCode, userID, inputData, opts...)
+}
+
+// CreateFlowWithDefinition 部署流程定义(如NewDefinition构建的流程定义)
+func CreateFlowWithDefinition(def *FlowDefinition) (string, error) {
+	return engine.CreateFlowWithDefinition(context.Background(), def)
+}
+
+// ExportFlow 将保存的流程导出为流程定义(FormatXML/FormatJSON/FormatYAML)
+func ExportFlow(flowID, format string) ([]byte, error) {
+	return engine.ExportFlow(context.Background(), flowID, format)
+}
+
+// RenderFlowDiagram 将流程的BPMN图形信息渲染为SVG
+func RenderFlowDiagram(flowID string) ([]byte, error) {
+	return engine.RenderFlowDiagram(context.Background(), flowID)
+}
+
+// RenderFlowInstanceDiagram 将流程实例的流程图渲染为SVG(高亮已完成的节点、进行中的节点及经过的顺序流)
+func RenderFlowInstanceDiagram(flowInstanceID string) ([]byte, error) {
+	return engine.RenderFlowInstanceDiagram(context.Background(), flowInstanceID)
+}
+
+// DiffFlowVersions 比较相同流程编号的两个流程版本
+func DiffFlowVersions(fromFlowID, toFlowID string) (*FlowDiff, error) {
+	return engine.DiffFlowVersions(context.Background(), fromFlowID, toFlowID)
+}
+
+// Simulate 按场景模拟运行流程(不写入数据库)
+func Simulate(flowCode string, scenario *SimulationScenario) (*SimulationResult, error) {
+	return engine.Simulate(context.Background(), flowCode, scenario)
+}
+
+// ActivateFlow 将流程设为启用的版本
+func ActivateFlow(flowID string) error {
+	return engine.ActivateFlow(context.Background(), flowID)
+}
+
+// SetManualActivation 设定是否手动启用流程版本(默认部署新版本后新版本为启用的版本)
+func SetManualActivation(manual bool) {
+	engine.SetManualActivation(manual)
+}
+
+// SetBusinessKeyUnique 设定流程编号下的业务主键是否唯一
+func SetBusinessKeyUnique(flowCode string, unique bool) {
+	engine.SetBusinessKeyUnique(flowCode, unique)
+}
+
+// GetFlowInstanceByBusinessKey 根据业务主键获取最近发起的流程实例
+func GetFlowInstanceByBusinessKey(flowCode, businessKey string) (*schema.FlowInstance, error) {
+	return engine.GetFlowInstanceByBusinessKey(context.Background(), flowCode, businessKey)
+}
+
+// QueryFlowInstancePage 查询流程实例分页数据
+func QueryFlowInstancePage(params schema.FlowInstanceQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowInstance, error) {
+	return engine.QueryFlowInstancePage(context.Background(), params, pageIndex, pageSize)
 }
 
 // HandleFlow 处理流程节点
@@ -96,6 +225,91 @@ func StopFlowInstance(flowInstanceID string, allowStop func(*schema.FlowInstance
 	return engine.StopFlowInstance(flowInstanceID, allowStop)
 }
 
+// SuspendFlowInstance 暂停流程实例
+func SuspendFlowInstance(flowInstanceID string) error {
+	return engine.SuspendFlowInstance(context.Background(), flowInstanceID)
+}
+
+// ResumeFlowInstance 恢复暂停的流程实例
+func ResumeFlowInstance(flowInstanceID string) error {
+	return engine.ResumeFlowInstance(context.Background(), flowInstanceID)
+}
+
+// AddListener 注册流程事件监听器
+func AddListener(listener Listener, opts ...ListenerOption) {
+	engine.AddListener(listener, opts...)
+}
+
+// EnableOutbox 启用事件发件箱
+func EnableOutbox(types ...EventType) {
+	engine.EnableOutbox(types...)
+}
+
+// NewOutboxRelay 创建发件箱中继
+func NewOutboxRelay(publisher Publisher, opts ...RelayOption) *OutboxRelay {
+	return engine.NewOutboxRelay(publisher, opts...)
+}
+
+// NewWebhookDispatcher 创建webhook投递器(secret为签名密钥，不能为空)
+func NewWebhookDispatcher(secret string, opts ...WebhookOption) (*WebhookDispatcher, error) {
+	return engine.NewWebhookDispatcher(secret, opts...)
+}
+
+// ExternalTask 获取外部任务服务
+func ExternalTask() *ExternalTaskService {
+	return engine.ExternalTask()
+}
+
+// SetJobRetry 设定作业失败时的最大重试次数及重试间隔
+func SetJobRetry(retries int, backoff func(attempts int) time.Duration) {
+	engine.SetJobRetry(retries, backoff)
+}
+
+// NewJobExecutor 创建作业执行器
+func NewJobExecutor(opts ...JobExecutorOption) *JobExecutor {
+	return engine.NewJobExecutor(opts...)
+}
+
+// CreateInstanceMigrationPlan 创建流程实例迁移计划
+func CreateInstanceMigrationPlan(sourceFlowID, targetFlowID string, mappings map[string]string) (*InstanceMigrationPlan, error) {
+	return engine.CreateInstanceMigrationPlan(context.Background(), sourceFlowID, targetFlowID, mappings)
+}
+
+// MigrateFlowInstances 按迁移计划将流程实例迁移到新版本
+func MigrateFlowInstances(plan *InstanceMigrationPlan, flowInstanceIDs []string, dryRun bool) (*InstanceMigrationResult, error) {
+	return engine.MigrateFlowInstances(context.Background(), plan, flowInstanceIDs, dryRun)
+}
+
+// NewRetention 创建流程实例保留任务
+func NewRetention(opts ...RetentionOption) *Retention {
+	return engine.NewRetention(opts...)
+}
+
+// QueryJobPage 查询作业分页数据
+func QueryJobPage(params schema.JobQueryParam, pageIndex, pageSize uint) (int64, []*schema.Job, error) {
+	return engine.QueryJobPage(context.Background(), params, pageIndex, pageSize)
+}
+
+// RetryJob 重新执行失败的作业
+func RetryJob(jobID string) error {
+	return engine.RetryJob(context.Background(), jobID)
+}
+
+// QueryOutboxPage 查询发件箱分页数据
+func QueryOutboxPage(params schema.OutboxQueryParam, pageIndex, pageSize uint) (int64, []*schema.Outbox, error) {
+	return engine.QueryOutboxPage(context.Background(), params, pageIndex, pageSize)
+}
+
+// RetryOutbox 重新投递失败的发件箱消息
+func RetryOutbox(outboxID string) error {
+	return engine.RetryOutbox(context.Background(), outboxID)
+}
+
+// QueryWebhookDelivery 查询流程实例的webhook投递记录
+func QueryWebhookDelivery(flowInstanceID string) ([]*schema.WebhookDelivery, error) {
+	return engine.QueryWebhookDelivery(context.Background(), flowInstanceID)
+}
+
 // QueryTodoFlows 查询流程待办数据
 // flowCode 流程编号
 // userID 待办人
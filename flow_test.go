@@ -1,19 +1,61 @@
 package flow_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/antlinker/flow"
+	"github.com/antlinker/flow/expression/sql"
 	"github.com/antlinker/flow/service/db"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// 通过环境变量FLOW_TEST_DIALECT指定测试使用的存储(memory/sqlite3/mysql/postgres，默认memory)，
+// FLOW_TEST_DSN指定数据库连接串(例如：root:123456@tcp(127.0.0.1:3306)/flow_test?charset=utf8)；
+// 仅设定FLOW_TEST_DSN时使用MySQL，sqlite3未指定连接串时使用临时目录下的数据库文件
+var (
+	testDialect = os.Getenv("FLOW_TEST_DIALECT")
+	testDSN     = os.Getenv("FLOW_TEST_DSN")
 )
 
 func init() {
-	flow.Init(
-		db.SetDSN("root:123456@tcp(127.0.0.1:3306)/flow_test?charset=utf8"),
+	if testDialect == "" && testDSN != "" {
+		testDialect = db.DialectMySQL
+	}
+
+	opts := []db.Option{
+		db.SetDSN(testDSN),
 		db.SetTrace(false),
-	)
+	}
+
+	flow.SetAutoMigrate(true)
+	switch testDialect {
+	case db.DialectMySQL:
+		flow.Init(opts...)
+	case db.DialectPostgres:
+		flow.InitPostgres(opts...)
+	case db.DialectSQLite:
+		if testDSN == "" {
+			name := filepath.Join(os.TempDir(), "flow_test.db")
+			_ = os.Remove(name)
+			testDSN = fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", name)
+			opts[0] = db.SetDSN(testDSN)
+		}
+		flow.InitSQLite(opts...)
+	default:
+		testDialect = ""
+		flow.InitMemory()
+	}
 
 	err := flow.LoadFile("test_data/leave.bpmn")
 	if err != nil {
@@ -29,6 +71,16 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+
+	err = flow.LoadFile("test_data/external_test.bpmn")
+	if err != nil {
+		panic(err)
+	}
+
+	err = flow.LoadFile("test_data/async_test.bpmn")
+	if err != nil {
+		panic(err)
+	}
 }
 
 func TestLeaveBzrApprovalPass(t *testing.T) {
@@ -208,7 +260,50 @@ func TestLeaveFdyApprovalPass(t *testing.T) {
 	}
 }
 
+// 准备SQL表达式查询的测试数据
+func prepareApplyUsers(t *testing.T) {
+	dialect, dsn := testDialect, testDSN
+	if dialect == "" {
+		// 内存存储时，SQL表达式查询SQLite数据库
+		dialect = db.DialectSQLite
+		dsn = fmt.Sprintf("file:%s", filepath.Join(os.TempDir(), "flow_test_users.db"))
+	}
+
+	sqlDB, _, err := db.Open(dialect, db.SetDSN(dsn))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if testDialect == "" {
+		sql.Reg(sqlDB)
+	} else {
+		defer sqlDB.Close()
+	}
+
+	_, err = sqlDB.Exec("CREATE TABLE IF NOT EXISTS test_apply_users (user_id VARCHAR(50), launcher VARCHAR(50))")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var n int
+	err = sqlDB.QueryRow("SELECT count(*) FROM test_apply_users").Scan(&n)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if n > 0 {
+		return
+	}
+
+	_, err = sqlDB.Exec("INSERT INTO test_apply_users (user_id, launcher) VALUES ('A002', 'A001'), ('A003', 'A001')")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
 func TestApplySQLPass(t *testing.T) {
+	if testDialect == db.DialectPostgres {
+		t.Skip("SQL表达式需要使用MySQL或SQLite测试")
+	}
+	prepareApplyUsers(t)
+
 	var (
 		flowCode = "process_apply_sqltest"
 	)
@@ -420,3 +515,560 @@ func TestLeaveRepeatedBack(t *testing.T) {
 		t.Fatalf("无效的下一级流转：%s", result.String())
 	}
 }
+
+func TestBusinessKey(t *testing.T) {
+	var (
+		flowCode    = "process_leave_test"
+		businessKey = fmt.Sprintf("leave-%d", time.Now().UnixNano())
+	)
+
+	flow.SetBusinessKeyUnique(flowCode, true)
+	defer flow.SetBusinessKeyUnique(flowCode, false)
+
+	input := map[string]interface{}{
+		"day": 1,
+		"bzr": "K002",
+	}
+
+	// 开始流程
+	result, err := flow.StartFlow(flowCode, "node_start", "K001", input, flow.BusinessKeyOption(businessKey))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if result.FlowInstance.BusinessKey != businessKey {
+		t.Fatalf("无效的业务主键：%s", result.String())
+	}
+
+	item, err := flow.GetFlowInstanceByBusinessKey(flowCode, businessKey)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if item == nil || item.RecordID != result.FlowInstance.RecordID {
+		t.Fatalf("无效的流程实例：%v", item)
+	}
+
+	// 重复发起
+	_, err = flow.StartFlow(flowCode, "node_start", "K001", input, flow.BusinessKeyOption(businessKey))
+	if err != flow.ErrBusinessKeyExists {
+		t.Fatalf("无效的重复发起结果：%v", err)
+	}
+
+	// 停止流程实例后释放业务主键
+	err = flow.StopFlowInstance(result.FlowInstance.RecordID, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = flow.StartFlow(flowCode, "node_start", "K001", input, flow.BusinessKeyOption(businessKey))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 并发发起时只有一个流程实例发起成功
+	var (
+		key     = businessKey + "-c"
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		success int
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := flow.StartFlow(flowCode, "node_start", "K001", input, flow.BusinessKeyOption(key))
+			if err == nil {
+				lock.Lock()
+				success++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if success != 1 {
+		t.Fatalf("并发发起成功的流程实例数量错误：%d", success)
+	}
+}
+
+// 未执行数据库迁移时，建表创建的唯一约束保证并发发起时只有一个流程实例发起成功
+func TestBusinessKeyWithoutMigration(t *testing.T) {
+	name := filepath.Join(os.TempDir(), "flow_business_key_test.db")
+	_ = os.Remove(name)
+
+	sdb, _, err := db.Open(db.DialectSQLite, db.SetDSN(fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate", name)), db.SetTrace(false))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer sdb.Close()
+
+	e, err := new(flow.Engine).InitWithDialect(flow.NewXMLParser(), flow.NewQLangExecer(), db.DialectSQLite, sdb, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = e.LoadFile("test_data/leave.bpmn")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	flowCode := "process_leave_test"
+	e.SetBusinessKeyUnique(flowCode, true)
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		success int
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := e.StartFlow(context.Background(), flowCode, "node_start", "K001", []byte(`{"day":1,"bzr":"K002"}`), flow.BusinessKeyOption("leave-1"))
+			if err == nil {
+				lock.Lock()
+				success++
+				lock.Unlock()
+			} else if err != flow.ErrBusinessKeyExists {
+				t.Error(err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	if success != 1 {
+		t.Fatalf("并发发起成功的流程实例数量错误：%d", success)
+	}
+
+	// 已锁定的业务主键由唯一约束拒绝再次锁定
+	ok, err := e.FlowBll().LockBusinessKey(flowCode, "leave-1", "FI-OTHER")
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if ok {
+		t.Fatal("已锁定的业务主键不应再次锁定")
+	}
+
+	// 唯一约束已存在，迁移时不再创建唯一索引
+	plans, err := e.Migrate(true)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, p := range plans {
+		for _, stmt := range p.Statements {
+			if strings.Contains(stmt, "uk_business_key") {
+				t.Fatalf("不应重复创建唯一索引：%s", stmt)
+			}
+		}
+	}
+}
+
+func TestTenant(t *testing.T) {
+	var (
+		flowCode = "process_leave_test"
+		bzr      = fmt.Sprintf("TT%d", time.Now().UnixNano())
+		e        = flow.DefaultEngine()
+		ctx1     = flow.WithTenant(context.Background(), "tenant1")
+		ctx2     = flow.WithTenant(context.Background(), "tenant2")
+	)
+
+	input := map[string]interface{}{
+		"day": 1,
+		"bzr": bzr,
+	}
+
+	// 租户发起全局的流程
+	result1, err := flow.StartFlowWithContext(ctx1, flowCode, "node_start", "TT001", input)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if result1.FlowInstance.TenantID != "tenant1" {
+		t.Fatalf("无效的流程实例租户：%s", result1.String())
+	}
+
+	// 其他租户不能查询及处理
+	todos, err := flow.QueryTodoFlows(flowCode, bzr)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(todos) != 0 {
+		t.Fatalf("无效的待办数据：%d", len(todos))
+	}
+
+	todos, err = e.QueryTodoFlowsWithContext(ctx1, flowCode, bzr)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(todos) != 1 {
+		t.Fatalf("无效的待办数据：%d", len(todos))
+	}
+
+	_, err = e.HandleFlow(ctx2, todos[0].RecordID, bzr, []byte(`{"action":"pass"}`))
+	if err != flow.ErrNotFound {
+		t.Fatalf("无效的处理结果：%v", err)
+	}
+
+	// 租户部署相同编号的流程
+	err = e.LoadFileWithContext(ctx2, "test_data/leave.bpmn")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result2, err := flow.StartFlowWithContext(ctx2, flowCode, "node_start", "TT001", input)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if result2.FlowInstance.FlowID == result1.FlowInstance.FlowID {
+		t.Fatalf("无效的流程：%s", result2.String())
+	}
+}
+
+func TestListener(t *testing.T) {
+	var (
+		flowCode  = "process_leave_test"
+		key       = fmt.Sprintf("listen-%d", time.Now().UnixNano())
+		rejectKey = key + "-reject"
+		events    []flow.EventType
+	)
+
+	flow.AddListener(flow.ListenerFunc(func(_ context.Context, event *flow.Event) error {
+		if event.FlowInstance == nil {
+			return nil
+		}
+
+		switch event.FlowInstance.BusinessKey {
+		case key:
+			events = append(events, event.Type)
+		case rejectKey:
+			return errors.New("拒绝发起")
+		}
+		return nil
+	}), flow.ListenFlowCodeOption(flowCode))
+
+	input := map[string]interface{}{
+		"day": 1,
+		"bzr": "L002",
+	}
+
+	_, err := flow.StartFlow(flowCode, "node_start", "L001", input, flow.BusinessKeyOption(key))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(events) == 0 || events[0] != flow.EventFlowStarted ||
+		events[len(events)-1] != flow.EventTaskAssigned {
+		t.Fatalf("无效的流程事件：%v", events)
+	}
+
+	// 同步监听返回错误时回滚
+	_, err = flow.StartFlow(flowCode, "node_start", "L001", input, flow.BusinessKeyOption(rejectKey))
+	if err == nil {
+		t.Fatal("监听器返回错误时应发起失败")
+	}
+
+	item, err := flow.GetFlowInstanceByBusinessKey(flowCode, rejectKey)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if item != nil {
+		t.Fatalf("流程实例未回滚：%v", item)
+	}
+}
+
+func TestErrorEvent(t *testing.T) {
+	var (
+		flowCode = "process_leave_test"
+		ctx      = context.Background()
+		user     = fmt.Sprintf("E%d", time.Now().UnixNano())
+		bzr      = user + "-bzr"
+		key      = user + "-key"
+		lock     sync.Mutex
+		errs     []string
+	)
+
+	// 错误事件按流程编号分发
+	flow.AddListener(flow.ListenerFunc(func(_ context.Context, event *flow.Event) error {
+		if event.UserID == user || event.UserID == bzr {
+			lock.Lock()
+			errs = append(errs, event.Error)
+			lock.Unlock()
+		}
+		return nil
+	}), flow.ListenFlowCodeOption(flowCode), flow.ListenEventOption(flow.EventError))
+
+	flow.SetBusinessKeyUnique(flowCode, true)
+	defer flow.SetBusinessKeyUnique(flowCode, false)
+
+	input := map[string]interface{}{
+		"day": 1,
+		"bzr": bzr,
+	}
+	result, err := flow.StartFlow(flowCode, "node_start", user, input, flow.BusinessKeyOption(key))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 按流程ID发起失败
+	data, _ := json.Marshal(input)
+	_, err = flow.DefaultEngine().LaunchFlow(ctx, result.FlowInstance.FlowID, user, data, flow.BusinessKeyOption(key))
+	if err != flow.ErrBusinessKeyExists {
+		t.Fatalf("无效的重复发起结果：%v", err)
+	}
+
+	// 处理暂停的流程实例失败
+	todos, err := flow.QueryTodoFlows(flowCode, bzr)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(todos) != 1 {
+		t.Fatalf("无效的待办数据：%d", len(todos))
+	}
+
+	err = flow.SuspendFlowInstance(result.FlowInstance.RecordID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = flow.HandleFlow(todos[0].RecordID, bzr, map[string]interface{}{"action": "pass"})
+	if err != flow.ErrFlowSuspended {
+		t.Fatalf("无效的处理结果：%v", err)
+	}
+
+	if len(errs) != 2 || errs[0] != flow.ErrBusinessKeyExists.Error() || errs[1] != flow.ErrFlowSuspended.Error() {
+		t.Fatalf("无效的错误事件：%v", errs)
+	}
+}
+
+type testPublisher struct {
+	messages map[string]*flow.OutboxMessage
+}
+
+func (p *testPublisher) Publish(_ context.Context, msg *flow.OutboxMessage) error {
+	p.messages[msg.FlowInstanceID+string(msg.Type)] = msg
+	return nil
+}
+
+func TestOutbox(t *testing.T) {
+	flow.EnableOutbox(flow.EventFlowStarted)
+
+	input := map[string]interface{}{
+		"day": 1,
+		"bzr": "M002",
+	}
+
+	result, err := flow.StartFlow("process_leave_test", "node_start", "M001", input)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	p := &testPublisher{messages: make(map[string]*flow.OutboxMessage)}
+	relay := flow.NewOutboxRelay(p)
+	for {
+		n, err := relay.Drain(context.Background())
+		if err != nil {
+			t.Fatal(err.Error())
+		} else if n == 0 {
+			break
+		}
+	}
+
+	msg, ok := p.messages[result.FlowInstance.RecordID+string(flow.EventFlowStarted)]
+	if !ok {
+		t.Fatal("未投递流程发起事件")
+	}
+
+	var event flow.Event
+	err = json.Unmarshal(msg.Payload, &event)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if event.FlowCode != "process_leave_test" || event.UserID != "M001" {
+		t.Fatalf("无效的事件数据：%s", string(msg.Payload))
+	}
+}
+
+func TestExternalTask(t *testing.T) {
+	flow.SetJobRetry(1, func(int) time.Duration { return 0 })
+	defer flow.SetJobRetry(0, nil)
+
+	var (
+		ctx    = context.Background()
+		topic  = "charge"
+		worker = fmt.Sprintf("worker-%d", time.Now().UnixNano())
+	)
+
+	result, err := flow.StartFlow("process_external_test", "node_start", "N001", map[string]interface{}{"amount": 10})
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if result.IsEnd {
+		t.Fatalf("外部任务未执行时流程不应结束：%s", result.String())
+	}
+
+	var fetch = func() string {
+		tasks, err := flow.ExternalTask().FetchAndLock(ctx, topic, worker, 100, time.Minute)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		for _, task := range tasks {
+			if task.FlowInstanceID == result.FlowInstance.RecordID {
+				return task.RecordID
+			}
+		}
+		return ""
+	}
+
+	taskID := fetch()
+	if taskID == "" {
+		t.Fatal("未拉取到外部任务")
+	}
+
+	// 已锁定的任务不能被其他执行者完成，发出流程的错误事件
+	var errs []string
+	flow.AddListener(flow.ListenerFunc(func(_ context.Context, event *flow.Event) error {
+		if event.UserID == worker+"-other" {
+			errs = append(errs, event.Error)
+		}
+		return nil
+	}), flow.ListenFlowCodeOption("process_external_test"), flow.ListenEventOption(flow.EventError))
+
+	_, err = flow.ExternalTask().Complete(ctx, taskID, worker+"-other", nil)
+	if err != flow.ErrJobNotLocked {
+		t.Fatalf("无效的完成结果：%v", err)
+	} else if len(errs) != 1 || errs[0] != flow.ErrJobNotLocked.Error() {
+		t.Fatalf("无效的错误事件：%v", errs)
+	}
+
+	// 失败后重新回到待执行状态
+	err = flow.ExternalTask().Failure(ctx, taskID, worker, "余额不足")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if id := fetch(); id != taskID {
+		t.Fatal("失败的外部任务未重新拉取")
+	}
+
+	err = flow.ExternalTask().ExtendLock(ctx, taskID, worker, time.Minute)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err = flow.ExternalTask().Complete(ctx, taskID, worker, []byte(`{"paid":true}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if !result.IsEnd {
+		t.Fatalf("无效的流程结束状态：%s", result.String())
+	}
+}
+
+func TestAsyncContinuation(t *testing.T) {
+	var (
+		ctx         = context.Background()
+		flowCode    = "process_async_test"
+		auditor     = "A002"
+		businessKey = fmt.Sprintf("async-%d", time.Now().UnixNano())
+	)
+
+	input := map[string]interface{}{
+		"auditor": auditor,
+	}
+
+	_, err := flow.StartFlow(flowCode, "node_start", "A001", input, flow.BusinessKeyOption(businessKey))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	todos, err := flow.QueryTodoFlows(flowCode, auditor)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(todos) == 0 {
+		t.Fatal("未查询到待办")
+	}
+
+	// 完成节点后异步继续，流程不应在当前事务中结束
+	result, err := flow.HandleFlow(todos[0].RecordID, auditor, input)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if result.IsEnd {
+		t.Fatalf("异步继续的流程不应结束：%s", result.String())
+	}
+
+	// 第一批执行完成节点后的作业，第二批执行进入结束节点前的作业
+	executor := flow.NewJobExecutor(flow.JobWorkersOption(2), flow.JobBatchSizeOption(100))
+	for i := 0; i < 2; i++ {
+		_, err = executor.Drain(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	item, err := flow.GetFlowInstanceByBusinessKey(flowCode, businessKey)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if item == nil || item.Status != 9 {
+		t.Fatalf("无效的流程实例状态：%v", item)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	var (
+		flowCode    = "process_leave_test"
+		businessKey = fmt.Sprintf("retention-%d", time.Now().UnixNano())
+	)
+
+	input := map[string]interface{}{
+		"day": 1,
+		"bzr": "R002",
+	}
+
+	result, err := flow.StartFlow(flowCode, "node_start", "R001", input, flow.BusinessKeyOption(businessKey))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = flow.StopFlowInstance(result.FlowInstance.RecordID, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 结束时间按秒记录
+	time.Sleep(time.Millisecond * 1100)
+
+	var archived int64
+	retention := flow.NewRetention(
+		flow.RetentionPolicyOption(flow.RetentionPolicy{FlowCode: flowCode, After: time.Millisecond}),
+		flow.RetentionProgressOption(func(p flow.RetentionProgress) {
+			archived = p.Archived
+		}),
+	)
+
+	n, err := retention.Run(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if n == 0 || archived != int64(n) {
+		t.Fatalf("无效的归档数量：%d,%d", n, archived)
+	}
+
+	// 归档后仍可查询
+	item, err := flow.GetFlowInstanceByBusinessKey(flowCode, businessKey)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if item == nil || item.RecordID != result.FlowInstance.RecordID {
+		t.Fatalf("无效的流程实例：%v", item)
+	}
+
+	history, err := flow.QueryFlowHistory(result.FlowInstance.RecordID)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(history) == 0 {
+		t.Fatalf("无效的历史数据：%d", len(history))
+	}
+
+	fi, err := flow.DefaultEngine().FlowBll().GetFlowInstance(result.FlowInstance.RecordID)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if fi == nil || fi.BusinessKey != businessKey {
+		t.Fatalf("无效的流程实例：%v", fi)
+	}
+
+	ids, err := flow.QueryDoneFlowIDs(flowCode, "R001")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var done bool
+	for _, id := range ids {
+		if id == result.FlowInstance.RecordID {
+			done = true
+		}
+	}
+	if !done {
+		t.Fatalf("已办理的流程实例应包括已归档的流程实例：%v", ids)
+	}
+}
@@ -0,0 +1,95 @@
+package flowtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseData 解析步骤的数据
+// 数据为JSON对象，键及字符串值可以不加引号(如{day:1,action:pass})，包含逗号、冒号等字符的字符串需要加引号
+func ParseData(s string) (map[string]interface{}, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &data); err == nil {
+		return data, nil
+	}
+
+	err := json.Unmarshal([]byte(relaxJSON(s)), &data)
+	if err != nil {
+		return nil, fmt.Errorf("无效的数据%s：%s", s, err.Error())
+	}
+	return data, nil
+}
+
+// 为未加引号的键及字符串值加上引号
+func relaxJSON(s string) string {
+	const delims = "{}[],:\""
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c == '"' {
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(s) {
+				j++
+			}
+			b.WriteString(s[i:j])
+			i = j
+			continue
+		} else if strings.IndexByte(delims, c) >= 0 {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(s) && strings.IndexByte(delims, s[j]) < 0 {
+			j++
+		}
+		word := strings.TrimSpace(s[i:j])
+		if word == "" || json.Valid([]byte(word)) {
+			b.WriteString(s[i:j])
+		} else {
+			b.WriteString(strconv.Quote(word))
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// 将YAML解析的map[interface{}]interface{}转换为map[string]interface{}，以便按JSON处理
+func normalize(v interface{}) interface{} {
+	switch item := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(item))
+		for k, v := range item {
+			m[fmt.Sprint(k)] = normalize(v)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(item))
+		for k, v := range item {
+			m[k] = normalize(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(item))
+		for i, v := range item {
+			s[i] = normalize(v)
+		}
+		return s
+	}
+	return v
+}
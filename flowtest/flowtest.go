@@ -0,0 +1,532 @@
+package flowtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antlinker/flow"
+	"github.com/antlinker/flow/schema"
+)
+
+// 步骤的格式
+var (
+	startPattern    = regexp.MustCompile(`^start(?:\s+(\S+))?\s+as\s+(\S+)(?:\s+with\s+(.+))?$`)
+	handlePattern   = regexp.MustCompile(`^(\S+)\s+handles(?:\s+(\S+))?(?:\s+with\s+(.+))?$`)
+	completePattern = regexp.MustCompile(`^(\S+)\s+completes\s+(\S+)(?:\s+with\s+(.+))?$`)
+)
+
+type options struct {
+	ctx      context.Context
+	execer   flow.Execer
+	resolver flow.GroupResolver
+}
+
+// Option 测试配置
+type Option func(*options)
+
+// ContextOption 流程处理使用的上下文(如租户)
+func ContextOption(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// ExecerOption 表达式执行器(默认使用qlang)
+func ExecerOption(execer flow.Execer) Option {
+	return func(o *options) {
+		o.execer = execer
+	}
+}
+
+// GroupResolverOption 候选组解析器
+func GroupResolverOption(resolver flow.GroupResolver) Option {
+	return func(o *options) {
+		o.resolver = resolver
+	}
+}
+
+// Harness 基于内存存储的流程测试
+// 按步骤发起、处理流程，并断言当前节点、候选人、流程变量及流程是否结束，失败时终止测试
+type Harness struct {
+	t            testing.TB
+	ctx          context.Context
+	engine       *flow.Engine
+	flows        map[string]string // 部署的流程(流程编号 -> 流程内码)
+	flowCode     string
+	flowInstance *schema.FlowInstance
+}
+
+// New 创建流程测试
+func New(t testing.TB, opts ...Option) *Harness {
+	o := &options{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	engine := flow.NewMemoryEngine()
+	if o.execer != nil {
+		engine.SetExecer(o.execer)
+	}
+	if o.resolver != nil {
+		engine.SetGroupResolver(o.resolver)
+	}
+
+	return &Harness{
+		t:      t,
+		ctx:    o.ctx,
+		engine: engine,
+		flows:  make(map[string]string),
+	}
+}
+
+// Engine 获取测试使用的流程引擎
+func (h *Harness) Engine() *flow.Engine {
+	return h.engine
+}
+
+// FlowInstance 获取当前测试的流程实例
+func (h *Harness) FlowInstance() *schema.FlowInstance {
+	if h.flowInstance == nil {
+		return nil
+	}
+
+	item, err := h.engine.FlowBll().GetFlowInstance(h.flowInstance.RecordID)
+	if err != nil {
+		h.t.Fatalf("查询流程实例发生错误：%s", err.Error())
+	}
+	return item
+}
+
+// Load 部署流程文件(.bpmn/.xml、.json、.yaml/.yml)，最后部署的流程作为默认发起的流程
+// 部署的流程不论是否可用(如isExecutable="false")都可以发起
+func (h *Harness) Load(names ...string) *Harness {
+	h.t.Helper()
+
+	for _, name := range names {
+		err := h.load(name)
+		if err != nil {
+			h.t.Fatalf("部署流程文件[%s]发生错误：%s", name, err.Error())
+		}
+	}
+	return h
+}
+
+func (h *Harness) load(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	format := flow.FormatByExtension(name)
+	if format == "" {
+		format = flow.DetectFormat(data)
+	}
+
+	flowID, err := h.engine.CreateFlowWithFormat(h.ctx, format, data)
+	if err != nil {
+		return err
+	}
+
+	f, err := h.engine.FlowBll().GetFlow(flowID)
+	if err != nil {
+		return err
+	} else if f == nil {
+		return flow.ErrNotFound
+	}
+	h.flows[f.Code] = flowID
+	h.flowCode = f.Code
+	return nil
+}
+
+// Flow 设定默认发起的流程编号
+func (h *Harness) Flow(flowCode string) *Harness {
+	h.flowCode = flowCode
+	return h
+}
+
+// Do 按顺序执行步骤，支持的步骤：
+// start [流程编号] as 发起人 [with 数据]
+// 处理人 handles [节点ID] with 数据
+// 执行者 completes 服务任务节点ID [with 数据]
+// 数据为JSON对象，键及字符串值可以不加引号(如{day:1,action:pass})；处理时的数据合并到节点的输入数据
+func (h *Harness) Do(steps ...string) *Harness {
+	h.t.Helper()
+
+	for _, step := range steps {
+		err := h.do(strings.TrimSpace(step))
+		if err != nil {
+			h.t.Fatalf("执行步骤[%s]发生错误：%s", step, err.Error())
+		}
+	}
+	return h
+}
+
+func (h *Harness) do(step string) error {
+	if m := startPattern.FindStringSubmatch(step); m != nil {
+		data, err := ParseData(m[3])
+		if err != nil {
+			return err
+		}
+		return h.start(m[1], m[2], data)
+	}
+
+	if m := completePattern.FindStringSubmatch(step); m != nil {
+		data, err := ParseData(m[3])
+		if err != nil {
+			return err
+		}
+		return h.complete(m[1], m[2], data)
+	}
+
+	if m := handlePattern.FindStringSubmatch(step); m != nil {
+		data, err := ParseData(m[3])
+		if err != nil {
+			return err
+		}
+		return h.handle(m[1], m[2], data)
+	}
+
+	return fmt.Errorf("无法识别的步骤")
+}
+
+// Start 发起流程(flowCode为空时发起默认的流程)
+func (h *Harness) Start(flowCode, launcher string, data map[string]interface{}) *Harness {
+	h.t.Helper()
+
+	err := h.start(flowCode, launcher, data)
+	if err != nil {
+		h.t.Fatalf("发起流程发生错误：%s", err.Error())
+	}
+	return h
+}
+
+func (h *Harness) start(flowCode, launcher string, data map[string]interface{}) error {
+	if flowCode == "" {
+		flowCode = h.flowCode
+	}
+
+	flowID, ok := h.flows[flowCode]
+	if !ok {
+		return fmt.Errorf("流程[%s]未部署", flowCode)
+	}
+
+	input, err := marshalData(nil, data)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.engine.LaunchFlow(h.ctx, flowID, launcher, input)
+	if err != nil {
+		return err
+	}
+	h.flowCode = flowCode
+	h.flowInstance = result.FlowInstance
+	return h.drain()
+}
+
+// Handle 处理人处理待办(nodeCode为空时处理人在流程实例中只能有一个待办)
+func (h *Harness) Handle(userID, nodeCode string, data map[string]interface{}) *Harness {
+	h.t.Helper()
+
+	err := h.handle(userID, nodeCode, data)
+	if err != nil {
+		h.t.Fatalf("处理待办发生错误：%s", err.Error())
+	}
+	return h
+}
+
+func (h *Harness) handle(userID, nodeCode string, data map[string]interface{}) error {
+	if h.flowInstance == nil {
+		return fmt.Errorf("流程未发起")
+	}
+
+	todos, err := h.engine.QueryTodoFlowsWithContext(h.ctx, h.flowCode, userID)
+	if err != nil {
+		return err
+	}
+
+	var items []*schema.FlowTodoResult
+	for _, todo := range todos {
+		if todo.FlowInstanceID == h.flowInstance.RecordID && (nodeCode == "" || todo.NodeCode == nodeCode) {
+			items = append(items, todo)
+		}
+	}
+	if len(items) == 0 && nodeCode != "" {
+		return fmt.Errorf("用户[%s]没有待处理的节点[%s]，当前节点：%v", userID, nodeCode, h.CurrentNodes())
+	} else if len(items) == 0 {
+		return fmt.Errorf("用户[%s]没有待处理的节点，当前节点：%v", userID, h.CurrentNodes())
+	} else if len(items) > 1 {
+		return fmt.Errorf("用户[%s]存在多个待处理的节点，需要指定节点ID", userID)
+	}
+
+	input, err := marshalData([]byte(items[0].InputData), data)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.engine.HandleFlow(h.ctx, items[0].RecordID, userID, input)
+	if err != nil {
+		return err
+	}
+	return h.drain()
+}
+
+// Complete 执行者完成服务任务的外部任务
+func (h *Harness) Complete(workerID, nodeCode string, data map[string]interface{}) *Harness {
+	h.t.Helper()
+
+	err := h.complete(workerID, nodeCode, data)
+	if err != nil {
+		h.t.Fatalf("完成外部任务发生错误：%s", err.Error())
+	}
+	return h
+}
+
+func (h *Harness) complete(workerID, nodeCode string, data map[string]interface{}) error {
+	nodeInstance, node, err := h.pending(nodeCode)
+	if err != nil {
+		return err
+	} else if nodeInstance == nil {
+		return fmt.Errorf("节点[%s]未处于待处理状态", nodeCode)
+	}
+
+	tasks, err := h.engine.ExternalTask().FetchAndLock(h.ctx, node.Topic, workerID, 100, time.Minute)
+	if err != nil {
+		return err
+	}
+
+	var task *schema.ExternalTaskResult
+	for _, item := range tasks {
+		if item.NodeInstanceID == nodeInstance.RecordID {
+			task = item
+			continue
+		}
+		// 释放其他节点的外部任务
+		_ = h.engine.ExternalTask().ExtendLock(h.ctx, item.RecordID, workerID, 0)
+	}
+	if task == nil {
+		return fmt.Errorf("节点[%s]没有可执行的外部任务", nodeCode)
+	}
+
+	output, err := marshalData([]byte(task.InputData), data)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.engine.ExternalTask().Complete(h.ctx, task.RecordID, workerID, output)
+	if err != nil {
+		return err
+	}
+	return h.drain()
+}
+
+// 执行异步继续的作业，直到没有到期的作业
+func (h *Harness) drain() error {
+	executor := h.engine.NewJobExecutor(flow.JobWorkersOption(1))
+	for {
+		n, err := executor.Drain(h.ctx)
+		if err != nil {
+			return err
+		} else if n == 0 {
+			return nil
+		}
+	}
+}
+
+// 查询流程实例的节点实例
+func (h *Harness) nodeInstances() ([]*schema.NodeInstance, error) {
+	if h.flowInstance == nil {
+		return nil, fmt.Errorf("流程未发起")
+	}
+	return h.engine.FlowBll().QueryNodeInstances(h.flowInstance.RecordID)
+}
+
+// 查询待处理的节点实例
+func (h *Harness) pending(nodeCode string) (*schema.NodeInstance, *schema.Node, error) {
+	items, err := h.nodeInstances()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, item := range items {
+		if item.Status != 1 {
+			continue
+		}
+
+		node, err := h.engine.FlowBll().GetNode(item.NodeID)
+		if err != nil {
+			return nil, nil, err
+		} else if node != nil && node.Code == nodeCode {
+			return item, node, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// CurrentNodes 获取待处理的节点ID(已排序)
+func (h *Harness) CurrentNodes() []string {
+	h.t.Helper()
+
+	items, err := h.nodeInstances()
+	if err != nil {
+		h.t.Fatalf("查询节点实例发生错误：%s", err.Error())
+	}
+
+	codes := []string{}
+	for _, item := range items {
+		if item.Status != 1 {
+			continue
+		}
+
+		node, err := h.engine.FlowBll().GetNode(item.NodeID)
+		if err != nil {
+			h.t.Fatalf("查询节点发生错误：%s", err.Error())
+		} else if node != nil {
+			codes = append(codes, node.Code)
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Candidates 获取待处理节点的候选人
+func (h *Harness) Candidates(nodeCode string) []string {
+	h.t.Helper()
+
+	nodeInstance, _, err := h.pending(nodeCode)
+	if err != nil {
+		h.t.Fatalf("查询节点实例发生错误：%s", err.Error())
+	} else if nodeInstance == nil {
+		h.t.Fatalf("节点[%s]未处于待处理状态，当前节点：%v", nodeCode, h.CurrentNodes())
+	}
+
+	candidates, err := h.engine.QueryNodeCandidatesWithContext(h.ctx, nodeInstance.RecordID)
+	if err != nil {
+		h.t.Fatalf("查询节点候选人发生错误：%s", err.Error())
+	}
+	if candidates == nil {
+		candidates = []string{}
+	}
+	return candidates
+}
+
+// Variables 获取流程变量(最近流转的节点的输入数据)
+func (h *Harness) Variables() map[string]interface{} {
+	h.t.Helper()
+
+	items, err := h.nodeInstances()
+	if err != nil {
+		h.t.Fatalf("查询节点实例发生错误：%s", err.Error())
+	}
+
+	vars := make(map[string]interface{})
+	if len(items) > 0 && items[len(items)-1].InputData != "" {
+		err = json.Unmarshal([]byte(items[len(items)-1].InputData), &vars)
+		if err != nil {
+			h.t.Fatalf("解析流程变量发生错误：%s", err.Error())
+		}
+	}
+	return vars
+}
+
+// Completed 流程是否结束
+func (h *Harness) Completed() bool {
+	h.t.Helper()
+
+	item := h.FlowInstance()
+	if item == nil {
+		h.t.Fatalf("流程未发起")
+	}
+	return item.Status == 9
+}
+
+// AssertCurrentNodes 断言待处理的节点(不区分顺序)
+func (h *Harness) AssertCurrentNodes(nodeCodes ...string) *Harness {
+	h.t.Helper()
+
+	expect := append([]string{}, nodeCodes...)
+	sort.Strings(expect)
+	if actual := h.CurrentNodes(); !reflect.DeepEqual(actual, expect) {
+		h.t.Fatalf("待处理的节点为%v，期望为%v", actual, expect)
+	}
+	return h
+}
+
+// AssertCandidates 断言待处理节点的候选人(不区分顺序)
+func (h *Harness) AssertCandidates(nodeCode string, userIDs ...string) *Harness {
+	h.t.Helper()
+
+	actual := append([]string{}, h.Candidates(nodeCode)...)
+	expect := append([]string{}, userIDs...)
+	sort.Strings(actual)
+	sort.Strings(expect)
+	if !reflect.DeepEqual(actual, expect) {
+		h.t.Fatalf("节点[%s]的候选人为%v，期望为%v", nodeCode, actual, expect)
+	}
+	return h
+}
+
+// AssertVariables 断言流程变量(只比较指定的变量，值按JSON比较)
+func (h *Harness) AssertVariables(vars map[string]interface{}) *Harness {
+	h.t.Helper()
+
+	actual := h.Variables()
+	for name, value := range vars {
+		v, ok := actual[name]
+		if !ok {
+			h.t.Fatalf("流程变量[%s]不存在", name)
+		}
+
+		a, _ := json.Marshal(v)
+		e, err := json.Marshal(normalize(value))
+		if err != nil {
+			h.t.Fatalf("无效的流程变量[%s]：%s", name, err.Error())
+		}
+		if string(a) != string(e) {
+			h.t.Fatalf("流程变量[%s]为%s，期望为%s", name, a, e)
+		}
+	}
+	return h
+}
+
+// AssertCompleted 断言流程已结束
+func (h *Harness) AssertCompleted() *Harness {
+	h.t.Helper()
+
+	if !h.Completed() {
+		h.t.Fatalf("流程未结束，待处理的节点：%v", h.CurrentNodes())
+	}
+	return h
+}
+
+// AssertRunning 断言流程进行中
+func (h *Harness) AssertRunning() *Harness {
+	h.t.Helper()
+
+	if h.Completed() {
+		h.t.Fatalf("流程已结束")
+	}
+	return h
+}
+
+// 合并输入数据
+func marshalData(base []byte, data map[string]interface{}) ([]byte, error) {
+	m := make(map[string]interface{})
+	if len(base) > 0 {
+		if err := json.Unmarshal(base, &m); err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range data {
+		m[k] = normalize(v)
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,62 @@
+package flowtest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLeaveBzrApprovalBack(t *testing.T) {
+	h := New(t).Load("../test_data/leave.bpmn")
+
+	h.Do("start as T001 with {day:1,bzr:T002}").
+		AssertCurrentNodes("node_user_bzr").
+		AssertCandidates("node_user_bzr", "T002").
+		AssertRunning()
+
+	h.Do("T002 handles with {action:back}").
+		AssertCurrentNodes("node_user_apply").
+		AssertCandidates("node_user_apply", "T001").
+		AssertVariables(map[string]interface{}{"day": 1, "action": "back"})
+
+	h.Do("T001 handles node_user_apply with {day:2,fdy:T003}", "T002 handles with {action:pass}").
+		AssertCurrentNodes("node_user_fdy").
+		AssertCandidates("node_user_fdy", "T003")
+
+	h.Do("T003 handles with {action:pass}").
+		AssertCurrentNodes().
+		AssertCompleted()
+}
+
+func TestExternalTask(t *testing.T) {
+	New(t).Load("../test_data/external_test.bpmn").
+		Do("start as T001 with {amount:100}").
+		AssertCurrentNodes("node_charge").
+		Do("worker1 completes node_charge with {paid:true}").
+		AssertCompleted().
+		AssertVariables(map[string]interface{}{"amount": 100, "paid": true})
+}
+
+func TestRunFiles(t *testing.T) {
+	RunFiles(t, "../test_data/*.scenario.yaml")
+}
+
+func TestParseData(t *testing.T) {
+	for s, expect := range map[string]map[string]interface{}{
+		``:                                 nil,
+		`{"day":1}`:                        {"day": float64(1)},
+		`{day:1,action:pass}`:              {"day": float64(1), "action": "pass"},
+		`{ok: true, name: "a,b", v: null}`: {"ok": true, "name": "a,b", "v": nil},
+		`{users:[T001, T002],day:1.5}`:     {"users": []interface{}{"T001", "T002"}, "day": 1.5},
+	} {
+		data, err := ParseData(s)
+		if err != nil {
+			t.Fatalf("解析数据[%s]发生错误：%s", s, err.Error())
+		} else if !reflect.DeepEqual(data, expect) {
+			t.Fatalf("解析数据[%s]错误：%v", s, data)
+		}
+	}
+
+	if _, err := ParseData(`{day:`); err == nil {
+		t.Fatal("无效的数据应返回错误")
+	}
+}
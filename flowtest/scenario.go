@@ -0,0 +1,139 @@
+package flowtest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Scenario 测试场景
+type Scenario struct {
+	Name  string   `yaml:"name"`  // 场景名称
+	Files []string `yaml:"files"` // 部署的流程文件(相对路径为相对于场景文件所在的目录)
+	Flow  string   `yaml:"flow"`  // 发起的流程编号(默认为最后部署的流程)
+	Steps []*Step  `yaml:"steps"` // 按顺序执行的步骤
+}
+
+// Step 场景的步骤，为执行的步骤(如"start as T001 with {day:1}")或断言(expect)
+type Step struct {
+	Action string  `yaml:"-"`      // 执行的步骤
+	Expect *Expect `yaml:"expect"` // 断言
+}
+
+// UnmarshalYAML 解析YAML格式的步骤
+func (s *Step) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&s.Action); err == nil {
+		return nil
+	}
+
+	var item struct {
+		Expect *Expect `yaml:"expect"`
+	}
+	err := unmarshal(&item)
+	if err != nil {
+		return err
+	}
+	s.Expect = item.Expect
+	return nil
+}
+
+// Expect 断言(未设定的项不断言)
+type Expect struct {
+	Nodes      []string               `yaml:"nodes"`      // 待处理的节点(不区分顺序)
+	Candidates map[string][]string    `yaml:"candidates"` // 待处理节点的候选人(节点ID -> 候选人)
+	Variables  map[string]interface{} `yaml:"variables"`  // 流程变量
+	Completed  *bool                  `yaml:"completed"`  // 流程是否结束
+}
+
+// LoadScenario 加载YAML格式的测试场景
+func LoadScenario(name string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var sc Scenario
+	err = yaml.Unmarshal(data, &sc)
+	if err != nil {
+		return nil, err
+	}
+
+	if sc.Name == "" {
+		sc.Name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	}
+	for i, f := range sc.Files {
+		if !filepath.IsAbs(f) {
+			sc.Files[i] = filepath.Join(filepath.Dir(name), f)
+		}
+	}
+	return &sc, nil
+}
+
+// RunFiles 按文件名匹配模式(如test_data/*.scenario.yaml)加载测试场景，并分别作为子测试执行
+func RunFiles(t *testing.T, pattern string, opts ...Option) {
+	t.Helper()
+
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(names) == 0 {
+		t.Fatalf("未找到测试场景文件：%s", pattern)
+	}
+
+	for _, name := range names {
+		sc, err := LoadScenario(name)
+		if err != nil {
+			t.Fatalf("加载测试场景[%s]发生错误：%s", name, err.Error())
+		}
+
+		t.Run(sc.Name, func(t *testing.T) {
+			New(t, opts...).Run(sc)
+		})
+	}
+}
+
+// Run 执行测试场景
+func (h *Harness) Run(sc *Scenario) *Harness {
+	h.t.Helper()
+
+	h.Load(sc.Files...)
+	if sc.Flow != "" {
+		h.Flow(sc.Flow)
+	}
+
+	for _, step := range sc.Steps {
+		if step.Action != "" {
+			h.Do(step.Action)
+		}
+		if step.Expect != nil {
+			h.Expect(step.Expect)
+		}
+	}
+	return h
+}
+
+// Expect 执行断言
+func (h *Harness) Expect(e *Expect) *Harness {
+	h.t.Helper()
+
+	if e.Nodes != nil {
+		h.AssertCurrentNodes(e.Nodes...)
+	}
+	for nodeCode, candidates := range e.Candidates {
+		h.AssertCandidates(nodeCode, candidates...)
+	}
+	if len(e.Variables) > 0 {
+		h.AssertVariables(e.Variables)
+	}
+	if e.Completed != nil {
+		if *e.Completed {
+			h.AssertCompleted()
+		} else {
+			h.AssertRunning()
+		}
+	}
+	return h
+}
@@ -0,0 +1,324 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/antlinker/flow/bll"
+	"github.com/antlinker/flow/schema"
+	"github.com/pkg/errors"
+)
+
+// 定义错误
+var (
+	ErrInvalidMigrationPlan = errors.New("无效的流程实例迁移计划")
+)
+
+// NodeMapping 节点映射
+type NodeMapping struct {
+	SourceNodeCode string `json:"source_node_code"` // 原版本的节点编号
+	TargetNodeCode string `json:"target_node_code"` // 新版本的节点编号
+	SourceNodeID   string `json:"source_node_id"`   // 原版本的节点内码
+	TargetNodeID   string `json:"target_node_id"`   // 新版本的节点内码
+}
+
+// InstanceMigrationPlan 流程实例迁移计划
+type InstanceMigrationPlan struct {
+	SourceFlowID string         `json:"source_flow_id"` // 原版本的流程内码
+	TargetFlowID string         `json:"target_flow_id"` // 新版本的流程内码
+	Mappings     []*NodeMapping `json:"mappings"`       // 节点映射
+}
+
+// NodeInstanceMigration 节点实例的迁移
+type NodeInstanceMigration struct {
+	NodeInstanceID string `json:"node_instance_id"` // 节点实例内码
+	SourceNodeCode string `json:"source_node_code"` // 原版本的节点编号
+	TargetNodeCode string `json:"target_node_code"` // 新版本的节点编号
+	SourceNodeID   string `json:"source_node_id"`   // 原版本的节点内码
+	TargetNodeID   string `json:"target_node_id"`   // 新版本的节点内码
+}
+
+// FlowInstanceMigration 流程实例的迁移
+type FlowInstanceMigration struct {
+	FlowInstanceID string                   `json:"flow_instance_id"` // 流程实例内码
+	NodeInstances  []*NodeInstanceMigration `json:"node_instances"`   // 重新绑定的节点实例
+}
+
+// InstanceMigrationResult 流程实例迁移结果
+type InstanceMigrationResult struct {
+	DryRun        bool                     `json:"dry_run"`        // 是否仅检查迁移计划
+	FlowInstances []*FlowInstanceMigration `json:"flow_instances"` // 迁移的流程实例
+	Problems      []string                 `json:"problems"`       // 不能迁移的原因
+}
+
+// CreateInstanceMigrationPlan 创建流程实例迁移计划
+// 原版本的节点自动映射到新版本中编号相同的节点，mappings(原节点编号->新节点编号)指定的映射优先，新节点编号为空时取消该节点的映射
+func (e *Engine) CreateInstanceMigrationPlan(ctx context.Context, sourceFlowID, targetFlowID string, mappings map[string]string) (*InstanceMigrationPlan, error) {
+	flowBll := e.tenantBll(ctx)
+	sourceNodes, targetNodes, err := e.migrationNodes(flowBll, sourceFlowID, targetFlowID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &InstanceMigrationPlan{
+		SourceFlowID: sourceFlowID,
+		TargetFlowID: targetFlowID,
+	}
+
+	for code := range mappings {
+		if _, ok := sourceNodes[code]; !ok {
+			return nil, errors.Wrapf(ErrInvalidMigrationPlan, "原版本不存在节点[%s]", code)
+		}
+	}
+
+	for _, code := range sortedNodeCodes(sourceNodes) {
+		targetCode, ok := mappings[code]
+		if !ok {
+			if _, exists := targetNodes[code]; !exists {
+				continue
+			}
+			targetCode = code
+		} else if targetCode == "" {
+			continue
+		}
+
+		plan.Mappings = append(plan.Mappings, &NodeMapping{
+			SourceNodeCode: code,
+			TargetNodeCode: targetCode,
+		})
+	}
+
+	err = validateMigrationPlan(plan, sourceNodes, targetNodes)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// MigrateFlowInstances 按迁移计划将流程实例迁移到新版本
+// 重新绑定流程实例的流程及节点实例的节点(节点实例的数据及候选人保持不变)，所有流程实例在同一事务中检查并迁移；
+// 进行中的节点实例必须存在映射，未映射的已完成节点实例保留原版本的节点；
+// dryRun为true时仅检查迁移计划，返回迁移结果但不执行，存在不能迁移的流程实例时返回ErrInvalidMigrationPlan
+func (e *Engine) MigrateFlowInstances(ctx context.Context, plan *InstanceMigrationPlan, flowInstanceIDs []string, dryRun bool) (*InstanceMigrationResult, error) {
+	result := &InstanceMigrationResult{DryRun: dryRun}
+	err := e.transaction(ctx, nil, func(flowBll *bll.Flow, emitter *eventEmitter) error {
+		flowInstances, err := e.checkInstanceMigration(flowBll, plan, flowInstanceIDs, result)
+		if err != nil || dryRun {
+			return err
+		}
+
+		for i, item := range result.FlowInstances {
+			nodes := make(map[string]string)
+			for _, ni := range item.NodeInstances {
+				nodes[ni.NodeInstanceID] = ni.TargetNodeID
+			}
+
+			// 迁移时再次检查流程实例的版本及状态，避免检查后被并发的处理变更
+			ok, err := flowBll.MigrateFlowInstance(item.FlowInstanceID, plan.SourceFlowID, plan.TargetFlowID, nodes)
+			if err != nil {
+				return err
+			} else if !ok {
+				return errors.Wrapf(ErrInvalidMigrationPlan, "流程实例[%s]已变更", item.FlowInstanceID)
+			}
+
+			flowInstance := flowInstances[i]
+			flowInstance.FlowID = plan.TargetFlowID
+			err = emitter.emit(&Event{
+				Type:         EventFlowMigrated,
+				FlowInstance: flowInstance,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if len(result.Problems) > 0 {
+			return result, err
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// 检查流程实例能否按迁移计划迁移，将迁移的流程实例及不能迁移的原因写入result
+func (e *Engine) checkInstanceMigration(flowBll *bll.Flow, plan *InstanceMigrationPlan, flowInstanceIDs []string, result *InstanceMigrationResult) ([]*schema.FlowInstance, error) {
+	sourceNodes, targetNodes, err := e.migrationNodes(flowBll, plan.SourceFlowID, plan.TargetFlowID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateMigrationPlan(plan, sourceNodes, targetNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := make(map[string]*NodeMapping)
+	for _, m := range plan.Mappings {
+		mappings[m.SourceNodeID] = m
+	}
+
+	var flowInstances []*schema.FlowInstance
+	for _, id := range flowInstanceIDs {
+		flowInstance, err := flowBll.GetFlowInstance(id)
+		if err != nil {
+			return nil, err
+		} else if flowInstance == nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("流程实例[%s]不存在", id))
+			continue
+		} else if flowInstance.FlowID != plan.SourceFlowID {
+			result.Problems = append(result.Problems, fmt.Sprintf("流程实例[%s]不属于原版本的流程", id))
+			continue
+		} else if flowInstance.Status != 1 && flowInstance.Status != 2 {
+			result.Problems = append(result.Problems, fmt.Sprintf("流程实例[%s]已结束", id))
+			continue
+		}
+
+		nodeInstances, err := flowBll.QueryNodeInstances(id)
+		if err != nil {
+			return nil, err
+		}
+
+		item := &FlowInstanceMigration{FlowInstanceID: id}
+		for _, ni := range nodeInstances {
+			m, ok := mappings[ni.NodeID]
+			if !ok {
+				if ni.Status == 1 {
+					code := ni.NodeID
+					if n, ok := sourceNodes.byID(ni.NodeID); ok {
+						code = n.Code
+					}
+					result.Problems = append(result.Problems, fmt.Sprintf("流程实例[%s]进行中的节点[%s]没有映射", id, code))
+				}
+				continue
+			}
+
+			item.NodeInstances = append(item.NodeInstances, &NodeInstanceMigration{
+				NodeInstanceID: ni.RecordID,
+				SourceNodeCode: m.SourceNodeCode,
+				TargetNodeCode: m.TargetNodeCode,
+				SourceNodeID:   m.SourceNodeID,
+				TargetNodeID:   m.TargetNodeID,
+			})
+		}
+		result.FlowInstances = append(result.FlowInstances, item)
+		flowInstances = append(flowInstances, flowInstance)
+	}
+
+	if len(result.Problems) > 0 {
+		return nil, errors.Wrap(ErrInvalidMigrationPlan, strings.Join(result.Problems, "；"))
+	}
+	return flowInstances, nil
+}
+
+// 迁移的节点(按节点编号)
+type migrationNodes map[string]*schema.Node
+
+func (m migrationNodes) byID(nodeID string) (*schema.Node, bool) {
+	for _, n := range m {
+		if n.RecordID == nodeID {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// 获取原版本及新版本的流程节点
+func (e *Engine) migrationNodes(flowBll *bll.Flow, sourceFlowID, targetFlowID string) (migrationNodes, migrationNodes, error) {
+	source, err := flowBll.GetFlow(sourceFlowID)
+	if err != nil {
+		return nil, nil, err
+	} else if source == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	target, err := flowBll.GetFlow(targetFlowID)
+	if err != nil {
+		return nil, nil, err
+	} else if target == nil {
+		return nil, nil, ErrNotFound
+	}
+
+	if source.Code != target.Code || source.TenantID != target.TenantID {
+		return nil, nil, errors.Wrapf(ErrInvalidMigrationPlan, "流程[%s]与流程[%s]不是同一流程的版本", source.Code, target.Code)
+	} else if source.RecordID == target.RecordID {
+		return nil, nil, errors.Wrap(ErrInvalidMigrationPlan, "原版本与新版本相同")
+	}
+
+	sourceNodes, err := e.queryMigrationNodes(flowBll, sourceFlowID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targetNodes, err := e.queryMigrationNodes(flowBll, targetFlowID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sourceNodes, targetNodes, nil
+}
+
+func (e *Engine) queryMigrationNodes(flowBll *bll.Flow, flowID string) (migrationNodes, error) {
+	nodes, err := flowBll.QueryNodes(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(migrationNodes)
+	for _, n := range nodes {
+		if _, ok := items[n.Code]; !ok {
+			items[n.Code] = n
+		}
+	}
+	return items, nil
+}
+
+// 检查迁移计划，并设定映射的节点内码
+func validateMigrationPlan(plan *InstanceMigrationPlan, sourceNodes, targetNodes migrationNodes) error {
+	var problems []string
+	codes := make(map[string]bool)
+	for _, m := range plan.Mappings {
+		if codes[m.SourceNodeCode] {
+			problems = append(problems, fmt.Sprintf("节点[%s]重复映射", m.SourceNodeCode))
+			continue
+		}
+		codes[m.SourceNodeCode] = true
+
+		source, ok := sourceNodes[m.SourceNodeCode]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("原版本不存在节点[%s]", m.SourceNodeCode))
+			continue
+		}
+
+		target, ok := targetNodes[m.TargetNodeCode]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("新版本不存在节点[%s]", m.TargetNodeCode))
+			continue
+		}
+
+		if source.TypeCode != target.TypeCode {
+			problems = append(problems, fmt.Sprintf("节点[%s](%s)不能映射到类型不同的节点[%s](%s)", source.Code, source.TypeCode, target.Code, target.TypeCode))
+			continue
+		}
+
+		m.SourceNodeID = source.RecordID
+		m.TargetNodeID = target.RecordID
+	}
+
+	if len(problems) > 0 {
+		return errors.Wrap(ErrInvalidMigrationPlan, strings.Join(problems, "；"))
+	}
+	return nil
+}
+
+// 按节点编号排序
+func sortedNodeCodes(nodes migrationNodes) []string {
+	codes := make([]string, 0, len(nodes))
+	for code := range nodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
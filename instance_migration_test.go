@@ -0,0 +1,124 @@
+package flow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestMigrateFlowInstances(t *testing.T) {
+	ctx := context.Background()
+	e := NewMemoryEngine()
+	e.SetExecer(simulateExecer{})
+
+	sourceID, err := e.CreateFlowWithDefinition(ctx, mustBuild(t, NewDefinition("process_migration").Version(1).
+		Start("start").
+		UserTask("apply", CandidatesOption("flow.launcher")).
+		UserTask("audit", CandidatesOption("input.bzr")).
+		UserTask("confirm", CandidatesOption("input.bzr")).
+		End("end")))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 新版本将audit改为review，并删除了confirm
+	targetID, err := e.CreateFlowWithDefinition(ctx, mustBuild(t, NewDefinition("process_migration").Version(2).
+		Start("start").
+		UserTask("apply", CandidatesOption("flow.launcher")).
+		UserTask("review", CandidatesOption("input.bzr")).
+		End("end")))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	launch := func() string {
+		result, err := e.LaunchFlow(ctx, sourceID, "M001", []byte(`{"bzr":"M002"}`))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return result.FlowInstance.RecordID
+	}
+	id1, id2 := launch(), launch()
+
+	// 进行中的节点audit在新版本中不存在，未映射时不能迁移
+	plan, err := e.CreateInstanceMigrationPlan(ctx, sourceID, targetID, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = e.MigrateFlowInstances(ctx, plan, []string{id1}, true)
+	if errors.Cause(err) != ErrInvalidMigrationPlan {
+		t.Fatalf("进行中的节点没有映射时应不能迁移：%v", err)
+	}
+
+	// 映射到新版本不存在的节点
+	_, err = e.CreateInstanceMigrationPlan(ctx, sourceID, targetID, map[string]string{"audit": "confirm"})
+	if errors.Cause(err) != ErrInvalidMigrationPlan {
+		t.Fatalf("映射到不存在的节点时应返回错误：%v", err)
+	}
+
+	plan, err = e.CreateInstanceMigrationPlan(ctx, sourceID, targetID, map[string]string{"audit": "review"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 仅检查迁移计划时不变更流程实例
+	result, err := e.MigrateFlowInstances(ctx, plan, []string{id1, id2}, true)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if !result.DryRun || len(result.FlowInstances) != 2 {
+		t.Fatalf("无效的迁移结果：%+v", result)
+	}
+
+	fi, err := e.FlowBll().GetFlowInstance(id1)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if fi.FlowID != sourceID {
+		t.Fatalf("仅检查迁移计划时不应迁移流程实例：%s", fi.FlowID)
+	}
+
+	// 已结束的流程实例不能迁移，同一批次的流程实例都不迁移
+	err = e.StopFlowInstanceWithContext(ctx, id2, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	result, err = e.MigrateFlowInstances(ctx, plan, []string{id1, id2}, false)
+	if errors.Cause(err) != ErrInvalidMigrationPlan || result == nil || len(result.Problems) != 1 {
+		t.Fatalf("已结束的流程实例应不能迁移：%v", err)
+	}
+
+	result, err = e.MigrateFlowInstances(ctx, plan, []string{id1}, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(result.FlowInstances) != 1 || len(result.FlowInstances[0].NodeInstances) == 0 {
+		t.Fatalf("无效的迁移结果：%+v", result)
+	}
+
+	fi, err = e.FlowBll().GetFlowInstance(id1)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if fi.FlowID != targetID {
+		t.Fatalf("流程实例未迁移：%s", fi.FlowID)
+	}
+
+	review, err := e.FlowBll().FlowModel.GetNodeByCode(targetID, "review")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	nodeInstances, err := e.FlowBll().QueryNodeInstances(id1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	last := nodeInstances[len(nodeInstances)-1]
+	if last.Status != 1 || last.NodeID != review.RecordID {
+		t.Fatalf("进行中的节点实例未映射到新版本的节点：%+v", last)
+	}
+
+	// 迁移后在新版本中继续流转
+	handled, err := e.HandleFlow(ctx, last.RecordID, "M002", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if !handled.IsEnd {
+		t.Fatalf("迁移后的流程实例应按新版本结束：%s", handled.String())
+	}
+}
@@ -0,0 +1,282 @@
+package flow
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/antlinker/flow/bll"
+	"github.com/antlinker/flow/schema"
+	"github.com/antlinker/flow/util"
+	"github.com/pkg/errors"
+)
+
+// 定义错误
+var (
+	ErrJobNotFailed = errors.New("作业不是失败的状态")
+)
+
+// 定义作业类型
+const (
+	// JobTypeExternal 外部任务
+	JobTypeExternal = "external"
+	// JobTypeAsync 异步继续
+	JobTypeAsync = "async"
+)
+
+// 定义异步继续的阶段
+const (
+	// JobStageBefore 进入节点前
+	JobStageBefore = "before"
+	// JobStageAfter 完成节点后
+	JobStageAfter = "after"
+)
+
+// SetJobRetry 设定作业失败时的最大重试次数及重试间隔(默认3次，按失败次数指数增长)
+func (e *Engine) SetJobRetry(retries int, backoff func(attempts int) time.Duration) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.retries = retries
+	e.backoff = backoff
+}
+
+func (e *Engine) jobRetries() int {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if e.retries <= 0 {
+		return 3
+	}
+	return e.retries
+}
+
+func (e *Engine) jobBackoff(attempts int) time.Duration {
+	e.lock.RLock()
+	backoff := e.backoff
+	e.lock.RUnlock()
+
+	if backoff == nil {
+		return defaultBackoff(attempts)
+	}
+	return backoff(attempts)
+}
+
+// QueryJobPage 查询作业分页数据(例如查询失败的作业：Status为4)
+func (e *Engine) QueryJobPage(ctx context.Context, params schema.JobQueryParam, pageIndex, pageSize uint) (int64, []*schema.Job, error) {
+	return e.tenantBll(ctx).QueryJobPage(params, pageIndex, pageSize)
+}
+
+// RetryJob 重新执行失败的作业
+func (e *Engine) RetryJob(ctx context.Context, jobID string) error {
+	flowBll := e.tenantBll(ctx)
+	job, err := flowBll.GetJob(jobID)
+	if err != nil {
+		return err
+	} else if job == nil {
+		return ErrNotFound
+	} else if job.Status != 4 {
+		return ErrJobNotFailed
+	}
+	return flowBll.RetryJob(jobID)
+}
+
+type jobExecutorOptions struct {
+	workers      int
+	interval     time.Duration
+	batchSize    int
+	lockDuration time.Duration
+}
+
+// JobExecutorOption 作业执行器配置
+type JobExecutorOption func(*jobExecutorOptions)
+
+// JobWorkersOption 并发执行作业的数量(默认4)
+func JobWorkersOption(workers int) JobExecutorOption {
+	return func(o *jobExecutorOptions) {
+		o.workers = workers
+	}
+}
+
+// JobIntervalOption 轮询作业的时间间隔(默认1秒)
+func JobIntervalOption(interval time.Duration) JobExecutorOption {
+	return func(o *jobExecutorOptions) {
+		o.interval = interval
+	}
+}
+
+// JobBatchSizeOption 每批次锁定的作业数量(默认20)
+func JobBatchSizeOption(batchSize int) JobExecutorOption {
+	return func(o *jobExecutorOptions) {
+		o.batchSize = batchSize
+	}
+}
+
+// JobLockDurationOption 作业的锁定时长，超过后其他执行器可重新执行(默认5分钟)
+func JobLockDurationOption(lockDuration time.Duration) JobExecutorOption {
+	return func(o *jobExecutorOptions) {
+		o.lockDuration = lockDuration
+	}
+}
+
+// JobExecutor 作业执行器
+// 执行异步继续(asyncBefore/asyncAfter)的作业，每个作业在独立的事务中继续流转；
+// 执行失败时按重试间隔重新执行，超过重试次数后标记为失败，可通过RetryJob重新执行
+type JobExecutor struct {
+	id     string
+	engine *Engine
+	opts   jobExecutorOptions
+	poller *poller
+}
+
+// NewJobExecutor 创建作业执行器
+func (e *Engine) NewJobExecutor(opts ...JobExecutorOption) *JobExecutor {
+	o := jobExecutorOptions{
+		workers:      4,
+		interval:     time.Second,
+		batchSize:    20,
+		lockDuration: time.Minute * 5,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.workers <= 0 {
+		o.workers = 1
+	}
+
+	x := &JobExecutor{
+		id:     "executor-" + util.UUID(),
+		engine: e,
+		opts:   o,
+	}
+	x.poller = &poller{
+		name:      "执行作业",
+		interval:  o.interval,
+		batchSize: o.batchSize,
+		drain:     x.Drain,
+	}
+	return x
+}
+
+// Start 启动执行器，按时间间隔轮询执行作业
+func (x *JobExecutor) Start() {
+	x.poller.start()
+}
+
+// Stop 停止执行器(等待当前批次执行完成)
+func (x *JobExecutor) Stop() {
+	x.poller.stop()
+}
+
+// Drain 锁定并执行一个批次的到期作业，返回本批次处理的作业数量
+func (x *JobExecutor) Drain(ctx context.Context) (int, error) {
+	lockExpire := time.Now().Add(x.opts.lockDuration).Unix()
+	ids, err := x.engine.flowBll.LockJobs(JobTypeAsync, "", x.id, x.opts.batchSize, lockExpire)
+	if err != nil {
+		return 0, err
+	} else if len(ids) == 0 {
+		return 0, nil
+	}
+
+	workers := x.opts.workers
+	if workers > len(ids) {
+		workers = len(ids)
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				x.execute(ctx, id)
+			}
+		}()
+	}
+
+	for _, id := range ids {
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+
+	return len(ids), nil
+}
+
+// 执行作业，失败时记录失败次数
+// 执行器处理所有租户的作业，作业在所属租户的上下文中继续流转
+func (x *JobExecutor) execute(ctx context.Context, jobID string) {
+	item, err := x.engine.flowBll.GetJob(jobID)
+	if err != nil {
+		log.Printf("执行作业[%s]发生错误：%s", jobID, err.Error())
+		return
+	} else if item == nil {
+		return
+	}
+	ctx = WithTenant(ctx, item.TenantID)
+
+	var job *schema.Job
+	err = x.engine.transaction(ctx, &Event{UserID: x.id}, func(flowBll *bll.Flow, emitter *eventEmitter) error {
+		item, err := flowBll.GetJob(jobID)
+		if err != nil {
+			return err
+		} else if item == nil || item.Status != 2 || item.WorkerID != x.id {
+			// 锁定已过期并被其他执行器重新锁定
+			return nil
+		}
+		job = item
+
+		err = flowBll.DoneJob(jobID)
+		if err != nil {
+			return err
+		}
+		return x.continueJob(ctx, flowBll, emitter, job)
+	})
+	if err == nil || job == nil {
+		if err != nil {
+			log.Printf("执行作业[%s]发生错误：%s", jobID, err.Error())
+		}
+		return
+	}
+
+	attempts := job.Attempts + 1
+	var nextTime int64
+	if attempts <= job.Retries {
+		nextTime = time.Now().Add(x.engine.jobBackoff(attempts)).Unix()
+	}
+
+	err = x.engine.flowBll.FailJob(jobID, attempts, nextTime, truncateError(err, 1024))
+	if err != nil {
+		log.Printf("记录作业[%s]失败发生错误：%s", jobID, err.Error())
+	}
+}
+
+// 继续流转
+func (x *JobExecutor) continueJob(ctx context.Context, flowBll *bll.Flow, emitter *eventEmitter, job *schema.Job) error {
+	nodeInstance, err := flowBll.GetNodeInstance(job.NodeInstanceID)
+	if err != nil {
+		return err
+	} else if nodeInstance == nil {
+		return ErrNotFound
+	}
+
+	inputData := nodeInstance.InputData
+	if job.Stage == JobStageAfter {
+		inputData = nodeInstance.OutData
+	}
+
+	nr, err := new(NodeRouter).Init(ctx, x.engine, job.NodeInstanceID, []byte(inputData), transactionOption(flowBll, emitter))
+	if err != nil {
+		return err
+	}
+
+	switch job.Stage {
+	case JobStageBefore:
+		nr.entered = true
+		return nr.Next(job.Processor)
+	case JobStageAfter:
+		return nr.leave(job.Processor)
+	}
+	return errors.Errorf("未知的异步继续阶段：%s", job.Stage)
+}
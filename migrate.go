@@ -0,0 +1,34 @@
+package flow
+
+import (
+	"github.com/antlinker/flow/register"
+	"github.com/antlinker/flow/service/db"
+	"github.com/pkg/errors"
+)
+
+// 定义错误
+var (
+	ErrMigrateNotSupported = errors.New("当前存储不支持数据库迁移")
+)
+
+// SetAutoMigrate 设定初始化时是否自动执行未执行的数据库迁移(需要在Init之前设定)
+func (e *Engine) SetAutoMigrate(autoMigrate bool) {
+	e.autoMigrate = autoMigrate
+}
+
+// Migrate 执行未执行的数据库迁移，返回执行的迁移计划
+// dryRun 为true时仅返回迁移计划(包含需要执行的SQL)，不执行
+func (e *Engine) Migrate(dryRun bool) ([]*db.MigrationPlan, error) {
+	if e.db == nil {
+		return nil, ErrMigrateNotSupported
+	}
+	return db.NewMigrator(e.db, register.FlowMigrations()...).Migrate(dryRun)
+}
+
+// MigrationStatus 查询数据库迁移状态
+func (e *Engine) MigrationStatus() ([]*db.MigrationStatus, error) {
+	if e.db == nil {
+		return nil, ErrMigrateNotSupported
+	}
+	return db.NewMigrator(e.db, register.FlowMigrations()...).Status()
+}
@@ -3,25 +3,201 @@ package model
 import (
 	"database/sql"
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/antlinker/flow/schema"
 	"github.com/antlinker/flow/service/db"
 	"github.com/pkg/errors"
+	"gopkg.in/gorp.v2"
 )
 
-// Flow 流程管理
+var _ Repository = &Flow{}
+
+// Flow 流程管理(SQL存储，支持MySQL/PostgreSQL/SQLite)
 type Flow struct {
-	DB *db.DB `inject:""`
+	DB       *db.DB `inject:""`
+	tran     *gorp.Transaction
+	tenantID string
+	scoped   bool
+}
+
+// SQL执行器(执行前将?占位符转换为当前方言的占位符)
+type sqlExecutor struct {
+	gorp.SqlExecutor
+	db *db.DB
+}
+
+func (e *sqlExecutor) Insert(list ...interface{}) error {
+	err := e.db.CheckSize(list...)
+	if err != nil {
+		return err
+	}
+	return e.SqlExecutor.Insert(list...)
+}
+
+func (e *sqlExecutor) Update(list ...interface{}) (int64, error) {
+	err := e.db.CheckSize(list...)
+	if err != nil {
+		return 0, err
+	}
+	return e.SqlExecutor.Update(list...)
+}
+
+func (e *sqlExecutor) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return e.SqlExecutor.Exec(e.db.Rebind(query), args...)
+}
+
+func (e *sqlExecutor) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	return e.SqlExecutor.Select(i, e.db.Rebind(query), args...)
+}
+
+func (e *sqlExecutor) SelectInt(query string, args ...interface{}) (int64, error) {
+	return e.SqlExecutor.SelectInt(e.db.Rebind(query), args...)
+}
+
+func (e *sqlExecutor) SelectStr(query string, args ...interface{}) (string, error) {
+	return e.SqlExecutor.SelectStr(e.db.Rebind(query), args...)
+}
+
+func (e *sqlExecutor) SelectOne(holder interface{}, query string, args ...interface{}) error {
+	return e.SqlExecutor.SelectOne(holder, e.db.Rebind(query), args...)
+}
+
+func (e *sqlExecutor) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return e.SqlExecutor.Query(e.db.Rebind(query), args...)
+}
+
+func (e *sqlExecutor) QueryRow(query string, args ...interface{}) *sql.Row {
+	return e.SqlExecutor.QueryRow(e.db.Rebind(query), args...)
+}
+
+// 事务(嵌套在外部事务中时，提交与回滚由外部事务负责)
+type transaction struct {
+	*gorp.Transaction
+	db     *db.DB
+	nested bool
+}
+
+func (t *transaction) Insert(list ...interface{}) error {
+	err := t.db.CheckSize(list...)
+	if err != nil {
+		return err
+	}
+	return t.Transaction.Insert(list...)
+}
+
+func (t *transaction) Update(list ...interface{}) (int64, error) {
+	err := t.db.CheckSize(list...)
+	if err != nil {
+		return 0, err
+	}
+	return t.Transaction.Update(list...)
+}
+
+func (t *transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Transaction.Exec(t.db.Rebind(query), args...)
+}
+
+func (t *transaction) Commit() error {
+	if t.nested {
+		return nil
+	}
+	return t.Transaction.Commit()
+}
+
+func (t *transaction) Rollback() error {
+	if t.nested {
+		return nil
+	}
+	return t.Transaction.Rollback()
+}
+
+// Tran 在同一事务中执行fn，fn返回错误时回滚事务；如果当前已处于事务中则复用当前事务
+func (a *Flow) Tran(fn func(Repository) error) error {
+	if a.tran != nil {
+		return fn(a)
+	}
+
+	tran, err := a.DB.Begin()
+	if err != nil {
+		return errors.Wrapf(err, "开启事物发生错误")
+	}
+
+	err = fn(&Flow{DB: a.DB, tran: tran, tenantID: a.tenantID, scoped: a.scoped})
+	if err != nil {
+		_ = tran.Rollback()
+		return err
+	}
+
+	err = tran.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "提交事物发生错误")
+	}
+	return nil
+}
+
+// WithTenant 获取按租户隔离的存储
+func (a *Flow) WithTenant(tenantID string) Repository {
+	return &Flow{DB: a.DB, tran: a.tran, tenantID: tenantID, scoped: true}
+}
+
+// 流程的租户条件(租户的流程及全局的流程)
+func (a *Flow) flowTenantWhere(column string) (string, []interface{}) {
+	if !a.scoped {
+		return "", nil
+	}
+	return fmt.Sprintf(" AND %s IN (?,'')", column), []interface{}{a.tenantID}
+}
+
+// 流程实例及作业的租户条件
+func (a *Flow) tenantWhere(column string) (string, []interface{}) {
+	if !a.scoped {
+		return "", nil
+	}
+	return fmt.Sprintf(" AND %s=?", column), []interface{}{a.tenantID}
+}
+
+// 开启事务
+func (a *Flow) begin() (*transaction, error) {
+	if a.tran != nil {
+		return &transaction{Transaction: a.tran, db: a.DB, nested: true}, nil
+	}
+
+	tran, err := a.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Transaction: tran, db: a.DB}, nil
+}
+
+// 获取SQL执行器(处于事务中时使用当前事务)
+func (a *Flow) executor() gorp.SqlExecutor {
+	if a.tran != nil {
+		return &sqlExecutor{SqlExecutor: a.tran, db: a.DB}
+	}
+	return &sqlExecutor{SqlExecutor: a.DB, db: a.DB}
+}
+
+// 根据主键更新数据
+func (a *Flow) updateByPK(table string, pk, info db.M) (int64, error) {
+	if a.tran != nil {
+		return a.DB.UpdateByPKWithTran(a.tran, table, pk, info)
+	}
+	return a.DB.UpdateByPK(table, pk, info)
 }
 
 // CreateFlow 创建流程数据
 func (a *Flow) CreateFlow(flow *schema.Flow, nodes *schema.NodeOperating, forms *schema.FormOperating) error {
-	tran, err := a.DB.Begin()
+	tran, err := a.begin()
 	if err != nil {
 		return errors.Wrapf(err, "创建流程基础数据开启事物发生错误")
 	}
 
+	if a.scoped {
+		flow.TenantID = a.tenantID
+	}
 	err = tran.Insert(flow)
 	if err != nil {
 		_ = tran.Rollback()
@@ -53,10 +229,11 @@ func (a *Flow) CreateFlow(flow *schema.Flow, nodes *schema.NodeOperating, forms
 
 // GetFlow 获取流程数据
 func (a *Flow) GetFlow(recordID string) (*schema.Flow, error) {
-	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=? LIMIT 1", schema.FlowTableName)
+	tw, args := a.flowTenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=?%s LIMIT 1", schema.FlowTableName, tw)
 
 	var flow schema.Flow
-	err := a.DB.SelectOne(&flow, query, recordID)
+	err := a.executor().SelectOne(&flow, query, append([]interface{}{recordID}, args...)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -69,10 +246,11 @@ func (a *Flow) GetFlow(recordID string) (*schema.Flow, error) {
 
 // GetFlowByCode 根据编号查询流程数据
 func (a *Flow) GetFlowByCode(code string) (*schema.Flow, error) {
-	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND flag=1 AND status=1 AND code=? ORDER BY version DESC LIMIT 1", schema.FlowTableName)
+	tw, args := a.flowTenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND flag=1 AND status=1 AND code=?%s ORDER BY CASE WHEN tenant_id='' THEN 1 ELSE 0 END,active DESC,version DESC LIMIT 1", schema.FlowTableName, tw)
 
 	var flow schema.Flow
-	err := a.DB.SelectOne(&flow, query, code)
+	err := a.executor().SelectOne(&flow, query, append([]interface{}{code}, args...)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -88,7 +266,7 @@ func (a *Flow) GetNode(recordID string) (*schema.Node, error) {
 	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=?", schema.NodeTableName)
 
 	var item schema.Node
-	err := a.DB.SelectOne(&item, query, recordID)
+	err := a.executor().SelectOne(&item, query, recordID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -104,7 +282,7 @@ func (a *Flow) GetNodeByCode(flowID, nodeCode string) (*schema.Node, error) {
 	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND flow_id=? AND code=? ORDER BY order_num LIMIT 1", schema.NodeTableName)
 
 	var item schema.Node
-	err := a.DB.SelectOne(&item, query, flowID, nodeCode)
+	err := a.executor().SelectOne(&item, query, flowID, nodeCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -115,12 +293,26 @@ func (a *Flow) GetNodeByCode(flowID, nodeCode string) (*schema.Node, error) {
 	return &item, nil
 }
 
+// QueryNodes 查询流程的节点
+func (a *Flow) QueryNodes(flowID string) ([]*schema.Node, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND flow_id=? ORDER BY order_num", schema.NodeTableName)
+
+	var items []*schema.Node
+	_, err := a.executor().Select(&items, query, flowID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询流程节点发生错误")
+	}
+
+	return items, nil
+}
+
 // GetFlowInstance 获取流程实例
 func (a *Flow) GetFlowInstance(recordID string) (*schema.FlowInstance, error) {
-	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=? LIMIT 1", schema.FlowInstanceTableName)
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=?%s LIMIT 1", schema.FlowInstanceTableName, tw)
 
 	var item schema.FlowInstance
-	err := a.DB.SelectOne(&item, query, recordID)
+	err := a.executor().SelectOne(&item, query, append([]interface{}{recordID}, args...)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -133,10 +325,11 @@ func (a *Flow) GetFlowInstance(recordID string) (*schema.FlowInstance, error) {
 
 // GetFlowInstanceByNode 根据节点实例获取流程实例
 func (a *Flow) GetFlowInstanceByNode(nodeInstanceID string) (*schema.FlowInstance, error) {
-	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id IN (SELECT flow_instance_id FROM %s WHERE deleted=0 AND record_id=?) LIMIT 1", schema.FlowInstanceTableName, schema.NodeInstanceTableName)
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id IN (SELECT flow_instance_id FROM %s WHERE deleted=0 AND record_id=?)%s LIMIT 1", schema.FlowInstanceTableName, schema.NodeInstanceTableName, tw)
 
 	var item schema.FlowInstance
-	err := a.DB.SelectOne(&item, query, nodeInstanceID)
+	err := a.executor().SelectOne(&item, query, append([]interface{}{nodeInstanceID}, args...)...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -147,12 +340,78 @@ func (a *Flow) GetFlowInstanceByNode(nodeInstanceID string) (*schema.FlowInstanc
 	return &item, nil
 }
 
+// GetFlowInstanceByBusinessKey 根据业务主键获取最近发起的流程实例
+func (a *Flow) GetFlowInstanceByBusinessKey(flowCode, businessKey string) (*schema.FlowInstance, error) {
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND business_key=? AND flow_id IN (SELECT record_id FROM %s WHERE deleted=0 AND code=?)%s ORDER BY id DESC LIMIT 1", schema.FlowInstanceTableName, schema.FlowTableName, tw)
+
+	var item schema.FlowInstance
+	err := a.executor().SelectOne(&item, query, append([]interface{}{businessKey, flowCode}, args...)...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "根据业务主键获取流程实例发生错误")
+	}
+
+	return &item, nil
+}
+
+// CheckFlowInstanceBusinessKey 检查业务主键是否存在进行中(或暂停)的流程实例
+func (a *Flow) CheckFlowInstanceBusinessKey(flowCode, businessKey string) (bool, error) {
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT count(*) FROM %s WHERE deleted=0 AND status IN(1,2) AND business_key=? AND flow_id IN (SELECT record_id FROM %s WHERE deleted=0 AND code=?)%s", schema.FlowInstanceTableName, schema.FlowTableName, tw)
+	n, err := a.executor().SelectInt(query, append([]interface{}{businessKey, flowCode}, args...)...)
+	if err != nil {
+		return false, errors.Wrapf(err, "检查业务主键发生错误")
+	}
+	return n > 0, nil
+}
+
+// LockBusinessKey 锁定业务主键(同一流程编号下的业务主键已被锁定时返回false)
+func (a *Flow) LockBusinessKey(flowCode, businessKey, flowInstanceID string) (bool, error) {
+	item := &schema.BusinessKey{
+		FlowCode:       flowCode,
+		BusinessKey:    businessKey,
+		FlowInstanceID: flowInstanceID,
+		Created:        time.Now().Unix(),
+	}
+	if a.scoped {
+		item.TenantID = a.tenantID
+	}
+
+	err := a.executor().Insert(item)
+	if err != nil {
+		if db.IsDuplicateError(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "锁定业务主键发生错误")
+	}
+	return true, nil
+}
+
+// UnlockBusinessKey 释放流程实例锁定的业务主键
+func (a *Flow) UnlockBusinessKey(flowInstanceID string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE flow_instance_id=?", schema.BusinessKeyTableName)
+	_, err := a.executor().Exec(query, flowInstanceID)
+	if err != nil {
+		return errors.Wrapf(err, "释放业务主键发生错误")
+	}
+	return nil
+}
+
 // GetNodeInstance 获取流程节点实例
 func (a *Flow) GetNodeInstance(recordID string) (*schema.NodeInstance, error) {
-	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=? LIMIT 1", schema.NodeInstanceTableName)
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=?", schema.NodeInstanceTableName)
+	args := []interface{}{recordID}
+	if tw, targs := a.tenantWhere("tenant_id"); tw != "" {
+		query = fmt.Sprintf("%s AND flow_instance_id IN (SELECT record_id FROM %s WHERE deleted=0%s)", query, schema.FlowInstanceTableName, tw)
+		args = append(args, targs...)
+	}
+	query = fmt.Sprintf("%s LIMIT 1", query)
 
 	var item schema.NodeInstance
-	err := a.DB.SelectOne(&item, query, recordID)
+	err := a.executor().SelectOne(&item, query, args...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -163,12 +422,31 @@ func (a *Flow) GetNodeInstance(recordID string) (*schema.NodeInstance, error) {
 	return &item, nil
 }
 
+// QueryNodeInstances 查询流程实例的节点实例
+func (a *Flow) QueryNodeInstances(flowInstanceID string) ([]*schema.NodeInstance, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND flow_instance_id=?", schema.NodeInstanceTableName)
+	args := []interface{}{flowInstanceID}
+	if tw, targs := a.tenantWhere("tenant_id"); tw != "" {
+		query = fmt.Sprintf("%s AND flow_instance_id IN (SELECT record_id FROM %s WHERE deleted=0%s)", query, schema.FlowInstanceTableName, tw)
+		args = append(args, targs...)
+	}
+	query = fmt.Sprintf("%s ORDER BY id", query)
+
+	var items []*schema.NodeInstance
+	_, err := a.executor().Select(&items, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询流程实例的节点实例发生错误")
+	}
+
+	return items, nil
+}
+
 // QueryNodeRouters 查询节点路由
 func (a *Flow) QueryNodeRouters(sourceNodeID string) ([]*schema.NodeRouter, error) {
 	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND source_node_id=?", schema.NodeRouterTableName)
 
 	var items []*schema.NodeRouter
-	_, err := a.DB.Select(&items, query, sourceNodeID)
+	_, err := a.executor().Select(&items, query, sourceNodeID)
 	if err != nil {
 		return nil, errors.Wrapf(err, "查询节点路由发生错误")
 	}
@@ -181,7 +459,7 @@ func (a *Flow) QueryNodeAssignments(nodeID string) ([]*schema.NodeAssignment, er
 	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND node_id=?", schema.NodeAssignmentTableName)
 
 	var items []*schema.NodeAssignment
-	_, err := a.DB.Select(&items, query, nodeID)
+	_, err := a.executor().Select(&items, query, nodeID)
 	if err != nil {
 		return nil, errors.Wrapf(err, "查询节点指派发生错误")
 	}
@@ -191,27 +469,21 @@ func (a *Flow) QueryNodeAssignments(nodeID string) ([]*schema.NodeAssignment, er
 
 // CreateNodeInstance 创建流程节点实例
 func (a *Flow) CreateNodeInstance(nodeInstance *schema.NodeInstance, nodeCandidates []*schema.NodeCandidate) error {
-	tran, err := a.DB.Begin()
+	tran, err := a.begin()
 	if err != nil {
 		return errors.Wrapf(err, "创建流程节点实例开启事物发生错误")
 	}
 
 	err = tran.Insert(nodeInstance)
 	if err != nil {
-		err = tran.Rollback()
-		if err != nil {
-			return errors.Wrapf(err, "创建流程节点实例回滚事物发生错误")
-		}
+		_ = tran.Rollback()
 		return errors.Wrapf(err, "插入流程节点实例数据发生错误")
 	}
 
 	for _, c := range nodeCandidates {
 		err = tran.Insert(c)
 		if err != nil {
-			err = tran.Rollback()
-			if err != nil {
-				return errors.Wrapf(err, "创建流程节点实例回滚事物发生错误")
-			}
+			_ = tran.Rollback()
 			return errors.Wrapf(err, "插入流程节点候选人数据发生错误")
 		}
 	}
@@ -225,7 +497,7 @@ func (a *Flow) CreateNodeInstance(nodeInstance *schema.NodeInstance, nodeCandida
 
 // UpdateNodeInstance 更新节点实例信息
 func (a *Flow) UpdateNodeInstance(recordID string, info map[string]interface{}) error {
-	_, err := a.DB.UpdateByPK(schema.NodeInstanceTableName, db.M{"record_id": recordID}, db.M(info))
+	_, err := a.updateByPK(schema.NodeInstanceTableName, db.M{"record_id": recordID}, db.M(info))
 	if err != nil {
 		return errors.Wrapf(err, "更新节点实例信息发生错误")
 	}
@@ -235,7 +507,7 @@ func (a *Flow) UpdateNodeInstance(recordID string, info map[string]interface{})
 // CheckFlowInstanceTodo 检查流程实例待办事项
 func (a *Flow) CheckFlowInstanceTodo(flowInstanceID string) (bool, error) {
 	query := fmt.Sprintf("SELECT count(*) FROM %s WHERE deleted=0 AND status=1 AND flow_instance_id=?", schema.NodeInstanceTableName)
-	n, err := a.DB.SelectInt(query, flowInstanceID)
+	n, err := a.executor().SelectInt(query, flowInstanceID)
 	if err != nil {
 		return false, errors.Wrapf(err, "检查流程待办事项发生错误")
 	}
@@ -244,36 +516,49 @@ func (a *Flow) CheckFlowInstanceTodo(flowInstanceID string) (bool, error) {
 
 // UpdateFlowInstance 更新流程实例信息
 func (a *Flow) UpdateFlowInstance(recordID string, info map[string]interface{}) error {
-	_, err := a.DB.UpdateByPK(schema.FlowInstanceTableName, db.M{"record_id": recordID}, db.M(info))
+	_, err := a.updateByPK(schema.FlowInstanceTableName, db.M{"record_id": recordID}, db.M(info))
 	if err != nil {
 		return errors.Wrapf(err, "更新流程实例信息发生错误")
 	}
 	return nil
 }
 
+// MigrateFlowInstance 迁移流程实例到新版本的流程，流程实例不属于原版本或已结束时返回false
+func (a *Flow) MigrateFlowInstance(recordID, sourceFlowID, targetFlowID string, updated int64) (bool, error) {
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("UPDATE %s SET flow_id=?,updated=? WHERE deleted=0 AND record_id=? AND flow_id=? AND status IN (1,2)%s", schema.FlowInstanceTableName, tw)
+	result, err := a.executor().Exec(query, append([]interface{}{targetFlowID, updated, recordID, sourceFlowID}, args...)...)
+	if err != nil {
+		return false, errors.Wrapf(err, "迁移流程实例发生错误")
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrapf(err, "迁移流程实例发生错误")
+	}
+	return n > 0, nil
+}
+
 // CreateFlowInstance 创建流程实例
 func (a *Flow) CreateFlowInstance(flowInstance *schema.FlowInstance, nodeInstances ...*schema.NodeInstance) error {
-	tran, err := a.DB.Begin()
+	tran, err := a.begin()
 	if err != nil {
 		return errors.Wrapf(err, "创建流程实例开启事物发生错误")
 	}
 
+	if a.scoped {
+		flowInstance.TenantID = a.tenantID
+	}
 	err = tran.Insert(flowInstance)
 	if err != nil {
-		err = tran.Rollback()
-		if err != nil {
-			return errors.Wrapf(err, "创建流程实例回滚事物发生错误")
-		}
+		_ = tran.Rollback()
 		return errors.Wrapf(err, "插入流程实例数据发生错误")
 	}
 
 	for _, n := range nodeInstances {
 		err = tran.Insert(n)
 		if err != nil {
-			err = tran.Rollback()
-			if err != nil {
-				return errors.Wrapf(err, "创建流程实例回滚事物发生错误")
-			}
+			_ = tran.Rollback()
 			return errors.Wrapf(err, "插入流程节点实例数据发生错误")
 		}
 	}
@@ -290,7 +575,7 @@ func (a *Flow) QueryNodeCandidates(nodeInstanceID string) ([]*schema.NodeCandida
 	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND node_instance_id=?", schema.NodeCandidateTableName)
 
 	var items []*schema.NodeCandidate
-	_, err := a.DB.Select(&items, query, nodeInstanceID)
+	_, err := a.executor().Select(&items, query, nodeInstanceID)
 	if err != nil {
 		return nil, errors.Wrapf(err, "查询节点候选人发生错误")
 	}
@@ -305,14 +590,18 @@ func (a *Flow) QueryTodo(flowCode, userID string) ([]*schema.FlowTodoResult, err
 		SELECT
 		  ni.record_id,
 		  ni.flow_instance_id,
+		  fi.business_key,
 		  ni.input_data,
 		  ni.node_id,
-		  f.data 'form_data',
-		  f.type_code 'form_type',
+		  f.data AS form_data,
+		  f.type_code AS form_type,
 		  fi.launcher,
 		  fi.launch_time,
-			n.code 'node_code',
-			n.name 'node_name'
+		  ni.due_time,
+		  ni.priority,
+			n.code AS node_code,
+			n.name AS node_name,
+			n.form_key
 		FROM %s ni
 		  JOIN %s fi ON ni.flow_instance_id = fi.record_id AND fi.deleted = ni.deleted
 		  LEFT JOIN %s n ON ni.node_id = n.record_id AND n.deleted = ni.deleted
@@ -321,6 +610,10 @@ func (a *Flow) QueryTodo(flowCode, userID string) ([]*schema.FlowTodoResult, err
 		`, schema.NodeInstanceTableName, schema.FlowInstanceTableName, schema.NodeTableName, schema.FormTableName, schema.NodeCandidateTableName)
 
 	args = append(args, userID)
+	if tw, targs := a.tenantWhere("fi.tenant_id"); tw != "" {
+		query = fmt.Sprintf("%s%s", query, tw)
+		args = append(args, targs...)
+	}
 	if flowCode != "" {
 		query = fmt.Sprintf("%s AND fi.flow_id IN (SELECT record_id FROM %s WHERE deleted=0 AND flag=1 AND code=?)", query, schema.FlowTableName)
 		args = append(args, flowCode)
@@ -328,7 +621,7 @@ func (a *Flow) QueryTodo(flowCode, userID string) ([]*schema.FlowTodoResult, err
 	query = fmt.Sprintf("%s ORDER BY ni.id", query)
 
 	var items []*schema.FlowTodoResult
-	_, err := a.DB.Select(&items, query, args...)
+	_, err := a.executor().Select(&items, query, args...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "查询用户的待办数据发生错误")
 	}
@@ -346,6 +639,11 @@ func (a *Flow) QueryDone(flowCode, userID string, lastID int64, count int) ([]*s
 	where := "WHERE ni.deleted = 0 AND ni.status = 2 AND ni.processor=?"
 	args := []interface{}{userID}
 
+	if tw, targs := a.tenantWhere("fi.tenant_id"); tw != "" {
+		where = fmt.Sprintf("%s%s", where, tw)
+		args = append(args, targs...)
+	}
+
 	if flowCode != "" {
 		where = fmt.Sprintf("%s AND fi.flow_id IN (SELECT record_id FROM %s WHERE deleted=0 AND flag=1 AND code=?)", where, schema.FlowTableName)
 		args = append(args, flowCode)
@@ -362,18 +660,18 @@ func (a *Flow) QueryDone(flowCode, userID string, lastID int64, count int) ([]*s
 	ni.flow_instance_id,
 	ni.out_data,
 	ni.process_time,
-	f.data 'form_data',
-	f.type_code 'form_type',
-	fi.status 'flow_status',
+	f.data AS form_data,
+	f.type_code AS form_type,
+	fi.status AS flow_status,
 	fi.launcher,
 	fi.launch_time,
-	n.name 'node_name',
-	fw.name 'flow_name'`
+	n.name AS node_name,
+	fw.name AS flow_name`
 
 	query := fmt.Sprintf("SELECT %s FROM %s %s ORDER BY ni.id DESC LIMIT %d", fieldsSelect, table, where, count)
 
 	var items []*schema.FlowDoneResult
-	_, err := a.DB.Select(&items, query, args...)
+	_, err := a.executor().Select(&items, query, args...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "查询用户的已办数据发生错误")
 	}
@@ -382,7 +680,7 @@ func (a *Flow) QueryDone(flowCode, userID string, lastID int64, count int) ([]*s
 
 // DeleteFlow 删除流程
 func (a *Flow) DeleteFlow(flowID string) error {
-	tran, err := a.DB.Begin()
+	tran, err := a.begin()
 	if err != nil {
 		return errors.Wrapf(err, "删除流程开启事物发生错误")
 	}
@@ -433,22 +731,266 @@ func (a *Flow) DeleteFlow(flowID string) error {
 
 // QueryHistory 查询流程实例历史数据
 func (a *Flow) QueryHistory(flowInstanceID string) ([]*schema.FlowHistoryResult, error) {
-	query := fmt.Sprintf("SELECT ni.record_id,ni.processor,ni.process_time,ni.out_data,ni.status,n.code 'node_code',n.name 'node_name' FROM %s ni JOIN %s n ON ni.node_id=n.record_id AND n.deleted=ni.deleted WHERE ni.deleted=0 AND ni.flow_instance_id=? AND n.type_code='userTask' ORDER BY ni.status DESC,ni.process_time", schema.NodeInstanceTableName, schema.NodeTableName)
+	query := fmt.Sprintf("SELECT ni.record_id,ni.processor,ni.process_time,ni.out_data,ni.status,n.code AS node_code,n.name AS node_name FROM %s ni JOIN %s n ON ni.node_id=n.record_id AND n.deleted=ni.deleted WHERE ni.deleted=0 AND ni.flow_instance_id=? AND n.type_code='userTask'", schema.NodeInstanceTableName, schema.NodeTableName)
+	args := []interface{}{flowInstanceID}
+	if tw, targs := a.tenantWhere("tenant_id"); tw != "" {
+		query = fmt.Sprintf("%s AND ni.flow_instance_id IN (SELECT record_id FROM %s WHERE deleted=0%s)", query, schema.FlowInstanceTableName, tw)
+		args = append(args, targs...)
+	}
+	query = fmt.Sprintf("%s ORDER BY ni.status DESC,ni.process_time", query)
 
 	var items []*schema.FlowHistoryResult
-	_, err := a.DB.Select(&items, query, flowInstanceID)
+	_, err := a.executor().Select(&items, query, args...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "查询流程实例历史数据发生错误")
 	}
 	return items, nil
 }
 
+// QueryExpiredFlowInstanceIDs 查询过期(已完成或已停止)的流程实例ID列表
+// 结束时间为流程实例的更新时间(未记录更新时间时使用发起时间)，仍有待投递的发件箱消息或webhook的流程实例不包含在内
+func (a *Flow) QueryExpiredFlowInstanceIDs(params schema.ExpiredFlowInstanceQueryParam, limit int) ([]string, error) {
+	query := fmt.Sprintf("SELECT record_id FROM %s WHERE status IN(3,9) AND (CASE WHEN updated>0 THEN updated ELSE launch_time END)<?", schema.FlowInstanceTableName)
+	query = fmt.Sprintf("%s AND record_id NOT IN(SELECT flow_instance_id FROM %s WHERE deleted=0 AND status=1)", query, schema.OutboxTableName)
+	query = fmt.Sprintf("%s AND record_id NOT IN(SELECT flow_instance_id FROM %s WHERE deleted=0 AND status=1)", query, schema.WebhookDeliveryTableName)
+	args := []interface{}{params.EndBefore}
+
+	if v := params.FlowCode; v != "" {
+		query = fmt.Sprintf("%s AND flow_id IN(SELECT record_id FROM %s WHERE code=?)", query, schema.FlowTableName)
+		args = append(args, v)
+	}
+
+	if v := params.ExcludeFlowCodes; len(v) > 0 {
+		query = fmt.Sprintf("%s AND flow_id NOT IN(SELECT record_id FROM %s WHERE code IN(?))", query, schema.FlowTableName)
+		args = append(args, v)
+	}
+
+	if tw, targs := a.tenantWhere("tenant_id"); tw != "" {
+		query += tw
+		args = append(args, targs...)
+	}
+	query = fmt.Sprintf("%s ORDER BY id LIMIT ?", query)
+	args = append(args, limit)
+
+	query, args, err := a.DB.In(query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询过期的流程实例发生错误")
+	}
+
+	var items []*schema.FlowInstance
+	_, err = a.executor().Select(&items, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询过期的流程实例发生错误")
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.RecordID
+	}
+	return ids, nil
+}
+
+// 获取表映射的列名
+func (a *Flow) columns(i interface{}) (string, error) {
+	table, err := a.DB.TableFor(reflect.TypeOf(i), false)
+	if err != nil {
+		return "", err
+	}
+
+	var cols []string
+	for _, c := range table.Columns {
+		if c.Transient {
+			continue
+		}
+		cols = append(cols, c.ColumnName)
+	}
+	return strings.Join(cols, ","), nil
+}
+
+// ArchiveFlowInstances 归档流程实例(将流程实例及其节点实例、候选人、已结束的webhook投递记录移动到归档表，删除作业、已结束的发件箱消息及业务主键锁定)
+func (a *Flow) ArchiveFlowInstances(flowInstanceIDs []string, archived int64) error {
+	if len(flowInstanceIDs) == 0 {
+		return nil
+	}
+
+	tables := []struct {
+		item    interface{}
+		table   string
+		archive string
+		where   string
+		name    string
+	}{
+		{schema.NodeCandidate{}, schema.NodeCandidateTableName, schema.NodeCandidateArchiveTableName,
+			fmt.Sprintf("node_instance_id IN(SELECT record_id FROM %s WHERE flow_instance_id IN(?))", schema.NodeInstanceTableName), "节点候选人"},
+		{schema.NodeInstance{}, schema.NodeInstanceTableName, schema.NodeInstanceArchiveTableName, "flow_instance_id IN(?)", "节点实例"},
+		{schema.FlowInstance{}, schema.FlowInstanceTableName, schema.FlowInstanceArchiveTableName, "record_id IN(?)", "流程实例"},
+		{schema.WebhookDelivery{}, schema.WebhookDeliveryTableName, schema.WebhookDeliveryArchiveTableName, "status<>1 AND flow_instance_id IN(?)", "webhook投递记录"},
+	}
+
+	tran, err := a.begin()
+	if err != nil {
+		return errors.Wrapf(err, "归档流程实例开启事物发生错误")
+	}
+
+	for _, t := range tables {
+		cols, err := a.columns(t.item)
+		if err != nil {
+			_ = tran.Rollback()
+			return errors.Wrapf(err, "归档%s发生错误", t.name)
+		}
+
+		query, args, err := a.DB.In(fmt.Sprintf("INSERT INTO %s(%s,archived) SELECT %s,? FROM %s WHERE %s", t.archive, cols, cols, t.table, t.where), archived, flowInstanceIDs)
+		if err != nil {
+			_ = tran.Rollback()
+			return errors.Wrapf(err, "归档%s发生错误", t.name)
+		}
+
+		_, err = tran.Exec(query, args...)
+		if err != nil {
+			_ = tran.Rollback()
+			return errors.Wrapf(err, "归档%s发生错误", t.name)
+		}
+	}
+
+	err = a.purgeFlowInstances(tran, flowInstanceIDs)
+	if err != nil {
+		_ = tran.Rollback()
+		return err
+	}
+
+	err = tran.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "归档流程实例提交事物发生错误")
+	}
+	return nil
+}
+
+// PurgeFlowInstances 清除流程实例(删除流程实例及其节点实例、候选人、作业、已结束的发件箱消息和webhook投递记录及业务主键锁定)
+func (a *Flow) PurgeFlowInstances(flowInstanceIDs []string) error {
+	if len(flowInstanceIDs) == 0 {
+		return nil
+	}
+
+	tran, err := a.begin()
+	if err != nil {
+		return errors.Wrapf(err, "清除流程实例开启事物发生错误")
+	}
+
+	err = a.purgeFlowInstances(tran, flowInstanceIDs)
+	if err != nil {
+		_ = tran.Rollback()
+		return err
+	}
+
+	err = tran.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "清除流程实例提交事物发生错误")
+	}
+	return nil
+}
+
+// 删除流程实例及其节点实例、候选人、作业、已结束的发件箱消息和webhook投递记录及业务主键锁定
+// 待投递(status=1)的发件箱消息和webhook投递记录保留，由投递器继续投递
+func (a *Flow) purgeFlowInstances(tran *transaction, flowInstanceIDs []string) error {
+	items := []struct {
+		query string
+		name  string
+	}{
+		{fmt.Sprintf("DELETE FROM %s WHERE node_instance_id IN(SELECT record_id FROM %s WHERE flow_instance_id IN(?))", schema.NodeCandidateTableName, schema.NodeInstanceTableName), "节点候选人"},
+		{fmt.Sprintf("DELETE FROM %s WHERE flow_instance_id IN(?)", schema.NodeInstanceTableName), "节点实例"},
+		{fmt.Sprintf("DELETE FROM %s WHERE flow_instance_id IN(?)", schema.JobTableName), "作业"},
+		{fmt.Sprintf("DELETE FROM %s WHERE status<>1 AND flow_instance_id IN(?)", schema.OutboxTableName), "发件箱"},
+		{fmt.Sprintf("DELETE FROM %s WHERE status<>1 AND flow_instance_id IN(?)", schema.WebhookDeliveryTableName), "webhook投递记录"},
+		{fmt.Sprintf("DELETE FROM %s WHERE flow_instance_id IN(?)", schema.BusinessKeyTableName), "业务主键锁定"},
+		{fmt.Sprintf("DELETE FROM %s WHERE record_id IN(?)", schema.FlowInstanceTableName), "流程实例"},
+	}
+
+	for _, item := range items {
+		query, args, err := a.DB.In(item.query, flowInstanceIDs)
+		if err != nil {
+			return errors.Wrapf(err, "删除%s发生错误", item.name)
+		}
+
+		_, err = tran.Exec(query, args...)
+		if err != nil {
+			return errors.Wrapf(err, "删除%s发生错误", item.name)
+		}
+	}
+	return nil
+}
+
+// GetArchivedFlowInstance 获取已归档的流程实例
+func (a *Flow) GetArchivedFlowInstance(recordID string) (*schema.FlowInstance, error) {
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=?%s LIMIT 1", schema.FlowInstanceArchiveTableName, tw)
+
+	var item schema.FlowInstanceArchive
+	err := a.executor().SelectOne(&item, query, append([]interface{}{recordID}, args...)...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "获取已归档的流程实例发生错误")
+	}
+
+	return &item.FlowInstance, nil
+}
+
+// GetArchivedFlowInstanceByBusinessKey 根据业务主键获取最近发起的已归档流程实例
+func (a *Flow) GetArchivedFlowInstanceByBusinessKey(flowCode, businessKey string) (*schema.FlowInstance, error) {
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND business_key=? AND flow_id IN (SELECT record_id FROM %s WHERE deleted=0 AND code=?)%s ORDER BY id DESC LIMIT 1", schema.FlowInstanceArchiveTableName, schema.FlowTableName, tw)
+
+	var item schema.FlowInstanceArchive
+	err := a.executor().SelectOne(&item, query, append([]interface{}{businessKey, flowCode}, args...)...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "根据业务主键获取已归档的流程实例发生错误")
+	}
+
+	return &item.FlowInstance, nil
+}
+
+// QueryArchivedHistory 查询已归档流程实例的历史数据
+func (a *Flow) QueryArchivedHistory(flowInstanceID string) ([]*schema.FlowHistoryResult, error) {
+	query := fmt.Sprintf("SELECT ni.record_id,ni.processor,ni.process_time,ni.out_data,ni.status,n.code AS node_code,n.name AS node_name FROM %s ni JOIN %s n ON ni.node_id=n.record_id AND n.deleted=ni.deleted WHERE ni.deleted=0 AND ni.flow_instance_id=? AND n.type_code='userTask'", schema.NodeInstanceArchiveTableName, schema.NodeTableName)
+	args := []interface{}{flowInstanceID}
+	if tw, targs := a.tenantWhere("tenant_id"); tw != "" {
+		query = fmt.Sprintf("%s AND ni.flow_instance_id IN (SELECT record_id FROM %s WHERE deleted=0%s)", query, schema.FlowInstanceArchiveTableName, tw)
+		args = append(args, targs...)
+	}
+	query = fmt.Sprintf("%s ORDER BY ni.status DESC,ni.process_time", query)
+
+	var items []*schema.FlowHistoryResult
+	_, err := a.executor().Select(&items, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询已归档流程实例历史数据发生错误")
+	}
+	return items, nil
+}
+
+// QueryArchivedDoneIDs 查询已办理的已归档流程实例ID列表
+func (a *Flow) QueryArchivedDoneIDs(flowCode, userID string) ([]string, error) {
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT record_id FROM %s WHERE deleted=0 AND flow_id IN (SELECT record_id FROM %s WHERE deleted=0 AND flag=1 AND code=?) AND record_id IN(SELECT flow_instance_id FROM %s WHERE deleted=0 AND status=2 AND processor=?)%s", schema.FlowInstanceArchiveTableName, schema.FlowTableName, schema.NodeInstanceArchiveTableName, tw)
+
+	var ids []string
+	_, err := a.executor().Select(&ids, query, append([]interface{}{flowCode, userID}, args...)...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询已办理的已归档流程数据发生错误")
+	}
+	return ids, nil
+}
+
 // QueryDoneIDs 查询已办理的流程实例ID列表
 func (a *Flow) QueryDoneIDs(flowCode, userID string) ([]string, error) {
-	query := fmt.Sprintf("SELECT record_id FROM %s WHERE deleted=0 AND flow_id IN (SELECT record_id FROM %s WHERE deleted=0 AND flag=1 AND code=?) AND record_id IN(SELECT flow_instance_id FROM %s WHERE deleted=0 AND status=2 AND processor=?)", schema.FlowInstanceTableName, schema.FlowTableName, schema.NodeInstanceTableName)
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT record_id FROM %s WHERE deleted=0 AND flow_id IN (SELECT record_id FROM %s WHERE deleted=0 AND flag=1 AND code=?) AND record_id IN(SELECT flow_instance_id FROM %s WHERE deleted=0 AND status=2 AND processor=?)%s", schema.FlowInstanceTableName, schema.FlowTableName, schema.NodeInstanceTableName, tw)
 
 	var items []*schema.FlowInstance
-	_, err := a.DB.Select(&items, query, flowCode, userID)
+	_, err := a.executor().Select(&items, query, append([]interface{}{flowCode, userID}, args...)...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "查询已办理的流程数据发生错误")
 	}
@@ -463,10 +1005,11 @@ func (a *Flow) QueryDoneIDs(flowCode, userID string) ([]string, error) {
 
 // QueryFlowIDsByType 根据类型查询流程ID列表
 func (a *Flow) QueryFlowIDsByType(typeCode string) ([]string, error) {
-	query := fmt.Sprintf("SELECT record_id FROM %s WHERE deleted=0 AND flag=1 AND status=1 AND type_code=?", schema.FlowTableName)
+	tw, args := a.flowTenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT record_id FROM %s WHERE deleted=0 AND flag=1 AND status=1 AND type_code=?%s", schema.FlowTableName, tw)
 
 	var items []*schema.Flow
-	_, err := a.DB.Select(&items, query, typeCode)
+	_, err := a.executor().Select(&items, query, append([]interface{}{typeCode}, args...)...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "根据类型查询流程ID列表发生错误")
 	}
@@ -480,15 +1023,16 @@ func (a *Flow) QueryFlowIDsByType(typeCode string) ([]string, error) {
 
 // QueryFlowByIDs 根据流程ID查询流程数据
 func (a *Flow) QueryFlowByIDs(flowIDs []string) ([]*schema.FlowQueryResult, error) {
-	query := fmt.Sprintf("SELECT code,MAX(version)'version' FROM %s WHERE deleted=0 AND flag=1 AND status=1 AND record_id IN(?)  GROUP BY code ORDER BY code", schema.FlowTableName)
+	tw, targs := a.flowTenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT tenant_id,code,MAX(version) AS version FROM %s WHERE deleted=0 AND flag=1 AND status=1 AND record_id IN(?)%s GROUP BY tenant_id,code ORDER BY code,tenant_id", schema.FlowTableName, tw)
 
-	query, args, err := a.DB.In(query, flowIDs)
+	query, args, err := a.DB.In(query, append([]interface{}{flowIDs}, targs...)...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "根据流程ID查询流程数据发生错误")
 	}
 
 	var items []*schema.Flow
-	_, err = a.DB.Select(&items, query, args...)
+	_, err = a.executor().Select(&items, query, args...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "根据流程ID查询流程数据发生错误")
 	} else if len(items) == 0 {
@@ -497,7 +1041,7 @@ func (a *Flow) QueryFlowByIDs(flowIDs []string) ([]*schema.FlowQueryResult, erro
 
 	result := make([]*schema.FlowQueryResult, len(items))
 	for i, item := range items {
-		flowResult, verr := a.GetFlowQueryResultByCodeAndVersion(item.Code, item.Version)
+		flowResult, verr := a.GetFlowQueryResultByCodeAndVersion(item.TenantID, item.Code, item.Version)
 		if verr != nil {
 			return nil, verr
 		}
@@ -524,7 +1068,7 @@ func (a *Flow) GetNodeByFlowAndTypeCode(flowID, typeCode string) (*schema.Node,
 	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND flow_id=? AND type_code=?", schema.NodeTableName)
 
 	var item schema.Node
-	err := a.DB.SelectOne(&item, query, flowID, typeCode)
+	err := a.executor().SelectOne(&item, query, flowID, typeCode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -540,7 +1084,7 @@ func (a *Flow) GetForm(formID string) (*schema.Form, error) {
 	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=?", schema.FormTableName)
 
 	var item schema.Form
-	err := a.DB.SelectOne(&item, query, formID)
+	err := a.executor().SelectOne(&item, query, formID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -552,7 +1096,7 @@ func (a *Flow) GetForm(formID string) (*schema.Form, error) {
 
 // Update 更新流程信息
 func (a *Flow) Update(recordID string, info map[string]interface{}) error {
-	_, err := a.DB.UpdateByPK(schema.FlowTableName, db.M{"record_id": recordID}, db.M(info))
+	_, err := a.updateByPK(schema.FlowTableName, db.M{"record_id": recordID}, db.M(info))
 	if err != nil {
 		return errors.Wrapf(err, "更新流程信息发生错误")
 	}
@@ -563,10 +1107,8 @@ func (a *Flow) Update(recordID string, info map[string]interface{}) error {
 
 // QueryAllFlowPage 查询流程分页数据
 func (a *Flow) QueryAllFlowPage(params schema.FlowQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowQueryResult, error) {
-	var (
-		where = "WHERE deleted=0 AND flag=1"
-		args  []interface{}
-	)
+	where, args := a.flowTenantWhere("tenant_id")
+	where = fmt.Sprintf("WHERE deleted=0 AND flag=1%s", where)
 
 	if code := params.Code; code != "" {
 		where = fmt.Sprintf("%s AND code LIKE ?", where)
@@ -588,20 +1130,20 @@ func (a *Flow) QueryAllFlowPage(params schema.FlowQueryParam, pageIndex, pageSiz
 		args = append(args, v)
 	}
 
-	n, err := a.DB.SelectInt(fmt.Sprintf("SELECT count(*) FROM %s %s", schema.FlowTableName, where), args...)
+	n, err := a.executor().SelectInt(fmt.Sprintf("SELECT count(*) FROM %s %s", schema.FlowTableName, where), args...)
 	if err != nil {
 		return 0, nil, errors.Wrapf(err, "查询分页数据发生错误")
 	} else if n == 0 {
 		return 0, nil, nil
 	}
 
-	query := fmt.Sprintf("SELECT id,record_id,created,code,name,version FROM %s %s ORDER BY id DESC", schema.FlowTableName, where)
+	query := fmt.Sprintf("SELECT id,record_id,tenant_id,created,code,name,version,status,active FROM %s %s ORDER BY id DESC", schema.FlowTableName, where)
 	if pageIndex > 0 && pageSize > 0 {
-		query = fmt.Sprintf("%s limit %d,%d", query, (pageIndex-1)*pageSize, pageSize)
+		query = fmt.Sprintf("%s LIMIT %d OFFSET %d", query, pageSize, (pageIndex-1)*pageSize)
 	}
 
 	var items []*schema.FlowQueryResult
-	_, err = a.DB.Select(&items, query, args...)
+	_, err = a.executor().Select(&items, query, args...)
 	if err != nil {
 		return 0, nil, errors.Wrapf(err, "查询分页数据发生错误")
 	}
@@ -609,12 +1151,56 @@ func (a *Flow) QueryAllFlowPage(params schema.FlowQueryParam, pageIndex, pageSiz
 	return n, items, err
 }
 
+// QueryFlowInstancePage 查询流程实例分页数据
+func (a *Flow) QueryFlowInstancePage(params schema.FlowInstanceQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowInstance, error) {
+	where, args := a.tenantWhere("tenant_id")
+	where = fmt.Sprintf("WHERE deleted=0%s", where)
+
+	if v := params.FlowCode; v != "" {
+		where = fmt.Sprintf("%s AND flow_id IN (SELECT record_id FROM %s WHERE deleted=0 AND code=?)", where, schema.FlowTableName)
+		args = append(args, v)
+	}
+
+	if v := params.BusinessKey; v != "" {
+		where = fmt.Sprintf("%s AND business_key LIKE ?", where)
+		args = append(args, "%"+v+"%")
+	}
+
+	if v := params.Launcher; v != "" {
+		where = fmt.Sprintf("%s AND launcher=?", where)
+		args = append(args, v)
+	}
+
+	if v := params.Status; v > 0 {
+		where = fmt.Sprintf("%s AND status=?", where)
+		args = append(args, v)
+	}
+
+	n, err := a.executor().SelectInt(fmt.Sprintf("SELECT count(*) FROM %s %s", schema.FlowInstanceTableName, where), args...)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "查询流程实例分页数据发生错误")
+	} else if n == 0 {
+		return 0, nil, nil
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %s %s ORDER BY id DESC", schema.FlowInstanceTableName, where)
+	if pageIndex > 0 && pageSize > 0 {
+		query = fmt.Sprintf("%s LIMIT %d OFFSET %d", query, pageSize, (pageIndex-1)*pageSize)
+	}
+
+	var items []*schema.FlowInstance
+	_, err = a.executor().Select(&items, query, args...)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "查询流程实例分页数据发生错误")
+	}
+
+	return n, items, nil
+}
+
 // QueryGroupFlowPage 查询流程分组分页数据
 func (a *Flow) QueryGroupFlowPage(params schema.FlowQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowQueryResult, error) {
-	var (
-		where = "WHERE deleted=0 AND flag=1"
-		args  []interface{}
-	)
+	where, args := a.flowTenantWhere("tenant_id")
+	where = fmt.Sprintf("WHERE deleted=0 AND flag=1%s", where)
 
 	if code := params.Code; code != "" {
 		where = fmt.Sprintf("%s AND code LIKE ?", where)
@@ -636,10 +1222,10 @@ func (a *Flow) QueryGroupFlowPage(params schema.FlowQueryParam, pageIndex, pageS
 		args = append(args, v)
 	}
 
-	query := fmt.Sprintf("SELECT code,MAX(version)'version' FROM %s %s GROUP BY code ORDER BY code", schema.FlowTableName, where)
+	query := fmt.Sprintf("SELECT tenant_id,code,MAX(version) AS version FROM %s %s GROUP BY tenant_id,code ORDER BY code,tenant_id", schema.FlowTableName, where)
 
 	var items []*schema.Flow
-	_, err := a.DB.Select(&items, query, args...)
+	_, err := a.executor().Select(&items, query, args...)
 	if err != nil {
 		return 0, nil, errors.Wrapf(err, "查询分页数据发生错误")
 	} else if len(items) == 0 {
@@ -660,7 +1246,7 @@ func (a *Flow) QueryGroupFlowPage(params schema.FlowQueryParam, pageIndex, pageS
 
 	result := make([]*schema.FlowQueryResult, len(data))
 	for i, item := range data {
-		flowResult, verr := a.GetFlowQueryResultByCodeAndVersion(item.Code, item.Version)
+		flowResult, verr := a.GetFlowQueryResultByCodeAndVersion(item.TenantID, item.Code, item.Version)
 		if verr != nil {
 			return 0, nil, verr
 		}
@@ -670,13 +1256,13 @@ func (a *Flow) QueryGroupFlowPage(params schema.FlowQueryParam, pageIndex, pageS
 	return int64(len(items)), result, err
 }
 
-// GetFlowQueryResultByCodeAndVersion 根据编号和版本获取流程结果
-func (a *Flow) GetFlowQueryResultByCodeAndVersion(code string, version int64) (*schema.FlowQueryResult, error) {
-	query := fmt.Sprintf("SELECT id,record_id,created,code,name,version,type_code,status,memo FROM %s", schema.FlowTableName)
-	query = fmt.Sprintf("%s WHERE deleted=0 AND flag=1 AND code=? AND version=?", query)
+// GetFlowQueryResultByCodeAndVersion 根据租户、编号和版本获取流程结果
+func (a *Flow) GetFlowQueryResultByCodeAndVersion(tenantID, code string, version int64) (*schema.FlowQueryResult, error) {
+	query := fmt.Sprintf("SELECT id,record_id,tenant_id,created,code,name,version,type_code,status,active,memo FROM %s", schema.FlowTableName)
+	query = fmt.Sprintf("%s WHERE deleted=0 AND flag=1 AND tenant_id=? AND code=? AND version=?", query)
 
 	var item schema.FlowQueryResult
-	err := a.DB.SelectOne(&item, query, code, version)
+	err := a.executor().SelectOne(&item, query, tenantID, code, version)
 	if err != nil {
 		return nil, errors.Wrapf(err, "查询流程结果发生错误")
 	}
@@ -686,11 +1272,12 @@ func (a *Flow) GetFlowQueryResultByCodeAndVersion(code string, version int64) (*
 
 // QueryFlowVersion 查询流程版本数据
 func (a *Flow) QueryFlowVersion(code string) ([]*schema.FlowQueryResult, error) {
-	query := fmt.Sprintf("SELECT id,record_id,created,code,name,version,type_code,status,memo FROM %s", schema.FlowTableName)
-	query = fmt.Sprintf("%s WHERE deleted=0 AND flag=1 AND code=? ORDER BY version", query)
+	tw, args := a.flowTenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT id,record_id,tenant_id,created,code,name,version,type_code,status,active,memo FROM %s", schema.FlowTableName)
+	query = fmt.Sprintf("%s WHERE deleted=0 AND flag=1 AND code=?%s ORDER BY version", query, tw)
 
 	var items []*schema.FlowQueryResult
-	_, err := a.DB.Select(&items, query, code)
+	_, err := a.executor().Select(&items, query, append([]interface{}{code}, args...)...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "查询流程版本数据发生错误")
 	}
@@ -699,3 +1286,354 @@ func (a *Flow) QueryFlowVersion(code string) ([]*schema.FlowQueryResult, error)
 }
 
 // -----------------------------web查询操作(end)---------------------------------
+
+// CreateOutbox 写入事件发件箱
+func (a *Flow) CreateOutbox(items ...*schema.Outbox) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	list := make([]interface{}, len(items))
+	for i, item := range items {
+		list[i] = item
+	}
+
+	err := a.executor().Insert(list...)
+	if err != nil {
+		return errors.Wrapf(err, "写入事件发件箱发生错误")
+	}
+	return nil
+}
+
+// QueryPendingOutbox 查询待投递的事件(按写入顺序)
+func (a *Flow) QueryPendingOutbox(now int64, limit int) ([]*schema.Outbox, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND status=1 AND next_time<=? ORDER BY id LIMIT %d", schema.OutboxTableName, limit)
+
+	var items []*schema.Outbox
+	_, err := a.executor().Select(&items, query, now)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询待投递的事件发生错误")
+	}
+	return items, nil
+}
+
+// 发件箱按流程实例(包括已归档的流程实例)检查租户
+func (a *Flow) outboxTenantWhere() (string, []interface{}) {
+	tw, targs := a.tenantWhere("tenant_id")
+	if tw == "" {
+		return "", nil
+	}
+	where := fmt.Sprintf(" AND flow_instance_id IN (SELECT record_id FROM %s WHERE deleted=0%s UNION SELECT record_id FROM %s WHERE deleted=0%s)",
+		schema.FlowInstanceTableName, tw, schema.FlowInstanceArchiveTableName, tw)
+	return where, append(targs, targs...)
+}
+
+// GetOutbox 获取事件发件箱
+func (a *Flow) GetOutbox(recordID string) (*schema.Outbox, error) {
+	tw, args := a.outboxTenantWhere()
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=?%s LIMIT 1", schema.OutboxTableName, tw)
+
+	var item schema.Outbox
+	err := a.executor().SelectOne(&item, query, append([]interface{}{recordID}, args...)...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "获取事件发件箱发生错误")
+	}
+
+	return &item, nil
+}
+
+// QueryOutboxPage 查询事件发件箱分页数据
+func (a *Flow) QueryOutboxPage(params schema.OutboxQueryParam, pageIndex, pageSize uint) (int64, []*schema.Outbox, error) {
+	where, args := a.outboxTenantWhere()
+	where = fmt.Sprintf("WHERE deleted=0%s", where)
+
+	if v := params.EventType; v != "" {
+		where = fmt.Sprintf("%s AND event_type=?", where)
+		args = append(args, v)
+	}
+
+	if v := params.FlowInstanceID; v != "" {
+		where = fmt.Sprintf("%s AND flow_instance_id=?", where)
+		args = append(args, v)
+	}
+
+	if v := params.Status; v > 0 {
+		where = fmt.Sprintf("%s AND status=?", where)
+		args = append(args, v)
+	}
+
+	n, err := a.executor().SelectInt(fmt.Sprintf("SELECT count(*) FROM %s %s", schema.OutboxTableName, where), args...)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "查询事件发件箱分页数据发生错误")
+	} else if n == 0 {
+		return 0, nil, nil
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %s %s ORDER BY id DESC", schema.OutboxTableName, where)
+	if pageIndex > 0 && pageSize > 0 {
+		query = fmt.Sprintf("%s LIMIT %d OFFSET %d", query, pageSize, (pageIndex-1)*pageSize)
+	}
+
+	var items []*schema.Outbox
+	_, err = a.executor().Select(&items, query, args...)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "查询事件发件箱分页数据发生错误")
+	}
+
+	return n, items, nil
+}
+
+// UpdateOutbox 更新事件发件箱
+func (a *Flow) UpdateOutbox(recordID string, info map[string]interface{}) error {
+	_, err := a.updateByPK(schema.OutboxTableName, db.M{"record_id": recordID}, db.M(info))
+	if err != nil {
+		return errors.Wrapf(err, "更新事件发件箱发生错误")
+	}
+	return nil
+}
+
+// QueryNodeProperties 查询节点属性
+func (a *Flow) QueryNodeProperties(nodeID string) ([]*schema.NodeProperty, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND node_id=?", schema.NodePropertyTableName)
+
+	var items []*schema.NodeProperty
+	_, err := a.executor().Select(&items, query, nodeID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询节点属性发生错误")
+	}
+	return items, nil
+}
+
+// CreateWebhookDelivery 写入webhook投递记录
+func (a *Flow) CreateWebhookDelivery(items ...*schema.WebhookDelivery) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	list := make([]interface{}, len(items))
+	for i, item := range items {
+		list[i] = item
+	}
+
+	err := a.executor().Insert(list...)
+	if err != nil {
+		return errors.Wrapf(err, "写入webhook投递记录发生错误")
+	}
+	return nil
+}
+
+// QueryPendingWebhookDelivery 查询待投递的webhook(按写入顺序)
+func (a *Flow) QueryPendingWebhookDelivery(now int64, limit int) ([]*schema.WebhookDelivery, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND status=1 AND next_time<=? ORDER BY id LIMIT %d", schema.WebhookDeliveryTableName, limit)
+
+	var items []*schema.WebhookDelivery
+	_, err := a.executor().Select(&items, query, now)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询待投递的webhook发生错误")
+	}
+	return items, nil
+}
+
+// QueryWebhookDelivery 查询流程实例的webhook投递记录
+// 流程实例归档后待投递的记录仍在运行表中，按运行表及归档表的流程实例检查租户
+func (a *Flow) QueryWebhookDelivery(flowInstanceID string) ([]*schema.WebhookDelivery, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND flow_instance_id=?", schema.WebhookDeliveryTableName)
+	args := []interface{}{flowInstanceID}
+	if tw, targs := a.tenantWhere("tenant_id"); tw != "" {
+		query = fmt.Sprintf("%s AND flow_instance_id IN (SELECT record_id FROM %s WHERE deleted=0%s UNION SELECT record_id FROM %s WHERE deleted=0%s)",
+			query, schema.FlowInstanceTableName, tw, schema.FlowInstanceArchiveTableName, tw)
+		args = append(args, targs...)
+		args = append(args, targs...)
+	}
+	query = fmt.Sprintf("%s ORDER BY id", query)
+
+	var items []*schema.WebhookDelivery
+	_, err := a.executor().Select(&items, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询webhook投递记录发生错误")
+	}
+	return items, nil
+}
+
+// QueryArchivedWebhookDelivery 查询流程实例已归档的webhook投递记录
+func (a *Flow) QueryArchivedWebhookDelivery(flowInstanceID string) ([]*schema.WebhookDelivery, error) {
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND flow_instance_id=?", schema.WebhookDeliveryArchiveTableName)
+	args := []interface{}{flowInstanceID}
+	if tw, targs := a.tenantWhere("tenant_id"); tw != "" {
+		query = fmt.Sprintf("%s AND flow_instance_id IN (SELECT record_id FROM %s WHERE deleted=0%s)", query, schema.FlowInstanceArchiveTableName, tw)
+		args = append(args, targs...)
+	}
+	query = fmt.Sprintf("%s ORDER BY id", query)
+
+	var items []*schema.WebhookDeliveryArchive
+	_, err := a.executor().Select(&items, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询已归档的webhook投递记录发生错误")
+	}
+
+	result := make([]*schema.WebhookDelivery, len(items))
+	for i, item := range items {
+		result[i] = &item.WebhookDelivery
+	}
+	return result, nil
+}
+
+// UpdateWebhookDelivery 更新webhook投递记录
+func (a *Flow) UpdateWebhookDelivery(recordID string, info map[string]interface{}) error {
+	_, err := a.updateByPK(schema.WebhookDeliveryTableName, db.M{"record_id": recordID}, db.M(info))
+	if err != nil {
+		return errors.Wrapf(err, "更新webhook投递记录发生错误")
+	}
+	return nil
+}
+
+// CreateJob 创建作业
+func (a *Flow) CreateJob(item *schema.Job) error {
+	if a.scoped {
+		item.TenantID = a.tenantID
+	}
+	err := a.executor().Insert(item)
+	if err != nil {
+		return errors.Wrapf(err, "创建作业发生错误")
+	}
+	return nil
+}
+
+// GetJob 获取作业
+func (a *Flow) GetJob(recordID string) (*schema.Job, error) {
+	tw, args := a.tenantWhere("tenant_id")
+	query := fmt.Sprintf("SELECT * FROM %s WHERE deleted=0 AND record_id=?%s LIMIT 1", schema.JobTableName, tw)
+
+	var item schema.Job
+	err := a.executor().SelectOne(&item, query, append([]interface{}{recordID}, args...)...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "获取作业发生错误")
+	}
+
+	return &item, nil
+}
+
+// QueryAvailableJobIDs 查询可锁定的作业ID列表(待执行或锁定已过期，且流程实例进行中)，topic为空时不限主题
+func (a *Flow) QueryAvailableJobIDs(typeCode, topic string, now int64, limit int) ([]string, error) {
+	where := "WHERE j.deleted=0 AND j.type_code=? AND fi.status=1 AND ((j.status=1 AND j.next_time<=?) OR (j.status=2 AND j.lock_expire<=?))"
+	args := []interface{}{typeCode, now, now}
+
+	if tw, targs := a.tenantWhere("j.tenant_id"); tw != "" {
+		where = fmt.Sprintf("%s%s", where, tw)
+		args = append(args, targs...)
+	}
+
+	if topic != "" {
+		where = fmt.Sprintf("%s AND j.topic=?", where)
+		args = append(args, topic)
+	}
+
+	query := fmt.Sprintf("SELECT j.record_id FROM %s j JOIN %s fi ON j.flow_instance_id=fi.record_id AND fi.deleted=j.deleted %s ORDER BY j.id LIMIT %d", schema.JobTableName, schema.FlowInstanceTableName, where, limit)
+
+	var items []*schema.Job
+	_, err := a.executor().Select(&items, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询可锁定的作业发生错误")
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.RecordID
+	}
+	return ids, nil
+}
+
+// LockJob 锁定作业，作业已被其他执行者锁定时返回false
+func (a *Flow) LockJob(recordID, workerID string, lockExpire, now int64) (bool, error) {
+	query := fmt.Sprintf("UPDATE %s SET status=2,worker_id=?,lock_expire=?,updated=? WHERE deleted=0 AND record_id=? AND ((status=1 AND next_time<=?) OR (status=2 AND lock_expire<=?))", schema.JobTableName)
+	result, err := a.executor().Exec(query, workerID, lockExpire, now, recordID, now, now)
+	if err != nil {
+		return false, errors.Wrapf(err, "锁定作业发生错误")
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrapf(err, "锁定作业发生错误")
+	}
+	return n > 0, nil
+}
+
+// UpdateJob 更新作业
+func (a *Flow) UpdateJob(recordID string, info map[string]interface{}) error {
+	_, err := a.updateByPK(schema.JobTableName, db.M{"record_id": recordID}, db.M(info))
+	if err != nil {
+		return errors.Wrapf(err, "更新作业发生错误")
+	}
+	return nil
+}
+
+// QueryExternalTasks 查询外部任务
+func (a *Flow) QueryExternalTasks(jobIDs []string) ([]*schema.ExternalTaskResult, error) {
+	query := fmt.Sprintf(`SELECT j.record_id,j.topic,j.worker_id,j.lock_expire,j.attempts,j.retries,j.flow_instance_id,j.node_instance_id,
+		ni.input_data,n.code AS node_code,fi.business_key,fw.code AS flow_code
+		FROM %s j
+		JOIN %s ni ON j.node_instance_id=ni.record_id AND ni.deleted=j.deleted
+		JOIN %s fi ON j.flow_instance_id=fi.record_id AND fi.deleted=j.deleted
+		JOIN %s n ON ni.node_id=n.record_id AND n.deleted=j.deleted
+		JOIN %s fw ON fi.flow_id=fw.record_id AND fw.deleted=j.deleted
+		WHERE j.deleted=0 AND j.record_id IN(?) ORDER BY j.id`, schema.JobTableName, schema.NodeInstanceTableName, schema.FlowInstanceTableName, schema.NodeTableName, schema.FlowTableName)
+
+	query, args, err := a.DB.In(query, jobIDs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询外部任务发生错误")
+	}
+
+	var items []*schema.ExternalTaskResult
+	_, err = a.executor().Select(&items, query, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询外部任务发生错误")
+	}
+	return items, nil
+}
+
+// QueryJobPage 查询作业分页数据
+func (a *Flow) QueryJobPage(params schema.JobQueryParam, pageIndex, pageSize uint) (int64, []*schema.Job, error) {
+	where, args := a.tenantWhere("tenant_id")
+	where = fmt.Sprintf("WHERE deleted=0%s", where)
+
+	if v := params.TypeCode; v != "" {
+		where = fmt.Sprintf("%s AND type_code=?", where)
+		args = append(args, v)
+	}
+
+	if v := params.FlowInstanceID; v != "" {
+		where = fmt.Sprintf("%s AND flow_instance_id=?", where)
+		args = append(args, v)
+	}
+
+	if v := params.Status; v > 0 {
+		where = fmt.Sprintf("%s AND status=?", where)
+		args = append(args, v)
+	}
+
+	n, err := a.executor().SelectInt(fmt.Sprintf("SELECT count(*) FROM %s %s", schema.JobTableName, where), args...)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "查询作业分页数据发生错误")
+	} else if n == 0 {
+		return 0, nil, nil
+	}
+
+	query := fmt.Sprintf("SELECT * FROM %s %s ORDER BY id DESC", schema.JobTableName, where)
+	if pageIndex > 0 && pageSize > 0 {
+		query = fmt.Sprintf("%s LIMIT %d OFFSET %d", query, pageSize, (pageIndex-1)*pageSize)
+	}
+
+	var items []*schema.Job
+	_, err = a.executor().Select(&items, query, args...)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "查询作业分页数据发生错误")
+	}
+
+	return n, items, nil
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antlinker/flow/schema"
+	"github.com/antlinker/flow/service/db"
+	"github.com/pkg/errors"
+)
+
+func TestFlowCheckSize(t *testing.T) {
+	m := db.NewWithDB(db.DialectSQLite, nil, false)
+	m.AddTableWithName(schema.Job{}, schema.JobTableName)
+	a := &Flow{DB: m}
+
+	// 按结构体新增及更新时都检查列的最大长度
+	job := &schema.Job{RecordID: "J1", ErrorMessage: strings.Repeat("a", 1025)}
+	if err := a.executor().Insert(job); errors.Cause(err) != db.ErrDataTooLong {
+		t.Fatalf("无效的检查结果：%v", err)
+	}
+	if _, err := a.executor().Update(job); errors.Cause(err) != db.ErrDataTooLong {
+		t.Fatalf("无效的检查结果：%v", err)
+	}
+}
@@ -0,0 +1,1912 @@
+package model
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antlinker/flow/schema"
+	"github.com/pkg/errors"
+)
+
+var _ Repository = &Memory{}
+
+// Memory 流程管理(内存存储)
+// 适用于测试及嵌入式场景，可安全地并发访问；
+// 写操作与事务串行执行，事务写时复制被修改的表及数据，提交时替换当前数据，读操作只读取已提交的数据
+// 注意：事务中(例如同步的事件监听)不能再使用非事务的存储执行写操作
+type Memory struct {
+	store    *memoryStore
+	data     *memoryData
+	tenantID string
+	scoped   bool
+}
+
+// NewMemory 创建内存存储
+func NewMemory() *Memory {
+	return &Memory{
+		store: &memoryStore{data: new(memoryData)},
+	}
+}
+
+type memoryStore struct {
+	tranLock sync.Mutex
+	lock     sync.RWMutex
+	data     *memoryData
+}
+
+// 内存数据(每个切片对应一张表，按写入顺序存储)
+type memoryData struct {
+	Seq              int64
+	Flows            []*schema.Flow
+	Nodes            []*schema.Node
+	NodeRouters      []*schema.NodeRouter
+	NodeAssignments  []*schema.NodeAssignment
+	NodeProperties   []*schema.NodeProperty
+	FlowInstances    []*schema.FlowInstance
+	NodeInstances    []*schema.NodeInstance
+	NodeCandidates   []*schema.NodeCandidate
+	Forms            []*schema.Form
+	FormFields       []*schema.FormField
+	FieldOptions     []*schema.FieldOption
+	FieldProperties  []*schema.FieldProperty
+	FieldValidations []*schema.FieldValidation
+	Outboxes         []*schema.Outbox
+	WebhookDelivery  []*schema.WebhookDelivery
+	Jobs             []*schema.Job
+	BusinessKeys     []*schema.BusinessKey
+
+	FlowInstanceArchives  []*schema.FlowInstanceArchive
+	NodeInstanceArchives  []*schema.NodeInstanceArchive
+	NodeCandidateArchives []*schema.NodeCandidateArchive
+
+	WebhookDeliveryArchives []*schema.WebhookDeliveryArchive
+
+	owned map[interface{}]bool // 事务中已复制的表(切片的地址)及数据
+}
+
+// 开始事务：事务与已提交的数据共享表，修改时才复制被修改的表及数据(写时复制)；
+// 新增的数据追加在表的末尾，已提交数据的切片长度不变，提交前对读操作不可见
+func (d *memoryData) begin() *memoryData {
+	c := *d
+	c.owned = make(map[interface{}]bool)
+	return &c
+}
+
+// 获取可修改的数据(事务中首次修改时复制表的切片及数据，不影响已提交的数据)
+// table为表(切片)的地址，i为数据在表中的位置
+func (d *memoryData) modify(table interface{}, i int) interface{} {
+	v := reflect.ValueOf(table).Elem()
+	if !d.owned[table] {
+		items := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(items, v)
+		v.Set(items)
+		d.owned[table] = true
+	}
+
+	item := v.Index(i)
+	if !d.owned[item.Interface()] {
+		c := reflect.New(item.Type().Elem())
+		c.Elem().Set(item.Elem())
+		item.Set(c)
+		d.owned[c.Interface()] = true
+	}
+	return item.Interface()
+}
+
+// 写入数据(与自增ID一致，写入时设定ID)
+func (d *memoryData) insert(items ...interface{}) error {
+	for _, item := range items {
+		d.Seq++
+		switch v := item.(type) {
+		case *schema.Flow:
+			v.ID = d.Seq
+			c := *v
+			d.Flows = append(d.Flows, &c)
+		case *schema.Node:
+			v.ID = d.Seq
+			c := *v
+			d.Nodes = append(d.Nodes, &c)
+		case *schema.NodeRouter:
+			v.ID = d.Seq
+			c := *v
+			d.NodeRouters = append(d.NodeRouters, &c)
+		case *schema.NodeAssignment:
+			v.ID = d.Seq
+			c := *v
+			d.NodeAssignments = append(d.NodeAssignments, &c)
+		case *schema.NodeProperty:
+			v.ID = d.Seq
+			c := *v
+			d.NodeProperties = append(d.NodeProperties, &c)
+		case *schema.FlowInstance:
+			v.ID = d.Seq
+			c := *v
+			d.FlowInstances = append(d.FlowInstances, &c)
+		case *schema.NodeInstance:
+			v.ID = d.Seq
+			c := *v
+			d.NodeInstances = append(d.NodeInstances, &c)
+		case *schema.NodeCandidate:
+			v.ID = d.Seq
+			c := *v
+			d.NodeCandidates = append(d.NodeCandidates, &c)
+		case *schema.Form:
+			v.ID = d.Seq
+			c := *v
+			d.Forms = append(d.Forms, &c)
+		case *schema.FormField:
+			v.ID = d.Seq
+			c := *v
+			d.FormFields = append(d.FormFields, &c)
+		case *schema.FieldOption:
+			v.ID = d.Seq
+			c := *v
+			d.FieldOptions = append(d.FieldOptions, &c)
+		case *schema.FieldProperty:
+			v.ID = d.Seq
+			c := *v
+			d.FieldProperties = append(d.FieldProperties, &c)
+		case *schema.FieldValidation:
+			v.ID = d.Seq
+			c := *v
+			d.FieldValidations = append(d.FieldValidations, &c)
+		case *schema.Outbox:
+			v.ID = d.Seq
+			c := *v
+			d.Outboxes = append(d.Outboxes, &c)
+		case *schema.WebhookDelivery:
+			v.ID = d.Seq
+			c := *v
+			d.WebhookDelivery = append(d.WebhookDelivery, &c)
+		case *schema.Job:
+			v.ID = d.Seq
+			c := *v
+			d.Jobs = append(d.Jobs, &c)
+		case *schema.BusinessKey:
+			v.ID = d.Seq
+			c := *v
+			d.BusinessKeys = append(d.BusinessKeys, &c)
+		default:
+			return errors.Errorf("未知的数据类型：%T", item)
+		}
+	}
+	return nil
+}
+
+// 根据字段名(structs标签)更新数据
+func setColumns(item interface{}, info map[string]interface{}) error {
+	v := reflect.ValueOf(item).Elem()
+	t := v.Type()
+
+	n := 0
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("structs")
+		value, ok := info[name]
+		if !ok {
+			continue
+		}
+		n++
+
+		f := v.Field(i)
+		rv := reflect.ValueOf(value)
+		if !rv.IsValid() || !rv.Type().ConvertibleTo(f.Type()) ||
+			(rv.Kind() == reflect.String) != (f.Kind() == reflect.String) {
+			return errors.Errorf("无效的字段值：%s", name)
+		}
+		f.Set(rv.Convert(f.Type()))
+	}
+
+	if n != len(info) {
+		return errors.Errorf("未知的字段：%v", info)
+	}
+	return nil
+}
+
+// 根据编号查询流程ID(mainOnly为true时仅查询主流程)
+func (d *memoryData) flowIDs(code string, mainOnly bool) map[string]bool {
+	ids := make(map[string]bool)
+	for _, item := range d.Flows {
+		if item.Deleted == 0 && item.Code == code && (!mainOnly || item.Flag == 1) {
+			ids[item.RecordID] = true
+		}
+	}
+	return ids
+}
+
+func (d *memoryData) flow(recordID string) *schema.Flow {
+	for _, item := range d.Flows {
+		if item.Deleted == 0 && item.RecordID == recordID {
+			return item
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) node(recordID string) *schema.Node {
+	for _, item := range d.Nodes {
+		if item.Deleted == 0 && item.RecordID == recordID {
+			return item
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) form(recordID string) *schema.Form {
+	for _, item := range d.Forms {
+		if item.Deleted == 0 && item.RecordID == recordID {
+			return item
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) flowInstance(recordID string) *schema.FlowInstance {
+	for _, item := range d.FlowInstances {
+		if item.Deleted == 0 && item.RecordID == recordID {
+			return item
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) nodeInstance(recordID string) *schema.NodeInstance {
+	for _, item := range d.NodeInstances {
+		if item.Deleted == 0 && item.RecordID == recordID {
+			return item
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) job(recordID string) *schema.Job {
+	for _, item := range d.Jobs {
+		if item.Deleted == 0 && item.RecordID == recordID {
+			return item
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) isCandidate(nodeInstanceID, userID string) bool {
+	for _, item := range d.NodeCandidates {
+		if item.Deleted == 0 && item.NodeInstanceID == nodeInstanceID && item.CandidateID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// 查询流程数据(与查询参数匹配且租户可见的主流程)
+func (a *Memory) queryFlows(d *memoryData, params schema.FlowQueryParam) []*schema.Flow {
+	var items []*schema.Flow
+	for _, item := range d.Flows {
+		if item.Deleted != 0 || item.Flag != 1 || !a.visibleFlow(item) {
+			continue
+		} else if params.Code != "" && !memoryLike(item.Code, params.Code) {
+			continue
+		} else if params.Name != "" && !memoryLike(item.Name, params.Name) {
+			continue
+		} else if params.TypeCode != "" && item.TypeCode != params.TypeCode {
+			continue
+		} else if params.Status > 0 && item.Status != params.Status {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// 检查流程a是否优先于流程b(租户的流程优先于全局的流程，其次为启用的版本，再次为版本号大的流程)
+func isFlowPrior(a, b *schema.Flow) bool {
+	if (a.TenantID == "") != (b.TenantID == "") {
+		return a.TenantID != ""
+	} else if a.Active != b.Active {
+		return a.Active > b.Active
+	}
+	return a.Version > b.Version
+}
+
+// 按租户及编号分组(取最大版本)，并按编号排序
+func groupFlowVersion(items []*schema.Flow) []*schema.Flow {
+	type groupKey struct {
+		tenantID string
+		code     string
+	}
+
+	groups := make(map[groupKey]*schema.Flow)
+	for _, item := range items {
+		key := groupKey{tenantID: item.TenantID, code: item.Code}
+		if g, ok := groups[key]; !ok || g.Version < item.Version {
+			groups[key] = item
+		}
+	}
+
+	result := make([]*schema.Flow, 0, len(groups))
+	for _, item := range groups {
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Code != result[j].Code {
+			return result[i].Code < result[j].Code
+		}
+		return result[i].TenantID < result[j].TenantID
+	})
+	return result
+}
+
+func (d *memoryData) getFlowQueryResultByCodeAndVersion(tenantID, code string, version int64) (*schema.FlowQueryResult, error) {
+	for _, item := range d.Flows {
+		if item.Deleted == 0 && item.Flag == 1 && item.TenantID == tenantID && item.Code == code && item.Version == version {
+			return toFlowQueryResult(item), nil
+		}
+	}
+	return nil, errors.Errorf("查询流程结果发生错误：%s(%d)不存在", code, version)
+}
+
+func toFlowQueryResult(item *schema.Flow) *schema.FlowQueryResult {
+	return &schema.FlowQueryResult{
+		ID:       item.ID,
+		RecordID: item.RecordID,
+		TenantID: item.TenantID,
+		Code:     item.Code,
+		Name:     item.Name,
+		Version:  item.Version,
+		TypeCode: item.TypeCode,
+		Status:   item.Status,
+		Active:   item.Active,
+		Created:  item.Created,
+		Memo:     item.Memo,
+	}
+}
+
+// 模糊匹配(忽略大小写)
+func memoryLike(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// 获取分页的起止位置
+func memoryPage(n int, pageIndex, pageSize uint) (int, int) {
+	if pageIndex == 0 || pageSize == 0 {
+		return 0, n
+	}
+
+	start := int((pageIndex - 1) * pageSize)
+	if start > n {
+		start = n
+	}
+	end := start + int(pageSize)
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+// 读取已提交的数据(处于事务中时读取事务中的数据)
+func (a *Memory) read(fn func(*memoryData) error) error {
+	if a.data != nil {
+		return fn(a.data)
+	}
+
+	a.store.lock.RLock()
+	defer a.store.lock.RUnlock()
+	return fn(a.store.data)
+}
+
+// 写入数据(不处于事务中时在新的事务中写入)
+func (a *Memory) write(fn func(*memoryData) error) error {
+	if a.data != nil {
+		return fn(a.data)
+	}
+
+	return a.Tran(func(r Repository) error {
+		return fn(r.(*Memory).data)
+	})
+}
+
+// WithTenant 获取按租户隔离的存储
+func (a *Memory) WithTenant(tenantID string) Repository {
+	return &Memory{store: a.store, data: a.data, tenantID: tenantID, scoped: true}
+}
+
+// 检查流程对租户是否可见(租户的流程及全局的流程)
+func (a *Memory) visibleFlow(item *schema.Flow) bool {
+	return !a.scoped || item.TenantID == "" || item.TenantID == a.tenantID
+}
+
+// 检查数据是否属于租户
+func (a *Memory) ownTenant(tenantID string) bool {
+	return !a.scoped || tenantID == a.tenantID
+}
+
+// 检查流程实例是否存在且属于租户
+func (a *Memory) ownFlowInstance(d *memoryData, flowInstanceID string) bool {
+	fi := d.flowInstance(flowInstanceID)
+	return fi != nil && a.ownTenant(fi.TenantID)
+}
+
+// 已归档的流程实例是否属于当前租户
+func (a *Memory) ownArchivedFlowInstance(d *memoryData, flowInstanceID string) bool {
+	for _, item := range d.FlowInstanceArchives {
+		if item.Deleted == 0 && item.RecordID == flowInstanceID {
+			return a.ownTenant(item.TenantID)
+		}
+	}
+	return false
+}
+
+// Tran 在同一事务中执行fn，fn返回错误时回滚事务；如果当前已处于事务中则复用当前事务
+func (a *Memory) Tran(fn func(Repository) error) error {
+	if a.data != nil {
+		return fn(a)
+	}
+
+	a.store.tranLock.Lock()
+	defer a.store.tranLock.Unlock()
+
+	a.store.lock.RLock()
+	data := a.store.data.begin()
+	a.store.lock.RUnlock()
+
+	err := fn(&Memory{store: a.store, data: data, tenantID: a.tenantID, scoped: a.scoped})
+	if err != nil {
+		return err
+	}
+
+	data.owned = nil
+	a.store.lock.Lock()
+	a.store.data = data
+	a.store.lock.Unlock()
+	return nil
+}
+
+// CreateFlow 创建流程数据
+func (a *Memory) CreateFlow(flow *schema.Flow, nodes *schema.NodeOperating, forms *schema.FormOperating) error {
+	return a.write(func(d *memoryData) error {
+		if a.scoped {
+			flow.TenantID = a.tenantID
+		}
+
+		items := []interface{}{flow}
+		items = append(items, nodes.All()...)
+		items = append(items, forms.All()...)
+
+		err := d.insert(items...)
+		if err != nil {
+			return errors.Wrapf(err, "创建流程基础数据发生错误")
+		}
+		return nil
+	})
+}
+
+// GetFlow 获取流程数据
+func (a *Memory) GetFlow(recordID string) (*schema.Flow, error) {
+	var result *schema.Flow
+	err := a.read(func(d *memoryData) error {
+		if item := d.flow(recordID); item != nil && a.visibleFlow(item) {
+			c := *item
+			result = &c
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetFlowByCode 根据编号查询流程数据
+func (a *Memory) GetFlowByCode(code string) (*schema.Flow, error) {
+	var result *schema.Flow
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.Flows {
+			if item.Deleted != 0 || item.Flag != 1 || item.Status != 1 || item.Code != code || !a.visibleFlow(item) {
+				continue
+			} else if result == nil || isFlowPrior(item, result) {
+				c := *item
+				result = &c
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetNode 获取流程节点
+func (a *Memory) GetNode(recordID string) (*schema.Node, error) {
+	var result *schema.Node
+	err := a.read(func(d *memoryData) error {
+		if item := d.node(recordID); item != nil {
+			c := *item
+			result = &c
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetNodeByCode 根据节点编号获取流程节点
+func (a *Memory) GetNodeByCode(flowID, nodeCode string) (*schema.Node, error) {
+	var result *schema.Node
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.Nodes {
+			if item.Deleted != 0 || item.FlowID != flowID || item.Code != nodeCode {
+				continue
+			} else if result == nil || item.OrderNum < result.OrderNum {
+				c := *item
+				result = &c
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// QueryNodes 查询流程的节点
+func (a *Memory) QueryNodes(flowID string) ([]*schema.Node, error) {
+	var items []*schema.Node
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.Nodes {
+			if item.Deleted == 0 && item.FlowID == flowID {
+				c := *item
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].OrderNum < items[j].OrderNum
+	})
+	return items, nil
+}
+
+// GetFlowInstance 获取流程实例
+func (a *Memory) GetFlowInstance(recordID string) (*schema.FlowInstance, error) {
+	var result *schema.FlowInstance
+	err := a.read(func(d *memoryData) error {
+		if item := d.flowInstance(recordID); item != nil && a.ownTenant(item.TenantID) {
+			c := *item
+			result = &c
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetFlowInstanceByNode 根据节点实例获取流程实例
+func (a *Memory) GetFlowInstanceByNode(nodeInstanceID string) (*schema.FlowInstance, error) {
+	var result *schema.FlowInstance
+	err := a.read(func(d *memoryData) error {
+		ni := d.nodeInstance(nodeInstanceID)
+		if ni == nil {
+			return nil
+		}
+
+		if item := d.flowInstance(ni.FlowInstanceID); item != nil && a.ownTenant(item.TenantID) {
+			c := *item
+			result = &c
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetFlowInstanceByBusinessKey 根据业务主键获取最近发起的流程实例
+func (a *Memory) GetFlowInstanceByBusinessKey(flowCode, businessKey string) (*schema.FlowInstance, error) {
+	var result *schema.FlowInstance
+	err := a.read(func(d *memoryData) error {
+		flowIDs := d.flowIDs(flowCode, false)
+		for i := len(d.FlowInstances) - 1; i >= 0; i-- {
+			item := d.FlowInstances[i]
+			if item.Deleted == 0 && item.BusinessKey == businessKey && flowIDs[item.FlowID] && a.ownTenant(item.TenantID) {
+				c := *item
+				result = &c
+				break
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// CheckFlowInstanceBusinessKey 检查业务主键是否存在进行中(或暂停)的流程实例
+func (a *Memory) CheckFlowInstanceBusinessKey(flowCode, businessKey string) (bool, error) {
+	var exists bool
+	err := a.read(func(d *memoryData) error {
+		flowIDs := d.flowIDs(flowCode, false)
+		for _, item := range d.FlowInstances {
+			if item.Deleted == 0 && (item.Status == 1 || item.Status == 2) &&
+				item.BusinessKey == businessKey && flowIDs[item.FlowID] && a.ownTenant(item.TenantID) {
+				exists = true
+				break
+			}
+		}
+		return nil
+	})
+	return exists, err
+}
+
+// LockBusinessKey 锁定业务主键(同一流程编号下的业务主键已被锁定时返回false)
+func (a *Memory) LockBusinessKey(flowCode, businessKey, flowInstanceID string) (bool, error) {
+	item := &schema.BusinessKey{
+		FlowCode:       flowCode,
+		BusinessKey:    businessKey,
+		FlowInstanceID: flowInstanceID,
+		Created:        time.Now().Unix(),
+	}
+	if a.scoped {
+		item.TenantID = a.tenantID
+	}
+
+	var ok bool
+	err := a.write(func(d *memoryData) error {
+		for _, v := range d.BusinessKeys {
+			if v.TenantID == item.TenantID && v.FlowCode == flowCode && v.BusinessKey == businessKey {
+				return nil
+			}
+		}
+
+		err := d.insert(item)
+		if err != nil {
+			return errors.Wrapf(err, "锁定业务主键发生错误")
+		}
+		ok = true
+		return nil
+	})
+	return ok, err
+}
+
+// UnlockBusinessKey 释放流程实例锁定的业务主键
+func (a *Memory) UnlockBusinessKey(flowInstanceID string) error {
+	return a.write(func(d *memoryData) error {
+		var items []*schema.BusinessKey
+		for _, item := range d.BusinessKeys {
+			if item.FlowInstanceID != flowInstanceID {
+				items = append(items, item)
+			}
+		}
+		d.BusinessKeys = items
+		return nil
+	})
+}
+
+// GetNodeInstance 获取流程节点实例
+func (a *Memory) GetNodeInstance(recordID string) (*schema.NodeInstance, error) {
+	var result *schema.NodeInstance
+	err := a.read(func(d *memoryData) error {
+		if item := d.nodeInstance(recordID); item != nil && (!a.scoped || a.ownFlowInstance(d, item.FlowInstanceID)) {
+			c := *item
+			result = &c
+		}
+		return nil
+	})
+	return result, err
+}
+
+// QueryNodeInstances 查询流程实例的节点实例
+func (a *Memory) QueryNodeInstances(flowInstanceID string) ([]*schema.NodeInstance, error) {
+	var items []*schema.NodeInstance
+	err := a.read(func(d *memoryData) error {
+		if a.scoped && !a.ownFlowInstance(d, flowInstanceID) {
+			return nil
+		}
+
+		for _, item := range d.NodeInstances {
+			if item.Deleted == 0 && item.FlowInstanceID == flowInstanceID {
+				c := *item
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// QueryNodeRouters 查询节点路由
+func (a *Memory) QueryNodeRouters(sourceNodeID string) ([]*schema.NodeRouter, error) {
+	var items []*schema.NodeRouter
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.NodeRouters {
+			if item.Deleted == 0 && item.SourceNodeID == sourceNodeID {
+				c := *item
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// QueryNodeAssignments 查询节点指派
+func (a *Memory) QueryNodeAssignments(nodeID string) ([]*schema.NodeAssignment, error) {
+	var items []*schema.NodeAssignment
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.NodeAssignments {
+			if item.Deleted == 0 && item.NodeID == nodeID {
+				c := *item
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// QueryNodeProperties 查询节点属性
+func (a *Memory) QueryNodeProperties(nodeID string) ([]*schema.NodeProperty, error) {
+	var items []*schema.NodeProperty
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.NodeProperties {
+			if item.Deleted == 0 && item.NodeID == nodeID {
+				c := *item
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// CreateNodeInstance 创建流程节点实例
+func (a *Memory) CreateNodeInstance(nodeInstance *schema.NodeInstance, nodeCandidates []*schema.NodeCandidate) error {
+	return a.write(func(d *memoryData) error {
+		items := []interface{}{nodeInstance}
+		for _, c := range nodeCandidates {
+			items = append(items, c)
+		}
+
+		err := d.insert(items...)
+		if err != nil {
+			return errors.Wrapf(err, "创建流程节点实例发生错误")
+		}
+		return nil
+	})
+}
+
+// UpdateNodeInstance 更新节点实例信息
+func (a *Memory) UpdateNodeInstance(recordID string, info map[string]interface{}) error {
+	return a.write(func(d *memoryData) error {
+		for i, item := range d.NodeInstances {
+			if item.RecordID == recordID {
+				err := setColumns(d.modify(&d.NodeInstances, i), info)
+				if err != nil {
+					return errors.Wrapf(err, "更新节点实例信息发生错误")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// CheckFlowInstanceTodo 检查流程实例待办事项
+func (a *Memory) CheckFlowInstanceTodo(flowInstanceID string) (bool, error) {
+	var exists bool
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.NodeInstances {
+			if item.Deleted == 0 && item.Status == 1 && item.FlowInstanceID == flowInstanceID {
+				exists = true
+				break
+			}
+		}
+		return nil
+	})
+	return exists, err
+}
+
+// UpdateFlowInstance 更新流程实例信息
+func (a *Memory) UpdateFlowInstance(recordID string, info map[string]interface{}) error {
+	return a.write(func(d *memoryData) error {
+		for i, item := range d.FlowInstances {
+			if item.RecordID == recordID {
+				err := setColumns(d.modify(&d.FlowInstances, i), info)
+				if err != nil {
+					return errors.Wrapf(err, "更新流程实例信息发生错误")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateFlowInstance 迁移流程实例到新版本的流程，流程实例不属于原版本或已结束时返回false
+func (a *Memory) MigrateFlowInstance(recordID, sourceFlowID, targetFlowID string, updated int64) (bool, error) {
+	var ok bool
+	err := a.write(func(d *memoryData) error {
+		for i, item := range d.FlowInstances {
+			if item.Deleted != 0 || item.RecordID != recordID || !a.ownTenant(item.TenantID) ||
+				item.FlowID != sourceFlowID || (item.Status != 1 && item.Status != 2) {
+				continue
+			}
+
+			item = d.modify(&d.FlowInstances, i).(*schema.FlowInstance)
+			item.FlowID = targetFlowID
+			item.Updated = updated
+			ok = true
+		}
+		return nil
+	})
+	return ok, err
+}
+
+// CreateFlowInstance 创建流程实例
+func (a *Memory) CreateFlowInstance(flowInstance *schema.FlowInstance, nodeInstances ...*schema.NodeInstance) error {
+	return a.write(func(d *memoryData) error {
+		if a.scoped {
+			flowInstance.TenantID = a.tenantID
+		}
+
+		items := []interface{}{flowInstance}
+		for _, n := range nodeInstances {
+			items = append(items, n)
+		}
+
+		err := d.insert(items...)
+		if err != nil {
+			return errors.Wrapf(err, "创建流程实例发生错误")
+		}
+		return nil
+	})
+}
+
+// QueryNodeCandidates 查询节点候选人
+func (a *Memory) QueryNodeCandidates(nodeInstanceID string) ([]*schema.NodeCandidate, error) {
+	var items []*schema.NodeCandidate
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.NodeCandidates {
+			if item.Deleted == 0 && item.NodeInstanceID == nodeInstanceID {
+				c := *item
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// QueryTodo 查询用户的待办数据
+func (a *Memory) QueryTodo(flowCode, userID string) ([]*schema.FlowTodoResult, error) {
+	var items []*schema.FlowTodoResult
+	err := a.read(func(d *memoryData) error {
+		var flowIDs map[string]bool
+		if flowCode != "" {
+			flowIDs = d.flowIDs(flowCode, true)
+		}
+
+		for _, ni := range d.NodeInstances {
+			if ni.Deleted != 0 || ni.Status != 1 || !d.isCandidate(ni.RecordID, userID) {
+				continue
+			}
+
+			fi := d.flowInstance(ni.FlowInstanceID)
+			if fi == nil || fi.Status != 1 || !a.ownTenant(fi.TenantID) {
+				continue
+			} else if flowIDs != nil && !flowIDs[fi.FlowID] {
+				continue
+			}
+
+			item := &schema.FlowTodoResult{
+				RecordID:       ni.RecordID,
+				FlowInstanceID: ni.FlowInstanceID,
+				BusinessKey:    fi.BusinessKey,
+				NodeID:         ni.NodeID,
+				InputData:      ni.InputData,
+				Launcher:       fi.Launcher,
+				LaunchTime:     fi.LaunchTime,
+				DueTime:        ni.DueTime,
+				Priority:       ni.Priority,
+			}
+			if n := d.node(ni.NodeID); n != nil {
+				item.NodeCode = n.Code
+				item.NodeName = n.Name
+				item.FormKey = n.FormKey
+				if f := d.form(n.FormID); f != nil {
+					data, typeCode := f.Data, f.TypeCode
+					item.FormData = &data
+					item.FormType = &typeCode
+				}
+			}
+			items = append(items, item)
+		}
+		return nil
+	})
+	return items, err
+}
+
+// QueryDone 查询用户的已办数据
+func (a *Memory) QueryDone(flowCode, userID string, lastID int64, count int) ([]*schema.FlowDoneResult, error) {
+	var items []*schema.FlowDoneResult
+	err := a.read(func(d *memoryData) error {
+		var flowIDs map[string]bool
+		if flowCode != "" {
+			flowIDs = d.flowIDs(flowCode, true)
+		}
+
+		for i := len(d.NodeInstances) - 1; i >= 0 && len(items) < count; i-- {
+			ni := d.NodeInstances[i]
+			if ni.Deleted != 0 || ni.Status != 2 || ni.Processor != userID {
+				continue
+			} else if lastID > 0 && ni.ID >= lastID {
+				continue
+			}
+
+			fi := d.flowInstance(ni.FlowInstanceID)
+			if fi == nil || !a.ownTenant(fi.TenantID) {
+				continue
+			} else if flowIDs != nil && !flowIDs[fi.FlowID] {
+				continue
+			}
+
+			item := &schema.FlowDoneResult{
+				ID:             ni.ID,
+				RecordID:       ni.RecordID,
+				FlowInstanceID: ni.FlowInstanceID,
+				FlowStatus:     strconv.FormatInt(fi.Status, 10),
+				ProcessTime:    strconv.FormatInt(ni.ProcessTime, 10),
+				OutData:        ni.OutData,
+				Launcher:       fi.Launcher,
+				LaunchTime:     fi.LaunchTime,
+			}
+			if n := d.node(ni.NodeID); n != nil {
+				item.NodeName = n.Name
+				if f := d.form(n.FormID); f != nil {
+					data, typeCode := f.Data, f.TypeCode
+					item.FormData = &data
+					item.FormType = &typeCode
+				}
+				if fw := d.flow(n.FlowID); fw != nil {
+					item.FlowName = fw.Name
+				}
+			}
+			items = append(items, item)
+		}
+		return nil
+	})
+	return items, err
+}
+
+// DeleteFlow 删除流程
+func (a *Memory) DeleteFlow(flowID string) error {
+	return a.write(func(d *memoryData) error {
+		now := time.Now().Unix()
+
+		for i, item := range d.Flows {
+			if item.Deleted == 0 && item.RecordID == flowID {
+				d.modify(&d.Flows, i).(*schema.Flow).Deleted = now
+			}
+		}
+
+		nodeIDs := make(map[string]bool)
+		for _, item := range d.Nodes {
+			if item.Deleted == 0 && item.FlowID == flowID {
+				nodeIDs[item.RecordID] = true
+			}
+		}
+
+		for i, item := range d.NodeRouters {
+			if item.Deleted == 0 && nodeIDs[item.SourceNodeID] {
+				d.modify(&d.NodeRouters, i).(*schema.NodeRouter).Deleted = now
+			}
+		}
+
+		for i, item := range d.NodeAssignments {
+			if item.Deleted == 0 && nodeIDs[item.NodeID] {
+				d.modify(&d.NodeAssignments, i).(*schema.NodeAssignment).Deleted = now
+			}
+		}
+
+		for i, item := range d.NodeProperties {
+			if item.Deleted == 0 && nodeIDs[item.NodeID] {
+				d.modify(&d.NodeProperties, i).(*schema.NodeProperty).Deleted = now
+			}
+		}
+
+		for i, item := range d.Nodes {
+			if item.Deleted == 0 && item.FlowID == flowID {
+				d.modify(&d.Nodes, i).(*schema.Node).Deleted = now
+			}
+		}
+
+		for i, item := range d.Forms {
+			if item.Deleted == 0 && item.FlowID == flowID {
+				d.modify(&d.Forms, i).(*schema.Form).Deleted = now
+			}
+		}
+		return nil
+	})
+}
+
+// QueryHistory 查询流程实例历史数据
+func (a *Memory) QueryHistory(flowInstanceID string) ([]*schema.FlowHistoryResult, error) {
+	var items []*schema.FlowHistoryResult
+	err := a.read(func(d *memoryData) error {
+		if a.scoped && !a.ownFlowInstance(d, flowInstanceID) {
+			return nil
+		}
+
+		for _, ni := range d.NodeInstances {
+			if ni.Deleted == 0 && ni.FlowInstanceID == flowInstanceID {
+				items = appendHistory(d, items, ni)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortHistory(items)
+	return items, nil
+}
+
+// 追加用户任务节点的历史数据
+func appendHistory(d *memoryData, items []*schema.FlowHistoryResult, ni *schema.NodeInstance) []*schema.FlowHistoryResult {
+	n := d.node(ni.NodeID)
+	if n == nil || n.TypeCode != "userTask" {
+		return items
+	}
+
+	return append(items, &schema.FlowHistoryResult{
+		RecordID:    ni.RecordID,
+		NodeCode:    n.Code,
+		NodeName:    n.Name,
+		Processor:   ni.Processor,
+		ProcessTime: ni.ProcessTime,
+		OutData:     ni.OutData,
+		Status:      ni.Status,
+	})
+}
+
+// 历史数据按状态倒序、处理时间顺序排列
+func sortHistory(items []*schema.FlowHistoryResult) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Status != items[j].Status {
+			return items[i].Status > items[j].Status
+		}
+		return items[i].ProcessTime < items[j].ProcessTime
+	})
+}
+
+// QueryExpiredFlowInstanceIDs 查询过期(已完成或已停止)的流程实例ID列表
+// 结束时间为流程实例的更新时间(未记录更新时间时使用发起时间)，仍有待投递的发件箱消息或webhook的流程实例不包含在内
+func (a *Memory) QueryExpiredFlowInstanceIDs(params schema.ExpiredFlowInstanceQueryParam, limit int) ([]string, error) {
+	var ids []string
+	err := a.read(func(d *memoryData) error {
+		exclude := make(map[string]bool)
+		for _, code := range params.ExcludeFlowCodes {
+			exclude[code] = true
+		}
+
+		pending := make(map[string]bool)
+		for _, item := range d.Outboxes {
+			if item.Deleted == 0 && item.Status == 1 {
+				pending[item.FlowInstanceID] = true
+			}
+		}
+		for _, item := range d.WebhookDelivery {
+			if item.Deleted == 0 && item.Status == 1 {
+				pending[item.FlowInstanceID] = true
+			}
+		}
+
+		for _, item := range d.FlowInstances {
+			if len(ids) >= limit {
+				break
+			} else if (item.Status != 3 && item.Status != 9) || !a.ownTenant(item.TenantID) || pending[item.RecordID] {
+				continue
+			}
+
+			end := item.Updated
+			if end <= 0 {
+				end = item.LaunchTime
+			}
+			if end >= params.EndBefore {
+				continue
+			}
+
+			var code string
+			for _, f := range d.Flows {
+				if f.RecordID == item.FlowID {
+					code = f.Code
+					break
+				}
+			}
+			if (params.FlowCode != "" && code != params.FlowCode) || exclude[code] {
+				continue
+			}
+			ids = append(ids, item.RecordID)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// ArchiveFlowInstances 归档流程实例(将流程实例及其节点实例、候选人、已结束的webhook投递记录移动到归档表，删除作业、已结束的发件箱消息及业务主键锁定)
+func (a *Memory) ArchiveFlowInstances(flowInstanceIDs []string, archived int64) error {
+	return a.write(func(d *memoryData) error {
+		d.removeFlowInstances(flowInstanceIDs, func(fi *schema.FlowInstance) {
+			d.FlowInstanceArchives = append(d.FlowInstanceArchives, &schema.FlowInstanceArchive{FlowInstance: *fi, Archived: archived})
+		}, func(ni *schema.NodeInstance) {
+			d.NodeInstanceArchives = append(d.NodeInstanceArchives, &schema.NodeInstanceArchive{NodeInstance: *ni, Archived: archived})
+		}, func(nc *schema.NodeCandidate) {
+			d.NodeCandidateArchives = append(d.NodeCandidateArchives, &schema.NodeCandidateArchive{NodeCandidate: *nc, Archived: archived})
+		}, func(wd *schema.WebhookDelivery) {
+			d.WebhookDeliveryArchives = append(d.WebhookDeliveryArchives, &schema.WebhookDeliveryArchive{WebhookDelivery: *wd, Archived: archived})
+		})
+		return nil
+	})
+}
+
+// PurgeFlowInstances 清除流程实例(删除流程实例及其节点实例、候选人、作业、已结束的发件箱消息和webhook投递记录及业务主键锁定)
+func (a *Memory) PurgeFlowInstances(flowInstanceIDs []string) error {
+	return a.write(func(d *memoryData) error {
+		d.removeFlowInstances(flowInstanceIDs, nil, nil, nil, nil)
+		return nil
+	})
+}
+
+// 删除流程实例及其节点实例、候选人、已结束的webhook投递记录，删除前回调(回调为nil时直接删除)
+func (d *memoryData) removeFlowInstances(flowInstanceIDs []string,
+	onFlowInstance func(*schema.FlowInstance),
+	onNodeInstance func(*schema.NodeInstance),
+	onNodeCandidate func(*schema.NodeCandidate),
+	onWebhookDelivery func(*schema.WebhookDelivery)) {
+	ids := make(map[string]bool)
+	for _, id := range flowInstanceIDs {
+		ids[id] = true
+	}
+
+	nodeInstanceIDs := make(map[string]bool)
+	var nodeInstances []*schema.NodeInstance
+	for _, item := range d.NodeInstances {
+		if !ids[item.FlowInstanceID] {
+			nodeInstances = append(nodeInstances, item)
+			continue
+		}
+		nodeInstanceIDs[item.RecordID] = true
+		if onNodeInstance != nil {
+			onNodeInstance(item)
+		}
+	}
+
+	var nodeCandidates []*schema.NodeCandidate
+	for _, item := range d.NodeCandidates {
+		if !nodeInstanceIDs[item.NodeInstanceID] {
+			nodeCandidates = append(nodeCandidates, item)
+		} else if onNodeCandidate != nil {
+			onNodeCandidate(item)
+		}
+	}
+
+	var flowInstances []*schema.FlowInstance
+	for _, item := range d.FlowInstances {
+		if !ids[item.RecordID] {
+			flowInstances = append(flowInstances, item)
+		} else if onFlowInstance != nil {
+			onFlowInstance(item)
+		}
+	}
+
+	d.FlowInstances = flowInstances
+	d.NodeInstances = nodeInstances
+	d.NodeCandidates = nodeCandidates
+
+	// 作业、已结束的发件箱消息及业务主键锁定直接删除，待投递(status=1)的发件箱消息和webhook投递记录保留
+	var jobs []*schema.Job
+	for _, item := range d.Jobs {
+		if !ids[item.FlowInstanceID] {
+			jobs = append(jobs, item)
+		}
+	}
+	d.Jobs = jobs
+
+	var outboxes []*schema.Outbox
+	for _, item := range d.Outboxes {
+		if !ids[item.FlowInstanceID] || item.Status == 1 {
+			outboxes = append(outboxes, item)
+		}
+	}
+	d.Outboxes = outboxes
+
+	var deliveries []*schema.WebhookDelivery
+	for _, item := range d.WebhookDelivery {
+		if !ids[item.FlowInstanceID] || item.Status == 1 {
+			deliveries = append(deliveries, item)
+		} else if onWebhookDelivery != nil {
+			onWebhookDelivery(item)
+		}
+	}
+	d.WebhookDelivery = deliveries
+
+	var businessKeys []*schema.BusinessKey
+	for _, item := range d.BusinessKeys {
+		if !ids[item.FlowInstanceID] {
+			businessKeys = append(businessKeys, item)
+		}
+	}
+	d.BusinessKeys = businessKeys
+}
+
+// GetArchivedFlowInstance 获取已归档的流程实例
+func (a *Memory) GetArchivedFlowInstance(recordID string) (*schema.FlowInstance, error) {
+	var result *schema.FlowInstance
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.FlowInstanceArchives {
+			if item.Deleted == 0 && item.RecordID == recordID && a.ownTenant(item.TenantID) {
+				c := item.FlowInstance
+				result = &c
+				break
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetArchivedFlowInstanceByBusinessKey 根据业务主键获取最近发起的已归档流程实例
+func (a *Memory) GetArchivedFlowInstanceByBusinessKey(flowCode, businessKey string) (*schema.FlowInstance, error) {
+	var result *schema.FlowInstance
+	err := a.read(func(d *memoryData) error {
+		flowIDs := d.flowIDs(flowCode, false)
+		for i := len(d.FlowInstanceArchives) - 1; i >= 0; i-- {
+			item := d.FlowInstanceArchives[i].FlowInstance
+			if item.Deleted == 0 && item.BusinessKey == businessKey && flowIDs[item.FlowID] && a.ownTenant(item.TenantID) {
+				result = &item
+				break
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// QueryArchivedHistory 查询已归档流程实例的历史数据
+func (a *Memory) QueryArchivedHistory(flowInstanceID string) ([]*schema.FlowHistoryResult, error) {
+	var items []*schema.FlowHistoryResult
+	err := a.read(func(d *memoryData) error {
+		if a.scoped {
+			var own bool
+			for _, item := range d.FlowInstanceArchives {
+				if item.Deleted == 0 && item.RecordID == flowInstanceID {
+					own = a.ownTenant(item.TenantID)
+					break
+				}
+			}
+			if !own {
+				return nil
+			}
+		}
+
+		for _, item := range d.NodeInstanceArchives {
+			if item.Deleted == 0 && item.FlowInstanceID == flowInstanceID {
+				items = appendHistory(d, items, &item.NodeInstance)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortHistory(items)
+	return items, nil
+}
+
+// QueryArchivedDoneIDs 查询已办理的已归档流程实例ID列表
+func (a *Memory) QueryArchivedDoneIDs(flowCode, userID string) ([]string, error) {
+	var ids []string
+	err := a.read(func(d *memoryData) error {
+		done := make(map[string]bool)
+		for _, ni := range d.NodeInstanceArchives {
+			if ni.Deleted == 0 && ni.Status == 2 && ni.Processor == userID {
+				done[ni.FlowInstanceID] = true
+			}
+		}
+
+		flowIDs := d.flowIDs(flowCode, true)
+		for _, item := range d.FlowInstanceArchives {
+			if item.Deleted == 0 && flowIDs[item.FlowID] && done[item.RecordID] && a.ownTenant(item.TenantID) {
+				ids = append(ids, item.RecordID)
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// QueryDoneIDs 查询已办理的流程实例ID列表
+func (a *Memory) QueryDoneIDs(flowCode, userID string) ([]string, error) {
+	var ids []string
+	err := a.read(func(d *memoryData) error {
+		done := make(map[string]bool)
+		for _, ni := range d.NodeInstances {
+			if ni.Deleted == 0 && ni.Status == 2 && ni.Processor == userID {
+				done[ni.FlowInstanceID] = true
+			}
+		}
+
+		flowIDs := d.flowIDs(flowCode, true)
+		for _, item := range d.FlowInstances {
+			if item.Deleted == 0 && flowIDs[item.FlowID] && done[item.RecordID] && a.ownTenant(item.TenantID) {
+				ids = append(ids, item.RecordID)
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// QueryFlowIDsByType 根据类型查询流程ID列表
+func (a *Memory) QueryFlowIDsByType(typeCode string) ([]string, error) {
+	var ids []string
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.Flows {
+			if item.Deleted == 0 && item.Flag == 1 && item.Status == 1 && item.TypeCode == typeCode && a.visibleFlow(item) {
+				ids = append(ids, item.RecordID)
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// QueryFlowByIDs 根据流程ID查询流程数据
+func (a *Memory) QueryFlowByIDs(flowIDs []string) ([]*schema.FlowQueryResult, error) {
+	var result []*schema.FlowQueryResult
+	err := a.read(func(d *memoryData) error {
+		ids := make(map[string]bool)
+		for _, id := range flowIDs {
+			ids[id] = true
+		}
+
+		var items []*schema.Flow
+		for _, item := range d.Flows {
+			if item.Deleted == 0 && item.Flag == 1 && item.Status == 1 && ids[item.RecordID] && a.visibleFlow(item) {
+				items = append(items, item)
+			}
+		}
+
+		for _, item := range groupFlowVersion(items) {
+			flowResult, err := d.getFlowQueryResultByCodeAndVersion(item.TenantID, item.Code, item.Version)
+			if err != nil {
+				return err
+			}
+			result = append(result, flowResult)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetFlowFormByNodeID 获取流程节点表单
+func (a *Memory) GetFlowFormByNodeID(nodeID string) (*schema.Form, error) {
+	node, err := a.GetNode(nodeID)
+	if err != nil {
+		return nil, err
+	} else if node == nil || node.FormID == "" {
+		return nil, nil
+	}
+
+	return a.GetForm(node.FormID)
+}
+
+// GetNodeByFlowAndTypeCode 根据流程ID和节点类型获取节点数据
+func (a *Memory) GetNodeByFlowAndTypeCode(flowID, typeCode string) (*schema.Node, error) {
+	var result *schema.Node
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.Nodes {
+			if item.Deleted == 0 && item.FlowID == flowID && item.TypeCode == typeCode {
+				c := *item
+				result = &c
+				break
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetForm 获取流程表单
+func (a *Memory) GetForm(formID string) (*schema.Form, error) {
+	var result *schema.Form
+	err := a.read(func(d *memoryData) error {
+		if item := d.form(formID); item != nil {
+			c := *item
+			result = &c
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Update 更新流程信息
+func (a *Memory) Update(recordID string, info map[string]interface{}) error {
+	return a.write(func(d *memoryData) error {
+		for i, item := range d.Flows {
+			if item.RecordID == recordID {
+				err := setColumns(d.modify(&d.Flows, i), info)
+				if err != nil {
+					return errors.Wrapf(err, "更新流程信息发生错误")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// QueryAllFlowPage 查询流程分页数据
+func (a *Memory) QueryAllFlowPage(params schema.FlowQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowQueryResult, error) {
+	var (
+		total  int64
+		result []*schema.FlowQueryResult
+	)
+	err := a.read(func(d *memoryData) error {
+		items := a.queryFlows(d, params)
+		total = int64(len(items))
+
+		start, end := memoryPage(len(items), pageIndex, pageSize)
+		for i := len(items) - 1 - start; i >= len(items)-end; i-- {
+			item := items[i]
+			result = append(result, &schema.FlowQueryResult{
+				ID:       item.ID,
+				RecordID: item.RecordID,
+				TenantID: item.TenantID,
+				Created:  item.Created,
+				Code:     item.Code,
+				Name:     item.Name,
+				Version:  item.Version,
+			})
+		}
+		return nil
+	})
+	return total, result, err
+}
+
+// QueryFlowInstancePage 查询流程实例分页数据
+func (a *Memory) QueryFlowInstancePage(params schema.FlowInstanceQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowInstance, error) {
+	var (
+		total  int64
+		result []*schema.FlowInstance
+	)
+	err := a.read(func(d *memoryData) error {
+		var flowIDs map[string]bool
+		if params.FlowCode != "" {
+			flowIDs = d.flowIDs(params.FlowCode, false)
+		}
+
+		var items []*schema.FlowInstance
+		for i := len(d.FlowInstances) - 1; i >= 0; i-- {
+			item := d.FlowInstances[i]
+			if item.Deleted != 0 || !a.ownTenant(item.TenantID) {
+				continue
+			} else if flowIDs != nil && !flowIDs[item.FlowID] {
+				continue
+			} else if params.BusinessKey != "" && !memoryLike(item.BusinessKey, params.BusinessKey) {
+				continue
+			} else if params.Launcher != "" && item.Launcher != params.Launcher {
+				continue
+			} else if params.Status > 0 && item.Status != int64(params.Status) {
+				continue
+			}
+			items = append(items, item)
+		}
+		total = int64(len(items))
+
+		start, end := memoryPage(len(items), pageIndex, pageSize)
+		for _, item := range items[start:end] {
+			c := *item
+			result = append(result, &c)
+		}
+		return nil
+	})
+	return total, result, err
+}
+
+// QueryGroupFlowPage 查询流程分组分页数据
+func (a *Memory) QueryGroupFlowPage(params schema.FlowQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowQueryResult, error) {
+	var (
+		total  int64
+		result []*schema.FlowQueryResult
+	)
+	err := a.read(func(d *memoryData) error {
+		items := groupFlowVersion(a.queryFlows(d, params))
+		total = int64(len(items))
+
+		start, end := memoryPage(len(items), pageIndex, pageSize)
+		for _, item := range items[start:end] {
+			flowResult, err := d.getFlowQueryResultByCodeAndVersion(item.TenantID, item.Code, item.Version)
+			if err != nil {
+				return err
+			}
+			result = append(result, flowResult)
+		}
+		return nil
+	})
+	return total, result, err
+}
+
+// QueryFlowVersion 查询流程版本数据
+func (a *Memory) QueryFlowVersion(code string) ([]*schema.FlowQueryResult, error) {
+	var result []*schema.FlowQueryResult
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.Flows {
+			if item.Deleted == 0 && item.Flag == 1 && item.Code == code && a.visibleFlow(item) {
+				result = append(result, toFlowQueryResult(item))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	return result, nil
+}
+
+// CreateOutbox 写入事件发件箱
+func (a *Memory) CreateOutbox(items ...*schema.Outbox) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	return a.write(func(d *memoryData) error {
+		for _, item := range items {
+			err := d.insert(item)
+			if err != nil {
+				return errors.Wrapf(err, "写入事件发件箱发生错误")
+			}
+		}
+		return nil
+	})
+}
+
+// QueryPendingOutbox 查询待投递的事件(按写入顺序)
+func (a *Memory) QueryPendingOutbox(now int64, limit int) ([]*schema.Outbox, error) {
+	var items []*schema.Outbox
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.Outboxes {
+			if len(items) >= limit {
+				break
+			} else if item.Deleted == 0 && item.Status == 1 && item.NextTime <= now {
+				c := *item
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// UpdateOutbox 更新事件发件箱
+func (a *Memory) UpdateOutbox(recordID string, info map[string]interface{}) error {
+	return a.write(func(d *memoryData) error {
+		for i, item := range d.Outboxes {
+			if item.RecordID == recordID {
+				err := setColumns(d.modify(&d.Outboxes, i), info)
+				if err != nil {
+					return errors.Wrapf(err, "更新事件发件箱发生错误")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// 发件箱按流程实例(包括已归档的流程实例)检查租户
+func (a *Memory) ownOutbox(d *memoryData, item *schema.Outbox) bool {
+	return !a.scoped || a.ownFlowInstance(d, item.FlowInstanceID) || a.ownArchivedFlowInstance(d, item.FlowInstanceID)
+}
+
+// GetOutbox 获取事件发件箱
+func (a *Memory) GetOutbox(recordID string) (*schema.Outbox, error) {
+	var result *schema.Outbox
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.Outboxes {
+			if item.Deleted == 0 && item.RecordID == recordID && a.ownOutbox(d, item) {
+				c := *item
+				result = &c
+				break
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// QueryOutboxPage 查询事件发件箱分页数据
+func (a *Memory) QueryOutboxPage(params schema.OutboxQueryParam, pageIndex, pageSize uint) (int64, []*schema.Outbox, error) {
+	var (
+		total  int64
+		result []*schema.Outbox
+	)
+	err := a.read(func(d *memoryData) error {
+		var items []*schema.Outbox
+		for i := len(d.Outboxes) - 1; i >= 0; i-- {
+			item := d.Outboxes[i]
+			if item.Deleted != 0 || !a.ownOutbox(d, item) {
+				continue
+			} else if params.EventType != "" && item.EventType != params.EventType {
+				continue
+			} else if params.FlowInstanceID != "" && item.FlowInstanceID != params.FlowInstanceID {
+				continue
+			} else if params.Status > 0 && item.Status != params.Status {
+				continue
+			}
+			items = append(items, item)
+		}
+		total = int64(len(items))
+
+		start, end := memoryPage(len(items), pageIndex, pageSize)
+		for _, item := range items[start:end] {
+			c := *item
+			result = append(result, &c)
+		}
+		return nil
+	})
+	return total, result, err
+}
+
+// CreateWebhookDelivery 写入webhook投递记录
+func (a *Memory) CreateWebhookDelivery(items ...*schema.WebhookDelivery) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	return a.write(func(d *memoryData) error {
+		for _, item := range items {
+			err := d.insert(item)
+			if err != nil {
+				return errors.Wrapf(err, "写入webhook投递记录发生错误")
+			}
+		}
+		return nil
+	})
+}
+
+// QueryPendingWebhookDelivery 查询待投递的webhook(按写入顺序)
+func (a *Memory) QueryPendingWebhookDelivery(now int64, limit int) ([]*schema.WebhookDelivery, error) {
+	var items []*schema.WebhookDelivery
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.WebhookDelivery {
+			if len(items) >= limit {
+				break
+			} else if item.Deleted == 0 && item.Status == 1 && item.NextTime <= now {
+				c := *item
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// QueryWebhookDelivery 查询流程实例的webhook投递记录
+// 流程实例归档后待投递的记录仍在运行表中，按运行表及归档表的流程实例检查租户
+func (a *Memory) QueryWebhookDelivery(flowInstanceID string) ([]*schema.WebhookDelivery, error) {
+	var items []*schema.WebhookDelivery
+	err := a.read(func(d *memoryData) error {
+		if a.scoped && !a.ownFlowInstance(d, flowInstanceID) && !a.ownArchivedFlowInstance(d, flowInstanceID) {
+			return nil
+		}
+
+		for _, item := range d.WebhookDelivery {
+			if item.Deleted == 0 && item.FlowInstanceID == flowInstanceID {
+				c := *item
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// QueryArchivedWebhookDelivery 查询流程实例已归档的webhook投递记录
+func (a *Memory) QueryArchivedWebhookDelivery(flowInstanceID string) ([]*schema.WebhookDelivery, error) {
+	var items []*schema.WebhookDelivery
+	err := a.read(func(d *memoryData) error {
+		if a.scoped && !a.ownArchivedFlowInstance(d, flowInstanceID) {
+			return nil
+		}
+
+		for _, item := range d.WebhookDeliveryArchives {
+			if item.Deleted == 0 && item.FlowInstanceID == flowInstanceID {
+				c := item.WebhookDelivery
+				items = append(items, &c)
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+// UpdateWebhookDelivery 更新webhook投递记录
+func (a *Memory) UpdateWebhookDelivery(recordID string, info map[string]interface{}) error {
+	return a.write(func(d *memoryData) error {
+		for i, item := range d.WebhookDelivery {
+			if item.RecordID == recordID {
+				err := setColumns(d.modify(&d.WebhookDelivery, i), info)
+				if err != nil {
+					return errors.Wrapf(err, "更新webhook投递记录发生错误")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// CreateJob 创建作业
+func (a *Memory) CreateJob(item *schema.Job) error {
+	return a.write(func(d *memoryData) error {
+		if a.scoped {
+			item.TenantID = a.tenantID
+		}
+
+		err := d.insert(item)
+		if err != nil {
+			return errors.Wrapf(err, "创建作业发生错误")
+		}
+		return nil
+	})
+}
+
+// GetJob 获取作业
+func (a *Memory) GetJob(recordID string) (*schema.Job, error) {
+	var result *schema.Job
+	err := a.read(func(d *memoryData) error {
+		if item := d.job(recordID); item != nil && a.ownTenant(item.TenantID) {
+			c := *item
+			result = &c
+		}
+		return nil
+	})
+	return result, err
+}
+
+// 检查作业是否可锁定(待执行或锁定已过期)
+func isJobAvailable(item *schema.Job, now int64) bool {
+	return (item.Status == 1 && item.NextTime <= now) ||
+		(item.Status == 2 && item.LockExpire <= now)
+}
+
+// QueryAvailableJobIDs 查询可锁定的作业ID列表(待执行或锁定已过期，且流程实例进行中)，topic为空时不限主题
+func (a *Memory) QueryAvailableJobIDs(typeCode, topic string, now int64, limit int) ([]string, error) {
+	var ids []string
+	err := a.read(func(d *memoryData) error {
+		for _, item := range d.Jobs {
+			if len(ids) >= limit {
+				break
+			} else if item.Deleted != 0 || item.TypeCode != typeCode || !isJobAvailable(item, now) || !a.ownTenant(item.TenantID) {
+				continue
+			} else if topic != "" && item.Topic != topic {
+				continue
+			}
+
+			if fi := d.flowInstance(item.FlowInstanceID); fi != nil && fi.Status == 1 {
+				ids = append(ids, item.RecordID)
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// LockJob 锁定作业，作业已被其他执行者锁定时返回false
+func (a *Memory) LockJob(recordID, workerID string, lockExpire, now int64) (bool, error) {
+	var ok bool
+	err := a.write(func(d *memoryData) error {
+		for i, item := range d.Jobs {
+			if item.Deleted != 0 || item.RecordID != recordID || !isJobAvailable(item, now) {
+				continue
+			}
+
+			item = d.modify(&d.Jobs, i).(*schema.Job)
+			item.Status = 2
+			item.WorkerID = workerID
+			item.LockExpire = lockExpire
+			item.Updated = now
+			ok = true
+		}
+		return nil
+	})
+	return ok, err
+}
+
+// UpdateJob 更新作业
+func (a *Memory) UpdateJob(recordID string, info map[string]interface{}) error {
+	return a.write(func(d *memoryData) error {
+		for i, item := range d.Jobs {
+			if item.RecordID == recordID {
+				err := setColumns(d.modify(&d.Jobs, i), info)
+				if err != nil {
+					return errors.Wrapf(err, "更新作业发生错误")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// QueryExternalTasks 查询外部任务
+func (a *Memory) QueryExternalTasks(jobIDs []string) ([]*schema.ExternalTaskResult, error) {
+	var items []*schema.ExternalTaskResult
+	err := a.read(func(d *memoryData) error {
+		ids := make(map[string]bool)
+		for _, id := range jobIDs {
+			ids[id] = true
+		}
+
+		for _, j := range d.Jobs {
+			if j.Deleted != 0 || !ids[j.RecordID] {
+				continue
+			}
+
+			ni := d.nodeInstance(j.NodeInstanceID)
+			fi := d.flowInstance(j.FlowInstanceID)
+			if ni == nil || fi == nil {
+				continue
+			}
+
+			n := d.node(ni.NodeID)
+			fw := d.flow(fi.FlowID)
+			if n == nil || fw == nil {
+				continue
+			}
+
+			items = append(items, &schema.ExternalTaskResult{
+				RecordID:       j.RecordID,
+				Topic:          j.Topic,
+				WorkerID:       j.WorkerID,
+				LockExpire:     j.LockExpire,
+				Attempts:       j.Attempts,
+				Retries:        j.Retries,
+				FlowCode:       fw.Code,
+				FlowInstanceID: j.FlowInstanceID,
+				BusinessKey:    fi.BusinessKey,
+				NodeCode:       n.Code,
+				NodeInstanceID: j.NodeInstanceID,
+				InputData:      ni.InputData,
+			})
+		}
+		return nil
+	})
+	return items, err
+}
+
+// QueryJobPage 查询作业分页数据
+func (a *Memory) QueryJobPage(params schema.JobQueryParam, pageIndex, pageSize uint) (int64, []*schema.Job, error) {
+	var (
+		total  int64
+		result []*schema.Job
+	)
+	err := a.read(func(d *memoryData) error {
+		var items []*schema.Job
+		for i := len(d.Jobs) - 1; i >= 0; i-- {
+			item := d.Jobs[i]
+			if item.Deleted != 0 || !a.ownTenant(item.TenantID) {
+				continue
+			} else if params.TypeCode != "" && item.TypeCode != params.TypeCode {
+				continue
+			} else if params.FlowInstanceID != "" && item.FlowInstanceID != params.FlowInstanceID {
+				continue
+			} else if params.Status > 0 && item.Status != params.Status {
+				continue
+			}
+			items = append(items, item)
+		}
+		total = int64(len(items))
+
+		start, end := memoryPage(len(items), pageIndex, pageSize)
+		for _, item := range items[start:end] {
+			c := *item
+			result = append(result, &c)
+		}
+		return nil
+	})
+	return total, result, err
+}
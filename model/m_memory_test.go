@@ -0,0 +1,333 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antlinker/flow/schema"
+)
+
+func createMemoryFlow(t *testing.T, m *Memory, recordID, code string) {
+	err := m.CreateFlow(&schema.Flow{RecordID: recordID, Code: code, Flag: 1, Status: 1, Version: 1},
+		&schema.NodeOperating{NodeGroup: []*schema.Node{{RecordID: recordID + "_start", FlowID: recordID, Code: "start"}}},
+		&schema.FormOperating{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestMemoryTran(t *testing.T) {
+	m := NewMemory()
+	createMemoryFlow(t, m, "F1", "flow1")
+
+	err := m.CreateFlowInstance(&schema.FlowInstance{RecordID: "FI1", FlowID: "F1", Status: 1},
+		&schema.NodeInstance{RecordID: "NI1", FlowInstanceID: "FI1", NodeID: "F1_start", Status: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 提交：事务中的写入在提交前对事务外不可见
+	err = m.Tran(func(r Repository) error {
+		err := r.UpdateFlowInstance("FI1", map[string]interface{}{"status": 9})
+		if err != nil {
+			return err
+		}
+		err = r.CreateNodeInstance(&schema.NodeInstance{RecordID: "NI2", FlowInstanceID: "FI1", NodeID: "F1_start", Status: 1}, nil)
+		if err != nil {
+			return err
+		}
+
+		if fi, _ := r.GetFlowInstance("FI1"); fi.Status != 9 {
+			t.Fatalf("事务中应读取到事务的写入：%d", fi.Status)
+		}
+		if fi, _ := m.GetFlowInstance("FI1"); fi.Status != 1 {
+			t.Fatalf("提交前事务外不应读取到事务的写入：%d", fi.Status)
+		}
+		if ni, _ := m.GetNodeInstance("NI2"); ni != nil {
+			t.Fatal("提交前事务外不应读取到新增的数据")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if fi, _ := m.GetFlowInstance("FI1"); fi.Status != 9 {
+		t.Fatalf("提交后应读取到事务的写入：%d", fi.Status)
+	}
+	if ni, _ := m.GetNodeInstance("NI2"); ni == nil {
+		t.Fatal("提交后应读取到新增的数据")
+	}
+
+	// 回滚：事务中的写入全部丢弃
+	rollback := errors.New("rollback")
+	err = m.Tran(func(r Repository) error {
+		err := r.UpdateNodeInstance("NI1", map[string]interface{}{"status": 2})
+		if err != nil {
+			return err
+		}
+		err = r.CreateFlowInstance(&schema.FlowInstance{RecordID: "FI2", FlowID: "F1", Status: 1})
+		if err != nil {
+			return err
+		}
+		err = r.DeleteFlow("F1")
+		if err != nil {
+			return err
+		}
+		return rollback
+	})
+	if err != rollback {
+		t.Fatalf("无效的事务结果：%v", err)
+	}
+
+	if ni, _ := m.GetNodeInstance("NI1"); ni.Status != 1 {
+		t.Fatalf("回滚后不应保留更新：%d", ni.Status)
+	}
+	if fi, _ := m.GetFlowInstance("FI2"); fi != nil {
+		t.Fatal("回滚后不应保留新增的数据")
+	}
+	if f, _ := m.GetFlow("F1"); f == nil {
+		t.Fatal("回滚后不应保留删除")
+	}
+
+	// 回滚后新增的数据不影响后续事务
+	err = m.CreateFlowInstance(&schema.FlowInstance{RecordID: "FI3", FlowID: "F1", Status: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	total, items, err := m.QueryFlowInstancePage(schema.FlowInstanceQueryParam{}, 0, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if total != 2 || items[0].RecordID != "FI3" || items[1].RecordID != "FI1" {
+		t.Fatalf("无效的流程实例：%d", total)
+	}
+}
+
+func TestMemoryQuery(t *testing.T) {
+	m := NewMemory()
+	createMemoryFlow(t, m, "F1", "flow1")
+	createMemoryFlow(t, m, "F2", "flow2")
+
+	instances := []*schema.FlowInstance{
+		{RecordID: "FI1", FlowID: "F1", Status: 1, BusinessKey: "order-001", Launcher: "U1"},
+		{RecordID: "FI2", FlowID: "F1", Status: 9, BusinessKey: "order-002", Launcher: "U2"},
+		{RecordID: "FI3", FlowID: "F2", Status: 1, BusinessKey: "leave-001", Launcher: "U1"},
+	}
+	for _, fi := range instances {
+		err := m.CreateFlowInstance(fi)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	err := m.WithTenant("T1").CreateFlowInstance(&schema.FlowInstance{RecordID: "FI4", FlowID: "F1", Status: 1, Launcher: "U1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	params := []struct {
+		repo   Repository
+		params schema.FlowInstanceQueryParam
+		expect int64
+	}{
+		{m, schema.FlowInstanceQueryParam{}, 4},
+		{m, schema.FlowInstanceQueryParam{FlowCode: "flow1"}, 3},
+		{m, schema.FlowInstanceQueryParam{BusinessKey: "order"}, 2},
+		{m, schema.FlowInstanceQueryParam{Launcher: "U1", Status: 1}, 3},
+		{m, schema.FlowInstanceQueryParam{FlowCode: "flow1", Status: 9}, 1},
+		{m.WithTenant("T1"), schema.FlowInstanceQueryParam{}, 1},
+		{m.WithTenant(""), schema.FlowInstanceQueryParam{FlowCode: "flow1"}, 2},
+	}
+	for i, p := range params {
+		total, _, err := p.repo.QueryFlowInstancePage(p.params, 1, 10)
+		if err != nil {
+			t.Fatal(err.Error())
+		} else if total != p.expect {
+			t.Fatalf("查询条件%d的数量错误：%d", i, total)
+		}
+	}
+
+	total, items, err := m.QueryFlowInstancePage(schema.FlowInstanceQueryParam{}, 2, 3)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if total != 4 || len(items) != 1 || items[0].RecordID != "FI1" {
+		t.Fatalf("无效的分页数据：%d,%d", total, len(items))
+	}
+
+	// 待办：进行中的节点实例及进行中的流程实例
+	nodeInstances := []struct {
+		item   *schema.NodeInstance
+		userID string
+	}{
+		{&schema.NodeInstance{RecordID: "NI1", FlowInstanceID: "FI1", NodeID: "F1_start", Status: 1}, "U9"},
+		{&schema.NodeInstance{RecordID: "NI2", FlowInstanceID: "FI1", NodeID: "F1_start", Status: 2}, "U9"},
+		{&schema.NodeInstance{RecordID: "NI3", FlowInstanceID: "FI2", NodeID: "F1_start", Status: 1}, "U9"},
+		{&schema.NodeInstance{RecordID: "NI4", FlowInstanceID: "FI3", NodeID: "F2_start", Status: 1}, "U9"},
+		{&schema.NodeInstance{RecordID: "NI5", FlowInstanceID: "FI3", NodeID: "F2_start", Status: 1}, "U8"},
+	}
+	for _, ni := range nodeInstances {
+		err := m.CreateNodeInstance(ni.item, []*schema.NodeCandidate{{RecordID: ni.item.RecordID + "_c", NodeInstanceID: ni.item.RecordID, CandidateID: ni.userID}})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	todos, err := m.QueryTodo("", "U9")
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(todos) != 2 || todos[0].RecordID != "NI1" || todos[1].RecordID != "NI4" {
+		t.Fatalf("无效的待办数据：%d", len(todos))
+	}
+
+	todos, err = m.QueryTodo("flow2", "U9")
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(todos) != 1 || todos[0].RecordID != "NI4" || todos[0].NodeCode != "start" {
+		t.Fatalf("无效的待办数据：%d", len(todos))
+	}
+
+	// 可执行的作业：待执行且到期，或锁定已过期，且流程实例进行中
+	jobs := []*schema.Job{
+		{RecordID: "J1", FlowInstanceID: "FI1", TypeCode: "external", Topic: "charge", Status: 1, NextTime: 100},
+		{RecordID: "J2", FlowInstanceID: "FI1", TypeCode: "external", Topic: "charge", Status: 1, NextTime: 300},
+		{RecordID: "J3", FlowInstanceID: "FI1", TypeCode: "external", Topic: "charge", Status: 2, LockExpire: 100},
+		{RecordID: "J4", FlowInstanceID: "FI1", TypeCode: "external", Topic: "charge", Status: 2, LockExpire: 300},
+		{RecordID: "J5", FlowInstanceID: "FI1", TypeCode: "external", Topic: "notice", Status: 1, NextTime: 100},
+		{RecordID: "J6", FlowInstanceID: "FI2", TypeCode: "external", Topic: "charge", Status: 1, NextTime: 100},
+		{RecordID: "J7", FlowInstanceID: "FI1", TypeCode: "async", Status: 1, NextTime: 100},
+	}
+	for _, job := range jobs {
+		err := m.CreateJob(job)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	ids, err := m.QueryAvailableJobIDs("external", "charge", 200, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(ids) != 2 || ids[0] != "J1" || ids[1] != "J3" {
+		t.Fatalf("无效的作业：%v", ids)
+	}
+
+	ids, err = m.QueryAvailableJobIDs("external", "", 200, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(ids) != 3 {
+		t.Fatalf("无效的作业：%v", ids)
+	}
+
+	ok, err := m.LockJob("J1", "W1", 300, 200)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if !ok {
+		t.Fatal("作业应锁定成功")
+	}
+	ok, err = m.LockJob("J1", "W2", 300, 200)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if ok {
+		t.Fatal("已锁定的作业不应再次锁定")
+	}
+}
+
+func TestMemoryArchive(t *testing.T) {
+	m := NewMemory()
+	createMemoryFlow(t, m, "F1", "flow1")
+
+	err := m.CreateFlowInstance(&schema.FlowInstance{RecordID: "FI1", FlowID: "F1", Status: 9},
+		&schema.NodeInstance{RecordID: "NI1", FlowInstanceID: "FI1", NodeID: "F1_start", Status: 2, Processor: "U1"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = m.CreateJob(&schema.Job{RecordID: "J1", FlowInstanceID: "FI1", TypeCode: "async", Status: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = m.CreateOutbox(&schema.Outbox{RecordID: "O1", FlowInstanceID: "FI1", Status: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = m.CreateWebhookDelivery(&schema.WebhookDelivery{RecordID: "W1", FlowInstanceID: "FI1", Status: 3})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	ok, err := m.LockBusinessKey("flow1", "K001", "FI1")
+	if err != nil || !ok {
+		t.Fatalf("业务主键应锁定成功：%v", err)
+	}
+
+	// 仍有待投递的发件箱消息时不过期
+	params := schema.ExpiredFlowInstanceQueryParam{EndBefore: 100}
+	if ids, _ := m.QueryExpiredFlowInstanceIDs(params, 10); len(ids) != 0 {
+		t.Fatalf("有待投递的发件箱消息时不应过期：%v", ids)
+	}
+	err = m.UpdateOutbox("O1", map[string]interface{}{"status": 2})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if ids, _ := m.QueryExpiredFlowInstanceIDs(params, 10); len(ids) != 1 || ids[0] != "FI1" {
+		t.Fatalf("无效的过期流程实例：%v", ids)
+	}
+
+	// 查询过期后写入的待投递消息不随归档删除
+	err = m.CreateOutbox(&schema.Outbox{RecordID: "O2", FlowInstanceID: "FI1", Status: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	err = m.CreateWebhookDelivery(&schema.WebhookDelivery{RecordID: "W2", FlowInstanceID: "FI1", Status: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = m.ArchiveFlowInstances([]string{"FI1"}, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 作业、已结束的发件箱消息及业务主键锁定随流程实例删除
+	if job, _ := m.GetJob("J1"); job != nil {
+		t.Fatal("归档后应删除作业")
+	}
+	if items, _ := m.QueryPendingOutbox(100, 10); len(items) != 1 || items[0].RecordID != "O2" {
+		t.Fatalf("归档后应只保留待投递的发件箱消息：%v", items)
+	}
+	if n := len(m.store.data.Outboxes); n != 1 {
+		t.Fatalf("归档后应删除已投递的发件箱消息：%d", n)
+	}
+	if ok, _ := m.LockBusinessKey("flow1", "K001", "FI2"); !ok {
+		t.Fatal("归档后应释放业务主键")
+	}
+
+	// 已结束的webhook投递记录移动到归档表，待投递的记录保留在运行表中
+	if items, _ := m.QueryWebhookDelivery("FI1"); len(items) != 1 || items[0].RecordID != "W2" {
+		t.Fatalf("归档后应只保留待投递的webhook投递记录：%v", items)
+	}
+	if items, _ := m.QueryArchivedWebhookDelivery("FI1"); len(items) != 1 || items[0].RecordID != "W1" {
+		t.Fatalf("应查询到已归档的webhook投递记录：%v", items)
+	}
+
+	// 投递记录按流程实例的租户查询
+	if items, _ := m.WithTenant("T1").QueryWebhookDelivery("FI1"); len(items) != 0 {
+		t.Fatalf("不应查询到其他租户的webhook投递记录：%d", len(items))
+	}
+	if items, _ := m.WithTenant("T1").QueryArchivedWebhookDelivery("FI1"); len(items) != 0 {
+		t.Fatalf("不应查询到其他租户已归档的webhook投递记录：%d", len(items))
+	}
+	if items, _ := m.WithTenant("").QueryWebhookDelivery("FI1"); len(items) != 1 {
+		t.Fatalf("应查询到当前租户的webhook投递记录：%d", len(items))
+	}
+
+	// 流程实例及已办理的ID可从归档中查询
+	if fi, _ := m.GetFlowInstance("FI1"); fi != nil {
+		t.Fatal("归档后流程实例应从运行表中删除")
+	}
+	if fi, _ := m.GetArchivedFlowInstance("FI1"); fi == nil {
+		t.Fatal("应查询到已归档的流程实例")
+	}
+	ids, err := m.QueryArchivedDoneIDs("flow1", "U1")
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(ids) != 1 || ids[0] != "FI1" {
+		t.Fatalf("无效的已办理数据：%v", ids)
+	}
+}
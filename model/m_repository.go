@@ -0,0 +1,149 @@
+package model
+
+import (
+	"github.com/antlinker/flow/schema"
+)
+
+// Repository 流程数据存储
+// 流程业务通过该接口访问存储，Flow(MySQL)为其中一种实现
+type Repository interface {
+	// 在同一事务中执行fn，fn返回错误时回滚；如果当前已处于事务中则复用当前事务
+	Tran(fn func(Repository) error) error
+	// 获取按租户隔离的存储(流程数据包含租户及全局的流程，实例及作业数据仅包含租户的数据，写入时记录租户)
+	// 未指定租户的存储不限制租户(用于后台的作业执行等)
+	WithTenant(tenantID string) Repository
+
+	// 创建流程数据
+	CreateFlow(flow *schema.Flow, nodes *schema.NodeOperating, forms *schema.FormOperating) error
+	// 获取流程数据
+	GetFlow(recordID string) (*schema.Flow, error)
+	// 根据编号查询流程数据(启用的版本或最新的有效版本，租户的流程优先于全局的流程)
+	GetFlowByCode(code string) (*schema.Flow, error)
+	// 更新流程数据
+	Update(recordID string, info map[string]interface{}) error
+	// 删除流程(软删除流程及其节点、路由、指派、表单数据)
+	DeleteFlow(flowID string) error
+	// 查询流程分页数据
+	QueryAllFlowPage(params schema.FlowQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowQueryResult, error)
+	// 查询流程分组分页数据(每个流程编号仅包含最新版本)
+	QueryGroupFlowPage(params schema.FlowQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowQueryResult, error)
+	// 查询流程编号的所有版本
+	QueryFlowVersion(code string) ([]*schema.FlowQueryResult, error)
+	// 根据类型查询流程ID列表
+	QueryFlowIDsByType(typeCode string) ([]string, error)
+	// 根据流程ID查询流程数据
+	QueryFlowByIDs(flowIDs []string) ([]*schema.FlowQueryResult, error)
+
+	// 获取流程节点
+	GetNode(recordID string) (*schema.Node, error)
+	// 根据节点编号获取流程节点
+	GetNodeByCode(flowID, nodeCode string) (*schema.Node, error)
+	// 查询流程的节点
+	QueryNodes(flowID string) ([]*schema.Node, error)
+	// 根据流程ID和节点类型获取节点
+	GetNodeByFlowAndTypeCode(flowID, typeCode string) (*schema.Node, error)
+	// 查询节点路由
+	QueryNodeRouters(sourceNodeID string) ([]*schema.NodeRouter, error)
+	// 查询节点指派
+	QueryNodeAssignments(nodeID string) ([]*schema.NodeAssignment, error)
+	// 查询节点属性
+	QueryNodeProperties(nodeID string) ([]*schema.NodeProperty, error)
+	// 获取流程节点表单
+	GetFlowFormByNodeID(nodeID string) (*schema.Form, error)
+	// 获取表单
+	GetForm(formID string) (*schema.Form, error)
+
+	// 创建流程实例及节点实例
+	CreateFlowInstance(flowInstance *schema.FlowInstance, nodeInstances ...*schema.NodeInstance) error
+	// 获取流程实例
+	GetFlowInstance(recordID string) (*schema.FlowInstance, error)
+	// 根据节点实例获取流程实例
+	GetFlowInstanceByNode(nodeInstanceID string) (*schema.FlowInstance, error)
+	// 根据业务主键获取最近发起的流程实例
+	GetFlowInstanceByBusinessKey(flowCode, businessKey string) (*schema.FlowInstance, error)
+	// 检查业务主键是否存在进行中的流程实例
+	CheckFlowInstanceBusinessKey(flowCode, businessKey string) (bool, error)
+	// 锁定业务主键(同一流程编号下的业务主键已被锁定时返回false)
+	LockBusinessKey(flowCode, businessKey, flowInstanceID string) (bool, error)
+	// 释放流程实例锁定的业务主键
+	UnlockBusinessKey(flowInstanceID string) error
+	// 更新流程实例
+	UpdateFlowInstance(recordID string, info map[string]interface{}) error
+	// 迁移流程实例到新版本的流程(流程实例属于原版本且未结束时才能迁移成功)
+	MigrateFlowInstance(recordID, sourceFlowID, targetFlowID string, updated int64) (bool, error)
+	// 检查流程实例是否存在未完成的节点实例
+	CheckFlowInstanceTodo(flowInstanceID string) (bool, error)
+	// 查询流程实例分页数据
+	QueryFlowInstancePage(params schema.FlowInstanceQueryParam, pageIndex, pageSize uint) (int64, []*schema.FlowInstance, error)
+
+	// 创建节点实例及候选人
+	CreateNodeInstance(nodeInstance *schema.NodeInstance, nodeCandidates []*schema.NodeCandidate) error
+	// 获取节点实例
+	GetNodeInstance(recordID string) (*schema.NodeInstance, error)
+	// 查询流程实例的节点实例
+	QueryNodeInstances(flowInstanceID string) ([]*schema.NodeInstance, error)
+	// 更新节点实例
+	UpdateNodeInstance(recordID string, info map[string]interface{}) error
+	// 查询节点实例的候选人
+	QueryNodeCandidates(nodeInstanceID string) ([]*schema.NodeCandidate, error)
+	// 查询用户的待办数据
+	QueryTodo(flowCode, userID string) ([]*schema.FlowTodoResult, error)
+	// 查询用户的已办数据
+	QueryDone(flowCode, userID string, lastID int64, count int) ([]*schema.FlowDoneResult, error)
+	// 查询已办理的流程实例ID列表
+	QueryDoneIDs(flowCode, userID string) ([]string, error)
+	// 查询流程实例的历史数据
+	QueryHistory(flowInstanceID string) ([]*schema.FlowHistoryResult, error)
+
+	// 查询过期(已完成或已停止)的流程实例ID列表
+	QueryExpiredFlowInstanceIDs(params schema.ExpiredFlowInstanceQueryParam, limit int) ([]string, error)
+	// 归档流程实例(将流程实例及其节点实例、候选人移动到归档表，删除作业、发件箱、webhook投递记录及业务主键锁定)
+	ArchiveFlowInstances(flowInstanceIDs []string, archived int64) error
+	// 清除流程实例(删除流程实例及其节点实例、候选人、作业、发件箱、webhook投递记录及业务主键锁定)
+	PurgeFlowInstances(flowInstanceIDs []string) error
+	// 获取已归档的流程实例
+	GetArchivedFlowInstance(recordID string) (*schema.FlowInstance, error)
+	// 根据业务主键获取最近发起的已归档流程实例
+	GetArchivedFlowInstanceByBusinessKey(flowCode, businessKey string) (*schema.FlowInstance, error)
+	// 查询已归档流程实例的历史数据
+	QueryArchivedHistory(flowInstanceID string) ([]*schema.FlowHistoryResult, error)
+	// 查询已办理的已归档流程实例ID列表
+	QueryArchivedDoneIDs(flowCode, userID string) ([]string, error)
+
+	// 写入事件发件箱
+	CreateOutbox(items ...*schema.Outbox) error
+	// 查询到期待投递的事件
+	QueryPendingOutbox(now int64, limit int) ([]*schema.Outbox, error)
+	// 更新发件箱
+	UpdateOutbox(recordID string, info map[string]interface{}) error
+	// 获取事件发件箱
+	GetOutbox(recordID string) (*schema.Outbox, error)
+	// 查询事件发件箱分页数据
+	QueryOutboxPage(params schema.OutboxQueryParam, pageIndex, pageSize uint) (int64, []*schema.Outbox, error)
+
+	// 写入webhook投递记录
+	CreateWebhookDelivery(items ...*schema.WebhookDelivery) error
+	// 查询到期待投递的webhook
+	QueryPendingWebhookDelivery(now int64, limit int) ([]*schema.WebhookDelivery, error)
+	// 查询流程实例的webhook投递记录
+	QueryWebhookDelivery(flowInstanceID string) ([]*schema.WebhookDelivery, error)
+	// 查询流程实例已归档的webhook投递记录
+	QueryArchivedWebhookDelivery(flowInstanceID string) ([]*schema.WebhookDelivery, error)
+	// 更新webhook投递记录
+	UpdateWebhookDelivery(recordID string, info map[string]interface{}) error
+
+	// 创建作业
+	CreateJob(item *schema.Job) error
+	// 获取作业
+	GetJob(recordID string) (*schema.Job, error)
+	// 查询可执行的作业ID列表(topic为空时不限主题)
+	QueryAvailableJobIDs(typeCode, topic string, now int64, limit int) ([]string, error)
+	// 锁定作业(作业未被锁定或锁定已过期时才能锁定成功)
+	LockJob(recordID, workerID string, lockExpire, now int64) (bool, error)
+	// 更新作业
+	UpdateJob(recordID string, info map[string]interface{}) error
+	// 查询外部任务
+	QueryExternalTasks(jobIDs []string) ([]*schema.ExternalTaskResult, error)
+	// 查询作业分页数据
+	QueryJobPage(params schema.JobQueryParam, pageIndex, pageSize uint) (int64, []*schema.Job, error)
+}
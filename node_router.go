@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 
+	"github.com/antlinker/flow/bll"
 	"github.com/antlinker/flow/schema"
 	"github.com/pkg/errors"
 )
@@ -24,6 +25,8 @@ type nodeRouterOptions struct {
 	autoStart  bool
 	onNextNode NextNodeHandle
 	onFlowEnd  EndHandle
+	flowBll    *bll.Flow
+	emitter    *eventEmitter
 }
 
 // NodeRouterOption 节点路由配置
@@ -50,6 +53,14 @@ func OnFlowEndOption(fn EndHandle) NodeRouterOption {
 	}
 }
 
+// 事务配置(节点流转使用事务中的流程业务，并发出流程事件)
+func transactionOption(flowBll *bll.Flow, emitter *eventEmitter) NodeRouterOption {
+	return func(o *nodeRouterOptions) {
+		o.flowBll = flowBll
+		o.emitter = emitter
+	}
+}
+
 // NodeRouter 节点路由
 type NodeRouter struct {
 	ctx          context.Context
@@ -58,8 +69,10 @@ type NodeRouter struct {
 	nodeInstance *schema.NodeInstance
 	inputData    []byte
 	engine       *Engine
+	flowBll      *bll.Flow
 	opts         *nodeRouterOptions
 	parent       *NodeRouter
+	entered      bool // 是否是流转进入的节点(否则为处理已存在的节点实例)
 	stop         bool
 }
 
@@ -75,12 +88,20 @@ func (n *NodeRouter) Init(ctx context.Context, engine *Engine, nodeInstanceID st
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	return n.init(ctx, engine, opts, nodeInstanceID, inputData)
+}
+
+func (n *NodeRouter) init(ctx context.Context, engine *Engine, opts *nodeRouterOptions, nodeInstanceID string, inputData []byte) (*NodeRouter, error) {
 	n.ctx = ctx
 	n.opts = opts
 	n.inputData = inputData
 	n.engine = engine
+	n.flowBll = opts.flowBll
+	if n.flowBll == nil {
+		n.flowBll = engine.tenantBll(ctx)
+	}
 
-	nodeInstance, err := n.engine.flowBll.GetNodeInstance(nodeInstanceID)
+	nodeInstance, err := n.flowBll.GetNodeInstance(nodeInstanceID)
 	if err != nil {
 		return nil, err
 	} else if nodeInstance == nil {
@@ -88,7 +109,7 @@ func (n *NodeRouter) Init(ctx context.Context, engine *Engine, nodeInstanceID st
 	}
 	n.nodeInstance = nodeInstance
 
-	flowInstance, err := n.engine.flowBll.GetFlowInstance(nodeInstance.FlowInstanceID)
+	flowInstance, err := n.flowBll.GetFlowInstance(nodeInstance.FlowInstanceID)
 	if err != nil {
 		return nil, err
 	} else if flowInstance == nil {
@@ -96,7 +117,7 @@ func (n *NodeRouter) Init(ctx context.Context, engine *Engine, nodeInstanceID st
 	}
 	n.flowInstance = flowInstance
 
-	node, err := n.engine.flowBll.GetNode(nodeInstance.NodeID)
+	node, err := n.flowBll.GetNode(nodeInstance.NodeID)
 	if err != nil {
 		return nil, err
 	} else if node == nil {
@@ -108,12 +129,32 @@ func (n *NodeRouter) Init(ctx context.Context, engine *Engine, nodeInstanceID st
 }
 
 func (n *NodeRouter) next(nodeInstanceID, processor string) (*NodeRouter, error) {
-	nextRouter, err := new(NodeRouter).Init(n.ctx, n.engine, nodeInstanceID, n.inputData)
+	nextRouter, err := new(NodeRouter).init(n.ctx, n.engine, n.opts, nodeInstanceID, n.inputData)
 	if err != nil {
 		return nil, err
 	}
-	nextRouter.opts = n.opts
 	nextRouter.parent = n
+	nextRouter.entered = true
+
+	err = n.emit(&Event{
+		Type:         EventNodeEntered,
+		FlowInstance: nextRouter.flowInstance,
+		Node:         nextRouter.node,
+		NodeInstance: nextRouter.nodeInstance,
+		UserID:       processor,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 进入节点前异步继续：创建作业，由作业执行器在新的事务中继续流转
+	if nextRouter.node.AsyncBefore {
+		err = nextRouter.createAsyncJob(JobStageBefore, processor)
+		if err != nil {
+			return nil, err
+		}
+		return nextRouter, nil
+	}
 
 	err = nextRouter.Next(processor)
 	if err != nil {
@@ -122,6 +163,11 @@ func (n *NodeRouter) next(nodeInstanceID, processor string) (*NodeRouter, error)
 	return nextRouter, nil
 }
 
+// 发出流程事件
+func (n *NodeRouter) emit(event *Event) error {
+	return n.opts.emitter.emit(event)
+}
+
 // GetFlowInstance 获取流程实例
 func (n *NodeRouter) GetFlowInstance() *schema.FlowInstance {
 	return n.flowInstance
@@ -134,19 +180,38 @@ func (n *NodeRouter) Next(processor string) error {
 		return err
 	}
 
-	if nodeType == UserTask && n.parent != nil {
-		pNodeType, err := GetNodeTypeByName(n.parent.node.TypeCode)
-		if err != nil {
-			return err
+	// 外部服务任务：创建作业等待执行者拉取，执行者完成后继续流转
+	if nodeType == ServiceTask && n.entered {
+		if n.node.Topic == "" {
+			return errors.Errorf("服务任务[%s]未配置外部任务主题", n.node.Code)
+		}
+		_, err := n.flowBll.CreateJob(JobTypeExternal, n.flowInstance.RecordID, n.nodeInstance.RecordID, n.node.Topic, "", processor, n.engine.jobRetries())
+		return err
+	}
+
+	if nodeType == UserTask && n.entered {
+		autoStart := false
+		if n.parent != nil {
+			pNodeType, err := GetNodeTypeByName(n.parent.node.TypeCode)
+			if err != nil {
+				return err
+			}
+			autoStart = pNodeType == StartEvent && n.parent.opts.autoStart
 		}
 
-		if !(pNodeType == StartEvent && n.parent.opts.autoStart) {
+		if !autoStart {
+			candidates, err := n.flowBll.QueryNodeCandidates(n.nodeInstance.RecordID)
+			if err != nil {
+				return err
+			}
+
+			err = n.emitTask(processor, candidates)
+			if err != nil {
+				return err
+			}
+
 			// 通知下一节点实例事件
 			if fn := n.opts.onNextNode; fn != nil {
-				candidates, err := n.engine.flowBll.QueryNodeCandidates(n.nodeInstance.RecordID)
-				if err != nil {
-					return err
-				}
 				fn(n.node, n.nodeInstance, candidates)
 			}
 			return nil
@@ -155,18 +220,46 @@ func (n *NodeRouter) Next(processor string) error {
 	}
 
 	// 完成当前节点
-	err = n.engine.flowBll.DoneNodeInstance(n.nodeInstance.RecordID, processor, n.inputData)
+	err = n.flowBll.DoneNodeInstance(n.nodeInstance.RecordID, processor, n.inputData)
+	if err != nil {
+		return err
+	}
+	n.nodeInstance.Status = 2
+	n.nodeInstance.Processor = processor
+
+	err = n.emit(&Event{
+		Type:         EventNodeCompleted,
+		FlowInstance: n.flowInstance,
+		Node:         n.node,
+		NodeInstance: n.nodeInstance,
+		UserID:       processor,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 完成节点后异步继续：创建作业，由作业执行器在新的事务中继续流转
+	if n.node.AsyncAfter {
+		return n.createAsyncJob(JobStageAfter, processor)
+	}
+
+	return n.leave(processor)
+}
+
+// 离开已完成的节点，流向下一节点
+func (n *NodeRouter) leave(processor string) error {
+	nodeType, err := GetNodeTypeByName(n.node.TypeCode)
 	if err != nil {
 		return err
 	}
 
-	// 如果当前节点是人工任务，检查下一节点是否是并行网关，如果是则检查还未完成的待办事项，如果有则停止流转
-	if nodeType == UserTask && n.parent == nil {
+	// 如果当前节点是人工任务或服务任务，检查下一节点是否是并行网关，如果是则检查还未完成的待办事项，如果有则停止流转
+	if (nodeType == UserTask || nodeType == ServiceTask) && !n.entered {
 		ok, err := n.checkNextNodeType(ParallelGateway)
 		if err != nil {
 			return err
 		} else if ok {
-			exists, err := n.engine.flowBll.CheckFlowInstanceTodo(n.flowInstance.RecordID)
+			exists, err := n.flowBll.CheckFlowInstanceTodo(n.flowInstance.RecordID)
 			if err != nil {
 				return err
 			} else if exists {
@@ -182,7 +275,7 @@ func (n *NodeRouter) Next(processor string) error {
 
 		// 如果是结束事件，则检查还未完成的待办事项，如果没有则结束流程并通知结束事件
 		if nodeType == EndEvent {
-			exists, err := n.engine.flowBll.CheckFlowInstanceTodo(n.flowInstance.RecordID)
+			exists, err := n.flowBll.CheckFlowInstanceTodo(n.flowInstance.RecordID)
 			if err != nil {
 				return err
 			} else if !exists {
@@ -197,7 +290,19 @@ func (n *NodeRouter) Next(processor string) error {
 
 		if isEnd {
 			// 流程实例结束处理
-			err = n.engine.flowBll.DoneFlowInstance(n.flowInstance.RecordID)
+			err = n.flowBll.DoneFlowInstance(n.flowInstance.RecordID)
+			if err != nil {
+				return err
+			}
+			n.flowInstance.Status = 9
+
+			err = n.emit(&Event{
+				Type:         EventFlowEnded,
+				FlowInstance: n.flowInstance,
+				Node:         n.node,
+				NodeInstance: n.nodeInstance,
+				UserID:       processor,
+			})
 			if err != nil {
 				return err
 			}
@@ -230,28 +335,33 @@ func (n *NodeRouter) Next(processor string) error {
 	return nil
 }
 
+// 创建异步继续的作业
+func (n *NodeRouter) createAsyncJob(stage, processor string) error {
+	_, err := n.flowBll.CreateJob(JobTypeAsync, n.flowInstance.RecordID, n.nodeInstance.RecordID, "", stage, processor, n.engine.jobRetries())
+	return err
+}
+
 // 增加下一处理节点实例
 func (n *NodeRouter) addNextNodeInstances() ([]string, error) {
-	routers, err := n.engine.flowBll.QueryNodeRouters(n.node.RecordID)
+	routers, err := n.flowBll.QueryNodeRouters(n.node.RecordID)
 	if err != nil {
 		return nil, err
 	} else if len(routers) == 0 {
 		return nil, nil
 	}
 
-	var nodeInstanceIDs []string
-	for _, r := range routers {
-		if r.Expression != "" {
-			allow, err := n.engine.execer.ExecReturnBool(n.ctx, []byte(r.Expression), n.getExpData())
-			if err != nil {
-				return nil, err
-			} else if !allow {
-				continue
-			}
-		}
+	routers, err = n.selectRouters(routers)
+	if err != nil {
+		return nil, err
+	}
 
+	var (
+		nodeInstanceIDs []string
+		targetNodeIDs   []string
+	)
+	for _, r := range routers {
 		// 查询指派人表达式
-		assigns, err := n.engine.flowBll.QueryNodeAssignments(r.TargetNodeID)
+		assigns, err := n.flowBll.QueryNodeAssignments(r.TargetNodeID)
 		if err != nil {
 			return nil, err
 		}
@@ -265,18 +375,114 @@ func (n *NodeRouter) addNextNodeInstances() ([]string, error) {
 			candidates = append(candidates, ss...)
 		}
 
-		instanceID, err := n.engine.flowBll.CreateNodeInstance(n.flowInstance.RecordID, r.TargetNodeID, n.inputData, candidates)
+		// 人工任务的处理人、候选组、到期时间及优先级
+		targetNode, err := n.flowBll.GetNode(r.TargetNodeID)
+		if err != nil {
+			return nil, err
+		}
+		task := new(taskAttributes)
+		if targetNode != nil {
+			task, err = n.taskAttributes(targetNode)
+			if err != nil {
+				return nil, err
+			}
+		}
+		candidates = uniqueStrings(append(candidates, task.candidates...))
+
+		instanceID, err := n.flowBll.CreateTaskInstance(n.flowInstance.RecordID, r.TargetNodeID, n.nodeInstance.RecordID, n.inputData, candidates, task.dueTime, task.priority)
 		if err != nil {
 			return nil, err
 		}
 		nodeInstanceIDs = append(nodeInstanceIDs, instanceID)
+		targetNodeIDs = append(targetNodeIDs, r.TargetNodeID)
+	}
+
+	if n.isGateway() {
+		err = n.emit(&Event{
+			Type:          EventGatewayEvaluated,
+			FlowInstance:  n.flowInstance,
+			Node:          n.node,
+			NodeInstance:  n.nodeInstance,
+			TargetNodeIDs: targetNodeIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 	return nodeInstanceIDs, nil
 }
 
+// 选择满足条件的路由(没有条件的路由总是满足)
+// 排他网关没有条件的路由为默认的路由，仅在其他路由的条件都不满足时选择
+func (n *NodeRouter) selectRouters(routers []*schema.NodeRouter) ([]*schema.NodeRouter, error) {
+	var (
+		items   []*schema.NodeRouter
+		matched bool
+	)
+	for _, r := range routers {
+		if r.Expression != "" {
+			allow, err := n.engine.execer.ExecReturnBool(n.ctx, []byte(r.Expression), n.getExpData())
+			if err != nil {
+				return nil, err
+			} else if !allow {
+				continue
+			}
+			matched = true
+		}
+		items = append(items, r)
+	}
+
+	if !matched || n.node.TypeCode != ExclusiveGateway.String() {
+		return items, nil
+	}
+
+	var result []*schema.NodeRouter
+	for _, r := range items {
+		if r.Expression != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// 发出人工任务的创建及指派事件
+func (n *NodeRouter) emitTask(processor string, candidates []*schema.NodeCandidate) error {
+	var cids []string
+	for _, c := range candidates {
+		cids = append(cids, c.CandidateID)
+	}
+
+	err := n.emit(&Event{
+		Type:         EventTaskCreated,
+		FlowInstance: n.flowInstance,
+		Node:         n.node,
+		NodeInstance: n.nodeInstance,
+		CandidateIDs: cids,
+		UserID:       processor,
+	})
+	if err != nil || len(cids) == 0 {
+		return err
+	}
+
+	return n.emit(&Event{
+		Type:         EventTaskAssigned,
+		FlowInstance: n.flowInstance,
+		Node:         n.node,
+		NodeInstance: n.nodeInstance,
+		CandidateIDs: cids,
+		UserID:       processor,
+	})
+}
+
+// 检查当前节点是否是网关
+func (n *NodeRouter) isGateway() bool {
+	return n.node.TypeCode == ExclusiveGateway.String() ||
+		n.node.TypeCode == ParallelGateway.String()
+}
+
 // 检查下一节点类型
 func (n *NodeRouter) checkNextNodeType(t NodeType) (bool, error) {
-	routers, err := n.engine.flowBll.QueryNodeRouters(n.node.RecordID)
+	routers, err := n.flowBll.QueryNodeRouters(n.node.RecordID)
 	if err != nil {
 		return false, err
 	} else if len(routers) == 0 {
@@ -293,7 +499,7 @@ func (n *NodeRouter) checkNextNodeType(t NodeType) (bool, error) {
 			}
 		}
 
-		node, err := n.engine.flowBll.GetNode(r.TargetNodeID)
+		node, err := n.flowBll.GetNode(r.TargetNodeID)
 		if err != nil {
 			return false, err
 		} else if node == nil {
@@ -18,6 +18,8 @@ const (
 	TerminateEvent NodeType = "terminateEvent"
 	// UserTask 人工任务
 	UserTask NodeType = "userTask"
+	// ServiceTask 服务任务(外部任务，由执行者拉取执行)
+	ServiceTask NodeType = "serviceTask"
 	// ExclusiveGateway 排他网关
 	ExclusiveGateway NodeType = "exclusiveGateway"
 	// ParallelGateway 并行网关
@@ -37,6 +39,8 @@ func GetNodeTypeByName(s string) (NodeType, error) {
 		return TerminateEvent, nil
 	case "userTask":
 		return UserTask, nil
+	case "serviceTask":
+		return ServiceTask, nil
 	case "exclusiveGateway":
 		return ExclusiveGateway, nil
 	case "parallelGateway":
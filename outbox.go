@@ -0,0 +1,240 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/antlinker/flow/bll"
+	"github.com/antlinker/flow/schema"
+	"github.com/pkg/errors"
+)
+
+// 定义错误
+var (
+	ErrOutboxNotFailed = errors.New("发件箱消息不是投递失败的状态")
+)
+
+// OutboxMessage 发件箱消息
+type OutboxMessage struct {
+	ID             string          `json:"id"`               // 消息ID(重复投递时不变，可用于消费端去重)
+	Type           EventType       `json:"type"`             // 事件类型
+	FlowCode       string          `json:"flow_code"`        // 流程编号
+	FlowInstanceID string          `json:"flow_instance_id"` // 流程实例内码
+	Payload        json.RawMessage `json:"payload"`          // 事件数据(Event的JSON)
+	Attempts       int             `json:"attempts"`         // 当前投递次数
+	Created        int64           `json:"created"`          // 写入时间戳
+}
+
+// Publisher 消息发布器
+type Publisher interface {
+	// 发布消息，返回错误时消息将按重试策略再次投递
+	Publish(ctx context.Context, msg *OutboxMessage) error
+}
+
+// EnableOutbox 启用事件发件箱
+// 流程事件与流程状态变更在同一事务中写入发件箱，由OutboxRelay投递到消息系统
+// types 需要写入发件箱的事件类型，为空时写入所有事件
+func (e *Engine) EnableOutbox(types ...EventType) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.outbox = true
+	e.outboxTypes = types
+}
+
+// 检查事件是否需要写入发件箱
+func (e *Engine) acceptOutbox(t EventType) bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if !e.outbox {
+		return false
+	} else if len(e.outboxTypes) == 0 {
+		return true
+	}
+
+	for _, item := range e.outboxTypes {
+		if item == t {
+			return true
+		}
+	}
+	return false
+}
+
+// 写入发件箱
+func writeOutbox(flowBll *bll.Flow, event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var flowInstanceID string
+	if event.FlowInstance != nil {
+		flowInstanceID = event.FlowInstance.RecordID
+	} else if event.NodeInstance != nil {
+		flowInstanceID = event.NodeInstance.FlowInstanceID
+	}
+
+	return flowBll.CreateOutbox(event.Type.String(), event.FlowCode, flowInstanceID, payload)
+}
+
+type relayOptions struct {
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	timeout     time.Duration
+	backoff     func(attempts int) time.Duration
+}
+
+// RelayOption 发件箱中继配置
+type RelayOption func(*relayOptions)
+
+// RelayIntervalOption 轮询发件箱的时间间隔(默认1秒)
+func RelayIntervalOption(interval time.Duration) RelayOption {
+	return func(o *relayOptions) {
+		o.interval = interval
+	}
+}
+
+// RelayBatchSizeOption 每批次投递的消息数量(默认100)
+func RelayBatchSizeOption(batchSize int) RelayOption {
+	return func(o *relayOptions) {
+		o.batchSize = batchSize
+	}
+}
+
+// RelayMaxAttemptsOption 最大投递次数，超过后标记为投递失败(默认10)，可通过RetryOutbox重新投递
+func RelayMaxAttemptsOption(maxAttempts int) RelayOption {
+	return func(o *relayOptions) {
+		o.maxAttempts = maxAttempts
+	}
+}
+
+// RelayTimeoutOption 单条消息的发布超时时间(默认10秒)
+func RelayTimeoutOption(timeout time.Duration) RelayOption {
+	return func(o *relayOptions) {
+		o.timeout = timeout
+	}
+}
+
+// RelayBackoffOption 投递失败后的重试间隔(默认按投递次数指数增长，最长10分钟)
+func RelayBackoffOption(backoff func(attempts int) time.Duration) RelayOption {
+	return func(o *relayOptions) {
+		o.backoff = backoff
+	}
+}
+
+// OutboxRelay 发件箱中继(至少投递一次，消费端需根据消息ID去重)
+type OutboxRelay struct {
+	engine    *Engine
+	publisher Publisher
+	opts      relayOptions
+	poller    *poller
+}
+
+// NewOutboxRelay 创建发件箱中继
+func (e *Engine) NewOutboxRelay(publisher Publisher, opts ...RelayOption) *OutboxRelay {
+	o := relayOptions{
+		interval:    time.Second,
+		batchSize:   100,
+		maxAttempts: 10,
+		timeout:     time.Second * 10,
+		backoff:     defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r := &OutboxRelay{
+		engine:    e,
+		publisher: publisher,
+		opts:      o,
+	}
+	r.poller = &poller{
+		name:      "投递发件箱",
+		interval:  o.interval,
+		batchSize: o.batchSize,
+		drain:     r.Drain,
+	}
+	return r
+}
+
+// Start 启动中继，按时间间隔轮询投递发件箱
+func (r *OutboxRelay) Start() {
+	r.poller.start()
+}
+
+// Stop 停止中继(等待当前批次投递完成)
+func (r *OutboxRelay) Stop() {
+	r.poller.stop()
+}
+
+// Drain 投递一个批次的到期消息，返回本批次处理的消息数量
+func (r *OutboxRelay) Drain(ctx context.Context) (int, error) {
+	flowBll := r.engine.flowBll
+	items, err := flowBll.QueryPendingOutbox(r.opts.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range items {
+		msg := &OutboxMessage{
+			ID:             item.RecordID,
+			Type:           EventType(item.EventType),
+			FlowCode:       item.FlowCode,
+			FlowInstanceID: item.FlowInstanceID,
+			Payload:        json.RawMessage(item.Payload),
+			Attempts:       item.Attempts + 1,
+			Created:        item.Created,
+		}
+
+		perr := r.publish(ctx, msg)
+		if perr == nil {
+			err = flowBll.DoneOutbox(item.RecordID, msg.Attempts)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		var nextTime int64
+		if msg.Attempts < r.opts.maxAttempts {
+			nextTime = time.Now().Add(r.opts.backoff(msg.Attempts)).Unix()
+		}
+
+		err = flowBll.FailOutbox(item.RecordID, msg.Attempts, nextTime, truncateError(perr, 1024))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(items), nil
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, msg *OutboxMessage) error {
+	if r.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.opts.timeout)
+		defer cancel()
+	}
+	return r.publisher.Publish(ctx, msg)
+}
+
+// QueryOutboxPage 查询发件箱分页数据(例如查询投递失败的消息：Status为3)
+func (e *Engine) QueryOutboxPage(ctx context.Context, params schema.OutboxQueryParam, pageIndex, pageSize uint) (int64, []*schema.Outbox, error) {
+	return e.tenantBll(ctx).QueryOutboxPage(params, pageIndex, pageSize)
+}
+
+// RetryOutbox 重新投递失败的发件箱消息(由中继在下一批次投递)
+func (e *Engine) RetryOutbox(ctx context.Context, outboxID string) error {
+	flowBll := e.tenantBll(ctx)
+	item, err := flowBll.GetOutbox(outboxID)
+	if err != nil {
+		return err
+	} else if item == nil {
+		return ErrNotFound
+	} else if item.Status != 3 {
+		return ErrOutboxNotFailed
+	}
+	return flowBll.RetryOutbox(outboxID)
+}
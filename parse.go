@@ -17,6 +17,7 @@ type ParseResult struct {
 	FlowVersion int64         // 流程版本号
 	FlowStatus  int           // 流程状态(1:可用 2:不可用)
 	Nodes       []*NodeResult // 节点数据
+	Problems    []*Problem    // 解析时发现的问题(如重复的元素ID、源节点不存在的顺序流)
 }
 
 // NodeResult 节点数据
@@ -28,10 +29,20 @@ type NodeResult struct {
 	Properties           []*PropertyResult // 节点属性
 	CandidateExpressions []string          // 候选人表达式
 	FormResult           *NodeFormResult   // 节点表单
+	Topic                string            // 外部任务主题(服务任务)
+	AsyncBefore          bool              // 进入节点前异步继续
+	AsyncAfter           bool              // 完成节点后异步继续
+	Assignee             string            // 处理人(用户ID，${表达式}为表达式)
+	CandidateGroups      string            // 候选组(逗号分隔的组ID，${表达式}为返回组ID列表的表达式)
+	DueDate              string            // 到期时间(ISO 8601的时间或时长，${表达式}为表达式)
+	Priority             string            // 优先级(整数，${表达式}为表达式)
+	FormKey              string            // 表单标识
+	DefaultFlow          string            // 默认的顺序流ID(排他网关)
 }
 
 // RouterResult 节点路由数据
 type RouterResult struct {
+	ID           string // 顺序流ID
 	TargetNodeID string // 目标节点ID
 	Explain      string // 说明
 	Expression   string // 条件表达式
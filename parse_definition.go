@@ -0,0 +1,276 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// 流程定义格式
+const (
+	FormatXML  = "xml"  // BPMN XML
+	FormatJSON = "json" // JSON(FlowDefinition)
+	FormatYAML = "yaml" // YAML(FlowDefinition)
+)
+
+// FormatByExtension 根据文件扩展名获取流程定义格式(不能识别时返回空)
+func FormatByExtension(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".bpmn", ".xml":
+		return FormatXML
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return ""
+}
+
+// FormatByContentType 根据内容类型获取流程定义格式(不能识别时返回空)
+func FormatByContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	switch {
+	case strings.HasSuffix(mediaType, "/xml") || strings.HasSuffix(mediaType, "+xml"):
+		return FormatXML
+	case strings.HasSuffix(mediaType, "/json") || strings.HasSuffix(mediaType, "+json"):
+		return FormatJSON
+	case strings.HasSuffix(mediaType, "/yaml") || strings.HasSuffix(mediaType, "/x-yaml"):
+		return FormatYAML
+	}
+	return ""
+}
+
+// DetectFormat 根据数据内容识别流程定义格式(以<开头为XML，以{开头为JSON，否则为YAML)
+func DetectFormat(data []byte) string {
+	s := strings.TrimSpace(strings.TrimPrefix(string(data), "\ufeff"))
+	switch {
+	case strings.HasPrefix(s, "<"):
+		return FormatXML
+	case strings.HasPrefix(s, "{"):
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+// FlowDefinition 流程定义(JSON/YAML格式)
+type FlowDefinition struct {
+	ID      string            `json:"id" yaml:"id"`                             // 流程ID(流程编号)
+	Name    string            `json:"name,omitempty" yaml:"name,omitempty"`     // 流程名称
+	Version int64             `json:"version" yaml:"version"`                   // 流程版本号
+	Status  int               `json:"status,omitempty" yaml:"status,omitempty"` // 流程状态(1:可用 2:不可用，默认可用)
+	Nodes   []*NodeDefinition `json:"nodes" yaml:"nodes"`                       // 节点
+}
+
+// NodeDefinition 节点定义
+type NodeDefinition struct {
+	ID              string                `json:"id" yaml:"id"`                                                 // 节点ID(节点编号)
+	Name            string                `json:"name,omitempty" yaml:"name,omitempty"`                         // 节点名称
+	Type            NodeType              `json:"type" yaml:"type"`                                             // 节点类型(startEvent、userTask等)
+	Candidates      []string              `json:"candidates,omitempty" yaml:"candidates,omitempty"`             // 候选人表达式
+	Topic           string                `json:"topic,omitempty" yaml:"topic,omitempty"`                       // 外部任务主题(服务任务)
+	AsyncBefore     bool                  `json:"async_before,omitempty" yaml:"async_before,omitempty"`         // 进入节点前异步继续
+	AsyncAfter      bool                  `json:"async_after,omitempty" yaml:"async_after,omitempty"`           // 完成节点后异步继续
+	Assignee        string                `json:"assignee,omitempty" yaml:"assignee,omitempty"`                 // 处理人(用户ID，${表达式}为表达式)
+	CandidateGroups string                `json:"candidate_groups,omitempty" yaml:"candidate_groups,omitempty"` // 候选组(逗号分隔的组ID，${表达式}为返回组ID列表的表达式)
+	DueDate         string                `json:"due_date,omitempty" yaml:"due_date,omitempty"`                 // 到期时间(ISO 8601的时间或时长，${表达式}为表达式)
+	Priority        string                `json:"priority,omitempty" yaml:"priority,omitempty"`                 // 优先级(整数，${表达式}为表达式)
+	FormKey         string                `json:"form_key,omitempty" yaml:"form_key,omitempty"`                 // 表单标识
+	Form            *FormDefinition       `json:"form,omitempty" yaml:"form,omitempty"`                         // 节点表单
+	Properties      []*PropertyDefinition `json:"properties,omitempty" yaml:"properties,omitempty"`             // 节点属性
+	Routers         []*RouterDefinition   `json:"routers,omitempty" yaml:"routers,omitempty"`                   // 节点路由(流出的顺序流)
+}
+
+// RouterDefinition 路由定义
+type RouterDefinition struct {
+	ID         string `json:"id,omitempty" yaml:"id,omitempty"`                 // 顺序流ID(为空时为"源节点ID_目标节点ID")
+	Target     string `json:"target" yaml:"target"`                             // 目标节点ID
+	Explain    string `json:"explain,omitempty" yaml:"explain,omitempty"`       // 说明
+	Expression string `json:"expression,omitempty" yaml:"expression,omitempty"` // 条件表达式
+}
+
+// PropertyDefinition 节点属性定义
+type PropertyDefinition struct {
+	Name  string `json:"name" yaml:"name"`   // 属性名称
+	Value string `json:"value" yaml:"value"` // 属性值
+}
+
+// FormDefinition 表单定义
+type FormDefinition struct {
+	ID     string                 `json:"id" yaml:"id"`                             // 表单ID
+	Fields []*FormFieldDefinition `json:"fields,omitempty" yaml:"fields,omitempty"` // 表单字段
+}
+
+// FormFieldDefinition 表单字段定义
+type FormFieldDefinition struct {
+	ID           string                       `json:"id" yaml:"id"`                                           // 字段ID
+	Type         string                       `json:"type,omitempty" yaml:"type,omitempty"`                   // 字段类型
+	Label        string                       `json:"label,omitempty" yaml:"label,omitempty"`                 // 字段标签
+	DefaultValue string                       `json:"default_value,omitempty" yaml:"default_value,omitempty"` // 默认值
+	Values       []*FieldOptionDefinition     `json:"values,omitempty" yaml:"values,omitempty"`               // 枚举选项
+	Validations  []*FieldValidationDefinition `json:"validations,omitempty" yaml:"validations,omitempty"`     // 字段验证
+	Properties   []*FieldPropertyDefinition   `json:"properties,omitempty" yaml:"properties,omitempty"`       // 字段属性
+}
+
+// FieldOptionDefinition 枚举选项定义
+type FieldOptionDefinition struct {
+	ID   string `json:"id" yaml:"id"`     // 选项值ID
+	Name string `json:"name" yaml:"name"` // 选项值名称
+}
+
+// FieldValidationDefinition 字段验证定义
+type FieldValidationDefinition struct {
+	Name   string `json:"name" yaml:"name"`                         // 约束名
+	Config string `json:"config,omitempty" yaml:"config,omitempty"` // 约束配置
+}
+
+// FieldPropertyDefinition 字段属性定义
+type FieldPropertyDefinition struct {
+	ID    string `json:"id" yaml:"id"`       // 属性ID
+	Value string `json:"value" yaml:"value"` // 属性值
+}
+
+// NewJSONParser JSON解析器(FlowDefinition格式)
+func NewJSONParser() Parser {
+	return &definitionParser{format: FormatJSON}
+}
+
+// NewYAMLParser YAML解析器(FlowDefinition格式)
+func NewYAMLParser() Parser {
+	return &definitionParser{format: FormatYAML}
+}
+
+type definitionParser struct {
+	format string
+}
+
+func (p *definitionParser) Parse(ctx context.Context, content []byte) (*ParseResult, error) {
+	var def FlowDefinition
+	var err error
+	if p.format == FormatYAML {
+		err = yaml.Unmarshal(content, &def)
+	} else {
+		err = json.Unmarshal(content, &def)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "解析流程定义发生错误")
+	}
+	return def.ParseResult()
+}
+
+// ParseResult 转换为流程数据
+func (d *FlowDefinition) ParseResult() (*ParseResult, error) {
+	if d.ID == "" {
+		return nil, errors.New("流程定义缺少id")
+	}
+
+	result := &ParseResult{
+		FlowID:      d.ID,
+		FlowName:    d.Name,
+		FlowVersion: d.Version,
+		FlowStatus:  d.Status,
+	}
+	if result.FlowStatus == 0 {
+		result.FlowStatus = 1
+	}
+
+	nodeIDs := make(map[string]bool)
+	for _, n := range d.Nodes {
+		if n.ID == "" {
+			result.Problems = append(result.Problems, newProblem(d.ID, ProblemError, "节点缺少id"))
+			continue
+		} else if nodeIDs[n.ID] {
+			result.Problems = append(result.Problems, newProblem(n.ID, ProblemError, "元素ID[%s]重复", n.ID))
+			continue
+		}
+		nodeIDs[n.ID] = true
+
+		nodeType, err := GetNodeTypeByName(n.Type.String())
+		if err != nil {
+			return nil, errors.Wrapf(err, "节点[%s]", n.ID)
+		}
+
+		node := &NodeResult{
+			NodeID:               n.ID,
+			NodeName:             n.Name,
+			NodeType:             nodeType,
+			CandidateExpressions: n.Candidates,
+			Topic:                n.Topic,
+			AsyncBefore:          n.AsyncBefore,
+			AsyncAfter:           n.AsyncAfter,
+			Assignee:             n.Assignee,
+			CandidateGroups:      n.CandidateGroups,
+			DueDate:              n.DueDate,
+			Priority:             n.Priority,
+			FormKey:              n.FormKey,
+		}
+
+		for _, r := range n.Routers {
+			id := r.ID
+			if id == "" {
+				id = fmt.Sprintf("%s_%s", n.ID, r.Target)
+			}
+			node.Routers = append(node.Routers, &RouterResult{
+				ID:           id,
+				TargetNodeID: r.Target,
+				Explain:      r.Explain,
+				Expression:   r.Expression,
+			})
+		}
+
+		for _, p := range n.Properties {
+			if p.Name != "" {
+				node.Properties = append(node.Properties, &PropertyResult{Name: p.Name, Value: p.Value})
+			}
+		}
+
+		if f := n.Form; f != nil {
+			form := &NodeFormResult{ID: f.ID}
+			for _, field := range f.Fields {
+				item := &FormFieldResult{
+					ID:           field.ID,
+					Type:         field.Type,
+					Label:        field.Label,
+					DefaultValue: field.DefaultValue,
+				}
+				for _, v := range field.Values {
+					item.Values = append(item.Values, &FieldOption{ID: v.ID, Name: v.Name})
+				}
+				for _, v := range field.Validations {
+					item.Validations = append(item.Validations, &FieldValidation{Name: v.Name, Config: v.Config})
+				}
+				for _, v := range field.Properties {
+					item.Properties = append(item.Properties, &FieldProperty{ID: v.ID, Value: v.Value})
+				}
+				form.Fields = append(form.Fields, item)
+			}
+			node.FormResult = form
+		}
+
+		result.Nodes = append(result.Nodes, node)
+	}
+
+	return result, nil
+}
+
+// Marshal 按格式(FormatXML/FormatJSON/FormatYAML)序列化流程定义(XML的图形信息为自动布局)
+func (d *FlowDefinition) Marshal(format string) ([]byte, error) {
+	switch format {
+	case FormatXML:
+		return marshalBPMN(d, nil)
+	case FormatJSON:
+		return json.MarshalIndent(d, "", "  ")
+	case FormatYAML:
+		return yaml.Marshal(d)
+	}
+	return nil, errors.Errorf("不支持的流程定义格式[%s]", format)
+}
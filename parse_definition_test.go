@@ -0,0 +1,57 @@
+package flow
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFlowDefinitionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	e := NewMemoryEngine()
+	e.SetStrictValidation(true)
+
+	data, err := e.parseFile("test_data/leave.yaml")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	source, err := NewYAMLParser().Parse(ctx, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	flowID, err := e.CreateFlowWithFormat(ctx, FormatByExtension("leave.yaml"), data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, format := range []string{FormatXML, FormatJSON, FormatYAML} {
+		exported, err := e.ExportFlow(ctx, flowID, format)
+		if err != nil {
+			t.Fatal(err.Error())
+		} else if DetectFormat(exported) != format {
+			t.Fatalf("导出的格式错误：%s", exported)
+		}
+
+		parser, _ := e.formatParser(format)
+		result, err := parser.Parse(ctx, exported)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// 顺序流ID不保存
+		for _, n := range source.Nodes {
+			for _, r := range n.Routers {
+				r.ID = ""
+			}
+		}
+		for _, n := range result.Nodes {
+			for _, r := range n.Routers {
+				r.ID = ""
+			}
+		}
+		if !reflect.DeepEqual(source, result) {
+			t.Fatalf("导出的%s流程定义不一致", format)
+		}
+	}
+}
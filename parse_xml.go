@@ -8,6 +8,7 @@ import (
 	"github.com/antlinker/flow/util"
 
 	"github.com/beevik/etree"
+	"github.com/pkg/errors"
 )
 
 // NewXMLParser xml解析器
@@ -26,12 +27,17 @@ func (p *xmlParser) Parse(ctx context.Context, content []byte) (*ParseResult, er
 
 	doc := etree.NewDocument()
 	if err = doc.ReadFromBytes(content); err != nil {
-		panic(err)
+		return nil, errors.Wrapf(err, "解析流程XML发生错误")
 	}
 
 	root := doc.SelectElement("definitions")
-	//root.Tag
+	if root == nil {
+		return nil, errors.New("流程XML缺少definitions元素")
+	}
 	process := root.SelectElement("process")
+	if process == nil {
+		return nil, errors.New("流程XML缺少process元素")
+	}
 
 	if id := process.SelectAttr("id"); id != nil {
 		result.FlowID = id.Value
@@ -54,6 +60,16 @@ func (p *xmlParser) Parse(ctx context.Context, content []byte) (*ParseResult, er
 
 	// 定义一个用于辅助的map，由节点id映射到noderesult
 	nodeMap := make(map[string]*NodeResult)
+	// 元素ID，用于检查重复的ID
+	elementIDs := make(map[string]bool)
+	checkID := func(tag, id string) {
+		if id == "" {
+			result.Problems = append(result.Problems, newProblem(result.FlowID, ProblemError, "元素<%s>缺少id", tag))
+		} else if elementIDs[id] {
+			result.Problems = append(result.Problems, newProblem(id, ProblemError, "元素ID[%s]重复", id))
+		}
+		elementIDs[id] = true
+	}
 	// 遍历找到所有的节点，因为是解析一个树，所以先解析节点，再解析sequenceFlow部分
 	// 解析sequenceFlow部分时，nodeMap里面应该已经有对应的nodeId了
 	for _, element := range process.ChildElements() {
@@ -62,38 +78,62 @@ func (p *xmlParser) Parse(ctx context.Context, content []byte) (*ParseResult, er
 			element.Tag == "sequenceFlow" {
 			continue
 		}
-		node, _ := p.ParseNode(element)
+		node, err := p.ParseNode(element)
+		if err != nil {
+			return nil, errors.Wrapf(err, "解析节点[%s]发生错误", element.SelectAttrValue("id", ""))
+		}
+		checkID(element.Tag, node.Code)
+		if _, exists := nodeMap[node.Code]; exists {
+			continue
+		}
+
 		var nodeResult NodeResult
 		nodeResult.NodeID = node.Code
 		nodeResult.NodeName = node.Name
 		nodeResult.NodeType, err = GetNodeTypeByName(node.Type)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrapf(err, "节点[%s]", node.Code)
 		}
 		nodeResult.CandidateExpressions = node.CandidateUsers
 		// yupengfei 2018-01-17 增加了form的解析
 		nodeResult.FormResult = node.FormResult
 		nodeResult.Properties = node.Properties
+		nodeResult.Topic = node.Topic
+		nodeResult.AsyncBefore = node.AsyncBefore
+		nodeResult.AsyncAfter = node.AsyncAfter
+		nodeResult.Assignee = node.Assignee
+		nodeResult.CandidateGroups = node.CandidateGroups
+		nodeResult.DueDate = node.DueDate
+		nodeResult.Priority = node.Priority
+		nodeResult.FormKey = node.FormKey
+		nodeResult.DefaultFlow = node.DefaultFlow
 		nodeMap[nodeResult.NodeID] = &nodeResult
-		// 如果节点是一个路由的话，需要特殊处理
+		// 按文档中的顺序保存节点
+		result.Nodes = append(result.Nodes, &nodeResult)
 	}
 
 	for _, element := range process.ChildElements() {
 		if element.Tag == "sequenceFlow" {
-			sequenceFlow, _ := p.ParsesequenceFlow(element)
+			sequenceFlow, err := p.ParsesequenceFlow(element)
+			if err != nil {
+				return nil, err
+			}
+			checkID(element.Tag, sequenceFlow.Code)
+
 			var routerResult RouterResult
+			routerResult.ID = sequenceFlow.Code
 			routerResult.Expression = sequenceFlow.Expression
 			routerResult.Explain = sequenceFlow.Explain
 			routerResult.TargetNodeID = sequenceFlow.TargetRef
 			if nodeResult, exist := nodeMap[sequenceFlow.SourceRef]; exist {
 				nodeResult.Routers = append(nodeResult.Routers, &routerResult)
+			} else {
+				// 源节点不存在的顺序流不会保存
+				result.Problems = append(result.Problems, newProblem(sequenceFlow.Code, ProblemError, "顺序流的源节点[%s]不存在", sequenceFlow.SourceRef))
 			}
 		}
 	}
 
-	for _, nodeResult := range nodeMap {
-		result.Nodes = append(result.Nodes, nodeResult)
-	}
 	return result, nil
 }
 
@@ -114,10 +154,40 @@ func (p *xmlParser) ParseNode(element *etree.Element) (*nodeInfo, error) {
 	if id := element.SelectAttr("id"); id != nil {
 		node.Code = id.Value
 	}
+	// 外部服务任务：camunda:type="external" camunda:topic="主题"
+	if t := element.SelectAttr("type"); t != nil && t.Value == "external" {
+		if topic := element.SelectAttr("topic"); topic != nil {
+			node.Topic = topic.Value
+		}
+	}
+	// 异步继续：camunda:asyncBefore="true"(兼容camunda:async) camunda:asyncAfter="true"
+	if v := element.SelectAttr("asyncBefore"); v != nil {
+		node.AsyncBefore, _ = strconv.ParseBool(v.Value)
+	} else if v := element.SelectAttr("async"); v != nil {
+		node.AsyncBefore, _ = strconv.ParseBool(v.Value)
+	}
+	if v := element.SelectAttr("asyncAfter"); v != nil {
+		node.AsyncAfter, _ = strconv.ParseBool(v.Value)
+	}
 	if candidateUsers := element.SelectAttr("candidateUsers"); candidateUsers != nil {
 		candidateUserList := strings.Split(candidateUsers.Value, ";")
 		node.CandidateUsers = candidateUserList
 	}
+	// bpmn:potentialOwner：每个元素为一个候选人表达式(表达式中可以包含分隔符)
+	for _, owner := range element.SelectElements("potentialOwner") {
+		if expr := owner.FindElement("./resourceAssignmentExpression/formalExpression"); expr != nil {
+			if v := strings.TrimSpace(expr.Text()); v != "" {
+				node.CandidateUsers = append(node.CandidateUsers, v)
+			}
+		}
+	}
+	// 人工任务：camunda:assignee、camunda:candidateGroups、camunda:dueDate、camunda:priority、camunda:formKey
+	node.Assignee = strings.TrimSpace(element.SelectAttrValue("assignee", ""))
+	node.CandidateGroups = strings.TrimSpace(element.SelectAttrValue("candidateGroups", ""))
+	node.DueDate = strings.TrimSpace(element.SelectAttrValue("dueDate", ""))
+	node.Priority = strings.TrimSpace(element.SelectAttrValue("priority", ""))
+	node.FormKey = strings.TrimSpace(element.SelectAttrValue("formKey", ""))
+	node.DefaultFlow = strings.TrimSpace(element.SelectAttrValue("default", ""))
 
 	if extensionElements := element.SelectElement("extensionElements"); extensionElements != nil {
 		if formData := extensionElements.SelectElement("formData"); formData != nil {
@@ -125,10 +195,8 @@ func (p *xmlParser) ParseNode(element *etree.Element) (*nodeInfo, error) {
 			if err != nil {
 				return nil, err
 			}
-			if form != nil {
-				if formKey := element.SelectAttr("formKey"); formKey != nil {
-					form.ID = formKey.Value
-				}
+			if form != nil && node.FormKey != "" {
+				form.ID = node.FormKey
 			}
 			node.FormResult = form
 		}
@@ -158,9 +226,9 @@ func (p *xmlParser) ParsesequenceFlow(element *etree.Element) (*sequenceFlow, er
 	hasExpression := false
 	var seq sequenceFlow
 	seq.XMLName = element.Tag
-	seq.Code = element.SelectAttr("id").Value
-	seq.SourceRef = element.SelectAttr("sourceRef").Value
-	seq.TargetRef = element.SelectAttr("targetRef").Value
+	seq.Code = element.SelectAttrValue("id", "")
+	seq.SourceRef = element.SelectAttrValue("sourceRef", "")
+	seq.TargetRef = element.SelectAttrValue("targetRef", "")
 	for _, element := range element.ChildElements() {
 		if element.Tag == "documentation" {
 			seq.Explain = element.Text()
@@ -274,13 +342,22 @@ func (p *xmlParser) ParseEnumValues(element *etree.Element) ([]*FieldOption, err
 }
 
 type nodeInfo struct {
-	ProcessCode    string
-	Type           string
-	Code           string
-	Name           string
-	CandidateUsers []string
-	Properties     []*PropertyResult
-	FormResult     *NodeFormResult
+	ProcessCode     string
+	Type            string
+	Code            string
+	Name            string
+	CandidateUsers  []string
+	Properties      []*PropertyResult
+	FormResult      *NodeFormResult
+	Topic           string
+	AsyncBefore     bool
+	AsyncAfter      bool
+	Assignee        string
+	CandidateGroups string
+	DueDate         string
+	Priority        string
+	FormKey         string
+	DefaultFlow     string
 }
 
 type sequenceFlow struct {
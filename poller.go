@@ -0,0 +1,102 @@
+package flow
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 定时轮询执行器
+// 每次轮询时执行drain，直到处理数量小于批次大小或发生错误
+type poller struct {
+	name      string
+	interval  time.Duration
+	batchSize int
+	drain     func(ctx context.Context) (int, error)
+
+	lock sync.Mutex
+	quit chan struct{}
+	done chan struct{}
+}
+
+func (p *poller) start() {
+	p.lock.Lock()
+	if p.quit != nil {
+		p.lock.Unlock()
+		return
+	}
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
+	quit, done := p.quit, p.done
+	p.lock.Unlock()
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			for {
+				n, err := p.drain(context.Background())
+				if err != nil {
+					log.Printf("%s发生错误：%s", p.name, err.Error())
+					break
+				} else if n < p.batchSize {
+					break
+				}
+			}
+
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// 停止轮询(等待当前批次执行完成)
+func (p *poller) stop() {
+	p.lock.Lock()
+	quit, done := p.quit, p.done
+	p.quit, p.done = nil, nil
+	p.lock.Unlock()
+
+	if quit == nil {
+		return
+	}
+	close(quit)
+	<-done
+}
+
+// 重试间隔(按重试次数指数增长，最长10分钟)
+func defaultBackoff(attempts int) time.Duration {
+	if attempts > 10 {
+		return time.Minute * 10
+	}
+
+	d := time.Second << uint(attempts)
+	if d > time.Minute*10 {
+		d = time.Minute * 10
+	}
+	return d
+}
+
+// 截断错误信息
+func truncateError(err error, size int) string {
+	return truncateString(err.Error(), size)
+}
+
+// 按字节数截断字符串，不截断多字节字符
+func truncateString(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+	return s[:size]
+}
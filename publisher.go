@@ -0,0 +1,121 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ChannelPublisher 进程内通道发布器
+type ChannelPublisher struct {
+	c chan *OutboxMessage
+}
+
+// NewChannelPublisher 创建进程内通道发布器
+// size 通道缓冲区大小
+func NewChannelPublisher(size int) *ChannelPublisher {
+	return &ChannelPublisher{
+		c: make(chan *OutboxMessage, size),
+	}
+}
+
+// C 获取消息通道
+func (p *ChannelPublisher) C() <-chan *OutboxMessage {
+	return p.c
+}
+
+// Publish 发布消息(通道已满时阻塞，直到超时)
+func (p *ChannelPublisher) Publish(ctx context.Context, msg *OutboxMessage) error {
+	select {
+	case p.c <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type httpPublisherOptions struct {
+	client *http.Client
+	header http.Header
+}
+
+// HTTPPublisherOption HTTP发布器配置
+type HTTPPublisherOption func(*httpPublisherOptions)
+
+// HTTPClientOption 设定HTTP客户端
+func HTTPClientOption(client *http.Client) HTTPPublisherOption {
+	return func(o *httpPublisherOptions) {
+		o.client = client
+	}
+}
+
+// HTTPTimeoutOption 设定请求超时时间
+func HTTPTimeoutOption(timeout time.Duration) HTTPPublisherOption {
+	return func(o *httpPublisherOptions) {
+		o.client = &http.Client{Timeout: timeout}
+	}
+}
+
+// HTTPHeaderOption 设定请求头
+func HTTPHeaderOption(key, value string) HTTPPublisherOption {
+	return func(o *httpPublisherOptions) {
+		o.header.Set(key, value)
+	}
+}
+
+// HTTPPublisher HTTP(webhook)发布器
+// 以POST方式发送事件数据，响应状态码为2xx时视为发布成功
+type HTTPPublisher struct {
+	url  string
+	opts httpPublisherOptions
+}
+
+// NewHTTPPublisher 创建HTTP发布器
+func NewHTTPPublisher(url string, opts ...HTTPPublisherOption) *HTTPPublisher {
+	o := httpPublisherOptions{
+		client: &http.Client{Timeout: time.Second * 10},
+		header: make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &HTTPPublisher{
+		url:  url,
+		opts: o,
+	}
+}
+
+// Publish 发布消息
+func (p *HTTPPublisher) Publish(ctx context.Context, msg *OutboxMessage) error {
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	for k, v := range p.opts.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Flow-Message-ID", msg.ID)
+	req.Header.Set("X-Flow-Event", msg.Type.String())
+	req.Header.Set("X-Flow-Attempts", strconv.Itoa(msg.Attempts))
+
+	resp, err := p.opts.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("发布消息失败，响应状态码：%d", resp.StatusCode)
+	}
+	return nil
+}
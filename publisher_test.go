@@ -0,0 +1,156 @@
+package flow
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/antlinker/flow/schema"
+)
+
+func TestChannelPublisher(t *testing.T) {
+	p := NewChannelPublisher(1)
+	msg := &OutboxMessage{ID: "m1", Type: EventFlowStarted}
+
+	err := p.Publish(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if item := <-p.C(); item.ID != msg.ID {
+		t.Fatalf("无效的消息：%v", item)
+	}
+
+	// 通道已满时超时返回
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = p.Publish(context.Background(), msg)
+	if err := p.Publish(ctx, msg); err == nil {
+		t.Fatal("通道已满时应返回错误")
+	}
+}
+
+func TestHTTPPublisher(t *testing.T) {
+	var (
+		body   string
+		header http.Header
+		reject bool
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		header = r.Header
+		if reject {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	p := NewHTTPPublisher(srv.URL, HTTPHeaderOption("Authorization", "Bearer T"))
+	msg := &OutboxMessage{
+		ID:       "m1",
+		Type:     EventTaskCreated,
+		Payload:  []byte(`{"type":"taskCreated"}`),
+		Attempts: 2,
+	}
+
+	err := p.Publish(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if body != string(msg.Payload) {
+		t.Fatalf("无效的请求数据：%s", body)
+	} else if header.Get("X-Flow-Message-ID") != "m1" ||
+		header.Get("X-Flow-Event") != "taskCreated" ||
+		header.Get("X-Flow-Attempts") != "2" ||
+		header.Get("Authorization") != "Bearer T" {
+		t.Fatalf("无效的请求头：%v", header)
+	}
+
+	reject = true
+	if err := p.Publish(context.Background(), msg); err == nil {
+		t.Fatal("响应状态码非2xx时应返回错误")
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	items := []struct {
+		s      string
+		size   int
+		expect string
+	}{
+		{"error", 10, "error"},
+		{"error", 3, "err"},
+		{"错误信息", 6, "错误"},
+		{"错误信息", 7, "错误"},
+		{"错误信息", 2, ""},
+	}
+	for _, item := range items {
+		if v := truncateString(item.s, item.size); v != item.expect {
+			t.Fatalf("无效的截断结果：%s(%d) %s", item.s, item.size, v)
+		}
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	reject := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reject {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	e := NewMemoryEngine()
+	e.EnableOutbox(EventFlowStarted)
+	_, err := e.CreateFlow([]byte(strings.Replace(webhookTestXML, "{{url}}", srv.URL, -1)))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = e.StartFlow(ctx, "process_webhook", "start", "W000", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 超过最大投递次数后标记为投递失败
+	relay := e.NewOutboxRelay(NewHTTPPublisher(srv.URL), RelayMaxAttemptsOption(1))
+	if n, err := relay.Drain(ctx); err != nil || n != 1 {
+		t.Fatalf("无效的投递数量：%d,%v", n, err)
+	}
+
+	total, items, err := e.QueryOutboxPage(ctx, schema.OutboxQueryParam{Status: 3}, 1, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if total != 1 || len(items) != 1 || items[0].LastError == "" {
+		t.Fatalf("无效的投递失败消息：%d,%v", total, items)
+	}
+
+	// 重新投递失败的消息
+	reject = false
+	err = e.RetryOutbox(ctx, items[0].RecordID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := e.RetryOutbox(ctx, items[0].RecordID); err != ErrOutboxNotFailed {
+		t.Fatalf("待投递的消息不能重新投递：%v", err)
+	}
+	if err := e.RetryOutbox(ctx, "not-found"); err != ErrNotFound {
+		t.Fatalf("无效的消息ID：%v", err)
+	}
+
+	if n, err := relay.Drain(ctx); err != nil || n != 1 {
+		t.Fatalf("无效的投递数量：%d,%v", n, err)
+	}
+	total, _, err = e.QueryOutboxPage(ctx, schema.OutboxQueryParam{Status: 2}, 1, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if total != 1 {
+		t.Fatalf("重新投递后应投递成功：%d", total)
+	}
+}
@@ -20,4 +20,13 @@ func FlowDBMap(db *db.DB) {
 	db.AddTableWithName(schema.FieldProperty{}, schema.FieldPropertyTableName)
 	db.AddTableWithName(schema.FieldValidation{}, schema.FieldValidationTableName)
 	db.AddTableWithName(schema.NodeProperty{}, schema.NodePropertyTableName)
+	db.AddTableWithName(schema.Outbox{}, schema.OutboxTableName)
+	db.AddTableWithName(schema.WebhookDelivery{}, schema.WebhookDeliveryTableName)
+	db.AddTableWithName(schema.Job{}, schema.JobTableName)
+	// 业务主键的唯一约束随建表创建，不依赖数据库迁移
+	db.AddTableWithName(schema.BusinessKey{}, schema.BusinessKeyTableName).SetUniqueTogether("tenant_id", "flow_code", "business_key")
+	db.AddTableWithName(schema.FlowInstanceArchive{}, schema.FlowInstanceArchiveTableName)
+	db.AddTableWithName(schema.NodeInstanceArchive{}, schema.NodeInstanceArchiveTableName)
+	db.AddTableWithName(schema.NodeCandidateArchive{}, schema.NodeCandidateArchiveTableName)
+	db.AddTableWithName(schema.WebhookDeliveryArchive{}, schema.WebhookDeliveryArchiveTableName)
 }
@@ -0,0 +1,214 @@
+package register
+
+import (
+	"fmt"
+
+	"github.com/antlinker/flow/schema"
+	"github.com/antlinker/flow/service/db"
+)
+
+// FlowMigrations 流程相关的数据库迁移(按版本号顺序执行)
+// 版本1为初始的表结构(由CreateTablesIfNotExists创建)，新建的表已包含所有列，执行时会跳过已存在的列及索引
+func FlowMigrations() []db.Migration {
+	return []db.Migration{
+		{
+			Version: 2,
+			Name:    "增加流程状态",
+			Steps: []db.MigrationStep{
+				db.AddColumn(schema.FlowTableName, "status", db.Dialects{
+					"":              "INT DEFAULT 1",
+					db.DialectMySQL: "INT DEFAULT 1 NULL AFTER parent_id",
+				}),
+			},
+		},
+		{
+			Version: 3,
+			Name:    "增加流程实例业务主键",
+			Steps: []db.MigrationStep{
+				db.AddColumn(schema.FlowInstanceTableName, "business_key", db.Dialects{
+					"":              "VARCHAR(100) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(100) NOT NULL DEFAULT '' AFTER flow_id",
+				}),
+				db.CreateIndex(schema.FlowInstanceTableName, "idx_flow_instance_business_key", "business_key"),
+			},
+		},
+		{
+			Version: 4,
+			Name:    "增加服务任务的外部任务主题",
+			Steps: []db.MigrationStep{
+				db.AddColumn(schema.NodeTableName, "topic", db.Dialects{
+					"":              "VARCHAR(100) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(100) NOT NULL DEFAULT '' AFTER form_id",
+				}),
+			},
+		},
+		{
+			Version: 5,
+			Name:    "增加节点的异步继续标识及作业的处理人",
+			Steps: []db.MigrationStep{
+				db.AddColumn(schema.NodeTableName, "async_before", db.Dialects{
+					"":                 "INTEGER NOT NULL DEFAULT 0",
+					db.DialectMySQL:    "TINYINT(1) NOT NULL DEFAULT 0 AFTER topic",
+					db.DialectPostgres: "BOOLEAN NOT NULL DEFAULT false",
+				}),
+				db.AddColumn(schema.NodeTableName, "async_after", db.Dialects{
+					"":                 "INTEGER NOT NULL DEFAULT 0",
+					db.DialectMySQL:    "TINYINT(1) NOT NULL DEFAULT 0 AFTER async_before",
+					db.DialectPostgres: "BOOLEAN NOT NULL DEFAULT false",
+				}),
+				db.AddColumn(schema.JobTableName, "processor", db.Dialects{
+					"":              "VARCHAR(36) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(36) NOT NULL DEFAULT '' AFTER topic",
+				}),
+			},
+		},
+		{
+			Version: 6,
+			Name:    "XML及数据列使用TEXT/LONGTEXT存储",
+			Steps: []db.MigrationStep{
+				db.ModifyColumn(schema.FlowTableName, "xml", longText),
+				db.ModifyColumn(schema.NodeRouterTableName, "expression", text),
+				db.ModifyColumn(schema.NodeAssignmentTableName, "expression", text),
+				db.ModifyColumn(schema.NodeInstanceTableName, "input_data", longText),
+				db.ModifyColumn(schema.NodeInstanceTableName, "out_data", longText),
+				db.ModifyColumn(schema.FormTableName, "data", longText),
+				db.ModifyColumn(schema.OutboxTableName, "payload", longText),
+				db.ModifyColumn(schema.WebhookDeliveryTableName, "payload", longText),
+			},
+		},
+		{
+			Version: 7,
+			Name:    "增加租户",
+			Steps: []db.MigrationStep{
+				db.AddColumn(schema.FlowTableName, "tenant_id", db.Dialects{
+					"":              "VARCHAR(36) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(36) NOT NULL DEFAULT '' AFTER record_id",
+				}),
+				db.AddColumn(schema.FlowInstanceTableName, "tenant_id", db.Dialects{
+					"":              "VARCHAR(36) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(36) NOT NULL DEFAULT '' AFTER record_id",
+				}),
+				db.AddColumn(schema.JobTableName, "tenant_id", db.Dialects{
+					"":              "VARCHAR(36) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(36) NOT NULL DEFAULT '' AFTER record_id",
+				}),
+				db.CreateIndex(schema.FlowTableName, "idx_flow_tenant_code", "tenant_id", "code"),
+				db.CreateIndex(schema.FlowInstanceTableName, "idx_flow_instance_tenant", "tenant_id"),
+			},
+		},
+		{
+			Version: 8,
+			Name:    "增加流程实例归档",
+			Steps: []db.MigrationStep{
+				db.CreateIndex(schema.FlowInstanceTableName, "idx_flow_instance_status_updated", "status", "updated"),
+				db.CreateIndex(schema.FlowInstanceArchiveTableName, "idx_flow_instance_archive_business_key", "business_key"),
+				db.CreateIndex(schema.NodeInstanceArchiveTableName, "idx_node_instance_archive_flow_instance", "flow_instance_id"),
+			},
+		},
+		{
+			Version: 9,
+			Name:    "增加流程的启用版本",
+			Steps: []db.MigrationStep{
+				db.AddColumn(schema.FlowTableName, "active", db.Dialects{
+					"":              "INT NOT NULL DEFAULT 0",
+					db.DialectMySQL: "INT NOT NULL DEFAULT 0 AFTER status",
+				}),
+				// 已有流程的最新有效版本作为启用的版本
+				db.Exec(db.Dialects{
+					"":              fmt.Sprintf("UPDATE %[1]s SET active=1 WHERE deleted=0 AND flag=1 AND status=1 AND version=(SELECT MAX(f.version) FROM %[1]s f WHERE f.deleted=0 AND f.flag=1 AND f.status=1 AND f.tenant_id=%[1]s.tenant_id AND f.code=%[1]s.code)", schema.FlowTableName),
+					db.DialectMySQL: fmt.Sprintf("UPDATE %[1]s f JOIN (SELECT tenant_id,code,MAX(version) AS version FROM %[1]s WHERE deleted=0 AND flag=1 AND status=1 GROUP BY tenant_id,code) m ON f.tenant_id=m.tenant_id AND f.code=m.code AND f.version=m.version SET f.active=1 WHERE f.deleted=0 AND f.flag=1 AND f.status=1", schema.FlowTableName),
+				}),
+			},
+		},
+		{
+			Version: 10,
+			Name:    "增加人工任务的处理人、候选组、到期时间、优先级及表单标识",
+			Steps: []db.MigrationStep{
+				db.AddColumn(schema.NodeTableName, "assignee", db.Dialects{
+					"":              "VARCHAR(255) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(255) NOT NULL DEFAULT '' AFTER async_after",
+				}),
+				db.AddColumn(schema.NodeTableName, "candidate_groups", db.Dialects{
+					"":              "VARCHAR(255) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(255) NOT NULL DEFAULT '' AFTER assignee",
+				}),
+				db.AddColumn(schema.NodeTableName, "due_date", db.Dialects{
+					"":              "VARCHAR(255) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(255) NOT NULL DEFAULT '' AFTER candidate_groups",
+				}),
+				db.AddColumn(schema.NodeTableName, "priority", db.Dialects{
+					"":              "VARCHAR(255) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(255) NOT NULL DEFAULT '' AFTER due_date",
+				}),
+				db.AddColumn(schema.NodeTableName, "form_key", db.Dialects{
+					"":              "VARCHAR(255) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(255) NOT NULL DEFAULT '' AFTER priority",
+				}),
+				db.AddColumn(schema.NodeInstanceTableName, "due_time", db.Dialects{
+					"":              "BIGINT NOT NULL DEFAULT 0",
+					db.DialectMySQL: "BIGINT NOT NULL DEFAULT 0 AFTER status",
+				}),
+				db.AddColumn(schema.NodeInstanceTableName, "priority", db.Dialects{
+					"":              "INT NOT NULL DEFAULT 0",
+					db.DialectMySQL: "INT NOT NULL DEFAULT 0 AFTER due_time",
+				}),
+				db.AddColumn(schema.NodeInstanceArchiveTableName, "due_time", db.Dialects{
+					"":              "BIGINT NOT NULL DEFAULT 0",
+					db.DialectMySQL: "BIGINT NOT NULL DEFAULT 0 AFTER status",
+				}),
+				db.AddColumn(schema.NodeInstanceArchiveTableName, "priority", db.Dialects{
+					"":              "INT NOT NULL DEFAULT 0",
+					db.DialectMySQL: "INT NOT NULL DEFAULT 0 AFTER due_time",
+				}),
+			},
+		},
+		{
+			Version: 11,
+			Name:    "增加业务主键锁定",
+			Steps: []db.MigrationStep{
+				db.CreateUniqueIndex(schema.BusinessKeyTableName, "uk_business_key", "tenant_id", "flow_code", "business_key"),
+				db.CreateIndex(schema.BusinessKeyTableName, "idx_business_key_flow_instance", "flow_instance_id"),
+			},
+		},
+		{
+			Version: 12,
+			Name:    "增加异步继续作业的继续阶段",
+			Steps: []db.MigrationStep{
+				db.AddColumn(schema.JobTableName, "stage", db.Dialects{
+					"":              "VARCHAR(20) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(20) NOT NULL DEFAULT '' AFTER topic",
+				}),
+				// 已有的异步继续作业将主题中的继续阶段移到继续阶段列
+				db.Exec(db.Dialects{
+					"": fmt.Sprintf("UPDATE %s SET stage=topic,topic='' WHERE type_code='async' AND stage=''", schema.JobTableName),
+				}),
+			},
+		},
+		{
+			Version: 13,
+			Name:    "增加节点实例的上一节点实例",
+			Steps: []db.MigrationStep{
+				db.AddColumn(schema.NodeInstanceTableName, "prev_id", db.Dialects{
+					"":              "VARCHAR(36) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(36) NOT NULL DEFAULT '' AFTER node_id",
+				}),
+				db.AddColumn(schema.NodeInstanceArchiveTableName, "prev_id", db.Dialects{
+					"":              "VARCHAR(36) NOT NULL DEFAULT ''",
+					db.DialectMySQL: "VARCHAR(36) NOT NULL DEFAULT '' AFTER node_id",
+				}),
+			},
+		},
+		{
+			Version: 14,
+			Name:    "增加webhook投递记录归档",
+			Steps: []db.MigrationStep{
+				db.CreateIndex(schema.WebhookDeliveryArchiveTableName, "idx_webhook_delivery_archive_flow_instance", "flow_instance_id"),
+			},
+		},
+	}
+}
+
+var (
+	text     = db.Dialects{"": "TEXT"}
+	longText = db.Dialects{"": "TEXT", db.DialectMySQL: "LONGTEXT"}
+)
@@ -0,0 +1,148 @@
+package register
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antlinker/flow/schema"
+	"github.com/antlinker/flow/service/db"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// 获取指定版本的迁移
+func flowMigration(t *testing.T, version int64) db.Migration {
+	for _, item := range FlowMigrations() {
+		if item.Version == version {
+			return item
+		}
+	}
+	t.Fatalf("未找到迁移版本：%d", version)
+	return db.Migration{}
+}
+
+func openTestDB(t *testing.T) *db.DB {
+	name := filepath.Join(os.TempDir(), "flow_migration_test.db")
+	_ = os.Remove(name)
+
+	mdb, _, err := db.Open(db.DialectSQLite, db.SetDSN(fmt.Sprintf("file:%s", name)), db.SetTrace(false))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	m := db.NewWithDB(db.DialectSQLite, mdb, false)
+	FlowDBMap(m)
+	err = m.CreateTablesIfNotExists()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return m
+}
+
+func TestMigrationActiveFlow(t *testing.T) {
+	m := openTestDB(t)
+	defer m.Db.Close()
+
+	// 迁移前没有启用的版本
+	flows := []*schema.Flow{
+		{RecordID: "F1", Code: "leave", Version: 1, Flag: 1, Status: 1},
+		{RecordID: "F2", Code: "leave", Version: 2, Flag: 1, Status: 1},
+		{RecordID: "F3", Code: "leave", Version: 3, Flag: 1, Status: 2},
+		{RecordID: "F4", Code: "leave", Version: 1, Flag: 1, Status: 1, TenantID: "T1"},
+		{RecordID: "F5", Code: "apply", Version: 1, Flag: 1, Status: 1},
+		{RecordID: "F6", Code: "apply", Version: 2, Flag: 1, Status: 1, Deleted: 1},
+	}
+	for _, f := range flows {
+		err := m.Insert(f)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	_, err := db.NewMigrator(m, flowMigration(t, 9)).Migrate(false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var items []struct {
+		RecordID string `db:"record_id"`
+	}
+	_, err = m.Select(&items, fmt.Sprintf("SELECT record_id FROM %s WHERE active=1 ORDER BY record_id", schema.FlowTableName))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 每个流程编号(区分租户)的最新有效版本作为启用的版本
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.RecordID)
+	}
+	if fmt.Sprint(ids) != "[F2 F4 F5]" {
+		t.Fatalf("无效的启用版本：%v", ids)
+	}
+}
+
+func TestMigrationBusinessKey(t *testing.T) {
+	m := openTestDB(t)
+	defer m.Db.Close()
+
+	_, err := db.NewMigrator(m, flowMigration(t, 11)).Migrate(false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	items := []*schema.BusinessKey{
+		{FlowCode: "leave", BusinessKey: "K001", FlowInstanceID: "FI1"},
+		{FlowCode: "leave", BusinessKey: "K001", FlowInstanceID: "FI2", TenantID: "T1"},
+		{FlowCode: "apply", BusinessKey: "K001", FlowInstanceID: "FI3"},
+	}
+	for _, item := range items {
+		err := m.Insert(item)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// 同一租户同一流程编号下的业务主键唯一
+	err = m.Insert(&schema.BusinessKey{FlowCode: "leave", BusinessKey: "K001", FlowInstanceID: "FI4"})
+	if !db.IsDuplicateError(err) {
+		t.Fatalf("重复的业务主键应违反唯一索引：%v", err)
+	}
+}
+
+func TestMigrationJobStage(t *testing.T) {
+	m := openTestDB(t)
+	defer m.Db.Close()
+
+	// 升级前异步继续的作业将继续阶段记录在主题中
+	items := []*schema.Job{
+		{RecordID: "J1", TypeCode: "async", Topic: "before"},
+		{RecordID: "J2", TypeCode: "async", Topic: "after"},
+		{RecordID: "J3", TypeCode: "external", Topic: "charge"},
+	}
+	for _, item := range items {
+		err := m.Insert(item)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	_, err := db.NewMigrator(m, flowMigration(t, 12)).Migrate(false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var jobs []*schema.Job
+	_, err = m.Select(&jobs, fmt.Sprintf("SELECT * FROM %s ORDER BY record_id", schema.JobTableName))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var result []string
+	for _, job := range jobs {
+		result = append(result, job.Topic+":"+job.Stage)
+	}
+	if fmt.Sprint(result) != "[:before :after charge:]" {
+		t.Fatalf("无效的作业数据：%v", result)
+	}
+}
@@ -0,0 +1,224 @@
+package flow
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/antlinker/flow/schema"
+)
+
+// RetentionPolicy 流程实例的保留策略
+type RetentionPolicy struct {
+	FlowCode string        // 流程编号(为空时作为默认策略，适用于未配置策略的流程)
+	After    time.Duration // 流程实例结束(完成或停止)后的保留时长，小于等于0时不清理
+	Purge    bool          // 是否直接删除(默认移动到归档表)
+}
+
+// RetentionProgress 保留任务的执行进度
+type RetentionProgress struct {
+	FlowCode string // 本批次的流程编号(为空时为默认策略)
+	Purge    bool   // 本批次是否直接删除
+	Count    int    // 本批次处理的流程实例数量
+	Archived int64  // 累计归档的流程实例数量
+	Purged   int64  // 累计删除的流程实例数量
+}
+
+type retentionOptions struct {
+	policies  []RetentionPolicy
+	interval  time.Duration
+	batchSize int
+	progress  func(RetentionProgress)
+}
+
+// RetentionOption 保留任务配置
+type RetentionOption func(*retentionOptions)
+
+// RetentionPolicyOption 流程实例的保留策略(同一流程编号以最后配置的为准)
+func RetentionPolicyOption(policies ...RetentionPolicy) RetentionOption {
+	return func(o *retentionOptions) {
+		o.policies = append(o.policies, policies...)
+	}
+}
+
+// RetentionIntervalOption 轮询过期流程实例的时间间隔(默认1小时)
+func RetentionIntervalOption(interval time.Duration) RetentionOption {
+	return func(o *retentionOptions) {
+		o.interval = interval
+	}
+}
+
+// RetentionBatchSizeOption 每批次处理的流程实例数量(默认100)
+func RetentionBatchSizeOption(batchSize int) RetentionOption {
+	return func(o *retentionOptions) {
+		o.batchSize = batchSize
+	}
+}
+
+// RetentionProgressOption 每批次处理完成后的进度回调
+func RetentionProgressOption(fn func(RetentionProgress)) RetentionOption {
+	return func(o *retentionOptions) {
+		o.progress = fn
+	}
+}
+
+// Retention 流程实例保留任务
+// 按保留策略将过期的流程实例及其节点实例、候选人移动到归档表或直接删除，每批次在独立的事务中执行；
+// 归档后查询流程历史数据及根据业务主键获取流程实例时会自动查询归档表；
+// 保留任务处理所有租户的流程实例
+type Retention struct {
+	engine   *Engine
+	opts     retentionOptions
+	policies []RetentionPolicy
+	poller   *poller
+
+	lock     sync.Mutex
+	archived int64
+	purged   int64
+}
+
+// NewRetention 创建流程实例保留任务
+func (e *Engine) NewRetention(opts ...RetentionOption) *Retention {
+	o := retentionOptions{
+		interval:  time.Hour,
+		batchSize: 100,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.batchSize <= 0 {
+		o.batchSize = 100
+	}
+
+	// 同一流程编号以最后配置的为准，默认策略放在最后
+	var (
+		policies []RetentionPolicy
+		index    = make(map[string]int)
+	)
+	for _, p := range o.policies {
+		if i, ok := index[p.FlowCode]; ok {
+			policies[i] = p
+			continue
+		}
+		index[p.FlowCode] = len(policies)
+		policies = append(policies, p)
+	}
+	if i, ok := index[""]; ok {
+		def := policies[i]
+		policies = append(append(policies[:i:i], policies[i+1:]...), def)
+	}
+
+	r := &Retention{
+		engine:   e,
+		opts:     o,
+		policies: policies,
+	}
+	r.poller = &poller{
+		name:      "清理流程实例",
+		interval:  o.interval,
+		batchSize: o.batchSize,
+		drain:     r.Drain,
+	}
+	return r
+}
+
+// Start 启动保留任务，按时间间隔轮询处理过期的流程实例
+func (r *Retention) Start() {
+	r.poller.start()
+}
+
+// Stop 停止保留任务(等待当前批次执行完成)
+func (r *Retention) Stop() {
+	r.poller.stop()
+}
+
+// Run 立即处理所有过期的流程实例，返回处理的流程实例数量
+func (r *Retention) Run(ctx context.Context) (int, error) {
+	var total int
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		n, err := r.Drain(ctx)
+		total += n
+		if err != nil {
+			return total, err
+		} else if n < r.opts.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// Drain 按每个保留策略处理一个批次的过期流程实例，返回本次处理的流程实例数量
+func (r *Retention) Drain(ctx context.Context) (int, error) {
+	var (
+		total int
+		codes []string
+	)
+
+	now := time.Now()
+	for _, p := range r.policies {
+		if p.FlowCode != "" {
+			codes = append(codes, p.FlowCode)
+		}
+		if p.After <= 0 {
+			continue
+		}
+
+		params := schema.ExpiredFlowInstanceQueryParam{
+			FlowCode:  p.FlowCode,
+			EndBefore: now.Add(-p.After).Unix(),
+		}
+		if p.FlowCode == "" {
+			params.ExcludeFlowCodes = codes
+		}
+
+		n, err := r.drainPolicy(p, params)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// 处理一个保留策略的一个批次
+func (r *Retention) drainPolicy(p RetentionPolicy, params schema.ExpiredFlowInstanceQueryParam) (int, error) {
+	flowBll := r.engine.flowBll
+	ids, err := flowBll.QueryExpiredFlowInstanceIDs(params, r.opts.batchSize)
+	if err != nil {
+		return 0, err
+	} else if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if p.Purge {
+		err = flowBll.PurgeFlowInstances(ids)
+	} else {
+		err = flowBll.ArchiveFlowInstances(ids)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	r.lock.Lock()
+	if p.Purge {
+		r.purged += int64(len(ids))
+	} else {
+		r.archived += int64(len(ids))
+	}
+	progress := RetentionProgress{
+		FlowCode: p.FlowCode,
+		Purge:    p.Purge,
+		Count:    len(ids),
+		Archived: r.archived,
+		Purged:   r.purged,
+	}
+	r.lock.Unlock()
+
+	if fn := r.opts.progress; fn != nil {
+		fn(progress)
+	}
+	return len(ids), nil
+}
@@ -15,21 +15,33 @@ const (
 	FieldOptionTableName     = "f_field_option"
 	FieldPropertyTableName   = "f_field_property"
 	FieldValidationTableName = "f_field_validation"
+	OutboxTableName          = "f_outbox"
+	WebhookDeliveryTableName = "f_webhook_delivery"
+	JobTableName             = "f_job"
+	BusinessKeyTableName     = "f_business_key"
+
+	FlowInstanceArchiveTableName  = "f_flow_instance_archive"
+	NodeInstanceArchiveTableName  = "f_node_instance_archive"
+	NodeCandidateArchiveTableName = "f_node_candidate_archive"
+
+	WebhookDeliveryArchiveTableName = "f_webhook_delivery_archive"
 )
 
 // Flow 流程
 type Flow struct {
 	ID       int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`     // 唯一标识(自增ID)
 	RecordID string `db:"record_id,size:36" structs:"record_id" json:"record_id"` // 记录内码(uuid)
+	TenantID string `db:"tenant_id,size:36" structs:"tenant_id" json:"tenant_id"` // 租户(空为全局流程，所有租户可发起)
 	Code     string `db:"code,size:50" structs:"code" json:"code"`                // 流程编号
 	Name     string `db:"name,size:50" structs:"name" json:"name"`                // 流程名称
 	Version  int64  `db:"version" structs:"version" json:"version"`               // 版本号
 	TypeCode string `db:"type_code,size:50" structs:"type_code" json:"type_code"` // 流程类型编号
-	XML      string `db:"xml,size:1024" structs:"xml" json:"xml"`                 // XML数据
+	XML      string `db:"xml,size:16777215" structs:"xml" json:"xml"`             // XML数据
 	Memo     string `db:"memo,size:255" structs:"memo" json:"memo"`               // 流程备注
 	Flag     int64  `db:"flag" structs:"flag" json:"flag"`                        // 流程标志(1:主流程 2:子流程)
 	ParentID string `db:"parent_id,size:36" structs:"parent_id" json:"parent_id"` // 父级流程内码
 	Status   int    `db:"status" structs:"status" json:"status"`                  // 流程状态(1:正常 2:禁用)
+	Active   int    `db:"active" structs:"active" json:"active"`                  // 是否为启用的版本(1:是，按编号发起时优先于其他版本)
 	Created  int64  `db:"created" structs:"created" json:"created"`               // 创建时间戳
 	Updated  int64  `db:"updated" structs:"updated" json:"updated"`               // 更新时间戳
 	Deleted  int64  `db:"deleted" structs:"deleted" json:"deleted"`               // 删除时间戳
@@ -37,17 +49,25 @@ type Flow struct {
 
 // Node 流程节点
 type Node struct {
-	ID       int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`     // 唯一标识(自增ID)
-	RecordID string `db:"record_id,size:36" structs:"record_id" json:"record_id"` // 记录内码(uuid)
-	FlowID   string `db:"flow_id,size:36" structs:"flow_id" json:"flow_id"`       // 流程内码
-	Code     string `db:"code,size:50" structs:"code" json:"code"`                // 节点编号
-	Name     string `db:"name,size:50" structs:"name" json:"name"`                // 节点名称
-	TypeCode string `db:"type_code,size:50" structs:"type_code" json:"type_code"` // 节点类型编号
-	OrderNum string `db:"order_num,size:10" structs:"order_num" json:"order_num"` // 排序值
-	FormID   string `db:"form_id,size:36" structs:"form_id" json:"form_id"`       // 表单内码
-	Created  int64  `db:"created" structs:"created" json:"created"`               // 创建时间戳
-	Updated  int64  `db:"updated" structs:"updated" json:"updated"`               // 更新时间戳
-	Deleted  int64  `db:"deleted" structs:"deleted" json:"deleted"`               // 删除时间戳
+	ID              int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`                           // 唯一标识(自增ID)
+	RecordID        string `db:"record_id,size:36" structs:"record_id" json:"record_id"`                       // 记录内码(uuid)
+	FlowID          string `db:"flow_id,size:36" structs:"flow_id" json:"flow_id"`                             // 流程内码
+	Code            string `db:"code,size:50" structs:"code" json:"code"`                                      // 节点编号
+	Name            string `db:"name,size:50" structs:"name" json:"name"`                                      // 节点名称
+	TypeCode        string `db:"type_code,size:50" structs:"type_code" json:"type_code"`                       // 节点类型编号
+	OrderNum        string `db:"order_num,size:10" structs:"order_num" json:"order_num"`                       // 排序值
+	FormID          string `db:"form_id,size:36" structs:"form_id" json:"form_id"`                             // 表单内码
+	Topic           string `db:"topic,size:100" structs:"topic" json:"topic"`                                  // 外部任务主题(服务任务)
+	AsyncBefore     bool   `db:"async_before" structs:"async_before" json:"async_before"`                      // 进入节点前异步继续
+	AsyncAfter      bool   `db:"async_after" structs:"async_after" json:"async_after"`                         // 完成节点后异步继续
+	Assignee        string `db:"assignee,size:255" structs:"assignee" json:"assignee"`                         // 处理人(camunda:assignee)
+	CandidateGroups string `db:"candidate_groups,size:255" structs:"candidate_groups" json:"candidate_groups"` // 候选组(camunda:candidateGroups)
+	DueDate         string `db:"due_date,size:255" structs:"due_date" json:"due_date"`                         // 到期时间(camunda:dueDate)
+	Priority        string `db:"priority,size:255" structs:"priority" json:"priority"`                         // 优先级(camunda:priority)
+	FormKey         string `db:"form_key,size:255" structs:"form_key" json:"form_key"`                         // 表单标识(camunda:formKey)
+	Created         int64  `db:"created" structs:"created" json:"created"`                                     // 创建时间戳
+	Updated         int64  `db:"updated" structs:"updated" json:"updated"`                                     // 更新时间戳
+	Deleted         int64  `db:"deleted" structs:"deleted" json:"deleted"`                                     // 删除时间戳
 }
 
 // NodeRouter 节点路由
@@ -56,7 +76,7 @@ type NodeRouter struct {
 	RecordID        string `db:"record_id,size:36" structs:"record_id" json:"record_id"`                 // 记录内码(uuid)
 	SourceNodeID    string `db:"source_node_id,size:36" structs:"source_node_id" json:"source_node_id"`  // 源节点内码
 	TargetNodeID    string `db:"target_node_id,size:36" structs:"target_node_id" json:"target_node_id"`  // 目标节点内码
-	Expression      string `db:"expression,size:1024" structs:"expression" json:"expression"`            // 条件表达式(使用qlang作为表达式脚本语言(返回值bool))
+	Expression      string `db:"expression,size:65535" structs:"expression" json:"expression"`           // 条件表达式(使用qlang作为表达式脚本语言(返回值bool))
 	Explain         string `db:"explain,size:255" structs:"explain" json:"explain"`                      // 说明
 	IsDefaultTarget int64  `db:"is_default_target" structs:"is_default_target" json:"is_default_target"` // 是否是默认节点(1:是 2:否)
 	Created         int64  `db:"created" structs:"created" json:"created"`                               // 创建时间戳
@@ -66,13 +86,13 @@ type NodeRouter struct {
 
 // NodeAssignment 节点指派
 type NodeAssignment struct {
-	ID         int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`          // 唯一标识(自增ID)
-	RecordID   string `db:"record_id,size:36" structs:"record_id" json:"record_id"`      // 记录内码(uuid)
-	NodeID     string `db:"node_id,size:36" structs:"node_id" json:"node_id"`            // 节点内码
-	Expression string `db:"expression,size:1024" structs:"expression" json:"expression"` // 执行表达式(基于qlang可提供多种内置函数支持，支持SQL查询)
-	Created    int64  `db:"created" structs:"created" json:"created"`                    // 创建时间戳
-	Updated    int64  `db:"updated" structs:"updated" json:"updated"`                    // 更新时间戳
-	Deleted    int64  `db:"deleted" structs:"deleted" json:"deleted"`                    // 删除时间戳
+	ID         int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`           // 唯一标识(自增ID)
+	RecordID   string `db:"record_id,size:36" structs:"record_id" json:"record_id"`       // 记录内码(uuid)
+	NodeID     string `db:"node_id,size:36" structs:"node_id" json:"node_id"`             // 节点内码
+	Expression string `db:"expression,size:65535" structs:"expression" json:"expression"` // 执行表达式(基于qlang可提供多种内置函数支持，支持SQL查询)
+	Created    int64  `db:"created" structs:"created" json:"created"`                     // 创建时间戳
+	Updated    int64  `db:"updated" structs:"updated" json:"updated"`                     // 更新时间戳
+	Deleted    int64  `db:"deleted" structs:"deleted" json:"deleted"`                     // 删除时间戳
 }
 
 // NodeProperty 节点属性
@@ -89,15 +109,17 @@ type NodeProperty struct {
 
 // FlowInstance 流程实例
 type FlowInstance struct {
-	ID         int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`     // 唯一标识(自增ID)
-	RecordID   string `db:"record_id,size:36" structs:"record_id" json:"record_id"` // 记录内码(uuid)
-	FlowID     string `db:"flow_id,size:36" structs:"flow_id" json:"flow_id"`       // 流程内码
-	Status     int64  `db:"status" structs:"status" json:"status"`                  // 流程状态(0:未开始 1:进行中 2:暂停 3:已停止 9:已完成)
-	Launcher   string `db:"launcher,size:36" structs:"launcher" json:"launcher"`    // 发起人
-	LaunchTime int64  `db:"launch_time" structs:"launch_time" json:"launch_time"`   // 发起时间
-	Created    int64  `db:"created" structs:"created" json:"created"`               // 创建时间戳
-	Updated    int64  `db:"updated" structs:"updated" json:"updated"`               // 更新时间戳
-	Deleted    int64  `db:"deleted" structs:"deleted" json:"deleted"`               // 删除时间戳
+	ID          int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`               // 唯一标识(自增ID)
+	RecordID    string `db:"record_id,size:36" structs:"record_id" json:"record_id"`           // 记录内码(uuid)
+	TenantID    string `db:"tenant_id,size:36" structs:"tenant_id" json:"tenant_id"`           // 租户
+	FlowID      string `db:"flow_id,size:36" structs:"flow_id" json:"flow_id"`                 // 流程内码
+	BusinessKey string `db:"business_key,size:100" structs:"business_key" json:"business_key"` // 业务主键(关联业务数据的唯一标识)
+	Status      int64  `db:"status" structs:"status" json:"status"`                            // 流程状态(0:未开始 1:进行中 2:暂停 3:已停止 9:已完成)
+	Launcher    string `db:"launcher,size:36" structs:"launcher" json:"launcher"`              // 发起人
+	LaunchTime  int64  `db:"launch_time" structs:"launch_time" json:"launch_time"`             // 发起时间
+	Created     int64  `db:"created" structs:"created" json:"created"`                         // 创建时间戳
+	Updated     int64  `db:"updated" structs:"updated" json:"updated"`                         // 更新时间戳
+	Deleted     int64  `db:"deleted" structs:"deleted" json:"deleted"`                         // 删除时间戳
 }
 
 // NodeInstance 节点实例表
@@ -106,11 +128,14 @@ type NodeInstance struct {
 	RecordID       string `db:"record_id,size:36" structs:"record_id" json:"record_id"`                      // 记录内码(uuid)
 	FlowInstanceID string `db:"flow_instance_id,size:36" structs:"flow_instance_id" json:"flow_instance_id"` // 流程实例内码
 	NodeID         string `db:"node_id,size:36" structs:"node_id" json:"node_id"`                            // 节点内码
+	PrevID         string `db:"prev_id,size:36" structs:"prev_id" json:"prev_id"`                            // 上一节点实例内码(流转到该节点的节点实例，开始节点为空)
 	Processor      string `db:"processor,size:36" structs:"processor" json:"processor"`                      // 处理人
 	ProcessTime    int64  `db:"process_time" structs:"process_time" json:"process_time"`                     // 处理时间(秒时间戳)
-	InputData      string `db:"input_data,size:1024" structs:"input_data" json:"input_data"`                 // 输入数据
-	OutData        string `db:"out_data,size:1024" structs:"out_data" json:"out_data"`                       // 输出数据
+	InputData      string `db:"input_data,size:16777215" structs:"input_data" json:"input_data"`             // 输入数据
+	OutData        string `db:"out_data,size:16777215" structs:"out_data" json:"out_data"`                   // 输出数据
 	Status         int64  `db:"status" structs:"status" json:"status"`                                       // 处理状态(1:待处理 2:已完成)
+	DueTime        int64  `db:"due_time" structs:"due_time" json:"due_time"`                                 // 到期时间(秒时间戳，0为不限)
+	Priority       int    `db:"priority" structs:"priority" json:"priority"`                                 // 优先级
 	Created        int64  `db:"created" structs:"created" json:"created"`                                    // 创建时间戳
 	Updated        int64  `db:"updated" structs:"updated" json:"updated"`                                    // 更新时间戳
 	Deleted        int64  `db:"deleted" structs:"deleted" json:"deleted"`                                    // 删除时间戳
@@ -127,6 +152,30 @@ type NodeCandidate struct {
 	Deleted        int64  `db:"deleted" structs:"deleted" json:"deleted"`                                    // 删除时间戳
 }
 
+// FlowInstanceArchive 归档的流程实例
+type FlowInstanceArchive struct {
+	FlowInstance
+	Archived int64 `db:"archived" structs:"archived" json:"archived"` // 归档时间戳
+}
+
+// NodeInstanceArchive 归档的节点实例
+type NodeInstanceArchive struct {
+	NodeInstance
+	Archived int64 `db:"archived" structs:"archived" json:"archived"` // 归档时间戳
+}
+
+// NodeCandidateArchive 归档的节点候选人
+type NodeCandidateArchive struct {
+	NodeCandidate
+	Archived int64 `db:"archived" structs:"archived" json:"archived"` // 归档时间戳
+}
+
+// WebhookDeliveryArchive 归档的webhook投递记录
+type WebhookDeliveryArchive struct {
+	WebhookDelivery
+	Archived int64 `db:"archived" structs:"archived" json:"archived"` // 归档时间戳
+}
+
 // Form 流程表单
 type Form struct {
 	ID       int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`     // 唯一标识(自增ID)
@@ -135,7 +184,7 @@ type Form struct {
 	Code     string `db:"code,size:50" structs:"code" json:"code"`                // 表单编号(唯一)
 	Name     string `db:"name,size:50" structs:"name" json:"name"`                // 表单名称
 	TypeCode string `db:"type_code,size:50" structs:"type_code" json:"type_code"` // 表单类型(URL:表单链接路径 META:表单元数据)
-	Data     string `db:"data,size:1024" structs:"data" json:"data"`              // 表单数据
+	Data     string `db:"data,size:16777215" structs:"data" json:"data"`          // 表单数据
 	Created  int64  `db:"created" structs:"created" json:"created"`               // 创建时间戳
 	Updated  int64  `db:"updated" structs:"updated" json:"updated"`               // 更新时间戳
 	Deleted  int64  `db:"deleted" structs:"deleted" json:"deleted"`               // 删除时间戳
@@ -191,6 +240,96 @@ type FieldValidation struct {
 	Deleted          int64  `db:"deleted" structs:"deleted" json:"deleted"`                                        // 删除时间戳
 }
 
+// Outbox 事件发件箱(与流程状态变更在同一事务中写入，由中继投递到消息系统)
+type Outbox struct {
+	ID             int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`                          // 唯一标识(自增ID)
+	RecordID       string `db:"record_id,size:36" structs:"record_id" json:"record_id"`                      // 记录内码(uuid)
+	EventType      string `db:"event_type,size:50" structs:"event_type" json:"event_type"`                   // 事件类型
+	FlowCode       string `db:"flow_code,size:50" structs:"flow_code" json:"flow_code"`                      // 流程编号
+	FlowInstanceID string `db:"flow_instance_id,size:36" structs:"flow_instance_id" json:"flow_instance_id"` // 流程实例内码
+	Payload        string `db:"payload,size:16777215" structs:"payload" json:"payload"`                      // 事件数据(JSON)
+	Status         int    `db:"status" structs:"status" json:"status"`                                       // 投递状态(1:待投递 2:已投递 3:投递失败)
+	Attempts       int    `db:"attempts" structs:"attempts" json:"attempts"`                                 // 投递次数
+	NextTime       int64  `db:"next_time" structs:"next_time" json:"next_time"`                              // 下次投递时间戳
+	LastError      string `db:"last_error,size:1024" structs:"last_error" json:"last_error"`                 // 最后一次投递错误
+	Created        int64  `db:"created" structs:"created" json:"created"`                                    // 创建时间戳
+	Updated        int64  `db:"updated" structs:"updated" json:"updated"`                                    // 更新时间戳
+	Deleted        int64  `db:"deleted" structs:"deleted" json:"deleted"`                                    // 删除时间戳
+}
+
+// WebhookDelivery webhook投递记录
+type WebhookDelivery struct {
+	ID             int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`                          // 唯一标识(自增ID)
+	RecordID       string `db:"record_id,size:36" structs:"record_id" json:"record_id"`                      // 记录内码(uuid)
+	FlowInstanceID string `db:"flow_instance_id,size:36" structs:"flow_instance_id" json:"flow_instance_id"` // 流程实例内码
+	NodeInstanceID string `db:"node_instance_id,size:36" structs:"node_instance_id" json:"node_instance_id"` // 节点实例内码
+	EventType      string `db:"event_type,size:50" structs:"event_type" json:"event_type"`                   // 事件类型
+	URL            string `db:"url,size:255" structs:"url" json:"url"`                                       // 推送地址
+	Payload        string `db:"payload,size:16777215" structs:"payload" json:"payload"`                      // 推送数据(JSON)
+	Status         int    `db:"status" structs:"status" json:"status"`                                       // 投递状态(1:待投递 2:已投递 3:投递失败)
+	Attempts       int    `db:"attempts" structs:"attempts" json:"attempts"`                                 // 投递次数
+	NextTime       int64  `db:"next_time" structs:"next_time" json:"next_time"`                              // 下次投递时间戳
+	ResponseCode   int    `db:"response_code" structs:"response_code" json:"response_code"`                  // 最后一次响应状态码
+	LastError      string `db:"last_error,size:1024" structs:"last_error" json:"last_error"`                 // 最后一次投递错误
+	Created        int64  `db:"created" structs:"created" json:"created"`                                    // 创建时间戳
+	Updated        int64  `db:"updated" structs:"updated" json:"updated"`                                    // 更新时间戳
+	Deleted        int64  `db:"deleted" structs:"deleted" json:"deleted"`                                    // 删除时间戳
+}
+
+// Job 作业(外部任务等需要由执行者处理的节点实例)
+type Job struct {
+	ID             int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`                          // 唯一标识(自增ID)
+	RecordID       string `db:"record_id,size:36" structs:"record_id" json:"record_id"`                      // 记录内码(uuid)
+	TenantID       string `db:"tenant_id,size:36" structs:"tenant_id" json:"tenant_id"`                      // 租户
+	FlowInstanceID string `db:"flow_instance_id,size:36" structs:"flow_instance_id" json:"flow_instance_id"` // 流程实例内码
+	NodeInstanceID string `db:"node_instance_id,size:36" structs:"node_instance_id" json:"node_instance_id"` // 节点实例内码
+	TypeCode       string `db:"type_code,size:20" structs:"type_code" json:"type_code"`                      // 作业类型(external:外部任务 async:异步继续)
+	Topic          string `db:"topic,size:100" structs:"topic" json:"topic"`                                 // 主题(外部任务)
+	Stage          string `db:"stage,size:20" structs:"stage" json:"stage"`                                  // 继续阶段(异步继续，before:进入节点前 after:完成节点后)
+	Processor      string `db:"processor,size:36" structs:"processor" json:"processor"`                      // 处理人(异步继续时使用)
+	WorkerID       string `db:"worker_id,size:100" structs:"worker_id" json:"worker_id"`                     // 锁定的执行者
+	LockExpire     int64  `db:"lock_expire" structs:"lock_expire" json:"lock_expire"`                        // 锁定过期时间戳
+	Retries        int    `db:"retries" structs:"retries" json:"retries"`                                    // 最大重试次数
+	Attempts       int    `db:"attempts" structs:"attempts" json:"attempts"`                                 // 失败次数
+	NextTime       int64  `db:"next_time" structs:"next_time" json:"next_time"`                              // 可执行时间戳(失败重试时延后)
+	ErrorMessage   string `db:"error_message,size:1024" structs:"error_message" json:"error_message"`        // 最后一次错误信息
+	Status         int    `db:"status" structs:"status" json:"status"`                                       // 作业状态(1:待执行 2:已锁定 3:已完成 4:失败(超过重试次数))
+	Created        int64  `db:"created" structs:"created" json:"created"`                                    // 创建时间戳
+	Updated        int64  `db:"updated" structs:"updated" json:"updated"`                                    // 更新时间戳
+	Deleted        int64  `db:"deleted" structs:"deleted" json:"deleted"`                                    // 删除时间戳
+}
+
+// BusinessKey 业务主键锁定(进行中的流程实例占用业务主键，唯一索引保证同一流程编号下的业务主键只被一个流程实例占用)
+type BusinessKey struct {
+	ID             int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`                          // 唯一标识(自增ID)
+	TenantID       string `db:"tenant_id,size:36" structs:"tenant_id" json:"tenant_id"`                      // 租户
+	FlowCode       string `db:"flow_code,size:50" structs:"flow_code" json:"flow_code"`                      // 流程编号
+	BusinessKey    string `db:"business_key,size:100" structs:"business_key" json:"business_key"`            // 业务主键
+	FlowInstanceID string `db:"flow_instance_id,size:36" structs:"flow_instance_id" json:"flow_instance_id"` // 占用的流程实例内码
+	Created        int64  `db:"created" structs:"created" json:"created"`                                    // 创建时间戳
+}
+
+// JobQueryParam 作业查询参数
+type JobQueryParam struct {
+	TypeCode       string // 作业类型
+	FlowInstanceID string // 流程实例内码
+	Status         int    // 作业状态(1:待执行 2:已锁定 3:已完成 4:失败)
+}
+
+// OutboxQueryParam 事件发件箱查询参数
+type OutboxQueryParam struct {
+	EventType      string // 事件类型
+	FlowInstanceID string // 流程实例内码
+	Status         int    // 投递状态(1:待投递 2:已投递 3:投递失败)
+}
+
+// ExpiredFlowInstanceQueryParam 过期的流程实例查询参数
+type ExpiredFlowInstanceQueryParam struct {
+	FlowCode         string   // 流程编号(为空时不限流程编号)
+	ExcludeFlowCodes []string // 排除的流程编号
+	EndBefore        int64    // 结束(完成或停止)时间早于该时间戳
+}
+
 // FlowQueryParam 流程查询参数
 type FlowQueryParam struct {
 	Code     string // 流程编号
@@ -199,15 +338,25 @@ type FlowQueryParam struct {
 	Status   int    // 流程状态(1:正常 2:禁用)
 }
 
+// FlowInstanceQueryParam 流程实例查询参数
+type FlowInstanceQueryParam struct {
+	FlowCode    string // 流程编号
+	BusinessKey string // 业务主键(模糊匹配)
+	Launcher    string // 发起人
+	Status      int    // 流程状态(1:进行中 2:暂停 3:已停止 9:已完成)
+}
+
 // FlowQueryResult 流程查询结果
 type FlowQueryResult struct {
 	ID       int64  `db:"id,primarykey,autoincrement" structs:"id" json:"id"`     // 唯一标识(自增ID)
 	RecordID string `db:"record_id,size:36" structs:"record_id" json:"record_id"` // 记录内码(uuid)
+	TenantID string `db:"tenant_id,size:36" structs:"tenant_id" json:"tenant_id"` // 租户(空为全局流程)
 	Code     string `db:"code,size:50" structs:"code" json:"code"`                // 流程编号(唯一)
 	Name     string `db:"name,size:50" structs:"name" json:"name"`                // 流程名称
 	Version  int64  `db:"version" structs:"version" json:"version"`               // 版本号
 	TypeCode string `db:"type_code,size:50" structs:"type_code" json:"type_code"` // 流程类型编号
 	Status   int    `db:"status" structs:"status" json:"status"`                  // 流程状态(1:正常 2:禁用)
+	Active   int    `db:"active" structs:"active" json:"active"`                  // 是否为启用的版本
 	Created  int64  `db:"created" structs:"created" json:"created"`               // 创建时间戳
 	Memo     string `db:"memo,size:255" structs:"memo" json:"memo"`               // 流程备注
 }
@@ -216,6 +365,7 @@ type FlowQueryResult struct {
 type FlowTodoResult struct {
 	RecordID       string  `db:"record_id" structs:"record_id" json:"record_id"`                      // 节点实例内码
 	FlowInstanceID string  `db:"flow_instance_id" structs:"flow_instance_id" json:"flow_instance_id"` // 流程实例内码
+	BusinessKey    string  `db:"business_key" structs:"business_key" json:"business_key"`             // 业务主键
 	NodeID         string  `db:"node_id" structs:"node_id" json:"node_id"`                            // 节点内码
 	NodeCode       string  `db:"node_code" structs:"node_code" json:"node_code"`                      // 节点编号
 	NodeName       string  `db:"node_name" structs:"node_name" json:"node_name"`                      // 节点名称
@@ -224,17 +374,36 @@ type FlowTodoResult struct {
 	LaunchTime     int64   `db:"launch_time" structs:"launch_time" json:"launch_time"`                // 发起时间
 	FormType       *string `db:"form_type" structs:"form_type" json:"form_type"`                      // 表单类型
 	FormData       *string `db:"form_data" structs:"form_data" json:"form_data"`                      // 表单数据
+	FormKey        string  `db:"form_key" structs:"form_key" json:"form_key"`                         // 表单标识
+	DueTime        int64   `db:"due_time" structs:"due_time" json:"due_time"`                         // 到期时间(秒时间戳，0为不限)
+	Priority       int     `db:"priority" structs:"priority" json:"priority"`                         // 优先级
+}
+
+// ExternalTaskResult 外部任务(已锁定)
+type ExternalTaskResult struct {
+	RecordID       string `db:"record_id" structs:"record_id" json:"id"`                             // 任务ID(作业内码)
+	Topic          string `db:"topic" structs:"topic" json:"topic"`                                  // 主题
+	WorkerID       string `db:"worker_id" structs:"worker_id" json:"worker_id"`                      // 锁定的执行者
+	LockExpire     int64  `db:"lock_expire" structs:"lock_expire" json:"lock_expire"`                // 锁定过期时间戳
+	Attempts       int    `db:"attempts" structs:"attempts" json:"attempts"`                         // 失败次数
+	Retries        int    `db:"retries" structs:"retries" json:"retries"`                            // 最大重试次数
+	FlowCode       string `db:"flow_code" structs:"flow_code" json:"flow_code"`                      // 流程编号
+	FlowInstanceID string `db:"flow_instance_id" structs:"flow_instance_id" json:"flow_instance_id"` // 流程实例内码
+	BusinessKey    string `db:"business_key" structs:"business_key" json:"business_key"`             // 业务主键
+	NodeCode       string `db:"node_code" structs:"node_code" json:"node_code"`                      // 节点编号
+	NodeInstanceID string `db:"node_instance_id" structs:"node_instance_id" json:"node_instance_id"` // 节点实例内码
+	InputData      string `db:"input_data" structs:"input_data" json:"input_data"`                   // 输入数据
 }
 
 // FlowHistoryResult 流程历史结果
 type FlowHistoryResult struct {
-	RecordID    string `db:"record_id,size:36" structs:"record_id" json:"record_id"`  // 记录内码(uuid)
-	NodeCode    string `db:"node_code,size:36" structs:"node_code" json:"node_code"`  // 节点编号
-	NodeName    string `db:"node_name,size:36" structs:"node_name" json:"node_name"`  // 节点名称
-	Processor   string `db:"processor,size:36" structs:"processor" json:"processor"`  // 处理人
-	ProcessTime int64  `db:"process_time" structs:"process_time" json:"process_time"` // 处理时间(秒时间戳)
-	OutData     string `db:"out_data,size:1024" structs:"out_data" json:"out_data"`   // 输出数据
-	Status      int64  `db:"status" structs:"status" json:"status"`                   // 处理状态(1:待处理 2:已完成)
+	RecordID    string `db:"record_id,size:36" structs:"record_id" json:"record_id"`    // 记录内码(uuid)
+	NodeCode    string `db:"node_code,size:36" structs:"node_code" json:"node_code"`    // 节点编号
+	NodeName    string `db:"node_name,size:36" structs:"node_name" json:"node_name"`    // 节点名称
+	Processor   string `db:"processor,size:36" structs:"processor" json:"processor"`    // 处理人
+	ProcessTime int64  `db:"process_time" structs:"process_time" json:"process_time"`   // 处理时间(秒时间戳)
+	OutData     string `db:"out_data,size:16777215" structs:"out_data" json:"out_data"` // 输出数据
+	Status      int64  `db:"status" structs:"status" json:"status"`                     // 处理状态(1:待处理 2:已完成)
 }
 
 // FlowDoneResult 流程已办结果
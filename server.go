@@ -17,6 +17,7 @@ type serverOptions struct {
 	prefix      string
 	staticRoot  string
 	middlewares []gear.Middleware
+	tenant      func(*http.Request) string
 }
 
 // ServerOption 流程服务配置
@@ -43,6 +44,14 @@ func ServerMiddlewareOption(middlewares ...gear.Middleware) ServerOption {
 	}
 }
 
+// ServerTenantOption 获取请求的租户(例如从认证信息或请求头中获取)，接口按租户隔离数据
+// 未配置时使用请求上下文中的租户(可在中间件中通过WithTenant设定)
+func ServerTenantOption(tenant func(*http.Request) string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.tenant = tenant
+	}
+}
+
 // Server 流程管理服务
 type Server struct {
 	opts   serverOptions
@@ -119,10 +128,31 @@ func newRouterMiddleware(srv *Server) gear.Handler {
 	})
 
 	api := new(API).Init(srv.engine)
+	api.tenant = srv.opts.tenant
 	router.Get("/flow/page", api.QueryFlowPage)
 	router.Get("/flow/:id", api.GetFlow)
 	router.Delete("/flow/:id", api.DeleteFlow)
 	router.Post("/flow", api.SaveFlow)
+	router.Post("/flow/validate", api.ValidateFlow)
+	router.Post("/flow/import", api.ImportFlow)
+	router.Get("/flow/:id/export", api.ExportFlow)
+	router.Get("/flow/:id/diagram", api.GetFlowDiagram)
+	router.Get("/flow/:id/version", api.QueryFlowVersion)
+	router.Get("/flow/:id/diff", api.DiffFlow)
+	router.Post("/flow/:id/activate", api.ActivateFlow)
+	router.Post("/flow/:id/launch", api.LaunchFlow)
+	router.Post("/flow/:id/simulate", api.SimulateFlow)
+	router.Get("/instance/page", api.QueryFlowInstancePage)
+	router.Get("/instance/:id/webhook", api.QueryWebhookDelivery)
+	router.Get("/instance/:id/diagram", api.GetFlowInstanceDiagram)
+	router.Post("/external-task/fetchAndLock", api.FetchAndLock)
+	router.Post("/external-task/:id/complete", api.CompleteExternalTask)
+	router.Post("/external-task/:id/failure", api.ExternalTaskFailure)
+	router.Post("/external-task/:id/extendLock", api.ExtendExternalTaskLock)
+	router.Get("/job/page", api.QueryJobPage)
+	router.Post("/job/:id/retry", api.RetryJob)
+	router.Get("/outbox/page", api.QueryOutboxPage)
+	router.Post("/outbox/:id/retry", api.RetryOutbox)
 
 	return router
 }
@@ -0,0 +1,448 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SchemaVersionTableName 数据库版本表
+const SchemaVersionTableName = "f_schema_version"
+
+// Dialects 按数据库方言区分的SQL定义，键为空字符串时作为默认定义
+type Dialects map[string]string
+
+// 获取方言对应的定义
+func (d Dialects) get(dialect string) string {
+	if v, ok := d[dialect]; ok {
+		return v
+	}
+	return d[""]
+}
+
+// MigrationStep 迁移步骤
+type MigrationStep interface {
+	// 检查该步骤是否已经执行过(未记录版本的已有数据库可能已手动执行过变更)
+	Applied(db *DB) (bool, error)
+	// 获取该步骤需要执行的SQL
+	Statements(dialect string) []string
+}
+
+// Migration 数据库迁移
+type Migration struct {
+	Version int64           // 版本号
+	Name    string          // 迁移名称
+	Steps   []MigrationStep // 迁移步骤
+}
+
+// MigrationPlan 迁移计划
+type MigrationPlan struct {
+	Version    int64    `json:"version"`    // 版本号
+	Name       string   `json:"name"`       // 迁移名称
+	Statements []string `json:"statements"` // 需要执行的SQL
+}
+
+// MigrationStatus 迁移状态
+type MigrationStatus struct {
+	Version int64  `json:"version"` // 版本号
+	Name    string `json:"name"`    // 迁移名称
+	Applied bool   `json:"applied"` // 是否已执行
+	Created int64  `json:"created"` // 执行时间戳
+}
+
+// AddColumn 增加列(列已存在时跳过)
+func AddColumn(table, column string, definition Dialects) MigrationStep {
+	return &addColumnStep{table: table, column: column, definition: definition}
+}
+
+type addColumnStep struct {
+	table      string
+	column     string
+	definition Dialects
+}
+
+func (s *addColumnStep) Applied(db *DB) (bool, error) {
+	return db.columnExists(s.table, s.column)
+}
+
+func (s *addColumnStep) Statements(dialect string) []string {
+	return []string{fmt.Sprintf("ALTER TABLE %s ADD %s %s", s.table, s.column, s.definition.get(dialect))}
+}
+
+// ModifyColumn 修改列的类型(列已是目标类型时跳过)
+// SQLite不支持修改列且不限制VARCHAR的长度，会跳过该步骤
+func ModifyColumn(table, column string, definition Dialects) MigrationStep {
+	return &modifyColumnStep{table: table, column: column, definition: definition}
+}
+
+type modifyColumnStep struct {
+	table      string
+	column     string
+	definition Dialects
+}
+
+func (s *modifyColumnStep) Applied(db *DB) (bool, error) {
+	if db.dialect == DialectSQLite {
+		return true, nil
+	}
+
+	typ, err := db.columnType(s.table, s.column)
+	if err != nil {
+		return false, err
+	}
+
+	// 比较定义中的类型(如TEXT、VARCHAR(1024))，忽略约束及位置
+	def := strings.Fields(s.definition.get(db.dialect))
+	return len(def) > 0 && strings.EqualFold(typ, def[0]), nil
+}
+
+func (s *modifyColumnStep) Statements(dialect string) []string {
+	def := s.definition.get(dialect)
+	switch dialect {
+	case DialectSQLite:
+		return nil
+	case DialectPostgres:
+		return []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", s.table, s.column, def)}
+	}
+	return []string{fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", s.table, s.column, def)}
+}
+
+// CreateIndex 创建索引(索引已存在时跳过)
+func CreateIndex(table, name string, columns ...string) MigrationStep {
+	return &createIndexStep{table: table, name: name, columns: columns}
+}
+
+// CreateUniqueIndex 创建唯一索引(索引或相同列的唯一约束已存在时跳过)
+func CreateUniqueIndex(table, name string, columns ...string) MigrationStep {
+	return &createIndexStep{table: table, name: name, columns: columns, unique: true}
+}
+
+type createIndexStep struct {
+	table   string
+	name    string
+	columns []string
+	unique  bool
+}
+
+func (s *createIndexStep) Applied(db *DB) (bool, error) {
+	ok, err := db.indexExists(s.table, s.name)
+	if err != nil || ok || !s.unique {
+		return ok, err
+	}
+
+	// 建表时已按表映射创建了唯一约束
+	return db.uniqueExists(s.table, s.columns)
+}
+
+func (s *createIndexStep) Statements(dialect string) []string {
+	index := "INDEX"
+	if s.unique {
+		index = "UNIQUE INDEX"
+	}
+	return []string{fmt.Sprintf("CREATE %s %s ON %s (%s)", index, s.name, s.table, strings.Join(s.columns, ","))}
+}
+
+// Exec 执行SQL(方言没有对应的SQL时跳过)
+func Exec(statements ...Dialects) MigrationStep {
+	return &execStep{statements: statements}
+}
+
+type execStep struct {
+	statements []Dialects
+}
+
+func (s *execStep) Applied(db *DB) (bool, error) {
+	return false, nil
+}
+
+func (s *execStep) Statements(dialect string) []string {
+	var items []string
+	for _, stmt := range s.statements {
+		if v := stmt.get(dialect); v != "" {
+			items = append(items, v)
+		}
+	}
+	return items
+}
+
+// 检查列是否存在
+func (m *DB) columnExists(table, column string) (bool, error) {
+	var query string
+	switch m.dialect {
+	case DialectPostgres:
+		query = "SELECT count(*) FROM information_schema.columns WHERE table_schema=current_schema() AND table_name=? AND column_name=?"
+	case DialectSQLite:
+		query = "SELECT count(*) FROM pragma_table_info(?) WHERE name=?"
+	default:
+		query = "SELECT count(*) FROM information_schema.columns WHERE table_schema=DATABASE() AND table_name=? AND column_name=?"
+	}
+
+	n, err := m.SelectInt(m.Rebind(query), table, column)
+	if err != nil {
+		return false, errors.Wrapf(err, "检查列发生错误")
+	}
+	return n > 0, nil
+}
+
+// 获取列的类型(如text、longtext、varchar(1024))，列不存在时返回空
+func (m *DB) columnType(table, column string) (string, error) {
+	var query string
+	switch m.dialect {
+	case DialectPostgres:
+		query = "SELECT udt_name || COALESCE('(' || character_maximum_length || ')', '') FROM information_schema.columns WHERE table_schema=current_schema() AND table_name=? AND column_name=?"
+	case DialectSQLite:
+		query = "SELECT type FROM pragma_table_info(?) WHERE name=?"
+	default:
+		query = "SELECT column_type FROM information_schema.columns WHERE table_schema=DATABASE() AND table_name=? AND column_name=?"
+	}
+
+	typ, err := m.SelectStr(m.Rebind(query), table, column)
+	if err != nil {
+		return "", errors.Wrapf(err, "获取列类型发生错误")
+	}
+	return typ, nil
+}
+
+// 检查索引是否存在
+func (m *DB) indexExists(table, name string) (bool, error) {
+	var query string
+	switch m.dialect {
+	case DialectPostgres:
+		query = "SELECT count(*) FROM pg_indexes WHERE tablename=? AND indexname=?"
+	case DialectSQLite:
+		query = "SELECT count(*) FROM sqlite_master WHERE type='index' AND tbl_name=? AND name=?"
+	default:
+		query = "SELECT count(*) FROM information_schema.statistics WHERE table_schema=DATABASE() AND table_name=? AND index_name=?"
+	}
+
+	n, err := m.SelectInt(m.Rebind(query), table, name)
+	if err != nil {
+		return false, errors.Wrapf(err, "检查索引发生错误")
+	}
+	return n > 0, nil
+}
+
+// 检查是否存在相同列(不区分顺序)的唯一索引或唯一约束
+func (m *DB) uniqueExists(table string, columns []string) (bool, error) {
+	var query string
+	switch m.dialect {
+	case DialectPostgres:
+		query = "SELECT ic.relname AS idx,a.attname AS col FROM pg_index i JOIN pg_class t ON t.oid=i.indrelid JOIN pg_class ic ON ic.oid=i.indexrelid JOIN pg_attribute a ON a.attrelid=t.oid AND a.attnum=ANY(i.indkey) WHERE t.relname=? AND i.indisunique"
+	case DialectSQLite:
+		query = "SELECT il.name AS idx,ii.name AS col FROM pragma_index_list(?) il,pragma_index_info(il.name) ii WHERE il.\"unique\"=1"
+	default:
+		query = "SELECT index_name AS idx,column_name AS col FROM information_schema.statistics WHERE table_schema=DATABASE() AND table_name=? AND non_unique=0"
+	}
+
+	var items []struct {
+		Index  string `db:"idx"`
+		Column string `db:"col"`
+	}
+	_, err := m.Select(&items, m.Rebind(query), table)
+	if err != nil {
+		return false, errors.Wrapf(err, "检查唯一索引发生错误")
+	}
+
+	indexes := make(map[string]map[string]bool)
+	for _, item := range items {
+		if indexes[item.Index] == nil {
+			indexes[item.Index] = make(map[string]bool)
+		}
+		indexes[item.Index][strings.ToLower(item.Column)] = true
+	}
+
+	for _, cols := range indexes {
+		if len(cols) != len(columns) {
+			continue
+		}
+		match := true
+		for _, c := range columns {
+			if !cols[strings.ToLower(c)] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Migrator 数据库迁移器
+// 按版本号顺序执行迁移，已执行的版本记录在版本表中
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+}
+
+// NewMigrator 创建数据库迁移器
+func NewMigrator(db *DB, migrations ...Migration) *Migrator {
+	items := make([]Migration, len(migrations))
+	copy(items, migrations)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Version < items[j].Version
+	})
+
+	return &Migrator{db: db, migrations: items}
+}
+
+// 创建版本表
+func (m *Migrator) createVersionTable() error {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(100) NOT NULL, created BIGINT NOT NULL)", SchemaVersionTableName)
+	_, err := m.db.Exec(query)
+	if err != nil {
+		return errors.Wrapf(err, "创建版本表发生错误")
+	}
+	return nil
+}
+
+// 查询已执行的版本(版本表不存在时视为没有执行过任何版本)
+func (m *Migrator) appliedVersions() (map[int64]int64, error) {
+	versions := make(map[int64]int64)
+	if exists, _ := m.db.columnExists(SchemaVersionTableName, "version"); !exists {
+		return versions, nil
+	}
+
+	var items []struct {
+		Version int64 `db:"version"`
+		Created int64 `db:"created"`
+	}
+
+	_, err := m.db.Select(&items, fmt.Sprintf("SELECT version,created FROM %s", SchemaVersionTableName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "查询已执行的版本发生错误")
+	}
+
+	for _, item := range items {
+		versions[item.Version] = item.Created
+	}
+	return versions, nil
+}
+
+// 检查版本号是否有效
+func (m *Migrator) check() error {
+	for i, item := range m.migrations {
+		if item.Version <= 0 {
+			return errors.Errorf("无效的迁移版本号：%d", item.Version)
+		} else if i > 0 && m.migrations[i-1].Version == item.Version {
+			return errors.Errorf("重复的迁移版本号：%d", item.Version)
+		}
+	}
+	return nil
+}
+
+// Status 查询迁移状态
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	versions, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*MigrationStatus, len(m.migrations))
+	for i, item := range m.migrations {
+		created, ok := versions[item.Version]
+		items[i] = &MigrationStatus{
+			Version: item.Version,
+			Name:    item.Name,
+			Applied: ok,
+			Created: created,
+		}
+	}
+	return items, nil
+}
+
+// Migrate 执行未执行的迁移，返回执行的迁移计划
+// dryRun 为true时仅返回迁移计划，不执行
+func (m *Migrator) Migrate(dryRun bool) ([]*MigrationPlan, error) {
+	err := m.check()
+	if err != nil {
+		return nil, err
+	}
+
+	if !dryRun {
+		err = m.createVersionTable()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	versions, err := m.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []*MigrationPlan
+	for _, item := range m.migrations {
+		if _, ok := versions[item.Version]; ok {
+			continue
+		}
+
+		plan, err := m.plan(item)
+		if err != nil {
+			return plans, err
+		}
+		plans = append(plans, plan)
+
+		if dryRun {
+			continue
+		}
+
+		err = m.apply(plan)
+		if err != nil {
+			return plans, err
+		}
+	}
+	return plans, nil
+}
+
+// 生成迁移计划(跳过已经执行过的步骤)
+func (m *Migrator) plan(item Migration) (*MigrationPlan, error) {
+	plan := &MigrationPlan{
+		Version: item.Version,
+		Name:    item.Name,
+	}
+
+	for _, step := range item.Steps {
+		applied, err := step.Applied(m.db)
+		if err != nil {
+			return nil, errors.Wrapf(err, "检查迁移[%d]发生错误", item.Version)
+		} else if applied {
+			continue
+		}
+		plan.Statements = append(plan.Statements, step.Statements(m.db.dialect)...)
+	}
+	return plan, nil
+}
+
+// 执行迁移计划并记录版本
+func (m *Migrator) apply(plan *MigrationPlan) error {
+	tran, err := m.db.Begin()
+	if err != nil {
+		return errors.Wrapf(err, "开启事物发生错误")
+	}
+
+	for _, stmt := range plan.Statements {
+		_, err = tran.Exec(stmt)
+		if err != nil {
+			_ = tran.Rollback()
+			return errors.Wrapf(err, "执行迁移[%d]发生错误：%s", plan.Version, stmt)
+		}
+	}
+
+	query := m.db.Rebind(fmt.Sprintf("INSERT INTO %s (version,name,created) VALUES(?,?,?)", SchemaVersionTableName))
+	_, err = tran.Exec(query, plan.Version, plan.Name, time.Now().Unix())
+	if err != nil {
+		_ = tran.Rollback()
+		return errors.Wrapf(err, "记录迁移版本发生错误")
+	}
+
+	err = tran.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "提交事物发生错误")
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestColumnExists(t *testing.T) {
+	name := filepath.Join(os.TempDir(), "db_migrate_test.db")
+	_ = os.Remove(name)
+
+	sdb, _, err := Open(DialectSQLite, SetDSN(fmt.Sprintf("file:%s", name)), SetTrace(false))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	m := NewWithDB(DialectSQLite, sdb, false)
+
+	_, err = m.Exec("CREATE TABLE t_migrate (id INTEGER, code VARCHAR(10))")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	step := AddColumn("t_migrate", "code", Dialects{"": "VARCHAR(10)"})
+	if ok, err := step.Applied(m); err != nil || !ok {
+		t.Fatalf("已存在的列应跳过：%v,%v", ok, err)
+	}
+
+	step = AddColumn("t_migrate", "name", Dialects{"": "VARCHAR(10)"})
+	if ok, err := step.Applied(m); err != nil || ok {
+		t.Fatalf("不存在的列应执行：%v,%v", ok, err)
+	}
+
+	if typ, err := m.columnType("t_migrate", "code"); err != nil || typ != "VARCHAR(10)" {
+		t.Fatalf("无效的列类型：%s,%v", typ, err)
+	}
+
+	// SQLite不限制VARCHAR的长度，不需要修改列
+	modify := ModifyColumn("t_migrate", "code", Dialects{"": "TEXT"})
+	if ok, err := modify.Applied(m); err != nil || !ok {
+		t.Fatalf("SQLite应跳过修改列：%v,%v", ok, err)
+	}
+
+	// 检查失败时返回错误，不能当作列不存在
+	_ = sdb.Close()
+	if _, err := step.Applied(m); err == nil {
+		t.Fatal("检查列失败时应返回错误")
+	}
+}
+
+func TestUniqueIndexApplied(t *testing.T) {
+	name := filepath.Join(os.TempDir(), "db_migrate_unique_test.db")
+	_ = os.Remove(name)
+
+	sdb, _, err := Open(DialectSQLite, SetDSN(fmt.Sprintf("file:%s", name)), SetTrace(false))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer sdb.Close()
+	m := NewWithDB(DialectSQLite, sdb, false)
+
+	_, err = m.Exec("CREATE TABLE t_unique (id INTEGER, code VARCHAR(10), name VARCHAR(10), UNIQUE (code,name))")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 建表时已创建相同列的唯一约束
+	step := CreateUniqueIndex("t_unique", "uk_unique", "name", "code")
+	if ok, err := step.Applied(m); err != nil || !ok {
+		t.Fatalf("已存在相同列的唯一约束应跳过：%v,%v", ok, err)
+	}
+
+	step = CreateUniqueIndex("t_unique", "uk_unique_code", "code", "id")
+	if ok, err := step.Applied(m); err != nil || ok {
+		t.Fatalf("不同列的唯一索引应执行：%v,%v", ok, err)
+	}
+
+	// 普通索引不检查唯一约束
+	index := CreateIndex("t_unique", "idx_unique", "code", "name")
+	if ok, err := index.Applied(m); err != nil || ok {
+		t.Fatalf("不存在的索引应执行：%v,%v", ok, err)
+	}
+}
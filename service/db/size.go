@@ -0,0 +1,183 @@
+package db
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"gopkg.in/gorp.v2"
+)
+
+// 定义字符串列的长度
+const (
+	// VarcharMaxSize 使用VARCHAR存储的最大长度(字符数)，超过后使用TEXT存储
+	VarcharMaxSize = 255
+	// TextMaxSize 使用TEXT存储的最大长度(字节数)，超过后使用LONGTEXT(或方言对应的类型)存储
+	TextMaxSize = 65535
+)
+
+// 定义错误
+var (
+	ErrDataTooLong = errors.New("数据超出列的最大长度")
+)
+
+// 按列的最大长度选择字符串类型的方言
+type mysqlDialect struct {
+	gorp.MySQLDialect
+}
+
+func (d mysqlDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool) string {
+	if val.Kind() == reflect.String && maxsize > TextMaxSize {
+		return "longtext"
+	}
+	return d.MySQLDialect.ToSqlType(val, maxsize, isAutoIncr)
+}
+
+type postgresDialect struct {
+	gorp.PostgresDialect
+}
+
+func (d postgresDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool) string {
+	if val.Kind() == reflect.String && maxsize > VarcharMaxSize {
+		return "text"
+	}
+	return d.PostgresDialect.ToSqlType(val, maxsize, isAutoIncr)
+}
+
+type sqliteDialect struct {
+	gorp.SqliteDialect
+}
+
+func (d sqliteDialect) ToSqlType(val reflect.Type, maxsize int, isAutoIncr bool) string {
+	if val.Kind() == reflect.String && maxsize > VarcharMaxSize {
+		return "text"
+	}
+	return d.SqliteDialect.ToSqlType(val, maxsize, isAutoIncr)
+}
+
+// 表的列长度定义
+type tableSize struct {
+	name    string
+	columns map[string]int
+}
+
+// AddTableWithName 注册表映射，并记录字符串列的最大长度(用于写入前检查)
+func (m *DB) AddTableWithName(i interface{}, name string) *gorp.TableMap {
+	t := reflect.TypeOf(i)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	ts := &tableSize{name: name, columns: make(map[string]int)}
+	for j := 0; j < t.NumField(); j++ {
+		f := t.Field(j)
+		if f.Type.Kind() != reflect.String {
+			continue
+		}
+
+		col, size := parseColumnSize(f.Tag.Get("db"))
+		if col == "" || col == "-" {
+			continue
+		} else if size < 1 {
+			size = VarcharMaxSize
+		}
+		ts.columns[col] = size
+	}
+
+	if m.tables == nil {
+		m.tables = make(map[reflect.Type]*tableSize)
+	}
+	m.tables[t] = ts
+
+	return m.DbMap.AddTableWithName(i, name)
+}
+
+// 解析db标签中的列名及长度
+func parseColumnSize(tag string) (string, int) {
+	args := strings.Split(tag, ",")
+	var size int
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "size:") {
+			size, _ = strconv.Atoi(strings.TrimPrefix(arg, "size:"))
+		}
+	}
+	return args[0], size
+}
+
+// 检查值是否超出列的最大长度
+// VARCHAR按字符数检查，TEXT及以上按字节数检查
+func checkColumnSize(table, column string, size int, value string) error {
+	n := len(value)
+	if size <= VarcharMaxSize {
+		n = utf8.RuneCountInString(value)
+	}
+
+	if n > size {
+		return errors.Wrapf(ErrDataTooLong, "%s.%s(%d>%d)", table, column, n, size)
+	}
+	return nil
+}
+
+// CheckSize 检查写入的数据是否超出列的最大长度(数据需要是已注册表映射的结构体)
+func (m *DB) CheckSize(list ...interface{}) error {
+	for _, item := range list {
+		v := reflect.ValueOf(item)
+		for v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+
+		ts, ok := m.tables[v.Type()]
+		if !ok {
+			continue
+		}
+
+		t := v.Type()
+		for j := 0; j < t.NumField(); j++ {
+			col, _ := parseColumnSize(t.Field(j).Tag.Get("db"))
+			size, ok := ts.columns[col]
+			if !ok {
+				continue
+			}
+
+			err := checkColumnSize(ts.name, col, size, v.Field(j).String())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckSizeM 检查写入表的字典数据是否超出列的最大长度
+func (m *DB) CheckSizeM(table string, info M) error {
+	var ts *tableSize
+	for _, item := range m.tables {
+		if item.name == table {
+			ts = item
+			break
+		}
+	}
+	if ts == nil {
+		return nil
+	}
+
+	for col, val := range info {
+		s, ok := val.(string)
+		if !ok {
+			continue
+		}
+
+		size, ok := ts.columns[col]
+		if !ok {
+			continue
+		}
+
+		err := checkColumnSize(table, col, size, s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
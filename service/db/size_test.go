@@ -0,0 +1,36 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type sizeTestItem struct {
+	ID   int64  `db:"id,primarykey,autoincrement"`
+	Code string `db:"code,size:5"`
+	Data string `db:"data,size:300"`
+}
+
+func TestCheckSize(t *testing.T) {
+	m := NewWithDB(DialectSQLite, nil, false)
+	m.AddTableWithName(sizeTestItem{}, "t_size")
+
+	// VARCHAR按字符数检查
+	err := m.CheckSize(&sizeTestItem{Code: "编号编号编", Data: strings.Repeat("a", 300)})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = m.CheckSize(&sizeTestItem{Code: "编号编号编号"})
+	if errors.Cause(err) != ErrDataTooLong {
+		t.Fatalf("无效的检查结果：%v", err)
+	}
+
+	// TEXT按字节数检查
+	err = m.CheckSizeM("t_size", M{"data": strings.Repeat("数", 101)})
+	if errors.Cause(err) != ErrDataTooLong {
+		t.Fatalf("无效的检查结果：%v", err)
+	}
+}
@@ -0,0 +1,428 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/antlinker/flow/bll"
+	"github.com/antlinker/flow/model"
+	"github.com/antlinker/flow/schema"
+	"github.com/pkg/errors"
+)
+
+// 模拟运行时服务任务的默认执行者
+const simulationWorker = "simulation"
+
+// SimulationScenario 流程模拟的场景
+type SimulationScenario struct {
+	Version     int64                  `json:"version,omitempty"`      // 模拟的流程版本(默认为启用的版本)
+	StartNode   string                 `json:"start_node,omitempty"`   // 开始节点ID(默认为第一个开始事件)
+	Launcher    string                 `json:"launcher"`               // 发起人
+	BusinessKey string                 `json:"business_key,omitempty"` // 业务主键
+	Input       map[string]interface{} `json:"input"`                  // 发起流程的输入数据
+	Steps       []*SimulationStep      `json:"steps"`                  // 按顺序处理的节点
+	Candidates  map[string][]string    `json:"candidates,omitempty"`   // 模拟的节点候选人(节点ID -> 候选人)，设定后不再解析节点的候选人、处理人及候选组
+}
+
+// SimulationStep 流程模拟的处理步骤
+type SimulationStep struct {
+	NodeID    string                 `json:"node_id"`             // 处理的节点ID(待处理的人工任务或服务任务)
+	Processor string                 `json:"processor,omitempty"` // 处理人(人工任务默认为第一个候选人，服务任务为执行者)
+	Input     map[string]interface{} `json:"input"`               // 处理的输入数据(服务任务为模拟的执行结果)
+}
+
+// SimulationResult 流程模拟的结果
+type SimulationResult struct {
+	FlowCode string            `json:"flow_code"`         // 流程编号
+	FlowID   string            `json:"flow_id,omitempty"` // 流程内码(模拟未部署的流程定义时为空)
+	Version  int64             `json:"version"`           // 流程版本号
+	Path     []*SimulationNode `json:"path"`              // 按进入顺序流转经过的节点
+	Active   []*SimulationNode `json:"active"`            // 模拟结束时待处理的节点
+	Status   int64             `json:"status"`            // 模拟结束时的流程实例状态(1:进行中 9:已结束)
+	IsEnd    bool              `json:"is_end"`            // 流程是否结束
+}
+
+// SimulationNode 模拟流转的节点
+type SimulationNode struct {
+	NodeID     string          `json:"node_id"`             // 节点ID
+	NodeName   string          `json:"node_name"`           // 节点名称
+	NodeType   string          `json:"node_type"`           // 节点类型
+	Candidates []string        `json:"candidates"`          // 节点候选人
+	Mocked     bool            `json:"mocked,omitempty"`    // 候选人是否为模拟的候选人
+	Processor  string          `json:"processor,omitempty"` // 处理人
+	Done       bool            `json:"done"`                // 是否已完成
+	Input      json.RawMessage `json:"input,omitempty"`     // 节点的输入数据
+	DueTime    int64           `json:"due_time,omitempty"`  // 到期时间戳
+	Priority   int             `json:"priority,omitempty"`  // 优先级
+	Targets    []string        `json:"targets,omitempty"`   // 网关流向的节点ID
+	instanceID string          // 模拟的节点实例内码
+}
+
+// Simulate 模拟运行流程(按流程编号模拟启用的版本，或场景指定的版本)
+// 在内存中按场景发起流程并依次处理节点，使用引擎的表达式执行器计算顺序流条件及候选人，不写入数据库、不通知事件监听
+func (e *Engine) Simulate(ctx context.Context, flowCode string, scenario *SimulationScenario) (*SimulationResult, error) {
+	if scenario == nil {
+		scenario = new(SimulationScenario)
+	}
+
+	flow, err := e.tenantBll(ctx).GetFlowByCodeAndVersion(flowCode, scenario.Version)
+	if err != nil {
+		return nil, err
+	} else if flow == nil {
+		return nil, ErrNotFound
+	}
+
+	def, err := e.GetFlowDefinition(ctx, flow.RecordID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := e.SimulateDefinition(ctx, def, scenario)
+	if err != nil {
+		return nil, err
+	}
+	result.FlowID = flow.RecordID
+	return result, nil
+}
+
+// SimulateDefinition 模拟运行流程定义(可用于部署前检查流程定义)
+func (e *Engine) SimulateDefinition(ctx context.Context, def *FlowDefinition, scenario *SimulationScenario) (*SimulationResult, error) {
+	if scenario == nil {
+		scenario = new(SimulationScenario)
+	}
+
+	s, err := e.newSimulation(ctx, def, scenario)
+	if err != nil {
+		return nil, err
+	}
+
+	startNode := scenario.StartNode
+	if startNode == "" {
+		for _, n := range def.Nodes {
+			if n.Type == StartEvent {
+				startNode = n.ID
+				break
+			}
+		}
+	}
+
+	input, err := simulationInput(scenario.Input)
+	if err != nil {
+		return nil, err
+	}
+	started, err := s.engine.StartFlow(ctx, def.ID, startNode, scenario.Launcher, input, BusinessKeyOption(scenario.BusinessKey))
+	if err != nil {
+		return nil, errors.Wrapf(err, "模拟发起流程[%s]发生错误", def.ID)
+	}
+	s.flowInstanceID = started.FlowInstance.RecordID
+
+	err = s.drain()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, step := range scenario.Steps {
+		err = s.handle(step)
+		if err != nil {
+			return nil, errors.Wrapf(err, "模拟第%d步处理节点[%s]发生错误", i+1, step.NodeID)
+		}
+
+		err = s.drain()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := s.result()
+	if err != nil {
+		return nil, err
+	}
+	result.FlowCode = def.ID
+	result.Version = def.Version
+	return result, nil
+}
+
+// 模拟运行的流程
+type simulation struct {
+	ctx            context.Context
+	engine         *Engine
+	flowBll        *bll.Flow
+	candidates     map[string][]string
+	targets        map[string][]string // 网关的节点实例内码 -> 流向的节点内码
+	flowInstanceID string
+}
+
+// 在内存存储的引擎中部署流程定义(使用当前引擎的解析器、表达式执行器及候选组解析器)
+func (e *Engine) newSimulation(ctx context.Context, def *FlowDefinition, scenario *SimulationScenario) (*simulation, error) {
+	// 模拟候选人的节点使用模拟候选人的表达式，由模拟的表达式执行器返回模拟的候选人
+	execer := &simulationExecer{Execer: e.execer, candidates: make(map[string][]string)}
+	for nodeID, candidates := range scenario.Candidates {
+		execer.candidates[simulationCandidatesExp(nodeID)] = candidates
+	}
+
+	engine, err := new(Engine).InitWithRepository(e.parser, execer, model.NewMemory())
+	if err != nil {
+		return nil, err
+	}
+	engine.groupResolver = e.getGroupResolver()
+
+	s := &simulation{
+		ctx:        ctx,
+		engine:     engine,
+		flowBll:    engine.tenantBll(ctx),
+		candidates: scenario.Candidates,
+		targets:    make(map[string][]string),
+	}
+	engine.AddListener(ListenerFunc(func(_ context.Context, event *Event) error {
+		s.targets[event.NodeInstance.RecordID] = event.TargetNodeIDs
+		return nil
+	}), ListenEventOption(EventGatewayEvaluated))
+
+	// 模拟停用的版本；模拟候选人的节点不再解析处理人及候选组
+	sim := *def
+	sim.Status = 1
+	sim.Nodes = make([]*NodeDefinition, len(def.Nodes))
+	for i, n := range def.Nodes {
+		node := *n
+		if _, ok := scenario.Candidates[n.ID]; ok {
+			node.Candidates = []string{simulationCandidatesExp(n.ID)}
+			node.Assignee = ""
+			node.CandidateGroups = ""
+		}
+		sim.Nodes[i] = &node
+	}
+
+	_, err = engine.CreateFlowWithDefinition(ctx, &sim)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 模拟候选人的表达式(字符串常量，节点ID区分不同的节点)
+func simulationCandidatesExp(nodeID string) string {
+	return strconv.Quote("simulation.candidates:" + nodeID)
+}
+
+// 模拟运行的表达式执行器：模拟候选人的表达式返回模拟的候选人，其他表达式使用引擎的表达式执行器执行
+type simulationExecer struct {
+	Execer
+	candidates map[string][]string // 模拟候选人的表达式 -> 候选人
+}
+
+// ExecReturnStringSlice 执行表达式返回字符串切片类型的值
+func (x *simulationExecer) ExecReturnStringSlice(ctx context.Context, exp, params []byte) ([]string, error) {
+	if candidates, ok := x.candidates[string(exp)]; ok {
+		return append([]string(nil), candidates...), nil
+	}
+	return x.Execer.ExecReturnStringSlice(ctx, exp, params)
+}
+
+// 处理模拟的步骤
+func (s *simulation) handle(step *SimulationStep) error {
+	nodeInstance, node, err := s.pending(step.NodeID)
+	if err != nil {
+		return err
+	} else if nodeInstance == nil {
+		return errors.Errorf("节点[%s]未处于待处理状态", step.NodeID)
+	}
+
+	input, err := simulationInput(step.Input)
+	if err != nil {
+		return err
+	}
+
+	if node.TypeCode == ServiceTask.String() {
+		return s.completeExternal(nodeInstance, step.Processor, input)
+	}
+
+	candidates, err := s.flowBll.QueryNodeCandidates(nodeInstance.RecordID)
+	if err != nil {
+		return err
+	}
+
+	processor := step.Processor
+	if processor == "" {
+		if len(candidates) == 0 {
+			return errors.Errorf("节点[%s]没有候选人，需要指定处理人", step.NodeID)
+		}
+		processor = candidates[0].CandidateID
+	} else if len(candidates) > 0 {
+		ok := false
+		for _, c := range candidates {
+			if c.CandidateID == processor {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errors.Errorf("处理人[%s]不是节点[%s]的候选人", processor, step.NodeID)
+		}
+	}
+
+	_, err = s.engine.HandleFlow(s.ctx, nodeInstance.RecordID, processor, input)
+	return err
+}
+
+// 完成服务任务的外部任务，使用模拟的执行结果继续流转
+func (s *simulation) completeExternal(nodeInstance *schema.NodeInstance, workerID string, outData []byte) error {
+	if workerID == "" {
+		workerID = simulationWorker
+	}
+
+	_, jobs, err := s.flowBll.QueryJobPage(schema.JobQueryParam{
+		TypeCode:       JobTypeExternal,
+		FlowInstanceID: s.flowInstanceID,
+	}, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	var job *schema.Job
+	for _, item := range jobs {
+		if item.NodeInstanceID == nodeInstance.RecordID && item.Status != 3 {
+			job = item
+			break
+		}
+	}
+	if job == nil {
+		return ErrNotFound
+	}
+
+	return s.engine.transaction(s.ctx, &Event{UserID: workerID}, func(flowBll *bll.Flow, emitter *eventEmitter) error {
+		err := flowBll.DoneJob(job.RecordID)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.engine.nextFlowHandle(s.ctx, flowBll, emitter, nodeInstance.RecordID, workerID, outData)
+		return err
+	})
+}
+
+// 执行异步继续的作业，直到没有到期的作业
+func (s *simulation) drain() error {
+	executor := s.engine.NewJobExecutor(JobWorkersOption(1))
+	for {
+		n, err := executor.Drain(s.ctx)
+		if err != nil {
+			return err
+		} else if n == 0 {
+			return nil
+		}
+	}
+}
+
+// 查询待处理的节点实例
+func (s *simulation) pending(nodeCode string) (*schema.NodeInstance, *schema.Node, error) {
+	nodeInstances, err := s.flowBll.QueryNodeInstances(s.flowInstanceID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, ni := range nodeInstances {
+		if ni.Status != 1 {
+			continue
+		}
+
+		node, err := s.flowBll.GetNode(ni.NodeID)
+		if err != nil {
+			return nil, nil, err
+		} else if node != nil && node.Code == nodeCode {
+			return ni, node, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// 获取模拟的结果
+func (s *simulation) result() (*SimulationResult, error) {
+	flowInstance, err := s.flowBll.GetFlowInstance(s.flowInstanceID)
+	if err != nil {
+		return nil, err
+	} else if flowInstance == nil {
+		return nil, ErrNotFound
+	}
+
+	nodeInstances, err := s.flowBll.QueryNodeInstances(s.flowInstanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SimulationResult{
+		Status: flowInstance.Status,
+		IsEnd:  flowInstance.Status == 9,
+	}
+
+	codes := make(map[string]string)
+	nodeCode := func(nodeID string) (*schema.Node, error) {
+		node, err := s.flowBll.GetNode(nodeID)
+		if err != nil {
+			return nil, err
+		} else if node == nil {
+			return nil, ErrNotFound
+		}
+		codes[nodeID] = node.Code
+		return node, nil
+	}
+
+	for _, ni := range nodeInstances {
+		node, err := nodeCode(ni.NodeID)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates, err := s.flowBll.QueryNodeCandidates(ni.RecordID)
+		if err != nil {
+			return nil, err
+		}
+
+		item := &SimulationNode{
+			NodeID:     node.Code,
+			NodeName:   node.Name,
+			NodeType:   node.TypeCode,
+			Candidates: []string{},
+			Processor:  ni.Processor,
+			Done:       ni.Status == 2,
+			DueTime:    ni.DueTime,
+			Priority:   ni.Priority,
+			instanceID: ni.RecordID,
+		}
+		_, item.Mocked = s.candidates[node.Code]
+		for _, c := range candidates {
+			item.Candidates = append(item.Candidates, c.CandidateID)
+		}
+		if ni.InputData != "" {
+			item.Input = json.RawMessage(ni.InputData)
+		}
+
+		result.Path = append(result.Path, item)
+		if ni.Status == 1 {
+			result.Active = append(result.Active, item)
+		}
+	}
+
+	for _, item := range result.Path {
+		for _, nodeID := range s.targets[item.instanceID] {
+			code, ok := codes[nodeID]
+			if !ok {
+				node, err := nodeCode(nodeID)
+				if err != nil {
+					return nil, err
+				}
+				code = node.Code
+			}
+			item.Targets = append(item.Targets, code)
+		}
+	}
+	return result, nil
+}
+
+// 模拟的输入数据
+func simulationInput(input map[string]interface{}) ([]byte, error) {
+	if input == nil {
+		return []byte(`{}`), nil
+	}
+	return json.Marshal(input)
+}
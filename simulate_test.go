@@ -0,0 +1,125 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/antlinker/flow/schema"
+)
+
+// 测试用的表达式执行器，支持a.b==c形式的条件及[]string{a.b}形式的候选人
+type simulateExecer struct{}
+
+func (simulateExecer) value(exp string, params []byte) string {
+	var m map[string]map[string]interface{}
+	json.Unmarshal(params, &m)
+	items := strings.SplitN(strings.TrimSpace(exp), ".", 2)
+	if len(items) != 2 || m[items[0]] == nil {
+		return ""
+	}
+	v := m[items[0]][items[1]]
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func (x simulateExecer) ExecReturnBool(_ context.Context, exp, params []byte) (bool, error) {
+	items := strings.SplitN(string(exp), "==", 2)
+	if len(items) != 2 {
+		return false, fmt.Errorf("无效的表达式：%s", exp)
+	}
+	return x.value(items[0], params) == strings.Trim(items[1], `"`), nil
+}
+
+func (x simulateExecer) ExecReturnStringSlice(_ context.Context, exp, params []byte) ([]string, error) {
+	s := strings.TrimSuffix(strings.TrimPrefix(string(exp), "[]string{"), "}")
+	if v := x.value(s, params); v != "" {
+		return []string{v}, nil
+	}
+	return nil, nil
+}
+
+func TestSimulate(t *testing.T) {
+	ctx := context.Background()
+	e := NewMemoryEngine()
+	e.SetExecer(simulateExecer{})
+
+	_, err := e.CreateFlowWithDefinition(ctx, mustBuild(t, NewDefinition("process_simulate").
+		Start("start").
+		UserTask("apply", CandidatesOption("flow.launcher")).
+		UserTask("audit", CandidatesOption("input.bzr")).
+		ExclusiveGateway("gw").
+		Condition(`input.action=="back"`).To("apply").
+		From("gw").Condition(`input.action=="pass"`).ServiceTask("notify", "notice").End("end")))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	scenario := &SimulationScenario{
+		Launcher: "T000",
+		Input:    map[string]interface{}{"bzr": "T001"},
+		Steps: []*SimulationStep{
+			{NodeID: "audit", Input: map[string]interface{}{"action": "back", "bzr": "T001"}},
+			{NodeID: "apply", Input: map[string]interface{}{"bzr": "T002"}},
+			{NodeID: "audit", Processor: "T002", Input: map[string]interface{}{"action": "pass"}},
+			{NodeID: "notify", Input: map[string]interface{}{"sent": true}},
+		},
+	}
+	result, err := e.Simulate(ctx, "process_simulate", scenario)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if !result.IsEnd || len(result.Active) != 0 {
+		t.Fatalf("流程应模拟结束：%+v", result)
+	}
+
+	var path []string
+	for _, n := range result.Path {
+		path = append(path, n.NodeID)
+	}
+	expect := []string{"start", "apply", "audit", "gw", "apply", "audit", "gw", "notify", "end"}
+	if !reflect.DeepEqual(path, expect) {
+		t.Fatalf("流转路径错误：%v", path)
+	}
+	if !reflect.DeepEqual(result.Path[2].Candidates, []string{"T001"}) || result.Path[2].Processor != "T001" ||
+		!reflect.DeepEqual(result.Path[4].Candidates, []string{"T000"}) ||
+		!reflect.DeepEqual(result.Path[5].Candidates, []string{"T002"}) {
+		t.Fatalf("节点候选人错误：%+v %+v %+v", result.Path[2], result.Path[4], result.Path[5])
+	}
+	if !reflect.DeepEqual(result.Path[3].Targets, []string{"apply"}) || !reflect.DeepEqual(result.Path[6].Targets, []string{"notify"}) {
+		t.Fatalf("网关流向错误：%v %v", result.Path[3].Targets, result.Path[6].Targets)
+	}
+
+	// 模拟不写入流程引擎的存储
+	total, _, err := e.QueryFlowInstancePage(ctx, schema.FlowInstanceQueryParam{}, 1, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if total != 0 {
+		t.Fatalf("模拟不应创建流程实例：%d", total)
+	}
+
+	// 模拟的候选人
+	result, err = e.Simulate(ctx, "process_simulate", &SimulationScenario{
+		Launcher:   "T000",
+		Candidates: map[string][]string{"audit": {"M001", "M002"}},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if result.IsEnd || len(result.Active) != 1 || !result.Active[0].Mocked ||
+		!reflect.DeepEqual(result.Active[0].Candidates, []string{"M001", "M002"}) {
+		t.Fatalf("模拟的候选人错误：%+v", result.Active)
+	}
+
+	_, err = e.Simulate(ctx, "process_simulate", &SimulationScenario{
+		Launcher: "T000",
+		Input:    map[string]interface{}{"bzr": "T001"},
+		Steps:    []*SimulationStep{{NodeID: "audit", Processor: "T009"}},
+	})
+	if err == nil {
+		t.Fatal("处理人不是候选人时应返回错误")
+	}
+}
@@ -0,0 +1,196 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antlinker/flow/schema"
+	"github.com/pkg/errors"
+)
+
+// GroupResolver 候选组解析器(将人工任务的候选组解析为候选人)
+type GroupResolver interface {
+	ResolveGroups(ctx context.Context, groups []string) ([]string, error)
+}
+
+// GroupResolverFunc 函数形式的候选组解析器
+type GroupResolverFunc func(ctx context.Context, groups []string) ([]string, error)
+
+// ResolveGroups 解析候选组
+func (f GroupResolverFunc) ResolveGroups(ctx context.Context, groups []string) ([]string, error) {
+	return f(ctx, groups)
+}
+
+// SetGroupResolver 设定候选组解析器
+// 人工任务配置了候选组(camunda:candidateGroups)时，由解析器将组ID解析为候选人
+func (e *Engine) SetGroupResolver(resolver GroupResolver) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.groupResolver = resolver
+}
+
+func (e *Engine) getGroupResolver() GroupResolver {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.groupResolver
+}
+
+// 人工任务的属性
+type taskAttributes struct {
+	candidates []string
+	dueTime    int64
+	priority   int
+}
+
+// 解析人工任务的处理人、候选组、到期时间及优先级
+func (n *NodeRouter) taskAttributes(node *schema.Node) (*taskAttributes, error) {
+	task := new(taskAttributes)
+
+	if node.Assignee != "" {
+		v, err := n.execTaskValue(node.Assignee)
+		if err != nil {
+			return nil, errors.Wrapf(err, "解析节点[%s]的处理人发生错误", node.Code)
+		} else if v != "" {
+			task.candidates = append(task.candidates, v)
+		}
+	}
+
+	if node.CandidateGroups != "" {
+		groups, err := n.execTaskGroups(node.CandidateGroups)
+		if err != nil {
+			return nil, errors.Wrapf(err, "解析节点[%s]的候选组发生错误", node.Code)
+		}
+		if len(groups) > 0 {
+			resolver := n.engine.getGroupResolver()
+			if resolver == nil {
+				return nil, errors.Errorf("节点[%s]配置了候选组，但没有设定候选组解析器", node.Code)
+			}
+			users, err := resolver.ResolveGroups(n.ctx, groups)
+			if err != nil {
+				return nil, errors.Wrapf(err, "解析节点[%s]的候选组发生错误", node.Code)
+			}
+			task.candidates = append(task.candidates, users...)
+		}
+	}
+
+	if node.DueDate != "" {
+		v, err := n.execTaskValue(node.DueDate)
+		if err != nil {
+			return nil, errors.Wrapf(err, "解析节点[%s]的到期时间发生错误", node.Code)
+		}
+		if v != "" {
+			due, err := ParseDueDate(v, time.Now())
+			if err != nil {
+				return nil, errors.Wrapf(err, "节点[%s]", node.Code)
+			}
+			task.dueTime = due.Unix()
+		}
+	}
+
+	if node.Priority != "" {
+		v, err := n.execTaskValue(node.Priority)
+		if err != nil {
+			return nil, errors.Wrapf(err, "解析节点[%s]的优先级发生错误", node.Code)
+		}
+		if v != "" {
+			task.priority, err = strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.Errorf("节点[%s]的优先级[%s]不是整数", node.Code, v)
+			}
+		}
+	}
+
+	return task, nil
+}
+
+// 执行任务属性的值(${表达式}时执行表达式，否则为字面值)
+func (n *NodeRouter) execTaskValue(value string) (string, error) {
+	exp, ok := taskExpression(value)
+	if !ok {
+		return value, nil
+	}
+
+	ss, err := n.engine.execer.ExecReturnStringSlice(n.ctx, []byte(fmt.Sprintf("[]string{sprint(%s)}", exp)), n.getExpData())
+	if err != nil {
+		return "", err
+	} else if len(ss) == 0 {
+		return "", nil
+	}
+	return strings.TrimSpace(ss[0]), nil
+}
+
+// 执行候选组(${表达式}时执行返回组ID列表的表达式，否则为逗号分隔的组ID)
+func (n *NodeRouter) execTaskGroups(value string) ([]string, error) {
+	exp, ok := taskExpression(value)
+	if !ok {
+		return splitGroups(value), nil
+	}
+
+	ss, err := n.engine.execer.ExecReturnStringSlice(n.ctx, []byte(exp), n.getExpData())
+	if err != nil {
+		return nil, err
+	}
+	return nonEmpty(ss), nil
+}
+
+// 获取任务属性中的表达式(${表达式})
+func taskExpression(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}") {
+		return strings.TrimSpace(value[2 : len(value)-1]), true
+	}
+	return "", false
+}
+
+func splitGroups(value string) []string {
+	var groups []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			groups = append(groups, s)
+		}
+	}
+	return groups
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// ParseDueDate 解析到期时间
+// 支持ISO 8601的时长(如：P3D、PT2H、P1DT12H，从now开始计算)及时间(如：2006-01-02T15:04:05Z、2006-01-02T15:04:05、2006-01-02)
+func ParseDueDate(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if m := isoDuration.FindStringSubmatch(value); m != nil && value != "P" && !strings.HasSuffix(value, "T") {
+		v := make([]int, len(m))
+		for i := 1; i < len(m); i++ {
+			if m[i] != "" {
+				v[i], _ = strconv.Atoi(m[i])
+			}
+		}
+		d := time.Duration(v[5])*time.Hour + time.Duration(v[6])*time.Minute + time.Duration(v[7])*time.Second
+		return now.AddDate(v[1], v[2], v[3]*7+v[4]).Add(d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("无效的到期时间[%s]", value)
+}
+
+// 去除重复及空的字符串(保持顺序)
+func uniqueStrings(items []string) []string {
+	var result []string
+	exists := make(map[string]bool)
+	for _, s := range items {
+		if s == "" || exists[s] {
+			continue
+		}
+		exists[s] = true
+		result = append(result, s)
+	}
+	return result
+}
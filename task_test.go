@@ -0,0 +1,89 @@
+package flow
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTaskAttributes(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<bpmn:definitions xmlns:bpmn="http://www.omg.org/spec/BPMN/20100524/MODEL" xmlns:camunda="http://camunda.org/schema/1.0/bpmn">
+  <bpmn:process id="process_task" isExecutable="true">
+    <bpmn:startEvent id="start" />
+    <bpmn:userTask id="apply" camunda:assignee="T000" />
+    <bpmn:userTask id="task" camunda:assignee="T001" camunda:candidateGroups="hr, finance" camunda:dueDate="P3D" camunda:priority="80" camunda:formKey="embedded:app:forms/leave.html" />
+    <bpmn:endEvent id="end" />
+    <bpmn:sequenceFlow id="flow1" sourceRef="start" targetRef="apply" />
+    <bpmn:sequenceFlow id="flow2" sourceRef="apply" targetRef="task" />
+    <bpmn:sequenceFlow id="flow3" sourceRef="task" targetRef="end" />
+  </bpmn:process>
+</bpmn:definitions>`)
+
+	ctx := context.Background()
+	e := NewMemoryEngine()
+	e.SetStrictValidation(true)
+	_, err := e.CreateFlowWithContext(ctx, data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = e.StartFlow(ctx, "process_task", "start", "T000", []byte(`{}`))
+	if err == nil {
+		t.Fatal("没有设定候选组解析器时应返回错误")
+	}
+
+	var groups []string
+	e.SetGroupResolver(GroupResolverFunc(func(ctx context.Context, items []string) ([]string, error) {
+		groups = items
+		return []string{"T002", "T001"}, nil
+	}))
+	_, err = e.StartFlow(ctx, "process_task", "start", "T000", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(groups) != 2 || groups[0] != "hr" || groups[1] != "finance" {
+		t.Fatalf("候选组错误：%v", groups)
+	}
+
+	for _, userID := range []string{"T001", "T002"} {
+		todos, err := e.QueryTodoFlowsWithContext(ctx, "process_task", userID)
+		if err != nil {
+			t.Fatal(err.Error())
+		} else if len(todos) != 1 {
+			t.Fatalf("用户[%s]的待办数量错误：%d", userID, len(todos))
+		}
+
+		todo := todos[0]
+		if todo.Priority != 80 || todo.FormKey != "embedded:app:forms/leave.html" {
+			t.Fatalf("待办的优先级或表单标识错误：%d,%s", todo.Priority, todo.FormKey)
+		} else if d := time.Until(time.Unix(todo.DueTime, 0)); d < 71*time.Hour || d > 73*time.Hour {
+			t.Fatalf("待办的到期时间错误：%d", todo.DueTime)
+		}
+	}
+}
+
+func TestParseDueDate(t *testing.T) {
+	now := time.Date(2018, 1, 2, 10, 0, 0, 0, time.Local)
+	expects := map[string]time.Time{
+		"P3D":                  now.AddDate(0, 0, 3),
+		"PT2H30M":              now.Add(150 * time.Minute),
+		"P1WT1S":               now.AddDate(0, 0, 7).Add(time.Second),
+		"2018-02-01T08:00:00":  time.Date(2018, 2, 1, 8, 0, 0, 0, time.Local),
+		"2018-02-01T08:00:00Z": time.Date(2018, 2, 1, 8, 0, 0, 0, time.UTC),
+		"2018-02-01":           time.Date(2018, 2, 1, 0, 0, 0, 0, time.Local),
+	}
+	for value, expect := range expects {
+		due, err := ParseDueDate(value, now)
+		if err != nil {
+			t.Fatal(err.Error())
+		} else if !due.Equal(expect) {
+			t.Fatalf("%s：期望%v，实际%v", value, expect, due)
+		}
+	}
+
+	for _, value := range []string{"P", "PT", "3D", "tomorrow"} {
+		if _, err := ParseDueDate(value, now); err == nil {
+			t.Fatalf("%s应返回错误", value)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 问题级别
+const (
+	ProblemError   = "error"   // 错误(严格校验时不允许部署)
+	ProblemWarning = "warning" // 警告
+)
+
+// Problem 流程定义中的问题
+type Problem struct {
+	ElementID string `json:"element_id"` // 元素ID(节点或顺序流的ID，流程级别的问题为流程ID)
+	Level     string `json:"level"`      // 级别(error/warning)
+	Message   string `json:"message"`    // 问题说明
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("[%s]%s：%s", p.Level, p.ElementID, p.Message)
+}
+
+func newProblem(elementID, level, format string, args ...interface{}) *Problem {
+	return &Problem{
+		ElementID: elementID,
+		Level:     level,
+		Message:   fmt.Sprintf(format, args...),
+	}
+}
+
+// ValidationError 流程定义校验失败(严格校验时部署存在错误的流程定义)
+type ValidationError struct {
+	FlowID   string     `json:"flow_id"`  // 流程ID
+	Problems []*Problem `json:"problems"` // 流程定义中的问题
+}
+
+func (e *ValidationError) Error() string {
+	var items []string
+	for _, p := range e.Problems {
+		if p.Level == ProblemError {
+			items = append(items, p.String())
+		}
+	}
+	return fmt.Sprintf("流程[%s]定义校验失败：%s", e.FlowID, strings.Join(items, "；"))
+}
+
+// HasProblemError 检查是否存在错误级别的问题
+func HasProblemError(problems []*Problem) bool {
+	for _, p := range problems {
+		if p.Level == ProblemError {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpressionChecker 表达式检查器(表达式执行器可选实现)
+// 校验流程定义时用于检查顺序流的条件表达式及节点的候选人表达式(语法错误、引用的未定义变量)
+type ExpressionChecker interface {
+	CheckExpression(exp string) error
+}
+
+// ValidateFlow 校验流程定义数据，返回流程定义中的问题(数据无法解析时返回错误)
+func (e *Engine) ValidateFlow(ctx context.Context, data []byte) ([]*Problem, error) {
+	result, err := e.parser.Parse(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	return e.validate(result), nil
+}
+
+// SetStrictValidation 设定是否严格校验流程定义
+// 严格校验时部署存在错误的流程定义返回*ValidationError，否则仅记录日志
+func (e *Engine) SetStrictValidation(strict bool) {
+	e.strictValidation = strict
+}
+
+// 校验流程定义
+func (e *Engine) validate(result *ParseResult) []*Problem {
+	checker, _ := e.execer.(ExpressionChecker)
+	return validateFlow(result, checker)
+}
+
+// 校验流程定义：
+// 开始事件、结束事件，顺序流的目标节点，节点的可达性，
+// 排他网关的条件，人工任务的候选人，表达式的语法及引用的变量
+func validateFlow(result *ParseResult, checker ExpressionChecker) []*Problem {
+	problems := append([]*Problem{}, result.Problems...)
+	add := func(elementID, level, format string, args ...interface{}) {
+		problems = append(problems, newProblem(elementID, level, format, args...))
+	}
+
+	var (
+		nodes    = make(map[string]*NodeResult)
+		incoming = make(map[string][]string)
+		starts   []string
+		ends     []string
+	)
+	for _, n := range result.Nodes {
+		nodes[n.NodeID] = n
+	}
+
+	for _, n := range result.Nodes {
+		switch n.NodeType {
+		case StartEvent:
+			starts = append(starts, n.NodeID)
+		case EndEvent, TerminateEvent:
+			ends = append(ends, n.NodeID)
+			if len(n.Routers) > 0 {
+				add(n.NodeID, ProblemWarning, "结束事件的顺序流不会执行")
+			}
+		case UserTask:
+			if len(nonEmpty(n.CandidateExpressions)) == 0 && n.Assignee == "" && n.CandidateGroups == "" {
+				add(n.NodeID, ProblemError, "人工任务没有候选人")
+			}
+			validateTaskAttributes(n, checker, add)
+		case ExclusiveGateway:
+			validateExclusiveGateway(n, add)
+		}
+
+		for _, r := range n.Routers {
+			if _, ok := nodes[r.TargetNodeID]; !ok {
+				add(r.ID, ProblemError, "顺序流的目标节点[%s]不存在", r.TargetNodeID)
+				continue
+			}
+			incoming[r.TargetNodeID] = append(incoming[r.TargetNodeID], n.NodeID)
+
+			if checker != nil && r.Expression != "" {
+				if err := checker.CheckExpression(r.Expression); err != nil {
+					add(r.ID, ProblemError, "条件表达式错误：%s", err.Error())
+				}
+			}
+		}
+
+		if checker != nil {
+			for _, exp := range nonEmpty(n.CandidateExpressions) {
+				if err := checker.CheckExpression(exp); err != nil {
+					add(n.NodeID, ProblemError, "候选人表达式错误：%s", err.Error())
+				}
+			}
+		}
+	}
+
+	if len(starts) == 0 {
+		add(result.FlowID, ProblemError, "流程没有开始事件")
+	}
+	if len(ends) == 0 {
+		add(result.FlowID, ProblemError, "流程没有结束事件")
+	}
+
+	// 从开始事件不可达的节点
+	reachable := walkNodes(starts, func(id string) []string {
+		var next []string
+		for _, r := range nodes[id].Routers {
+			if _, ok := nodes[r.TargetNodeID]; ok {
+				next = append(next, r.TargetNodeID)
+			}
+		}
+		return next
+	})
+	// 不能到达结束事件的节点
+	terminable := walkNodes(ends, func(id string) []string {
+		return incoming[id]
+	})
+
+	for _, n := range result.Nodes {
+		if len(starts) > 0 && !reachable[n.NodeID] {
+			add(n.NodeID, ProblemError, "节点从开始事件不可达")
+		}
+		if len(ends) > 0 && !terminable[n.NodeID] {
+			add(n.NodeID, ProblemError, "节点不能到达结束事件")
+		}
+	}
+
+	return problems
+}
+
+// 检查排他网关的条件：
+// 没有条件的顺序流为默认的顺序流，仅在其他顺序流的条件都不满足时执行；
+// 指定default属性时仅默认的顺序流可以没有条件，未指定时最多一个顺序流没有条件
+func validateExclusiveGateway(n *NodeResult, add func(elementID, level, format string, args ...interface{})) {
+	if n.DefaultFlow != "" {
+		exists := false
+		for _, r := range n.Routers {
+			if r.ID == n.DefaultFlow {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			add(n.NodeID, ProblemError, "默认的顺序流[%s]不是排他网关的顺序流", n.DefaultFlow)
+		}
+	}
+
+	if len(n.Routers) < 2 {
+		return
+	}
+
+	var missing []*RouterResult
+	for _, r := range n.Routers {
+		if r.ID != n.DefaultFlow && strings.TrimSpace(r.Expression) == "" {
+			missing = append(missing, r)
+		}
+	}
+
+	// 未指定默认的顺序流时，唯一没有条件的顺序流作为默认的顺序流
+	if n.DefaultFlow == "" && len(missing) == 1 {
+		return
+	}
+	for _, r := range missing {
+		add(r.ID, ProblemError, "排他网关的顺序流缺少条件")
+	}
+}
+
+// 检查人工任务的处理人、候选组、到期时间及优先级：
+// ${表达式}检查表达式，到期时间及优先级的字面值检查格式
+func validateTaskAttributes(n *NodeResult, checker ExpressionChecker, add func(elementID, level, format string, args ...interface{})) {
+	attrs := []struct {
+		name  string
+		value string
+		check func(v string) error
+	}{
+		{"处理人", n.Assignee, nil},
+		{"候选组", n.CandidateGroups, nil},
+		{"到期时间", n.DueDate, func(v string) error {
+			_, err := ParseDueDate(v, time.Now())
+			return err
+		}},
+		{"优先级", n.Priority, func(v string) error {
+			if _, err := strconv.Atoi(v); err != nil {
+				return fmt.Errorf("优先级[%s]不是整数", v)
+			}
+			return nil
+		}},
+	}
+
+	for _, attr := range attrs {
+		if attr.value == "" {
+			continue
+		}
+		if exp, ok := taskExpression(attr.value); ok {
+			if checker != nil {
+				if err := checker.CheckExpression(exp); err != nil {
+					add(n.NodeID, ProblemError, "%s表达式错误：%s", attr.name, err.Error())
+				}
+			}
+		} else if attr.check != nil {
+			if err := attr.check(attr.value); err != nil {
+				add(n.NodeID, ProblemError, "%s错误：%s", attr.name, err.Error())
+			}
+		}
+	}
+}
+
+// 从起始节点遍历，返回遍历到的节点
+func walkNodes(from []string, next func(id string) []string) map[string]bool {
+	visited := make(map[string]bool)
+	queue := append([]string{}, from...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		queue = append(queue, next(id)...)
+	}
+	return visited
+}
+
+func nonEmpty(items []string) []string {
+	var result []string
+	for _, s := range items {
+		if strings.TrimSpace(s) != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
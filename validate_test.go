@@ -0,0 +1,157 @@
+package flow
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestValidateFlow(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<bpmn:definitions xmlns:bpmn="http://www.omg.org/spec/BPMN/20100524/MODEL" xmlns:camunda="http://camunda.org/schema/1.0/bpmn">
+  <bpmn:process id="process_validate" isExecutable="true">
+    <bpmn:startEvent id="start" />
+    <bpmn:userTask id="task" />
+    <bpmn:exclusiveGateway id="gateway" />
+    <bpmn:userTask id="orphan" camunda:candidateUsers="'admin'" />
+    <bpmn:userTask id="loop" camunda:candidateUsers="'admin'" />
+    <bpmn:endEvent id="end" />
+    <bpmn:sequenceFlow id="flow1" sourceRef="start" targetRef="task" />
+    <bpmn:sequenceFlow id="flow2" sourceRef="task" targetRef="gateway" />
+    <bpmn:sequenceFlow id="flow3" sourceRef="gateway" targetRef="end" />
+    <bpmn:sequenceFlow id="flow4" sourceRef="gateway" targetRef="loop">
+      <bpmn:conditionExpression>inptu.day &gt; 3</bpmn:conditionExpression>
+    </bpmn:sequenceFlow>
+    <bpmn:sequenceFlow id="flow5" sourceRef="loop" targetRef="loop" />
+    <bpmn:sequenceFlow id="flow6" sourceRef="gateway" targetRef="missing" />
+    <bpmn:sequenceFlow id="flow7" sourceRef="unknown" targetRef="end" />
+    <bpmn:sequenceFlow id="flow1" sourceRef="orphan" targetRef="end" />
+  </bpmn:process>
+</bpmn:definitions>`)
+
+	e := NewMemoryEngine()
+	problems, err := e.ValidateFlow(context.Background(), data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expects := map[string]bool{
+		"flow1":  true, // 重复的ID
+		"flow7":  true, // 源节点不存在
+		"flow6":  true, // 目标节点不存在
+		"task":   true, // 没有候选人
+		"flow3":  true, // 排他网关缺少条件
+		"flow4":  true, // 引用未定义的变量
+		"orphan": true, // 不可达
+		"loop":   true, // 不能到达结束事件
+	}
+	for _, p := range problems {
+		if p.Level == ProblemError {
+			delete(expects, p.ElementID)
+		}
+	}
+	if len(expects) > 0 {
+		t.Fatalf("缺少问题：%v，实际：%v", expects, problems)
+	}
+
+	e.SetStrictValidation(true)
+	_, err = e.CreateFlow(data)
+	if verr, ok := err.(*ValidationError); !ok || len(verr.Problems) == 0 {
+		t.Fatalf("严格校验时应返回ValidationError：%v", err)
+	}
+
+	_, err = e.ValidateFlow(context.Background(), []byte("<bpmn:definitions>"))
+	if err == nil {
+		t.Fatal("无效的XML应返回错误")
+	}
+}
+
+func TestValidateExclusiveGatewayDefault(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<bpmn:definitions xmlns:bpmn="http://www.omg.org/spec/BPMN/20100524/MODEL" xmlns:camunda="http://camunda.org/schema/1.0/bpmn">
+  <bpmn:process id="process_gateway_default" isExecutable="true">
+    <bpmn:startEvent id="start" />
+    <bpmn:exclusiveGateway id="gateway" default="flow4" />
+    <bpmn:exclusiveGateway id="gateway2" default="missing" />
+    <bpmn:endEvent id="end" />
+    <bpmn:sequenceFlow id="flow1" sourceRef="start" targetRef="gateway" />
+    <bpmn:sequenceFlow id="flow2" sourceRef="gateway" targetRef="gateway2">
+      <bpmn:conditionExpression>input.day &gt; 3</bpmn:conditionExpression>
+    </bpmn:sequenceFlow>
+    <bpmn:sequenceFlow id="flow3" sourceRef="gateway" targetRef="end" />
+    <bpmn:sequenceFlow id="flow4" sourceRef="gateway" targetRef="end" />
+    <bpmn:sequenceFlow id="flow5" sourceRef="gateway2" targetRef="end" />
+  </bpmn:process>
+</bpmn:definitions>`)
+
+	problems, err := NewMemoryEngine().ValidateFlow(context.Background(), data)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expects := map[string]bool{
+		"flow3":    true, // 不是默认的顺序流且缺少条件
+		"gateway2": true, // 默认的顺序流不存在
+	}
+	for _, p := range problems {
+		if !expects[p.ElementID] {
+			t.Fatalf("无效的问题：%v", p)
+		}
+		delete(expects, p.ElementID)
+	}
+	if len(expects) > 0 {
+		t.Fatalf("缺少问题：%v，实际：%v", expects, problems)
+	}
+
+	// 测试数据中排他网关的顺序流
+	for _, name := range []string{"leave", "route", "parallel_test"} {
+		data, err := ioutil.ReadFile("test_data/" + name + ".bpmn")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		problems, err := NewMemoryEngine().ValidateFlow(context.Background(), data)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, p := range problems {
+			if strings.HasPrefix(p.ElementID, "ExclusiveGateway") || strings.Contains(p.Message, "排他网关") {
+				t.Fatalf("流程[%s]的排他网关存在问题：%v", name, p)
+			} else if name == "leave" {
+				t.Fatalf("流程[%s]存在问题：%v", name, p)
+			}
+		}
+	}
+}
+
+func TestExclusiveGatewayDefaultFlow(t *testing.T) {
+	ctx := context.Background()
+	e := NewMemoryEngine()
+	e.SetExecer(simulateExecer{})
+
+	flowID, err := e.CreateFlowWithDefinition(ctx, mustBuild(t, NewDefinition("process_gateway_default").
+		Start("start").
+		UserTask("apply", CandidatesOption("flow.launcher")).
+		ExclusiveGateway("gw").
+		Condition(`input.action=="audit"`).UserTask("audit", CandidatesOption("flow.launcher")).End("end").
+		From("gw").To("end")))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// 条件满足时不执行默认的顺序流
+	result, err := e.LaunchFlow(ctx, flowID, "G001", []byte(`{"action":"audit"}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if result.IsEnd || len(result.NextNodes) != 1 || result.NextNodes[0].Node.Code != "audit" {
+		t.Fatalf("无效的流转结果：%s", result.String())
+	}
+
+	result, err = e.LaunchFlow(ctx, flowID, "G001", []byte(`{"action":"none"}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if !result.IsEnd {
+		t.Fatalf("条件都不满足时应执行默认的顺序流：%s", result.String())
+	}
+}
@@ -0,0 +1,289 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antlinker/flow/schema"
+	"github.com/pkg/errors"
+)
+
+// ErrWebhookSecretRequired 未指定webhook签名密钥
+var ErrWebhookSecretRequired = errors.New("webhook签名密钥不能为空")
+
+// WebhookPropertyPrefix 节点属性中webhook配置的前缀
+// 例如：webhook.onTaskCreated=https://example.com/hook，多个地址使用逗号分隔
+const WebhookPropertyPrefix = "webhook."
+
+// WebhookPropertyName 获取事件类型对应的webhook节点属性名称
+func WebhookPropertyName(t EventType) string {
+	s := t.String()
+	if s == "" {
+		return ""
+	}
+	return WebhookPropertyPrefix + "on" + strings.ToUpper(s[:1]) + s[1:]
+}
+
+// WebhookPayload webhook推送数据
+type WebhookPayload struct {
+	Event          EventType `json:"event"`            // 事件类型
+	FlowCode       string    `json:"flow_code"`        // 流程编号
+	FlowInstanceID string    `json:"flow_instance_id"` // 流程实例内码
+	BusinessKey    string    `json:"business_key"`     // 业务主键
+	NodeID         string    `json:"node_id"`          // 节点内码
+	NodeCode       string    `json:"node_code"`        // 节点编号
+	NodeInstanceID string    `json:"node_instance_id"` // 节点实例内码
+	CandidateIDs   []string  `json:"candidate_ids"`    // 节点候选人
+	UserID         string    `json:"user_id"`          // 操作人
+	Time           int64     `json:"time"`             // 事件时间戳
+}
+
+// 根据节点属性写入webhook投递记录
+func (em *eventEmitter) writeWebhook(event *Event) error {
+	if event.Node == nil || event.FlowInstance == nil {
+		return nil
+	}
+
+	props, ok := em.props[event.Node.RecordID]
+	if !ok {
+		items, err := em.flowBll.QueryNodeProperties(event.Node.RecordID)
+		if err != nil {
+			return err
+		}
+		props = items
+		em.props[event.Node.RecordID] = props
+	}
+
+	var urls []string
+	name := WebhookPropertyName(event.Type)
+	for _, p := range props {
+		if p.Name != name {
+			continue
+		}
+
+		for _, url := range strings.Split(p.Value, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				urls = append(urls, url)
+			}
+		}
+	}
+
+	if len(urls) == 0 {
+		return nil
+	}
+
+	payload := &WebhookPayload{
+		Event:          event.Type,
+		FlowCode:       event.FlowCode,
+		FlowInstanceID: event.FlowInstance.RecordID,
+		BusinessKey:    event.FlowInstance.BusinessKey,
+		NodeID:         event.Node.RecordID,
+		NodeCode:       event.Node.Code,
+		CandidateIDs:   event.CandidateIDs,
+		UserID:         event.UserID,
+		Time:           event.Time,
+	}
+	if event.NodeInstance != nil {
+		payload.NodeInstanceID = event.NodeInstance.RecordID
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return em.flowBll.CreateWebhookDelivery(payload.FlowInstanceID, payload.NodeInstanceID, event.Type.String(), urls, b)
+}
+
+// SignWebhook 计算webhook签名
+// 签名为hex(HMAC-SHA256(secret, timestamp + "." + body))
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhookOptions struct {
+	client      *http.Client
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	backoff     func(attempts int) time.Duration
+}
+
+// WebhookOption webhook投递配置
+type WebhookOption func(*webhookOptions)
+
+// WebhookTimeoutOption 请求超时时间(默认10秒)
+func WebhookTimeoutOption(timeout time.Duration) WebhookOption {
+	return func(o *webhookOptions) {
+		o.client = &http.Client{Timeout: timeout}
+	}
+}
+
+// WebhookClientOption 设定HTTP客户端
+func WebhookClientOption(client *http.Client) WebhookOption {
+	return func(o *webhookOptions) {
+		o.client = client
+	}
+}
+
+// WebhookIntervalOption 轮询投递记录的时间间隔(默认1秒)
+func WebhookIntervalOption(interval time.Duration) WebhookOption {
+	return func(o *webhookOptions) {
+		o.interval = interval
+	}
+}
+
+// WebhookMaxAttemptsOption 最大投递次数，超过后标记为投递失败(默认10)
+func WebhookMaxAttemptsOption(maxAttempts int) WebhookOption {
+	return func(o *webhookOptions) {
+		o.maxAttempts = maxAttempts
+	}
+}
+
+// WebhookBackoffOption 投递失败后的重试间隔(默认按投递次数指数增长，最长10分钟)
+func WebhookBackoffOption(backoff func(attempts int) time.Duration) WebhookOption {
+	return func(o *webhookOptions) {
+		o.backoff = backoff
+	}
+}
+
+// WebhookDispatcher webhook投递器
+type WebhookDispatcher struct {
+	engine *Engine
+	secret string
+	opts   webhookOptions
+	poller *poller
+}
+
+// NewWebhookDispatcher 创建webhook投递器
+// secret 为签名密钥(不能为空)，每个请求的请求头X-Flow-Signature为签名值，X-Flow-Timestamp为签名时间戳
+func (e *Engine) NewWebhookDispatcher(secret string, opts ...WebhookOption) (*WebhookDispatcher, error) {
+	if secret == "" {
+		return nil, ErrWebhookSecretRequired
+	}
+
+	o := webhookOptions{
+		client:      &http.Client{Timeout: time.Second * 10},
+		interval:    time.Second,
+		batchSize:   100,
+		maxAttempts: 10,
+		backoff:     defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	d := &WebhookDispatcher{
+		engine: e,
+		secret: secret,
+		opts:   o,
+	}
+	d.poller = &poller{
+		name:      "投递webhook",
+		interval:  o.interval,
+		batchSize: o.batchSize,
+		drain:     d.Drain,
+	}
+	return d, nil
+}
+
+// Start 启动投递器，按时间间隔轮询投递记录
+func (d *WebhookDispatcher) Start() {
+	d.poller.start()
+}
+
+// Stop 停止投递器(等待当前批次投递完成)
+func (d *WebhookDispatcher) Stop() {
+	d.poller.stop()
+}
+
+// Drain 投递一个批次的到期记录，返回本批次处理的记录数量
+func (d *WebhookDispatcher) Drain(ctx context.Context) (int, error) {
+	flowBll := d.engine.flowBll
+	items, err := flowBll.QueryPendingWebhookDelivery(d.opts.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range items {
+		attempts := item.Attempts + 1
+		code, perr := d.post(ctx, item, attempts)
+		if perr == nil {
+			err = flowBll.DoneWebhookDelivery(item.RecordID, attempts, code)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		var nextTime int64
+		if attempts < d.opts.maxAttempts {
+			nextTime = time.Now().Add(d.opts.backoff(attempts)).Unix()
+		}
+
+		err = flowBll.FailWebhookDelivery(item.RecordID, attempts, code, nextTime, truncateError(perr, 1024))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(items), nil
+}
+
+// 发送webhook请求，返回响应状态码
+func (d *WebhookDispatcher) post(ctx context.Context, item *schema.WebhookDelivery, attempts int) (int, error) {
+	body := []byte(item.Payload)
+	req, err := http.NewRequest(http.MethodPost, item.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Flow-Delivery", item.RecordID)
+	req.Header.Set("X-Flow-Event", item.EventType)
+	req.Header.Set("X-Flow-Attempts", strconv.Itoa(attempts))
+
+	ts := time.Now().Unix()
+	req.Header.Set("X-Flow-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Flow-Signature", SignWebhook(d.secret, ts, body))
+
+	resp, err := d.opts.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook推送失败，响应状态码：%d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// QueryWebhookDelivery 查询流程实例的webhook投递记录
+func (e *Engine) QueryWebhookDelivery(ctx context.Context, flowInstanceID string) ([]*schema.WebhookDelivery, error) {
+	flowBll := e.tenantBll(ctx)
+	flowInstance, err := flowBll.GetFlowInstance(flowInstanceID)
+	if err != nil {
+		return nil, err
+	} else if flowInstance == nil {
+		return nil, nil
+	}
+	return flowBll.QueryWebhookDelivery(flowInstanceID)
+}
@@ -0,0 +1,179 @@
+package flow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/antlinker/flow/schema"
+)
+
+func TestWebhookPropertyName(t *testing.T) {
+	if name := WebhookPropertyName(EventTaskCreated); name != "webhook.onTaskCreated" {
+		t.Fatalf("无效的属性名称：%s", name)
+	}
+
+	if name := WebhookPropertyName(EventFlowEnded); name != "webhook.onFlowEnded" {
+		t.Fatalf("无效的属性名称：%s", name)
+	}
+}
+
+func TestWebhookDispatcherPost(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	item := &schema.WebhookDelivery{
+		RecordID:  "d1",
+		EventType: EventTaskCreated.String(),
+		URL:       srv.URL,
+		Payload:   `{"event":"taskCreated"}`,
+	}
+
+	if _, err := new(Engine).NewWebhookDispatcher(""); err != ErrWebhookSecretRequired {
+		t.Fatalf("未指定签名密钥时应返回错误：%v", err)
+	}
+
+	d, err := new(Engine).NewWebhookDispatcher("secret")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	code, err := d.post(context.Background(), item, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if code != http.StatusAccepted {
+		t.Fatalf("无效的响应状态码：%d", code)
+	}
+
+	ts, _ := strconv.ParseInt(header.Get("X-Flow-Timestamp"), 10, 64)
+	if header.Get("X-Flow-Signature") != SignWebhook("secret", ts, []byte(item.Payload)) {
+		t.Fatalf("无效的签名：%v", header)
+	} else if header.Get("X-Flow-Delivery") != "d1" {
+		t.Fatalf("无效的请求头：%v", header)
+	}
+}
+
+const webhookTestXML = `<?xml version="1.0" encoding="UTF-8"?>
+<bpmn:definitions xmlns:bpmn="http://www.omg.org/spec/BPMN/20100524/MODEL" xmlns:camunda="http://camunda.org/schema/1.0/bpmn">
+  <bpmn:process id="process_webhook" isExecutable="true">
+    <bpmn:startEvent id="start">
+      <bpmn:outgoing>flow1</bpmn:outgoing>
+    </bpmn:startEvent>
+    <bpmn:userTask id="apply" camunda:assignee="W000">
+      <bpmn:incoming>flow1</bpmn:incoming>
+      <bpmn:outgoing>flow2</bpmn:outgoing>
+    </bpmn:userTask>
+    <bpmn:userTask id="audit" camunda:assignee="W001">
+      <bpmn:extensionElements>
+        <camunda:properties>
+          <camunda:property name="webhook.onTaskCreated" value="{{url}}/task, {{url}}/audit" />
+        </camunda:properties>
+      </bpmn:extensionElements>
+      <bpmn:incoming>flow2</bpmn:incoming>
+      <bpmn:outgoing>flow3</bpmn:outgoing>
+    </bpmn:userTask>
+    <bpmn:endEvent id="end">
+      <bpmn:extensionElements>
+        <camunda:properties>
+          <camunda:property name="webhook.onFlowEnded" value="{{url}}/end" />
+        </camunda:properties>
+      </bpmn:extensionElements>
+      <bpmn:incoming>flow3</bpmn:incoming>
+    </bpmn:endEvent>
+    <bpmn:sequenceFlow id="flow1" sourceRef="start" targetRef="apply" />
+    <bpmn:sequenceFlow id="flow2" sourceRef="apply" targetRef="audit" />
+    <bpmn:sequenceFlow id="flow3" sourceRef="audit" targetRef="end" />
+  </bpmn:process>
+</bpmn:definitions>`
+
+func TestWebhookDelivery(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	e := NewMemoryEngine()
+	_, err := e.CreateFlow([]byte(strings.Replace(webhookTestXML, "{{url}}", srv.URL, -1)))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	result, err := e.StartFlow(ctx, "process_webhook", "start", "W000", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	flowInstanceID := result.FlowInstance.RecordID
+
+	todos, err := e.QueryTodoFlows("process_webhook", "W001")
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(todos) != 1 {
+		t.Fatalf("无效的待办数量：%d", len(todos))
+	}
+
+	// 节点属性中声明的每个地址写入一条投递记录
+	items, err := e.QueryWebhookDelivery(ctx, flowInstanceID)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(items) != 2 {
+		t.Fatalf("无效的投递记录数量：%d", len(items))
+	}
+	for _, item := range items {
+		if item.EventType != EventTaskCreated.String() || item.Status != 1 ||
+			item.NodeInstanceID != todos[0].RecordID ||
+			!strings.Contains(item.Payload, `"node_code":"audit"`) {
+			t.Fatalf("无效的投递记录：%+v", item)
+		}
+	}
+
+	_, err = e.HandleFlow(ctx, todos[0].RecordID, "W001", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	items, err = e.QueryWebhookDelivery(ctx, flowInstanceID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var ended int
+	for _, item := range items {
+		if item.EventType == EventFlowEnded.String() && item.URL == srv.URL+"/end" {
+			ended++
+		}
+	}
+	if len(items) != 3 || ended != 1 {
+		t.Fatalf("流程结束时应写入投递记录：%d,%d", len(items), ended)
+	}
+
+	// 投递器按投递记录推送
+	d, err := e.NewWebhookDispatcher("secret")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	n, err := d.Drain(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if n != 3 || len(paths) != 3 {
+		t.Fatalf("无效的投递数量：%d,%v", n, paths)
+	}
+
+	// 归档后仍可查询投递记录
+	err = e.FlowBll().ArchiveFlowInstances([]string{flowInstanceID})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	items, err = e.QueryWebhookDelivery(ctx, flowInstanceID)
+	if err != nil {
+		t.Fatal(err.Error())
+	} else if len(items) != 3 || items[0].Status != 2 {
+		t.Fatalf("归档后应查询到投递记录：%d", len(items))
+	}
+}